@@ -0,0 +1,42 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+
+	"github.com/systemboot/systemboot/pkg/bootconfig"
+	"github.com/systemboot/systemboot/pkg/storage"
+)
+
+// restrictToDevice returns the device found by find for the value of the
+// given argument of the running kernel's command line, so that only it is
+// scanned. If there is no such device, all the devices are returned.
+func restrictToDevice(devices []storage.BlockDev, arg, value string, find func(string) (string, error)) []storage.BlockDev {
+	devname, err := find(value)
+	if err != nil {
+		log.Printf("Warning: ignoring %s=%q, scanning all the devices: %v", arg, value, err)
+		return devices
+	}
+	for _, dev := range devices {
+		if dev.Name == filepath.Base(devname) {
+			log.Printf("Only scanning %s, as set with %s=%q", devname, arg, value)
+			return []storage.BlockDev{dev}
+		}
+	}
+	log.Printf("Warning: ignoring %s=%q, scanning all the devices: %s is not a known block device", arg, value, devname)
+	return devices
+}
+
+// restrictToUUID returns the device with the given file system or partition
+// UUID, as set with bootconfig.BootUUIDArg. If no device has this UUID, all
+// the devices are returned.
+func restrictToUUID(devices []storage.BlockDev, uuid string) []storage.BlockDev {
+	return restrictToDevice(devices, bootconfig.BootUUIDArg, uuid, storage.FindByUUID)
+}
+
+// restrictToLabel returns the device with the given file system label, as set
+// with bootconfig.BootLabelArg. If no device has this label, all the devices
+// are returned.
+func restrictToLabel(devices []storage.BlockDev, label string) []storage.BlockDev {
+	return restrictToDevice(devices, bootconfig.BootLabelArg, label, storage.FindByLabel)
+}
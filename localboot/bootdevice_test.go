@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/systemboot/systemboot/pkg/bootscan"
+	"github.com/systemboot/systemboot/pkg/storage"
+)
+
+func TestRestrictToUUID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bootdevice")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	// sda2 stands for both the device node and, once mounted, its contents
+	sda2 := path.Join(dir, "sda2")
+	require.NoError(t, os.MkdirAll(path.Join(sda2, "boot/grub2"), 0755))
+	require.NoError(t, ioutil.WriteFile(path.Join(sda2, "boot/grub2/grub.cfg"), []byte(`
+menuentry 'Linux' {
+	linux /vmlinuz root=/dev/sda2
+}
+`), 0644))
+	byUUID := path.Join(dir, "by-uuid")
+	require.NoError(t, os.Mkdir(byUUID, 0755))
+	require.NoError(t, os.Symlink("../sda2", path.Join(byUUID, "0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0")))
+	defer func(u, p string) {
+		storage.DiskByUUIDPath, storage.DiskByPartUUIDPath = u, p
+	}(storage.DiskByUUIDPath, storage.DiskByPartUUIDPath)
+	storage.DiskByUUIDPath = byUUID
+	storage.DiskByPartUUIDPath = path.Join(dir, "nonexistent")
+
+	devices := []storage.BlockDev{{Name: "sda"}, {Name: "sda1"}, {Name: "sda2"}}
+	restricted := restrictToUUID(devices, "0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0")
+	require.Equal(t, []storage.BlockDev{{Name: "sda2"}}, restricted)
+	entries := bootscan.Scan(path.Join(dir, restricted[0].Name), bootscan.Options{})
+	require.Equal(t, 1, len(entries))
+	require.Equal(t, "Linux", entries[0].Name)
+	require.Equal(t, path.Join(sda2, "vmlinuz"), entries[0].Kernel)
+
+	// an unknown UUID falls back to all the devices
+	require.Equal(t, devices, restrictToUUID(devices, "c0ffee00-0000-4000-8000-000000000001"))
+}
+
+func TestRestrictToLabel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bootdevice")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	// sdb1 stands for both the device node and, once mounted, its contents
+	sdb1 := path.Join(dir, "sdb1")
+	writeTestFile(t, sdb1, "boot/grub2/grub.cfg", `
+menuentry 'Rescue' {
+	linux /vmlinuz-rescue root=LABEL=Rescue
+}
+`)
+	byLabel := path.Join(dir, "by-label")
+	require.NoError(t, os.Mkdir(byLabel, 0755))
+	// as created by udev for a label with a space
+	require.NoError(t, os.Symlink("../sdb1", path.Join(byLabel, `Boot\x20Disk`)))
+	defer func(p string) { storage.DiskByLabelPath = p }(storage.DiskByLabelPath)
+	storage.DiskByLabelPath = byLabel
+
+	devices := []storage.BlockDev{{Name: "sda1"}, {Name: "sdb"}, {Name: "sdb1"}}
+	restricted := restrictToLabel(devices, "Boot Disk")
+	require.Equal(t, []storage.BlockDev{{Name: "sdb1"}}, restricted)
+	entries := bootscan.Scan(path.Join(dir, restricted[0].Name), bootscan.Options{})
+	require.Equal(t, 1, len(entries))
+	require.Equal(t, "Rescue", entries[0].Name)
+	require.Equal(t, path.Join(sdb1, "vmlinuz-rescue"), entries[0].Kernel)
+
+	// an unknown label falls back to all the devices
+	require.Equal(t, devices, restrictToLabel(devices, "No Such Disk"))
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/systemboot/systemboot/pkg/bootconfig"
+	"github.com/systemboot/systemboot/pkg/storage"
+)
+
+// bootFilePartitionsTimeout is how long to wait for the partitions of a disk
+// image to show up once it is set up on a loop device.
+const bootFilePartitionsTimeout = 3 * time.Second
+
+// bootFileDevices sets up a loop device over the disk image file set with
+// bootconfig.BootFileArg, and returns the block devices of the image, i.e.
+// the loop device and its partitions, so that only they are scanned. detach
+// must be called once they are unmounted.
+func bootFileDevices(file string) (devices []storage.BlockDev, detach func(), err error) {
+	name, err := storage.AttachLoop(file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot set up a loop device for %s=%s: %v", bootconfig.BootFileArg, file, err)
+	}
+	detach = func() {
+		if err := storage.DetachLoop(name); err != nil {
+			log.Printf("Cannot detach %s: %v", name, err)
+		}
+	}
+	devices, err = storage.WaitLoopDevices(name, bootFilePartitionsTimeout)
+	if err != nil {
+		detach()
+		return nil, nil, err
+	}
+	log.Printf("Only scanning the image %s on /dev/%s, as set with %s, %d block devices", file, name, bootconfig.BootFileArg, len(devices))
+	return devices, detach, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/systemboot/systemboot/pkg/storage"
+)
+
+// writeDiskImage writes a disk image with an MBR partition table and a single
+// ext4 partition, populated with the files under root.
+func writeDiskImage(t *testing.T, image, root string) {
+	const (
+		sectorSize = 512
+		start      = 2048
+		sectors    = 8192
+	)
+	mbr := make([]byte, sectorSize)
+	entry := mbr[446:462]
+	entry[4] = 0x83 // Linux
+	binary.LittleEndian.PutUint32(entry[8:], start)
+	binary.LittleEndian.PutUint32(entry[12:], sectors)
+	mbr[510], mbr[511] = 0x55, 0xaa
+	require.NoError(t, ioutil.WriteFile(image, mbr, 0644))
+	require.NoError(t, os.Truncate(image, (start+sectors)*sectorSize))
+	run(t, "mke2fs", "-q", "-F", "-t", "ext4", "-d", root, "-E", "offset=1048576", image, "4M")
+}
+
+// bootFileIntegration skips the test unless it can set up loop devices over
+// disk images, and returns a directory with the files of a partition with a
+// grub.cfg in root.
+func bootFileIntegration(t *testing.T) (dir, root string) {
+	if os.Getuid() != 0 {
+		t.Skip("this test requires root")
+	}
+	if _, err := exec.LookPath("mke2fs"); err != nil {
+		t.Skip("this test requires mke2fs")
+	}
+	dir, err := ioutil.TempDir("", "bootfile")
+	require.NoError(t, err)
+	root = path.Join(dir, "root")
+	writeTestFile(t, root, "boot/vmlinuz", "kernel")
+	writeTestFile(t, root, "boot/grub2/grub.cfg", `
+menuentry 'Linux' {
+	linux /boot/vmlinuz root=/dev/sda1
+}
+`)
+	return dir, root
+}
+
+// requireGrubConfig requires that the device has a grub config.
+func requireGrubConfig(t *testing.T, dev storage.BlockDev, baseMountpoint string) {
+	filesystems, err := storage.GetSupportedFilesystems()
+	require.NoError(t, err)
+	info := inspectDevice(dev, filesystems, baseMountpoint)
+	require.True(t, info.Mounted)
+	require.Equal(t, map[string]int{"grub2": 1}, info.Configs)
+}
+
+// TestBootFileIntegration sets up a disk image file on a loop device and
+// scans its partition.
+func TestBootFileIntegration(t *testing.T) {
+	dir, root := bootFileIntegration(t)
+	defer os.RemoveAll(dir)
+	image := path.Join(dir, "disk.img")
+	writeDiskImage(t, image, root)
+
+	devices, detach, err := bootFileDevices(image)
+	if err != nil {
+		t.Skipf("cannot set up a loop device: %v", err)
+	}
+	defer detach()
+	if len(devices) == 1 {
+		t.Skip("the kernel does not scan the partitions of loop devices")
+	}
+	require.Len(t, devices, 2)
+	require.Equal(t, devices[0].Name+"p1", devices[1].Name)
+	requireGrubConfig(t, devices[1], path.Join(dir, "mnt"))
+}
+
+// TestBootFileIntegrationNoPartitionTable scans an image file that is a file
+// system, without partition table.
+func TestBootFileIntegrationNoPartitionTable(t *testing.T) {
+	dir, root := bootFileIntegration(t)
+	defer os.RemoveAll(dir)
+	image := path.Join(dir, "fs.img")
+	run(t, "mke2fs", "-q", "-F", "-t", "ext4", "-d", root, image, "4M")
+
+	devices, detach, err := bootFileDevices(image)
+	if err != nil {
+		t.Skipf("cannot set up a loop device: %v", err)
+	}
+	defer detach()
+	require.Len(t, devices, 1)
+	requireGrubConfig(t, devices[0], path.Join(dir, "mnt"))
+}
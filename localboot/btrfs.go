@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/systemboot/systemboot/pkg/storage"
+)
+
+// parseBtrfsDefaultSubvol parses the output of `btrfs subvolume get-default`,
+// e.g. "ID 256 gen 10 top level 5 path @", and returns the path of the
+// default subvolume relative to the top-level subvolume. The top-level
+// subvolume itself ("ID 5 (FS_TREE)") is returned as an empty string.
+func parseBtrfsDefaultSubvol(out string) (string, error) {
+	out = strings.TrimSpace(out)
+	if strings.HasPrefix(out, "ID 5 ") || out == "ID 5" {
+		return "", nil
+	}
+	idx := strings.Index(out, " path ")
+	if idx == -1 {
+		return "", fmt.Errorf("cannot parse default subvolume from %q", out)
+	}
+	subvol := strings.TrimSpace(out[idx+len(" path "):])
+	// older btrfs-progs prefix paths with <FS_TREE>/
+	subvol = strings.TrimPrefix(subvol, "<FS_TREE>/")
+	return subvol, nil
+}
+
+// btrfsDefaultSubvol returns the default subvolume of the btrfs file system
+// mounted on mountpath. It is a variable so it can be overridden for testing.
+var btrfsDefaultSubvol = func(mountpath string) (string, error) {
+	out, err := exec.Command("btrfs", "subvolume", "get-default", mountpath).Output()
+	if err != nil {
+		return "", fmt.Errorf("btrfs subvolume get-default failed: %v", err)
+	}
+	return parseBtrfsDefaultSubvol(string(out))
+}
+
+// btrfsTopLevelSuffix is appended to the mount path of a btrfs file system to
+// get the one of its top-level subvolume.
+const btrfsTopLevelSuffix = "-toplevel"
+
+// mountBtrfsTopLevel mounts the top-level subvolume of the btrfs file system
+// mounted on the given mount point, making every subvolume reachable. The
+// default subvolume stays mounted on the given mount point, the top-level one
+// is mounted next to it. It returns the mount point of the top-level
+// subvolume, which is the given one if it is the default subvolume, and the
+// path of the default subvolume.
+func mountBtrfsTopLevel(mountpoint storage.Mountpoint) (*storage.Mountpoint, string, error) {
+	defaultSubvol, err := btrfsDefaultSubvol(mountpoint.Path)
+	if err != nil {
+		return nil, "", err
+	}
+	debug("Default subvolume of %s is %q", mountpoint.DeviceName, defaultSubvol)
+	if defaultSubvol == "" {
+		// the top-level subvolume is already mounted
+		return &mountpoint, "", nil
+	}
+	mp, err := storage.MountWithOptions(mountpoint.DeviceName, mountpoint.Path+btrfsTopLevelSuffix, []string{"btrfs"}, "subvolid=5")
+	if err != nil {
+		return nil, "", err
+	}
+	return mp, defaultSubvol, nil
+}
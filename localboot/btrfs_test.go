@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/systemboot/systemboot/pkg/bootscan"
+	"github.com/systemboot/systemboot/pkg/storage"
+)
+
+func TestParseBtrfsDefaultSubvol(t *testing.T) {
+	for out, expected := range map[string]string{
+		"ID 5 (FS_TREE)\n":                                         "",
+		"ID 256 gen 10 top level 5 path @\n":                       "@",
+		"ID 259 gen 42 top level 258 path @/.snapshots/1/snapshot": "@/.snapshots/1/snapshot",
+		"ID 256 gen 10 top level 5 path <FS_TREE>/@":               "@",
+	} {
+		subvol, err := parseBtrfsDefaultSubvol(out)
+		require.NoError(t, err)
+		require.Equal(t, expected, subvol)
+	}
+	_, err := parseBtrfsDefaultSubvol("ERROR: not a btrfs filesystem")
+	require.Error(t, err)
+}
+
+var btrfsGrubCfg = `
+set btrfs_relative_path="y"
+menuentry 'openSUSE' {
+	linux /boot/vmlinuz root=UUID=abcd
+	initrd /boot/initrd
+}
+menuentry 'openSUSE snapshot 2' {
+	linux /boot/vmlinuz root=UUID=abcd rootflags=subvol=@/.snapshots/2/snapshot
+	initrd /boot/initrd
+}
+`
+
+// writeTestFile writes a file for the integration test, creating the parent
+// directories.
+func writeTestFile(t *testing.T, dir, relpath, content string) {
+	fullpath := path.Join(dir, relpath)
+	require.NoError(t, os.MkdirAll(path.Dir(fullpath), 0755))
+	require.NoError(t, ioutil.WriteFile(fullpath, []byte(content), 0644))
+}
+
+// run runs a command for the integration test, failing the test on error.
+func run(t *testing.T, name string, args ...string) string {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	require.NoError(t, err, "%s %v: %s", name, args, out)
+	return strings.TrimSpace(string(out))
+}
+
+// TestBtrfsIntegration creates a btrfs image with an openSUSE-like subvolume
+// layout, and checks that the kernels are found in the right subvolumes.
+func TestBtrfsIntegration(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("this test requires root")
+	}
+	for _, tool := range []string{"mkfs.btrfs", "btrfs", "losetup"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("this test requires %s", tool)
+		}
+	}
+	dir, err := ioutil.TempDir("", "btrfs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// build the image
+	image := path.Join(dir, "btrfs.img")
+	require.NoError(t, ioutil.WriteFile(image, nil, 0644))
+	require.NoError(t, os.Truncate(image, 128<<20))
+	run(t, "mkfs.btrfs", "-q", image)
+	loopdev := run(t, "losetup", "-f", "--show", image)
+	defer run(t, "losetup", "-d", loopdev)
+	setup := path.Join(dir, "setup")
+	require.NoError(t, os.Mkdir(setup, 0755))
+	require.NoError(t, syscall.Mount(loopdev, setup, "btrfs", 0, ""))
+	run(t, "btrfs", "subvolume", "create", path.Join(setup, "@"))
+	require.NoError(t, os.MkdirAll(path.Join(setup, "@/.snapshots/1"), 0755))
+	require.NoError(t, os.MkdirAll(path.Join(setup, "@/.snapshots/2"), 0755))
+	for _, snapshot := range []string{"1", "2"} {
+		subvol := path.Join(setup, "@/.snapshots", snapshot, "snapshot")
+		run(t, "btrfs", "subvolume", "create", subvol)
+		writeTestFile(t, subvol, "boot/vmlinuz", "kernel "+snapshot)
+		writeTestFile(t, subvol, "boot/initrd", "initrd "+snapshot)
+	}
+	defaultSubvol := path.Join(setup, "@/.snapshots/1/snapshot")
+	writeTestFile(t, defaultSubvol, "boot/grub2/grub.cfg", btrfsGrubCfg)
+	run(t, "btrfs", "subvolume", "set-default", defaultSubvol)
+	require.NoError(t, syscall.Unmount(setup, 0))
+
+	// mount it like localboot does, i.e. the default subvolume first
+	mountpath := path.Join(dir, "mnt")
+	mp, err := storage.Mount(loopdev, mountpath, []string{"btrfs"})
+	require.NoError(t, err)
+	defer syscall.Unmount(mp.Path, 0)
+	topLevel, subvol, err := mountBtrfsTopLevel(*mp)
+	require.NoError(t, err)
+	defer syscall.Unmount(topLevel.Path, 0)
+	require.Equal(t, "@/.snapshots/1/snapshot", subvol)
+	// the default subvolume is still mounted
+	require.NotEqual(t, mp.Path, topLevel.Path)
+	_, err = os.Stat(path.Join(mp.Path, "boot/grub2/grub.cfg"))
+	require.NoError(t, err)
+
+	cfgs := bootscan.ScanBtrfs(topLevel.Path, subvol, bootscan.Options{})
+	require.Equal(t, 2, len(cfgs))
+	for idx, snapshot := range []string{"1", "2"} {
+		kernel, err := ioutil.ReadFile(cfgs[idx].Kernel)
+		require.NoError(t, err)
+		require.Equal(t, "kernel "+snapshot, string(kernel))
+		initrd, err := ioutil.ReadFile(cfgs[idx].Initramfs)
+		require.NoError(t, err)
+		require.Equal(t, "initrd "+snapshot, string(initrd))
+	}
+}
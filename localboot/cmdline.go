@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path"
+
+	"github.com/systemboot/systemboot/pkg/bootconfig"
+	"github.com/systemboot/systemboot/pkg/storage"
+)
+
+// BootCmdlineMode boots the boot configuration set on the running kernel's
+// command line, see bootconfig.ReadCmdlineConfig, without scanning for any.
+// Its kernel and initrd are downloaded if they are URLs, or else read from its
+// device, mounted under baseMountpoint, if any.
+func BootCmdlineMode(cc *bootconfig.CmdlineConfig, baseMountpoint string, dryrun bool) error {
+	var cfg *bootconfig.BootConfig
+	if cc.IsRemote() {
+		tempDir, err := ioutil.TempDir(os.TempDir(), "cmdline")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tempDir)
+		base, err := url.Parse(cc.Kernel)
+		if err != nil {
+			return err
+		}
+		if cfg, err = cc.RemoteConfig().Download(newFetcher(), base, tempDir); err != nil {
+			return fmt.Errorf("cannot download the kernel set on the command line: %v", err)
+		}
+	} else {
+		basedir := "/"
+		if cc.Device != "" {
+			filesystems, err := storage.GetSupportedFilesystems()
+			if err != nil {
+				return err
+			}
+			mountpath := path.Join(baseMountpoint, path.Base(cc.Device))
+			mountpoint, err := storage.Mount(cc.Device, mountpath, filesystems)
+			if err != nil {
+				return fmt.Errorf("cannot mount %s on %s: %v", cc.Device, mountpath, err)
+			}
+			basedir = mountpoint.Path
+		}
+		cfg = cc.BootConfig(basedir)
+	}
+	if *flagAddConsoles {
+		addConsoles(cfg, bootconfig.DetectConsoles())
+	}
+	allowed := applyPolicy([]bootconfig.BootConfig{*cfg})
+	if len(allowed) == 0 {
+		return fmt.Errorf("the boot policy does not allow booting kernel %s", cfg.Kernel)
+	}
+	*cfg = allowed[0]
+	if err := cfg.ExpandTemplate(templateVars(cc.Device), *flagStrictTemplate); err != nil {
+		return err
+	}
+	debug("Trying boot configuration %+v", *cfg)
+	if dryrun {
+		log.Printf("Dry-run, will not actually boot %+v", *cfg)
+		return nil
+	}
+	if err := bootVerified(*cfg); err != nil {
+		return fmt.Errorf("Failed to boot kernel %s: %v", cfg.Kernel, err)
+	}
+	return nil
+}
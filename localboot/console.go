@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+
+	"github.com/systemboot/systemboot/pkg/bootconfig"
+	"github.com/systemboot/systemboot/pkg/crypto"
+	"github.com/systemboot/systemboot/pkg/storage"
+)
+
+// BootConsoleMode reads a boot configuration pasted on the console and boots
+// it. If pubkeyfile is not empty, the configuration must be signed with the
+// matching private key. This is meant for the bringup of boards that have
+// neither network nor a bootable disk yet. The kernel, initramfs and device
+// tree are read from the device set in the configuration, mounted under
+// baseMountpoint, if any, or else from the initramfs.
+func BootConsoleMode(pubkeyfile, baseMountpoint string, dryrun bool) error {
+	var pubkey []byte
+	if pubkeyfile != "" {
+		var err error
+		if pubkey, err = crypto.LoadPublicKeyFromFile(pubkeyfile); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Paste a boot configuration in JSON format, or in base64 format if signed, followed by an empty line (%v timeout):\n", *flagConsoleTimeout)
+	cfg, err := bootconfig.ReadConsoleConfig(os.Stdin, *flagConsoleMaxSize, *flagConsoleTimeout, pubkey)
+	if err != nil {
+		return err
+	}
+	if cfg.Device != "" {
+		filesystems, err := storage.GetSupportedFilesystems()
+		if err != nil {
+			return err
+		}
+		mountpath := path.Join(baseMountpoint, path.Base(cfg.Device))
+		mountpoint, err := storage.Mount(cfg.Device, mountpath, filesystems)
+		if err != nil {
+			return fmt.Errorf("cannot mount %s on %s: %v", cfg.Device, mountpath, err)
+		}
+		resolveOnDevice(cfg, mountpoint.Path)
+	}
+	allowed := applyPolicy([]bootconfig.BootConfig{*cfg})
+	if len(allowed) == 0 {
+		return fmt.Errorf("the boot policy does not allow booting kernel %s", cfg.Kernel)
+	}
+	cfg = &allowed[0]
+	if *flagAddConsoles {
+		addConsoles(cfg, bootconfig.DetectConsoles())
+	}
+	if err := cfg.ExpandTemplate(templateVars(cfg.Device), *flagStrictTemplate); err != nil {
+		return err
+	}
+	debug("Trying boot configuration %+v", *cfg)
+	if dryrun {
+		log.Printf("Dry-run, will not actually boot %+v", *cfg)
+		return nil
+	}
+	if err := bootVerified(*cfg); err != nil {
+		return fmt.Errorf("Failed to boot kernel %s: %v", cfg.Kernel, err)
+	}
+	return nil
+}
+
+// resolveOnDevice makes the kernel, initramfs and device tree paths of a boot
+// configuration relative to the mount point of its device. They cannot point
+// outside of it, e.g. with "..".
+func resolveOnDevice(cfg *bootconfig.BootConfig, mountpath string) {
+	for _, p := range []*string{&cfg.Kernel, &cfg.Initramfs, &cfg.DeviceTree} {
+		if *p != "" {
+			*p = path.Join(mountpath, path.Clean("/"+*p))
+		}
+	}
+}
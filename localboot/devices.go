@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/systemboot/systemboot/pkg/bootscan"
+	"github.com/systemboot/systemboot/pkg/storage"
+)
+
+// deviceInfo is a row of the -list-devices table.
+type deviceInfo struct {
+	Name   string
+	FsType string
+	Label  string
+	UUID   string
+	Size   uint64
+	// Mounted is false if the device could not be mounted, so it was not
+	// scanned for boot configurations
+	Mounted bool
+	// Configs is the number of boot configurations found on the device, by
+	// config format
+	Configs map[string]int
+}
+
+// formatSize returns a size in bytes in a human readable form, e.g. 1.5G.
+func formatSize(size uint64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := uint64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%c", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// configsSummary returns the number of boot configurations of a device, with
+// their config formats, e.g. `3 (bls, grub2)`.
+func (d deviceInfo) configsSummary() string {
+	if !d.Mounted {
+		return "-"
+	}
+	total := 0
+	formats := make([]string, 0, len(d.Configs))
+	for format, count := range d.Configs {
+		total += count
+		formats = append(formats, format)
+	}
+	if total == 0 {
+		return "none"
+	}
+	sort.Strings(formats)
+	return fmt.Sprintf("%d (%s)", total, strings.Join(formats, ", "))
+}
+
+// writeDeviceTable writes the devices as a table, one device per line.
+func writeDeviceTable(w io.Writer, devices []deviceInfo) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "DEVICE\tFSTYPE\tLABEL\tUUID\tSIZE\tBOOT CONFIGS")
+	orDash := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+	for _, d := range devices {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", d.Name, orDash(d.FsType), orDash(d.Label), orDash(d.UUID), formatSize(d.Size), d.configsSummary())
+	}
+	return tw.Flush()
+}
+
+// inspectDevice collects the information of a block device for
+// -list-devices, mounting it read-only to scan it for boot configurations.
+func inspectDevice(dev storage.BlockDev, filesystems []string, baseMountpoint string) deviceInfo {
+	devname := path.Join("/dev", dev.Name)
+	info := deviceInfo{Name: devname}
+	var err error
+	if info.Size, err = storage.DeviceSize(dev.Name); err != nil {
+		debug("Cannot read the size of %s: %v", devname, err)
+	}
+	if info.FsType, err = storage.DetectFilesystem(devname); err != nil {
+		debug("Cannot detect the file system of %s: %v", devname, err)
+	}
+	if isLUKS, _ := storage.IsLUKS(devname); isLUKS {
+		// not unlocked just to list it
+		info.FsType = "crypto_LUKS"
+	}
+	// a device without file system has no UUID or label, and blkid would
+	// only fail on it
+	if info.FsType == "" {
+		return info
+	}
+	info.UUID, _ = storage.DeviceUUID(devname)
+	info.Label, _ = storage.DeviceLabel(devname)
+	if info.FsType == "crypto_LUKS" {
+		return info
+	}
+	mountpoint, err := storage.Mount(devname, path.Join(baseMountpoint, dev.Name), filesystems)
+	if err != nil {
+		debug("Cannot mount %s: %v", devname, err)
+		return info
+	}
+	defer func() {
+		if err := storage.UnmountVerified(*mountpoint); err != nil {
+			debug("Not cleanly unmounted: %v", err)
+		}
+	}()
+	// a kernel file system may have a more precise name, e.g. ext4 for a
+	// detected ext2
+	info.FsType = mountpoint.FsType
+	info.Mounted = true
+	info.Configs = make(map[string]int)
+	opts := bootscan.Options{Debugf: debug, Disabled: disabledScanners}
+	for _, entry := range bootscan.Scan(mountpoint.Path, opts) {
+		info.Configs[entry.Format]++
+	}
+	return info
+}
+
+// ListDevices prints a table of the block devices, with their file system,
+// label, UUID, size and the boot configurations found on them, without
+// booting anything.
+func ListDevices(devices []storage.BlockDev, baseMountpoint string) error {
+	filesystems, err := storage.GetSupportedFilesystems()
+	if err != nil {
+		return err
+	}
+	infos := make([]deviceInfo, 0, len(devices))
+	for _, dev := range devices {
+		infos = append(infos, inspectDevice(dev, filesystems, baseMountpoint))
+	}
+	return writeDeviceTable(os.Stdout, infos)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatSize(t *testing.T) {
+	for size, expected := range map[uint64]string{
+		0:                    "0B",
+		512:                  "512B",
+		1536:                 "1.5K",
+		512 << 20:            "512.0M",
+		256060514304:         "238.5G",
+		3 << 40:              "3.0T",
+		18446744073709551615: "16.0E",
+	} {
+		require.Equal(t, expected, formatSize(size))
+	}
+}
+
+func TestWriteDeviceTable(t *testing.T) {
+	devices := []deviceInfo{
+		{Name: "/dev/sda", Size: 256060514304},
+		{Name: "/dev/sda1", FsType: "vfat", Label: "EFI", UUID: "1234-ABCD", Size: 512 << 20, Mounted: true, Configs: map[string]int{"grub2": 2, "bls": 1}},
+		{Name: "/dev/sda2", FsType: "ext4", UUID: "0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0", Size: 200 << 30, Mounted: true, Configs: map[string]int{}},
+		{Name: "/dev/sda3", FsType: "crypto_LUKS", UUID: "c0ffee00-0000-4000-8000-000000000001", Size: 32 << 30},
+	}
+	var out bytes.Buffer
+	require.NoError(t, writeDeviceTable(&out, devices))
+	expected := `DEVICE     FSTYPE       LABEL  UUID                                  SIZE    BOOT CONFIGS
+/dev/sda   -            -      -                                     238.5G  -
+/dev/sda1  vfat         EFI    1234-ABCD                             512.0M  3 (bls, grub2)
+/dev/sda2  ext4         -      0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0  200.0G  none
+/dev/sda3  crypto_LUKS  -      c0ffee00-0000-4000-8000-000000000001  32.0G   -
+`
+	require.Equal(t, expected, out.String())
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/systemboot/systemboot/pkg/storage"
+)
+
+// loopback mounts the images of GRUB loopback devices with -loopback. It is
+// nil otherwise.
+var loopback *loopImages
+
+// loopImage is the image of a GRUB loopback device, and the mount point
+// reserved for it.
+type loopImage struct {
+	image     string
+	mountpath string
+	mounted   bool
+}
+
+// loopImages is an ImageMounter that mounts images read-only on loop devices,
+// under baseMountpoint/loop. MountImage only reserves a mount point, so that
+// the paths of the boot configurations can be resolved without holding a
+// loop device for every image: an image is only mounted by mountFor, once a
+// boot configuration using it is checked or booted. unmountAll releases them.
+type loopImages struct {
+	baseMountpoint string
+	images         []*loopImage
+	mounts         []storage.Mountpoint
+	loops          []string
+}
+
+func newLoopImages(baseMountpoint string) *loopImages {
+	return &loopImages{baseMountpoint: baseMountpoint}
+}
+
+// MountImage reserves the mount point of an image, see mountFor.
+func (l *loopImages) MountImage(image string) (string, error) {
+	mountpath := path.Join(l.baseMountpoint, "loop", strconv.Itoa(len(l.images)))
+	l.images = append(l.images, &loopImage{image: image, mountpath: mountpath})
+	return mountpath, nil
+}
+
+// mountLoopImage sets up a loop device over an image and mounts it read-only
+// on mountpath. It is a variable so it can be overridden for testing.
+var mountLoopImage = func(image, mountpath string) (mountpoint *storage.Mountpoint, loop string, err error) {
+	filesystems, err := storage.GetSupportedFilesystems()
+	if err != nil {
+		return nil, "", err
+	}
+	loop, err = storage.AttachLoop(image)
+	if err != nil {
+		return nil, "", err
+	}
+	mountpoint, err = storage.Mount("/dev/"+loop, mountpath, filesystems)
+	if err != nil {
+		if err := storage.DetachLoop(loop); err != nil {
+			log.Printf("Cannot detach %s: %v", loop, err)
+		}
+		return nil, "", err
+	}
+	return mountpoint, loop, nil
+}
+
+// mountFor mounts the images the given files are on, if they are not mounted
+// yet, along with the images these images are on. Empty paths are ignored.
+func (l *loopImages) mountFor(files ...string) error {
+	if l == nil {
+		return nil
+	}
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+		for _, img := range l.images {
+			if file != img.mountpath && !strings.HasPrefix(file, img.mountpath+"/") {
+				continue
+			}
+			if img.mounted {
+				break
+			}
+			if err := l.mountFor(img.image); err != nil {
+				return err
+			}
+			mountpoint, loop, err := mountLoopImage(img.image, img.mountpath)
+			if err != nil {
+				return fmt.Errorf("cannot mount image %s: %v", img.image, err)
+			}
+			log.Printf("Mounted image %s on %s", img.image, img.mountpath)
+			img.mounted = true
+			l.mounts = append(l.mounts, *mountpoint)
+			l.loops = append(l.loops, loop)
+			break
+		}
+	}
+	return nil
+}
+
+// unmountAll unmounts the mounted images, nested ones first, and detaches
+// their loop devices.
+func (l *loopImages) unmountAll() {
+	if l == nil {
+		return
+	}
+	for _, err := range storage.UnmountAll(l.mounts) {
+		log.Printf("Not cleanly unmounted: %v", err)
+	}
+	for idx := len(l.loops) - 1; idx >= 0; idx-- {
+		if err := storage.DetachLoop(l.loops[idx]); err != nil {
+			log.Printf("Cannot detach %s: %v", l.loops[idx], err)
+		}
+	}
+	for _, img := range l.images {
+		img.mounted = false
+	}
+	l.mounts, l.loops = nil, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/systemboot/systemboot/pkg/storage"
+)
+
+func TestLoopImagesMountLazily(t *testing.T) {
+	defer func(orig func(string, string) (*storage.Mountpoint, string, error)) { mountLoopImage = orig }(mountLoopImage)
+	var mounted []string
+	mountLoopImage = func(image, mountpath string) (*storage.Mountpoint, string, error) {
+		mounted = append(mounted, image+" on "+mountpath)
+		return &storage.Mountpoint{Path: mountpath}, "loop" + mountpath[len(mountpath)-1:], nil
+	}
+	l := newLoopImages("/mnt")
+	iso, err := l.MountImage("/mnt/sda1/live.iso")
+	require.NoError(t, err)
+	require.Equal(t, "/mnt/loop/0", iso)
+	squashfs, err := l.MountImage(iso + "/rootfs.squashfs")
+	require.NoError(t, err)
+	require.Equal(t, "/mnt/loop/1", squashfs)
+	_, err = l.MountImage("/mnt/sda1/other.iso")
+	require.NoError(t, err)
+	require.Empty(t, mounted)
+
+	// the images a kernel is on are mounted, outermost first, only once
+	require.NoError(t, l.mountFor(squashfs+"/vmlinuz", "", squashfs+"/initrd"))
+	require.NoError(t, l.mountFor(iso+"/vmlinuz"))
+	require.NoError(t, l.mountFor("/mnt/sda1/vmlinuz"))
+	require.Equal(t, []string{
+		"/mnt/sda1/live.iso on /mnt/loop/0",
+		"/mnt/loop/0/rootfs.squashfs on /mnt/loop/1",
+	}, mounted)
+	require.Equal(t, []string{"loop0", "loop1"}, l.loops)
+
+	// without -loopback, nothing is mounted
+	var none *loopImages
+	require.NoError(t, none.mountFor(squashfs+"/vmlinuz"))
+}
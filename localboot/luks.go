@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/systemboot/systemboot/pkg/crypto"
+	"github.com/systemboot/systemboot/pkg/storage"
+)
+
+// parsePCRList parses a comma-separated list of PCR indices.
+func parsePCRList(list string) ([]int, error) {
+	var pcrs []int
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		pcr, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, err
+		}
+		pcrs = append(pcrs, pcr)
+	}
+	return pcrs, nil
+}
+
+// unlockAndMountLUKS unlocks the given LUKS devices and mounts them. The
+// passphrase is unsealed from the TPM if a sealed key is found on any of the
+// already mounted partitions (typically the ESP), otherwise it is asked to the
+// operator.
+func unlockAndMountLUKS(devnames []string, mounted []storage.Mountpoint, filesystems []string, baseMountpoint string) []storage.Mountpoint {
+	var unsealer storage.Unsealer
+	if prefix, err := storage.FindSealedKey(mounted); err != nil {
+		log.Printf("%v, the LUKS passphrase will be asked", err)
+	} else {
+		pcrs, err := parsePCRList(*flagLUKSPCRs)
+		if err != nil {
+			log.Printf("Invalid PCR list %q: %v", *flagLUKSPCRs, err)
+		} else {
+			debug("Using TPM-sealed LUKS key %s bound to PCRs %v", prefix, pcrs)
+			unsealer = crypto.TPM2ToolsUnsealer{KeyPrefix: prefix, PCRs: pcrs}
+		}
+	}
+	var unlocked []storage.Mountpoint
+	for _, devname := range devnames {
+		mapped, err := storage.UnlockLUKS(devname, unsealer, storage.CryptsetupUnlocker{}, storage.StdinPassphrasePrompter)
+		if err != nil {
+			log.Printf("Cannot unlock LUKS device %s: %v", devname, err)
+			continue
+		}
+		mountpath := path.Join(baseMountpoint, path.Base(mapped))
+		mountpoint, err := storage.Mount(mapped, mountpath, filesystems)
+		if err != nil {
+			debug("Failed to mount %s on %s: %v", mapped, mountpath, err)
+			continue
+		}
+		unlocked = append(unlocked, *mountpoint)
+	}
+	return unlocked
+}
@@ -1,14 +1,27 @@
 package main
 
 import (
+	"crypto/x509"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"os"
 	"path"
-	"syscall"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/systemboot/systemboot/pkg/bootconfig"
+	"github.com/systemboot/systemboot/pkg/bootscan"
+	"github.com/systemboot/systemboot/pkg/crypto"
+	"github.com/systemboot/systemboot/pkg/menu"
+	"github.com/systemboot/systemboot/pkg/policy"
+	"github.com/systemboot/systemboot/pkg/remotelog"
+	"github.com/systemboot/systemboot/pkg/safemode"
 	"github.com/systemboot/systemboot/pkg/storage"
 )
 
@@ -16,18 +29,331 @@ import (
 // TODO use a proper parser for grub config (see grub.go)
 
 var (
-	flagBaseMountPoint = flag.String("m", "/mnt", "Base mount point where to mount partitions")
-	flagDryRun         = flag.Bool("dryrun", false, "Do not actually kexec into the boot config")
-	flagDebug          = flag.Bool("d", false, "Print debug output")
-	flagGrubMode       = flag.Bool("grub", false, "Use GRUB mode, i.e. look for valid Grub/Grub2 configuration in default locations to boot a kernel. GRUB mode ignores -kernel/-initramfs/-cmdline")
-	flagKernelPath     = flag.String("kernel", "", "Specify the path of the kernel to execute. If using -grub, this argument is ignored")
-	flagInitramfsPath  = flag.String("initramfs", "", "Specify the path of the initramfs to load. If using -grub, this argument is ignored")
-	flagKernelCmdline  = flag.String("cmdline", "", "Specify the kernel command line. If using -grub, this argument is ignored")
-	flagDeviceGUID     = flag.String("guid", "", "GUID of the device where the kernel (and optionally initramfs) are located. Ignored if -grub is set or if -kernel is not specified")
+	flagBaseMountPoint   = flag.String("m", "/mnt", "Base mount point where to mount partitions")
+	flagDryRun           = flag.Bool("dryrun", false, "Do not actually kexec into the boot config")
+	flagSafeMode         = flag.Bool("safe-mode", false, "Only scan and report the boot menu, for forensic or recovery use: implies -dryrun, and refuses any disk write, read-write mount, boot counter update or kexec")
+	flagBootReport       = flag.Bool("boot-report", false, "In GRUB mode, write a JSON report of the booted entry, the entries that failed to boot before it, what was measured and the timings to EFI/systemboot/report.json on the ESP, or else to etc/systemboot/report.json on the partition of the booted entry, right before the kexec")
+	flagKexecRetries     = flag.Int("kexec-retries", bootconfig.DefaultKexecLoadRetries, "How many times to retry loading a kernel with kexec_file_load when it fails with EBUSY or ENOMEM, which can be transient, e.g. because of memory fragmentation. Other errors are not retried")
+	flagDebug            = flag.Bool("d", false, "Print debug output")
+	flagGrubDebug        = flag.Bool("grub-debug", false, "Trace the parsing of the GRUB configs that set the debug variable, e.g. with \"set debug=all\", from that line to the end of the file")
+	flagGrubMode         = flag.Bool("grub", false, "Use GRUB mode, i.e. look for valid Grub/Grub2 configuration in default locations to boot a kernel. GRUB mode ignores -kernel/-initramfs/-cmdline")
+	flagKernelPath       = flag.String("kernel", "", "Specify the path of the kernel to execute. If using -grub, this argument is ignored")
+	flagInitramfsPath    = flag.String("initramfs", "", "Specify the path of the initramfs to load. If using -grub, this argument is ignored")
+	flagKernelCmdline    = flag.String("cmdline", "", "Specify the kernel command line. If using -grub, this argument is ignored")
+	flagDeviceGUID       = flag.String("guid", "", "GUID of the device where the kernel (and optionally initramfs) are located. Ignored if -grub is set or if -kernel is not specified")
+	flagRecursive        = flag.Bool("recursive", false, "In GRUB mode, look for boot configurations anywhere on the partitions instead of only in the default locations")
+	flagLUKSPCRs         = flag.String("luks-pcrs", "7", "Comma-separated list of SHA256 PCRs the TPM-sealed LUKS key is bound to")
+	flagMaxDepth         = flag.Int("maxdepth", bootscan.DefaultMaxScanDepth, "Maximum directory depth to look for boot configurations when using -recursive")
+	flagDedupByContent   = flag.Bool("dedup-by-content", false, "Merge boot configurations whose kernel and initramfs have the same content, even if found at different paths. This reads every kernel and initramfs in full")
+	flagSortByVersion    = flag.Bool("sort-by-version", false, "In GRUB mode, boot the newest kernel first, by the kernel release read from the bzImage header, or from the kernel file name if the header is not readable")
+	flagAllArchs         = flag.Bool("all-archs", false, "In GRUB mode, also show and try the boot configurations whose kernel is for another architecture than the running one, as read from the kernel image header")
+	flagFilterFirmware   = flag.Bool("filter-firmware", false, "In GRUB mode, hide the boot configurations meant for another firmware type than the running one, e.g. the linux16 entries of a BIOS boot loader when running under UEFI. LinuxBoot firmware looks like BIOS to this check")
+	flagShowSnapshots    = flag.Bool("show-snapshots", false, "In GRUB mode, also show and try the boot configurations of btrfs snapshots, e.g. the ones added by grub-btrfs, which otherwise only differ from the live one by the rootflags=subvol= kernel argument")
+	flagMenu             = flag.Bool("menu", false, "In GRUB mode, show a boot menu on the console, with the timeout and timeout_style of the grub.cfg of the default entry, if any")
+	flagMenuTimeout      = flag.Duration("menu-timeout", 5*time.Second, "With -menu, how long to wait before booting the default entry if grub.cfg does not set a timeout. Zero boots it immediately, and a negative value waits forever")
+	flagMenuEdit         = flag.Bool("menu-edit", false, "With -menu, allow editing the kernel command line of an entry before booting it, by typing e and its number. If the grub.cfg of the default entry sets superusers, only they can edit, with their password")
+	flagMenuGrace        = flag.Duration("menu-grace", 0, "With -menu, the minimum time during which pressing Enter interrupts the boot of the default entry, even if the timeout is shorter or zero, e.g. 3s for laggy serial or IPMI consoles")
+	flagMenuMaxEntries   = flag.Int("menu-max-entries", 0, "With -menu, show at most this many entries at once, e.g. on a serial console with dozens of kernels or snapshots: the others are on the next pages, shown by typing m, and can be selected from any page. Zero shows them all")
+	flagMenuSingleEntry  = flag.String("menu-single-entry", menu.SingleEntryBoot, "With -menu, what to do when only one entry is found: boot to boot it without menu nor timeout, only waiting for -menu-grace if set, or menu to show the menu anyway")
+	flagMenuStyle        = flag.String("menu-style", menu.StyleMenu, "With -menu, how to show the menu if grub.cfg does not set a timeout_style: menu, countdown, or hidden to only show it if Enter is pressed before the timeout")
+	flagDefaultCmdline   = flag.String("default-cmdline", "", "In GRUB mode, kernel command line for the boot configurations that have none, e.g. \"root=/dev/sda1 console=ttyS0\". Boot configurations with a command line are not changed")
+	flagTemplateIface    = flag.String("template-interface", "", "Network interface whose MAC address and first IP address replace the ${sb:MAC} and ${sb:IP} placeholders. Defaults to the first interface that is up, other than loopback")
+	flagStrictTemplate   = flag.Bool("strict-template", false, "Skip boot configurations whose kernel command line has unknown ${sb:NAME} placeholders, instead of replacing them with empty strings")
+	flagListDevices      = flag.Bool("list-devices", false, "List the block devices with their file system, label, UUID, size and the number of boot configurations found on them, then exit without booting")
+	flagSlots            = flag.String("ab", "", "Use A/B mode, i.e. boot the active one of the SYSTEM_A/SYSTEM_B partitions, rolling back to the other one if it runs out of tries. The argument is where the slot state is stored: gpt (ChromeOS-style partition attributes) or vpd")
+	flagSlotCooldown     = flag.Duration("ab-cooldown", 0, "In A/B mode, skip a slot that has not booted successfully yet if it was already tried less than this long ago, e.g. 2m, and boot the other one instead, to break crash and reboot loops. This needs a slot marker that records the time of the tries, i.e. -ab vpd: it is refused with -ab gpt")
+	flagScanners         = flag.String("scanners", "", "Comma-separated list of the only config formats to scan for, e.g. grub2,grub. Defaults to all of "+strings.Join(bootscan.FormatNames(), ","))
+	flagDisableScanners  = flag.String("disable-scanners", "", "Comma-separated list of config formats not to scan for, e.g. syslinux,bls")
+	flagGrubConfigKey    = flag.String("grub-config-key", "", "Public key file grub configs can be signed with, in the same path with a .sig suffix. If a grub config of a partition has a valid signature, its grub configs without one are ignored")
+	flagBLSIndexKey      = flag.String("bls-index-key", "", "Public key file the BLS index loader/entries.json must be signed with, in loader/entries.json.sig. If set, the unsigned loader/entries/*.conf files are ignored. If not set, the signature is not checked")
+	flagAddConsoles      = flag.Bool("add-consoles", false, "Append console= parameters for the consoles of the running kernel and the ACPI SPCR serial port, if missing from the kernel command line")
+	flagOverlayFS        = flag.String("overlayfs", "", "In GRUB mode, also scan the merged view of an overlayfs, mounted read-only, given as lower=DIR[:DIR...],upper=DIR with the absolute paths of its directories on the mounted partitions, e.g. lower=/mnt/sda2/image,upper=/mnt/sda3/upper. The upper directory shadows the lower ones")
+	flagLoopback         = flag.Bool("loopback", false, "Follow GRUB loopback devices, e.g. \"loopback loop /boot/live.iso\", by mounting their images, so that kernels inside ISO or squashfs images can be booted")
+	flagConsole          = flag.Bool("console", false, "Use console mode, i.e. read a boot configuration pasted on the console and boot it")
+	flagConsoleKey       = flag.String("console-key", "", "Public key file the boot configuration pasted in console mode must be signed with. If not set, the signature is not checked")
+	flagConsoleTimeout   = flag.Duration("console-timeout", 5*time.Minute, "How long to wait for a boot configuration in console mode")
+	flagConsoleMaxSize   = flag.Int("console-max-size", bootconfig.DefaultConsoleConfigMaxSize, "Maximum size in bytes of a boot configuration pasted in console mode")
+	flagPolicy           = flag.String("policy", "", "Boot policy file in JSON format, measured when loaded. It can append kernel arguments, restrict the kernels that can be booted and select the config formats to scan for, in addition to -scanners and -disable-scanners")
+	flagOverlay          = flag.String("overlay", "", "In GRUB mode, URL of an overlay config whose boot entries, e.g. rescue tools, are appended to the ones found on the disks. The boot continues without them if the overlay cannot be fetched")
+	flagOverlayKey       = flag.String("overlay-key", "", "Public key file the overlay config must be signed with, in the same URL with a .sig suffix. If not set, the signature is not checked and a warning is logged for every unsigned entry appended")
+	flagFetchTimeout     = flag.Duration("fetch-timeout", 30*time.Second, "How long each HTTP request of -overlay, -remote-config or a remote boot config on the kernel command line may take, including reading the body, before it fails. Zero disables the timeout")
+	flagRemoteConfig     = flag.String("remote-config", "", "In GRUB mode, URL of a grub.cfg, menu.lst or loader/entries.json to use instead of the configs on the disks, with the kernel and initrd paths resolved on the local partitions. The configs on the disks are used if it cannot be fetched or has no bootable entry")
+	flagRemoteConfigKey  = flag.String("remote-config-key", "", "Public key file the remote config must be signed with, in the same URL with a .sig suffix. If not set, the signature is not checked")
+	flagDiscoverPolicy   = flag.Bool("discover-policy", false, "In GRUB mode, if -policy is not set, look for a boot policy in "+policy.ESPPolicyPath+" and "+policy.DiskPolicyPath+" on the partitions. A policy on the ESP takes precedence over one on another partition")
+	flagEventDescription = flag.String("event-description", crypto.EventDescriptionFull, "How the measurements are described in the event log of the boot report, to match the verifier: full for the full path of the measured files, basename for their base name, or hashed for the hex SHA-256 of the full description")
+	flagRandomSeed       = flag.String("random-seed", "", "In GRUB mode, append a random seed to the initramfs of the booted entry, as this file, e.g. var/lib/systemd/random-seed. The seed comes from the kernel RNG, mixed with the TPM RNG and EFI/systemboot/random-seed on the ESP, if any")
+	flagMeasureNVIndex   = flag.String("measure-nv-index", "", "TPM NV index, e.g. 0x01500020, into which the digest of every measurement is also extended, for NV-based policies. The index must be defined with the extend type and the owner authorization")
+	flagPreserveCrash    = flag.Bool("preserve-crashkernel", false, "Add the crashkernel= arguments of systemboot's own command line to the command line of the booted kernel when it has none, so that kdump keeps working after the kexec")
+	flagMeasureDirectIO  = flag.Bool("measure-direct-io", false, "Read the kernel and initramfs files to measure them with O_DIRECT, so that large images do not fill the page cache of a constrained initramfs. Buffered reads are used on file systems that do not support O_DIRECT. The kexec load itself is done by the kernel, which reads the files through the page cache")
+	flagDeferMeasure     = flag.Bool("defer-measurements", false, "In GRUB mode, only measure the config file of the boot configuration that is booted, right before booting it, instead of every config file that is scanned. This saves TPM operations, but the PCRs then do not cover the other config files")
+	flagSyslog           = flag.String("syslog", "", "Also send the logs, including the boot configurations found and the one booted, to this remote syslog server, in RFC 5424 format, e.g. udp://10.0.0.1 or tcp://logs.example.com:6514. Logs that cannot be sent are dropped")
+	flagInitrdCert       = flag.String("initrd-cert", "", "PEM file of the certificates trusted to sign initramfs images. If set, boot configurations whose initramfs has no valid PKCS7 signature, in a .p7s sidecar file or appended, are refused")
 )
 
 var debug = func(string, ...interface{}) {}
 
+// disabledScanners are the config formats not to scan for, as selected with
+// -scanners and -disable-scanners.
+var disabledScanners []string
+
+// blsIndexKey is the verifier of the public key loaded from -bls-index-key.
+var blsIndexKey crypto.Verifier
+
+// grubConfigKey is the verifier of the public key loaded from
+// -grub-config-key.
+var grubConfigKey crypto.Verifier
+
+// bootPolicy is the policy loaded from -policy, if any.
+var bootPolicy *policy.Policy
+
+// usePolicy makes p the boot policy, including its scanner selection. Its
+// measurement hash was already selected by policy.Load.
+func usePolicy(p *policy.Policy) error {
+	disabled, err := selectScanners(p.Scanners, p.DisableScanners)
+	if err != nil {
+		return fmt.Errorf("invalid scanner selection in the boot policy: %v", err)
+	}
+	if p.MeasurementHash != "" {
+		log.Printf("Measuring into the %s PCR bank, as set by the boot policy", p.MeasurementHash)
+	}
+	disabledScanners = append(disabledScanners, disabled...)
+	bootPolicy = p
+	return nil
+}
+
+// mountOverlayFS mounts the merged view of the overlayfs set with -overlayfs.
+func mountOverlayFS(spec, baseMountpoint string) (*storage.Mountpoint, error) {
+	dirs, err := storage.ParseOverlayDirs(spec)
+	if err != nil {
+		return nil, err
+	}
+	return storage.MountOverlay(*dirs, path.Join(baseMountpoint, "overlay"))
+}
+
+// discoverPolicy looks for a policy file on the mounted partitions if none
+// was set with -policy, see policy.LoadDiscovered. An ESP policy, on a
+// partition with the EFI system partition GPT type, takes precedence over a
+// disk one. A broken policy is fatal, like with -policy.
+func discoverPolicy(mounted []storage.Mountpoint) error {
+	if bootPolicy != nil {
+		return nil
+	}
+	devices, err := storage.GetBlockStats()
+	if err != nil {
+		return fmt.Errorf("cannot look for EFI system partitions: %v", err)
+	}
+	esps := make(map[string]bool)
+	for _, devpath := range storage.EFISystemPartitionPaths(devices) {
+		esps[devpath] = true
+	}
+	roots := make([]policy.Root, 0, len(mounted))
+	for _, mp := range mounted {
+		roots = append(roots, policy.Root{Path: mp.Path, ESP: esps[mp.DeviceName]})
+	}
+	p, err := policy.LoadDiscovered(roots)
+	if err != nil {
+		return fmt.Errorf("cannot load the boot policy: %v", err)
+	}
+	if p == nil {
+		debug("No boot policy found on the partitions")
+		return nil
+	}
+	return usePolicy(p)
+}
+
+// applyPolicy returns the boot configurations allowed by -policy, with the
+// policy applied.
+func applyPolicy(bootconfigs []bootconfig.BootConfig) []bootconfig.BootConfig {
+	if bootPolicy == nil {
+		return bootconfigs
+	}
+	if bootPolicy.SameDevice || bootPolicy.FilePermissions != "" {
+		// the files are checked, so the images they are on must be mounted
+		for _, cfg := range bootconfigs {
+			if err := loopback.mountFor(cfg.Kernel, cfg.Initramfs, cfg.DeviceTree); err != nil {
+				log.Printf("Boot configuration %q: %v", cfg.Name, err)
+			}
+		}
+	}
+	allowed := bootPolicy.Apply(bootconfigs)
+	if refused := len(bootconfigs) - len(allowed); refused > 0 {
+		log.Printf("The boot policy refused %d boot configuration(s)", refused)
+	}
+	return allowed
+}
+
+// initrdCerts are the certificates loaded from -initrd-cert.
+var initrdCerts []*x509.Certificate
+
+// bootVerified boots a boot configuration, after verifying the signature of
+// its initramfs if -initrd-cert is set. The GRUB loopback images its files
+// are on are mounted first.
+func bootVerified(cfg bootconfig.BootConfig) error {
+	if err := loopback.mountFor(cfg.Kernel, cfg.Initramfs, cfg.DeviceTree); err != nil {
+		return err
+	}
+	if initrdCerts != nil {
+		initramfs := cfg.Initramfs
+		if err := cfg.VerifyInitrdSignature(initrdCerts); err != nil {
+			return err
+		}
+		if cfg.Initramfs != initramfs {
+			// the stripped copy is only needed if the boot goes ahead
+			defer os.Remove(cfg.Initramfs)
+		}
+	}
+	return cfg.Boot()
+}
+
+// skipAction returns true if the boot configuration at index idx of the boot
+// order is an action to skip: only the default entry can halt or reboot, not
+// a fallback.
+func skipAction(idx int, cfg bootconfig.BootConfig) bool {
+	if cfg.Action == "" || idx == 0 {
+		return false
+	}
+	debug("Skipping boot configuration %q, its action is %s", cfg.Name, cfg.Action)
+	return true
+}
+
+// reportMenu prints the boot menu, i.e. the boot configurations in the order
+// they would be tried.
+func reportMenu(bootconfigs []bootconfig.BootConfig) {
+	fmt.Printf("Boot menu (%d entries):\n", len(bootconfigs))
+	for idx, cfg := range bootconfigs {
+		if cfg.Action != "" {
+			fmt.Printf("%d. %q action=%s (from %s)\n", idx, cfg.Name, cfg.Action, cfg.Source)
+			continue
+		}
+		protected := ""
+		if cfg.Protected {
+			protected = " password-protected"
+		}
+		if len(cfg.Unsupported) > 0 {
+			protected += " unsupported=" + strings.Join(cfg.Unsupported, ",")
+		}
+		if cfg.Firmware != "" {
+			protected += " firmware=" + cfg.Firmware
+		}
+		fmt.Printf("%d. %q kernel=%s initramfs=%s cmdline=%q%s (from %s)\n", idx, cfg.Name, cfg.Kernel, cfg.Initramfs, cfg.KernelArgs, protected, cfg.Source)
+	}
+}
+
+// espRandomSeedPath is the seed file on the ESP that is mixed into the random
+// seed set with -random-seed.
+const espRandomSeedPath = "EFI/systemboot/random-seed"
+
+// setRandomSeed appends a random seed to the initramfs of the booted entry, as
+// the file name, mixing in the seed files of the mounted ESPs.
+func setRandomSeed(name string, mounted []storage.Mountpoint) {
+	rs := bootconfig.RandomSeed{Name: name}
+	for _, mp := range mounted {
+		if isESP(mp) {
+			rs.Files = append(rs.Files, path.Join(mp.Path, espRandomSeedPath))
+		}
+	}
+	bootconfig.SetRandomSeed(&rs)
+}
+
+// deferredMeasurements are the measurements of the config files that are
+// only extended once a boot configuration is selected, with
+// -defer-measurements.
+var deferredMeasurements crypto.DeferredMeasurements
+
+// deferMeasurements makes opts record the config files in
+// deferredMeasurements instead of measuring them.
+func deferMeasurements(opts *bootscan.Options) {
+	opts.Measure = func(path string, data []byte) {
+		deferredMeasurements.Add(crypto.ConfigData, data, path)
+	}
+	opts.MeasureBatch = func(paths []string, data [][]byte) {
+		for idx, path := range paths {
+			deferredMeasurements.Add(crypto.ConfigData, data[idx], path)
+		}
+	}
+}
+
+// scanOptions returns the options used to scan for boot configurations: every
+// config file is measured before being parsed.
+func scanOptions() bootscan.Options {
+	opts := bootscan.Options{
+		Measure: func(path string, data []byte) {
+			crypto.TryMeasureData(crypto.ConfigData, data, path)
+		},
+		MeasureBatch:  measureConfigBatch,
+		Logf:          log.Printf,
+		Debugf:        debug,
+		Disabled:      disabledScanners,
+		BLSIndexKey:   blsIndexKey,
+		GrubConfigKey: grubConfigKey,
+	}
+	if *flagGrubDebug {
+		opts.Tracef = log.Printf
+	}
+	if *flagLoopback {
+		if loopback == nil {
+			loopback = newLoopImages(*flagBaseMountPoint)
+		}
+		opts.ImageMounter = loopback
+	}
+	return opts
+}
+
+// measureConfigBatch measures many config files at once, computing their
+// digests in parallel, and falls back to measuring them one by one if the
+// tpm2-tools binaries cannot be used.
+func measureConfigBatch(paths []string, data [][]byte) {
+	measurements := make([]crypto.Measurement, 0, len(paths))
+	for idx, path := range paths {
+		measurements = append(measurements, crypto.Measurement{Data: data[idx], Info: path})
+	}
+	if measured, err := crypto.MeasureBatch(crypto.ConfigData, measurements); err != nil {
+		log.Printf("Cannot measure the config files in a batch, measuring the rest one by one: %v", err)
+		for _, m := range measurements[measured:] {
+			crypto.TryMeasureData(crypto.ConfigData, m.Data, m.Info)
+		}
+	}
+}
+
+// splitList splits a comma-separated list, ignoring empty items.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// selectScanners returns the config formats not to scan for, given the list
+// of the only ones to scan for, if not empty, and the list of the ones to
+// disable.
+func selectScanners(enabled, disabled []string) ([]string, error) {
+	if err := bootscan.CheckFormatNames(enabled); err != nil {
+		return nil, err
+	}
+	if err := bootscan.CheckFormatNames(disabled); err != nil {
+		return nil, err
+	}
+	if len(enabled) == 0 {
+		return disabled, nil
+	}
+	for _, name := range bootscan.FormatNames() {
+		found := false
+		for _, e := range enabled {
+			if e == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			disabled = append(disabled, name)
+		}
+	}
+	return disabled, nil
+}
+
 // mountByGUID looks for a partition with the given GUID, and tries to mount it
 // in a subdirectory under the specified mount point. The subdirectory has the
 // same name of the device (e.g. /your/base/mountpoint/sda1).
@@ -55,6 +381,97 @@ func mountByGUID(devices []storage.BlockDev, filesystems []string, guid, baseMou
 	return mountpoint, nil
 }
 
+// addConsoles appends the given console= parameters to the boot configuration
+// if missing, and logs the ones that were added.
+func addConsoles(cfg *bootconfig.BootConfig, consoles []string) {
+	if added := cfg.AddConsoles(consoles); len(added) > 0 {
+		log.Printf("Added console parameters to %s: %v", cfg.Name, added)
+	}
+}
+
+// templateVars returns the values for the command line placeholders of a boot
+// configuration found on the given device.
+func templateVars(device string) bootconfig.TemplateVars {
+	vars := make(bootconfig.TemplateVars)
+	if serial, err := bootconfig.SMBIOSSerial(); err == nil {
+		vars[bootconfig.TemplateSerial] = serial
+	} else {
+		debug("Cannot read the SMBIOS serial number: %v", err)
+	}
+	if iface, err := templateInterface(); err == nil {
+		vars[bootconfig.TemplateMAC] = iface.HardwareAddr.String()
+		if ip, err := interfaceIP(iface); err == nil {
+			vars[bootconfig.TemplateIP] = ip.String()
+		} else {
+			debug("Cannot get the IP address of %s: %v", iface.Name, err)
+		}
+	} else {
+		debug("Cannot get the network interface of the placeholders: %v", err)
+	}
+	if device != "" {
+		if uuid, err := storage.DeviceUUID(device); err == nil {
+			vars[bootconfig.TemplateBootUUID] = uuid
+		} else {
+			debug("Cannot get the UUID of %s: %v", device, err)
+		}
+		if partuuid, err := storage.DevicePartUUID(device); err == nil {
+			vars[bootconfig.TemplateBootPartUUID] = partuuid
+		} else {
+			debug("Cannot get the PARTUUID of %s: %v", device, err)
+		}
+	}
+	return vars
+}
+
+// templateInterface returns the network interface set with
+// -template-interface, or else the first one that is up and has a hardware
+// address, other than loopback.
+func templateInterface() (*net.Interface, error) {
+	if *flagTemplateIface != "" {
+		return net.InterfaceByName(*flagTemplateIface)
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for idx, iface := range ifaces {
+		if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagLoopback == 0 && len(iface.HardwareAddr) > 0 {
+			return &ifaces[idx], nil
+		}
+	}
+	return nil, errors.New("no network interface is up")
+}
+
+// interfaceIP returns the first global unicast address of a network
+// interface, e.g. from a DHCP lease of the initramfs.
+func interfaceIP(iface *net.Interface) (net.IP, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.IsGlobalUnicast() {
+			return ipnet.IP, nil
+		}
+	}
+	return nil, errors.New("no global address")
+}
+
+// expandTemplates expands the command line placeholders of the given boot
+// configurations. In strict mode, the configurations that cannot be expanded
+// are dropped.
+func expandTemplates(bootconfigs []bootconfig.BootConfig) []bootconfig.BootConfig {
+	expanded := make([]bootconfig.BootConfig, 0, len(bootconfigs))
+	for _, cfg := range bootconfigs {
+		if err := cfg.ExpandTemplate(templateVars(cfg.Device), *flagStrictTemplate); err != nil {
+			log.Printf("Skipping boot configuration %q: %v", cfg.Name, err)
+			continue
+		}
+		expanded = append(expanded, cfg)
+	}
+	return expanded
+}
+
 // BootGrubMode tries to boot a kernel in GRUB mode. GRUB mode means:
 // * look for the partition with the specified GUID, and mount it
 // * if no GUID is specified, mount all of the specified devices
@@ -81,26 +498,50 @@ func BootGrubMode(devices []storage.BlockDev, baseMountpoint string, guid string
 	}
 	debug("Supported file systems: %v", filesystems)
 
-	var mounted []storage.Mountpoint
+	var (
+		mounted []storage.Mountpoint
+		// devices skipped because of their file system, as opposed to
+		// empty ones, e.g. `/dev/sdb1 (zfs_member)`
+		unsupported []string
+	)
 	if guid == "" {
 		// try mounting all the available devices, with all the supported file
 		// systems
 		debug("trying to mount all the available block devices with all the supported file system types")
 		mounted = make([]storage.Mountpoint, 0)
+		var luksDevices []string
 		for _, dev := range devices {
 			devname := path.Join("/dev", dev.Name)
+			if isLUKS, _ := storage.IsLUKS(devname); isLUKS {
+				// unlocked later, once the partition holding the sealed
+				// key is mounted
+				luksDevices = append(luksDevices, devname)
+				continue
+			}
 			mountpath := path.Join(baseMountpoint, dev.Name)
 			if mountpoint, err := storage.Mount(devname, mountpath, filesystems); err != nil {
-				debug("Failed to mount %s on %s: %v", devname, mountpath, err)
+				if fserr, ok := err.(*storage.UnsupportedFilesystemError); ok {
+					log.Printf("Skipping %v", fserr)
+					unsupported = append(unsupported, fmt.Sprintf("%s (%s)", fserr.Device, fserr.FsType))
+				} else {
+					debug("Failed to mount %s on %s: %v", devname, mountpath, err)
+				}
 			} else {
 				mounted = append(mounted, *mountpoint)
 			}
 		}
+		if len(luksDevices) > 0 {
+			log.Printf("Found LUKS devices: %v", luksDevices)
+			mounted = append(mounted, unlockAndMountLUKS(luksDevices, mounted, filesystems, baseMountpoint)...)
+		}
 		log.Printf("mounted: %+v", mounted)
+		if len(unsupported) > 0 {
+			log.Printf("Skipped devices with unsupported file systems: %s", strings.Join(unsupported, ", "))
+		}
 		defer func() {
-			// clean up
-			for _, mountpoint := range mounted {
-				syscall.Unmount(mountpoint.Path, syscall.MNT_DETACH)
+			// clean up, and make sure the next stage can use the devices
+			for _, err := range storage.UnmountAll(mounted) {
+				log.Printf("Not cleanly unmounted: %v", err)
 			}
 		}()
 	} else {
@@ -110,32 +551,175 @@ func BootGrubMode(devices []storage.BlockDev, baseMountpoint string, guid string
 		}
 		mounted = []storage.Mountpoint{*mount}
 	}
+	if *flagOverlayFS != "" {
+		// after the partitions its directories are on, so it is unmounted
+		// before them
+		if mp, err := mountOverlayFS(*flagOverlayFS, baseMountpoint); err != nil {
+			log.Printf("Not scanning the overlayfs: %v", err)
+		} else {
+			mounted = append(mounted, *mp)
+		}
+	}
+
+	if *flagDiscoverPolicy {
+		if err := discoverPolicy(mounted); err != nil {
+			return err
+		}
+	}
 
 	// search for a valid grub config and extracts the boot configuration
-	bootconfigs := make([]bootconfig.BootConfig, 0)
+	opts := scanOptions()
+	// before the partitions the images are on
+	defer loopback.unmountAll()
+	if *flagDeferMeasure {
+		deferMeasurements(&opts)
+	}
+	recordScannedConfigs(&opts)
+	entries := remoteEntries(mounted, opts)
+	// the remote config, if usable, replaces the ones on the disks
+	scanDisks := len(entries) == 0
 	for _, mountpoint := range mounted {
-		bootconfigs = append(bootconfigs, ScanGrubConfigs(mountpoint.Path)...)
+		if !scanDisks {
+			break
+		}
+		var found []bootscan.Entry
+		if mountpoint.FsType == "btrfs" && !*flagRecursive {
+			mp, defaultSubvol, err := mountBtrfsTopLevel(mountpoint)
+			if err == nil {
+				if mp.Path != mountpoint.Path {
+					// the range is over the mount points found before,
+					// so the new one is not scanned again
+					mounted = append(mounted, *mp)
+				}
+				found = bootscan.ScanBtrfs(mp.Path, defaultSubvol, opts)
+			} else {
+				log.Printf("Cannot mount the top-level btrfs subvolume of %s, subvolumes will be ignored: %v", mountpoint.DeviceName, err)
+			}
+		}
+		if found == nil {
+			if *flagRecursive {
+				found = bootscan.ScanRecursive(mountpoint.Path, *flagMaxDepth, opts)
+			} else {
+				found = bootscan.Scan(mountpoint.Path, opts)
+			}
+		}
+		bootscan.SetDevice(found, mountpoint.DeviceName)
+		entries = append(entries, found...)
+	}
+	bootconfigs := bootscan.BootConfigs(entries)
+	bootconfigs = bootconfig.MergeOverlay(bootconfigs, fetchOverlay(), bootconfig.DedupOptions{ByContent: *flagDedupByContent})
+	if *flagDefaultCmdline != "" {
+		// before the policy, which can append arguments
+		for idx := range bootconfigs {
+			bootconfigs[idx].SetDefaultArgs(*flagDefaultCmdline)
+		}
+	}
+	bootconfigs = applyPolicy(bootconfigs)
+	if !*flagShowSnapshots {
+		var hidden []bootconfig.BootConfig
+		bootconfigs, hidden = bootconfig.CollapseSnapshots(bootconfigs)
+		if len(hidden) > 0 {
+			log.Printf("Hiding %d boot configurations of btrfs snapshots, use -show-snapshots to try them", len(hidden))
+		}
+		for _, cfg := range hidden {
+			debug("Hiding boot configuration %q, its root is a snapshot", cfg.Name)
+		}
+	}
+	if !*flagAllArchs {
+		var hidden []bootconfig.BootConfig
+		bootconfigs, hidden = bootconfig.FilterByArch(bootconfigs, runtime.GOARCH)
+		for _, cfg := range hidden {
+			log.Printf("Hiding boot configuration %q, its kernel %s is for %s, not %s", cfg.Name, cfg.Kernel, cfg.KernelArch(), runtime.GOARCH)
+		}
+	}
+	if *flagFilterFirmware {
+		firmware := bootconfig.DetectFirmware()
+		matching, other := bootconfig.FilterByFirmware(bootconfigs, firmware)
+		if len(matching) == 0 && len(other) > 0 {
+			log.Printf("No boot configuration is meant for %s firmware, trying the other ones", firmware)
+		} else {
+			bootconfigs = matching
+			for _, cfg := range other {
+				log.Printf("Hiding boot configuration %q, it is meant for %s firmware, not %s", cfg.Name, cfg.Firmware, firmware)
+			}
+		}
+	}
+	if *flagSortByVersion {
+		bootconfig.SortByKernelRelease(bootconfigs)
+	}
+	if bootconfig.BootPreviousRequested() {
+		previous, err := bootconfig.SelectPrevious(bootconfigs)
+		if err != nil {
+			return fmt.Errorf("Cannot boot the previous kernel as requested by %s: %v", bootconfig.BootPreviousArg, err)
+		}
+		log.Printf("Booting the previous kernel %s, as requested by %s", previous[0].Kernel, bootconfig.BootPreviousArg)
+		bootconfigs = previous
 	}
 	log.Printf("Found %d boot configs", len(bootconfigs))
 	for _, cfg := range bootconfigs {
-		debug("%+v", cfg)
+		debug("%+v, defined in %s", cfg, cfg.Source)
 	}
 	if len(bootconfigs) == 0 {
+		if len(unsupported) > 0 {
+			return fmt.Errorf("No boot configuration found, and skipped devices with unsupported file systems: %s", strings.Join(unsupported, ", "))
+		}
 		return fmt.Errorf("No boot configuration found")
 	}
+	if *flagAddConsoles {
+		consoles := bootconfig.DetectConsoles()
+		for idx := range bootconfigs {
+			addConsoles(&bootconfigs[idx], consoles)
+		}
+	}
+	// placeholders are expanded last, so they are visible in the dry-run
+	// output and measured along with the rest of the command line
+	bootconfigs = expandTemplates(bootconfigs)
+	if len(bootconfigs) == 0 {
+		return fmt.Errorf("No boot configuration left after expanding the command line placeholders")
+	}
+
+	if *flagMenu && !safemode.Enabled() {
+		bootconfigs = selectBootConfig(bootconfigs)
+	}
 
 	if dryrun {
+		if safemode.Enabled() {
+			reportMenu(bootconfigs)
+			return nil
+		}
 		cfg := bootconfigs[0]
 		debug("Dry-run mode: will not boot the found configuration")
-		debug("Boot configuration: %+v", cfg)
+		log.Printf("Boot configuration: %+v", cfg)
 		return nil
 	}
 
+	if *flagBootReport {
+		registerBootReport(mounted)
+	}
+	if *flagRandomSeed != "" {
+		setRandomSeed(*flagRandomSeed, mounted)
+	}
 	// try to kexec into every boot config kernel until one succeeds
-	for _, cfg := range bootconfigs {
+	for idx, cfg := range bootconfigs {
+		if skipAction(idx, cfg) {
+			continue
+		}
+		if cfg.Protected {
+			log.Printf("Skipping boot configuration %q, it is password-protected", cfg.Name)
+			continue
+		}
+		log.Printf("Booting %q, kernel %s", cfg.Name, cfg.Kernel)
+		if len(cfg.Unsupported) > 0 {
+			log.Printf("Boot configuration %q uses unsupported features, it may not boot as intended: %s", cfg.Name, strings.Join(cfg.Unsupported, ", "))
+		}
+		if *flagDeferMeasure && cfg.Source != nil {
+			// the kernel and initramfs are measured when booting
+			deferredMeasurements.MeasureFor(cfg.Source.Path, cfg.Name)
+		}
 		debug("Trying boot configuration %+v", cfg)
-		if err := cfg.Boot(); err != nil {
+		if err := bootVerified(cfg); err != nil {
 			log.Printf("Failed to boot kernel %s: %v", cfg.Kernel, err)
+			reportState.failed = append(reportState.failed, failedBoot{Name: cfg.Name, Kernel: cfg.Kernel, Error: err.Error()})
 		}
 	}
 	// if we reach this point, no boot configuration succeeded
@@ -176,11 +760,23 @@ func BootPathMode(devices []storage.BlockDev, baseMountpoint string, guid string
 		Initramfs:  fullInitramfsPath,
 		KernelArgs: *flagKernelCmdline,
 	}
+	if *flagAddConsoles {
+		addConsoles(&cfg, bootconfig.DetectConsoles())
+	}
+	cfg.Device = mount.DeviceName
+	allowed := applyPolicy([]bootconfig.BootConfig{cfg})
+	if len(allowed) == 0 {
+		return fmt.Errorf("the boot policy does not allow booting kernel %s", cfg.Kernel)
+	}
+	cfg = allowed[0]
+	if err := cfg.ExpandTemplate(templateVars(cfg.Device), *flagStrictTemplate); err != nil {
+		return err
+	}
 	debug("Trying boot configuration %+v", cfg)
 	if dryrun {
-		log.Printf("Dry-run, will not actually boot")
+		log.Printf("Dry-run, will not actually boot %+v", cfg)
 	} else {
-		if err := cfg.Boot(); err != nil {
+		if err := bootVerified(cfg); err != nil {
 			return fmt.Errorf("Failed to boot kernel %s: %v", cfg.Kernel, err)
 		}
 	}
@@ -192,14 +788,104 @@ func main() {
 	if *flagGrubMode && *flagKernelPath != "" {
 		log.Fatal("Options -grub and -kernel are mutually exclusive")
 	}
+	if *flagSlotCooldown > 0 && *flagSlots == "gpt" {
+		// the GPT attributes have no room for the time of the tries
+		log.Fatal("Option -ab-cooldown needs -ab vpd, -ab gpt cannot record the time of the tries")
+	}
 	if *flagDebug {
 		debug = log.Printf
 	}
+	if *flagSyslog != "" {
+		w, err := remotelog.New(*flagSyslog, "localboot")
+		if err != nil {
+			log.Fatalf("Invalid syslog URL: %v", err)
+		}
+		log.SetOutput(io.MultiWriter(os.Stderr, w))
+	}
+	if *flagSafeMode {
+		log.Print("Safe mode: nothing will be written, and nothing will be booted")
+		safemode.Enable()
+		*flagDryRun = true
+	}
+	var err error
+	// minimal environments may not have mounted them yet
+	if err := storage.MountPseudoFilesystems(); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	storage.SetDirectIO(*flagMeasureDirectIO)
+	bootconfig.SetPreserveCrashKernel(*flagPreserveCrash)
+	bootconfig.SetKexecLoadRetries(*flagKexecRetries, bootconfig.DefaultKexecLoadRetryDelay)
+	if *flagBootReport {
+		// for the measurements of the report
+		crypto.EnableEventLog()
+	}
+	if err := crypto.SetEventDescriptionFormat(*flagEventDescription); err != nil {
+		log.Fatal(err)
+	}
+	if *flagMeasureNVIndex != "" {
+		index, err := strconv.ParseUint(*flagMeasureNVIndex, 0, 32)
+		if err != nil {
+			log.Fatalf("Invalid NV index %q: %v", *flagMeasureNVIndex, err)
+		}
+		if err := crypto.SetMeasurementNVIndex(uint32(index)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	disabledScanners, err = selectScanners(splitList(*flagScanners), splitList(*flagDisableScanners))
+	if err != nil {
+		log.Fatalf("Invalid scanner selection: %v", err)
+	}
+	if *flagPolicy != "" {
+		p, err := policy.Load(*flagPolicy)
+		if err != nil {
+			log.Fatalf("Cannot load the boot policy: %v", err)
+		}
+		if err := usePolicy(p); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *flagBLSIndexKey != "" {
+		if blsIndexKey, err = crypto.LoadVerifierFromFile(*flagBLSIndexKey); err != nil {
+			log.Fatalf("Cannot load the BLS index key: %v", err)
+		}
+	}
+	if *flagGrubConfigKey != "" {
+		if grubConfigKey, err = crypto.LoadVerifierFromFile(*flagGrubConfigKey); err != nil {
+			log.Fatalf("Cannot load the grub config key: %v", err)
+		}
+	}
+	if *flagRemoteConfigKey != "" {
+		if remoteConfigKey, err = crypto.LoadVerifierFromFile(*flagRemoteConfigKey); err != nil {
+			log.Fatalf("Cannot load the remote config key: %v", err)
+		}
+	}
+	if *flagOverlayKey != "" {
+		if overlayKey, err = crypto.LoadVerifierFromFile(*flagOverlayKey); err != nil {
+			log.Fatalf("Cannot load the overlay key: %v", err)
+		}
+	}
+	if *flagInitrdCert != "" {
+		if initrdCerts, err = crypto.LoadCertificatesFromFile(*flagInitrdCert); err != nil {
+			log.Fatalf("Cannot load the initramfs certificates: %v", err)
+		}
+	}
+
+	// a boot configuration on the kernel command line takes precedence
+	cc, err := bootconfig.ReadCmdlineConfig()
+	if err != nil {
+		log.Printf("Ignoring the boot configuration on the kernel command line: %v", err)
+	} else if cc != nil {
+		log.Printf("Booting kernel %s, as set on the kernel command line with %s", cc.Kernel, bootconfig.CmdlineKernelArg)
+		if err := BootCmdlineMode(cc, *flagBaseMountPoint, *flagDryRun); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(1)
+	}
 
 	// Get all the available block devices
 	devices, err := storage.GetBlockStats()
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("Cannot list the block devices: %v", err)
 	}
 	// print partition info
 	if *flagDebug {
@@ -221,8 +907,36 @@ func main() {
 
 	// TODO boot from EFI system partitions. See storage.FilterEFISystemPartitions
 
-	if *flagGrubMode {
-		if err := BootGrubMode(devices, *flagBaseMountPoint, *flagDeviceGUID, *flagDryRun); err != nil {
+	if *flagListDevices {
+		if err := ListDevices(devices, *flagBaseMountPoint); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *flagConsole {
+		if err := BootConsoleMode(*flagConsoleKey, *flagBaseMountPoint, *flagDryRun); err != nil {
+			log.Fatal(err)
+		}
+	} else if *flagSlots != "" {
+		if err := BootSlotMode(devices, *flagBaseMountPoint, *flagSlots, *flagDryRun); err != nil {
+			log.Fatal(err)
+		}
+	} else if *flagGrubMode {
+		detach := func() {}
+		if file := bootconfig.ReadBootFile(); file != "" {
+			if devices, detach, err = bootFileDevices(file); err != nil {
+				log.Fatal(err)
+			}
+		} else if uuid := bootconfig.ReadBootUUID(); uuid != "" {
+			devices = restrictToUUID(devices, uuid)
+		} else if label := bootconfig.ReadBootLabel(); label != "" {
+			devices = restrictToLabel(devices, label)
+		}
+		err := BootGrubMode(devices, *flagBaseMountPoint, *flagDeviceGUID, *flagDryRun)
+		// the partitions of the image are unmounted by now
+		detach()
+		if err != nil {
 			log.Fatal(err)
 		}
 	} else if *flagKernelPath != "" {
@@ -230,7 +944,7 @@ func main() {
 			log.Fatal(err)
 		}
 	} else {
-		log.Fatal("You must specify either -grub or -kernel")
+		log.Fatal("You must specify either -ab, -console, -grub or -kernel")
 	}
 	os.Exit(1)
 }
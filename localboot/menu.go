@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/systemboot/systemboot/pkg/bootconfig"
+	"github.com/systemboot/systemboot/pkg/bootscan"
+	"github.com/systemboot/systemboot/pkg/menu"
+)
+
+// scannedConfigs are the config files measured during the scan, by path, so
+// that the menu settings are read from the data that was measured, not from
+// the disk again.
+var scannedConfigs = make(map[string][]byte)
+
+// recordScannedConfigs makes opts keep the config files it measures one by
+// one, like the grub configs, in scannedConfigs.
+func recordScannedConfigs(opts *bootscan.Options) {
+	measure := opts.Measure
+	opts.Measure = func(path string, data []byte) {
+		scannedConfigs[path] = data
+		if measure != nil {
+			measure(path, data)
+		}
+	}
+}
+
+// menuSettings returns the settings of the menu: the ones of the grub config
+// the default boot configuration comes from, if any, or else the ones set
+// with the -menu-* flags.
+func menuSettings(bootconfigs []bootconfig.BootConfig) menu.Settings {
+	settings := menu.Settings{
+		Timeout:     *flagMenuTimeout,
+		Style:       *flagMenuStyle,
+		Grace:       *flagMenuGrace,
+		MaxEntries:  *flagMenuMaxEntries,
+		SingleEntry: *flagMenuSingleEntry,
+		Edit:        *flagMenuEdit,
+	}
+	source := bootconfigs[0].Source
+	if source == nil {
+		return settings
+	}
+	if format := bootscan.FormatOf(source.Path); format == nil || format.Name != "grub2" {
+		return settings
+	}
+	data, ok := scannedConfigs[source.Path]
+	if !ok {
+		debug("No measured data for the menu settings of %s", source.Path)
+		return settings
+	}
+	return bootscan.GrubMenuSettings(string(data), settings)
+}
+
+// selectBootConfig asks on the console which boot configuration to boot, and
+// moves it first. The other ones are still tried after it, in order.
+func selectBootConfig(bootconfigs []bootconfig.BootConfig) []bootconfig.BootConfig {
+	selector := menu.Selector{Settings: menuSettings(bootconfigs), In: os.Stdin, Out: os.Stdout}
+	idx, err := selector.Select(bootconfigs)
+	if err != nil {
+		log.Printf("Cannot show the boot menu, booting the default entry: %v", err)
+		return bootconfigs
+	}
+	if idx == 0 {
+		return bootconfigs
+	}
+	selected := make([]bootconfig.BootConfig, 0, len(bootconfigs))
+	selected = append(selected, bootconfigs[idx])
+	selected = append(selected, bootconfigs[:idx]...)
+	return append(selected, bootconfigs[idx+1:]...)
+}
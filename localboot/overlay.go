@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/systemboot/systemboot/pkg/bootconfig"
+	"github.com/systemboot/systemboot/pkg/crypto"
+	"github.com/systemboot/systemboot/pkg/fetch"
+)
+
+// overlayKey is the verifier of the public key loaded from -overlay-key.
+var overlayKey crypto.Verifier
+
+// newFetcher returns a fetcher whose HTTP requests fail after -fetch-timeout,
+// so that an unresponsive server cannot hang the boot.
+func newFetcher() *fetch.Fetcher {
+	fetcher := fetch.NewFetcher()
+	fetcher.Client = &http.Client{Timeout: *flagFetchTimeout}
+	return fetcher
+}
+
+// fetchOverlay returns the boot configurations of the overlay config set with
+// -overlay, if any. The overlay is optional: if it cannot be fetched or
+// verified, the error is logged and no configuration is returned.
+func fetchOverlay() []bootconfig.BootConfig {
+	if *flagOverlay == "" {
+		return nil
+	}
+	tempDir, err := ioutil.TempDir(os.TempDir(), "overlay")
+	if err != nil {
+		log.Printf("Skipping the overlay: %v", err)
+		return nil
+	}
+	bootconfigs, err := bootconfig.FetchOverlay(newFetcher(), *flagOverlay, overlayKey, tempDir)
+	if err != nil {
+		log.Printf("Skipping the overlay %s: %v", *flagOverlay, err)
+		os.RemoveAll(tempDir)
+		return nil
+	}
+	log.Printf("Found %d boot configs in the overlay %s", len(bootconfigs), *flagOverlay)
+	if overlayKey == nil {
+		for _, cfg := range bootconfigs {
+			log.Printf("WARNING: appending %q from the overlay %s without checking its signature, set -overlay-key to verify it", cfg.Name, *flagOverlay)
+		}
+	}
+	return bootconfigs
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/systemboot/systemboot/pkg/bootscan"
+)
+
+// TestOverlayFSIntegration mounts an overlay of two directories, where the
+// upper one shadows the grub.cfg of the lower one, and scans the merged view.
+func TestOverlayFSIntegration(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("this test requires root")
+	}
+	// overlay is a nodev file system, not listed by GetSupportedFilesystems
+	if filesystems, err := ioutil.ReadFile("/proc/filesystems"); err != nil || !strings.Contains(string(filesystems), "\toverlay\n") {
+		t.Skip("this test requires overlayfs")
+	}
+	dir, err := ioutil.TempDir("", "overlayfs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	lower := path.Join(dir, "lower")
+	upper := path.Join(dir, "upper")
+	writeTestFile(t, lower, "boot/vmlinuz-4.19", "old kernel")
+	writeTestFile(t, lower, "boot/grub2/grub.cfg", `
+menuentry 'Linux 4.19' {
+	linux /boot/vmlinuz-4.19 root=/dev/sda2
+}
+`)
+	writeTestFile(t, upper, "boot/vmlinuz-5.4", "new kernel")
+	writeTestFile(t, upper, "boot/grub2/grub.cfg", `
+menuentry 'Linux 5.4' {
+	linux /boot/vmlinuz-5.4 root=/dev/sda2
+}
+menuentry 'Linux 4.19' {
+	linux /boot/vmlinuz-4.19 root=/dev/sda2
+}
+`)
+
+	mp, err := mountOverlayFS("lower="+lower+",upper="+upper, path.Join(dir, "mnt"))
+	require.NoError(t, err)
+	defer syscall.Unmount(mp.Path, 0)
+
+	entries := bootscan.Scan(mp.Path, bootscan.Options{})
+	require.Equal(t, 2, len(entries))
+	require.Equal(t, "Linux 5.4", entries[0].Name)
+	require.Equal(t, path.Join(mp.Path, "boot/grub2/grub.cfg"), entries[0].ConfigPath)
+	for idx, content := range []string{"new kernel", "old kernel"} {
+		kernel, err := ioutil.ReadFile(entries[idx].Kernel)
+		require.NoError(t, err)
+		require.Equal(t, content, string(kernel))
+	}
+}
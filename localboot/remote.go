@@ -0,0 +1,54 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/systemboot/systemboot/pkg/bootscan"
+	"github.com/systemboot/systemboot/pkg/crypto"
+	"github.com/systemboot/systemboot/pkg/storage"
+)
+
+// remoteConfigKey is the verifier of the public key loaded from
+// -remote-config-key.
+var remoteConfigKey crypto.Verifier
+
+// remoteEntries returns the boot entries of the config set with
+// -remote-config, if any, with their kernel and initrd paths resolved against
+// the mounted partitions. Each entry is kept for the first partition that has
+// its kernel. If the config cannot be fetched or verified, the error is
+// logged and no entry is returned.
+func remoteEntries(mounted []storage.Mountpoint, opts bootscan.Options) []bootscan.Entry {
+	if *flagRemoteConfig == "" {
+		return nil
+	}
+	data, err := bootscan.FetchRemote(newFetcher(), *flagRemoteConfig, remoteConfigKey, opts)
+	if err != nil {
+		log.Printf("Cannot use the remote config: %v", err)
+		return nil
+	}
+	var (
+		entries []bootscan.Entry
+		found   = make(map[int]bool)
+	)
+	for _, mountpoint := range mounted {
+		parsed, err := bootscan.ParseRemote(*flagRemoteConfig, data, mountpoint.Path, opts)
+		if err != nil {
+			log.Printf("Cannot use the remote config: %v", err)
+			return nil
+		}
+		for idx, entry := range parsed {
+			if found[idx] {
+				continue
+			}
+			if _, err := os.Stat(entry.Kernel); err != nil {
+				continue
+			}
+			found[idx] = true
+			entry.Device = mountpoint.DeviceName
+			entries = append(entries, entry)
+		}
+	}
+	log.Printf("Found %d boot configs in the remote config %s", len(entries), *flagRemoteConfig)
+	return entries
+}
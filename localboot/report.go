@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/systemboot/systemboot/pkg/bootconfig"
+	"github.com/systemboot/systemboot/pkg/crypto"
+	"github.com/systemboot/systemboot/pkg/storage"
+)
+
+// Where the boot report is written, relative to the root of the partition: the
+// ESP if any, otherwise the partition of the booted entry.
+const (
+	espReportPath  = "EFI/systemboot/report.json"
+	diskReportPath = "etc/systemboot/report.json"
+)
+
+// started is when localboot started, for the timings of the boot report.
+var started = time.Now()
+
+// remount is storage.Remount. It is a variable so it can be overridden for
+// testing.
+var remount = storage.Remount
+
+// bootReport is what systemboot decided, written with -boot-report for the
+// booted OS.
+type bootReport struct {
+	// Entry is the booted entry
+	Entry bootconfig.BootConfig `json:"entry"`
+	// Failed are the entries tried before it, whose boot failed
+	Failed       []failedBoot        `json:"failed,omitempty"`
+	Measurements measurementsSummary `json:"measurements"`
+	Timings      bootTimings         `json:"timings"`
+}
+
+// failedBoot is an entry whose boot failed, before falling back to the next
+// one.
+type failedBoot struct {
+	Name   string `json:"name"`
+	Kernel string `json:"kernel"`
+	Error  string `json:"error"`
+}
+
+// measurementsSummary lists what was measured into the TPM, without the
+// digests, which are in the TPM event log, and the resulting values of the
+// PCRs, in hexadecimal, if they could be read.
+type measurementsSummary struct {
+	Hash     string         `json:"hash"`
+	Deferred bool           `json:"deferred,omitempty"`
+	Measured []measurement  `json:"measured"`
+	PCRs     map[int]string `json:"pcrs,omitempty"`
+}
+
+type measurement struct {
+	PCR  uint32 `json:"pcr"`
+	Info string `json:"info"`
+}
+
+// bootTimings are the durations since localboot started, in milliseconds.
+type bootTimings struct {
+	Started time.Time `json:"started"`
+	// ScanMs is when the boot entries were found
+	ScanMs int64 `json:"scan_ms"`
+	// BootMs is when the booted entry was loaded, right before the kexec
+	BootMs int64 `json:"boot_ms"`
+}
+
+// readPCRs is crypto.ReadPCRs. It is a variable so it can be overridden for
+// testing.
+var readPCRs = crypto.ReadPCRs
+
+// reportState is what the boot report is built from, collected during the
+// boot.
+var reportState struct {
+	scanned time.Time
+	failed  []failedBoot
+}
+
+// newBootReport returns the boot report for a boot configuration being
+// booted at the given time.
+func newBootReport(cfg bootconfig.BootConfig, now time.Time) bootReport {
+	report := bootReport{
+		Entry:  cfg,
+		Failed: reportState.failed,
+		Measurements: measurementsSummary{
+			Hash:     crypto.MeasurementHash().String(),
+			Deferred: *flagDeferMeasure,
+			Measured: []measurement{},
+		},
+		Timings: bootTimings{
+			Started: started,
+			ScanMs:  int64(reportState.scanned.Sub(started) / time.Millisecond),
+			BootMs:  int64(now.Sub(started) / time.Millisecond),
+		},
+	}
+	events := crypto.RecordedEvents()
+	for _, event := range events {
+		report.Measurements.Measured = append(report.Measurements.Measured, measurement{PCR: event.PCR, Info: string(event.Data)})
+	}
+	report.Measurements.PCRs = reportPCRs(events)
+	return report
+}
+
+// reportPCRs returns the values of the PCRs the given events were measured
+// into, in the bank of the measurement hash, or nil if they cannot be read.
+func reportPCRs(events []crypto.Event) map[int]string {
+	var pcrs []int
+	seen := make(map[uint32]bool)
+	for _, event := range events {
+		if !seen[event.PCR] {
+			seen[event.PCR] = true
+			pcrs = append(pcrs, int(event.PCR))
+		}
+	}
+	if len(pcrs) == 0 {
+		return nil
+	}
+	values, err := readPCRs(pcrs, crypto.MeasurementHash())
+	if err != nil {
+		debug("Cannot read the PCRs for the boot report: %v", err)
+		return nil
+	}
+	hexValues := make(map[int]string, len(values))
+	for pcr, digest := range values {
+		hexValues[pcr] = hex.EncodeToString(digest)
+	}
+	return hexValues
+}
+
+// isESP returns true if a partition looks like an EFI system partition: a FAT
+// file system with an EFI directory.
+func isESP(mp storage.Mountpoint) bool {
+	if mp.FsType != "vfat" {
+		return false
+	}
+	info, err := os.Stat(path.Join(mp.Path, "EFI"))
+	return err == nil && info.IsDir()
+}
+
+// reportTarget is a partition the boot report can be written to.
+type reportTarget struct {
+	storage.Mountpoint
+	RelPath string
+}
+
+// reportTargets returns where to try writing the boot report, in order: the
+// ESPs, then the partition of the booted entry.
+func reportTargets(mounted []storage.Mountpoint, device string) []reportTarget {
+	var targets []reportTarget
+	for _, mp := range mounted {
+		if isESP(mp) {
+			targets = append(targets, reportTarget{mp, espReportPath})
+		}
+	}
+	for _, mp := range mounted {
+		if mp.DeviceName == device && !isESP(mp) {
+			targets = append(targets, reportTarget{mp, diskReportPath})
+		}
+	}
+	return targets
+}
+
+// writeFileAtomic writes a file through a temporary file renamed over it, and
+// syncs both, so that the file is complete or unchanged even if the machine
+// is kexec'd or reset right after.
+func writeFileAtomic(name string, data []byte) error {
+	dir := filepath.Dir(name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, ".report")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return err
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// writeBootReport writes the boot report to the first target that can be
+// remounted read-write, and remounts it read-only again. It silently skips
+// the report if there is no such target. No target is remounted read-write
+// after deadline, so that a partition is not left read-write by a write cut
+// short by the kexec.
+func writeBootReport(report bootReport, targets []reportTarget, deadline time.Time) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	for _, target := range targets {
+		if time.Now().After(deadline) {
+			debug("Not writing the boot report on %s, it is too late", target.DeviceName)
+			return nil
+		}
+		if err := remount(target.Mountpoint, false); err != nil {
+			debug("Cannot write the boot report on %s: %v", target.DeviceName, err)
+			continue
+		}
+		name := path.Join(target.Path, target.RelPath)
+		err := writeFileAtomic(name, data)
+		if rerr := remount(target.Mountpoint, true); rerr != nil {
+			debug("%v", rerr)
+		}
+		if err != nil {
+			debug("Cannot write the boot report %s: %v", name, err)
+			continue
+		}
+		debug("Wrote the boot report %s", name)
+		return nil
+	}
+	debug("No writable partition for the boot report")
+	return nil
+}
+
+// bootReportTimeout is how long the boot report hook can take. Syncing a
+// partition, e.g. a slow USB stick or SD card, can take longer than the
+// default pre-boot hook timeout.
+const bootReportTimeout = 30 * time.Second
+
+// registerBootReport writes the boot report right before the kexec into a
+// boot configuration, on one of the mounted partitions. A write is only
+// started within the first half of bootReportTimeout, so that it has the
+// other half to complete and remount the partition read-only.
+func registerBootReport(mounted []storage.Mountpoint) {
+	reportState.scanned = time.Now()
+	bootconfig.RegisterPreBootHook(bootconfig.PreBootHook{
+		Name:    "boot-report",
+		Timeout: bootReportTimeout,
+		Run: func(bc *bootconfig.BootConfig) error {
+			deadline := time.Now().Add(bootReportTimeout / 2)
+			return writeBootReport(newBootReport(*bc, time.Now()), reportTargets(mounted, bc.Device), deadline)
+		},
+	})
+}
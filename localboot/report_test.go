@@ -0,0 +1,128 @@
+package main
+
+import (
+	gocrypto "crypto"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/systemboot/systemboot/pkg/bootconfig"
+	"github.com/systemboot/systemboot/pkg/crypto"
+	"github.com/systemboot/systemboot/pkg/storage"
+)
+
+// fakeRemount records the remounts, failing the read-write ones of the
+// partitions in readOnly.
+func fakeRemount(readOnly ...string) (*[]string, func()) {
+	orig := remount
+	var calls []string
+	remount = func(mp storage.Mountpoint, ro bool) error {
+		mode := "rw"
+		if ro {
+			mode = "ro"
+		}
+		calls = append(calls, mp.DeviceName+" "+mode)
+		for _, dev := range readOnly {
+			if dev == mp.DeviceName && !ro {
+				return errors.New("read-only device")
+			}
+		}
+		return nil
+	}
+	return &calls, func() { remount = orig }
+}
+
+func TestWriteBootReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "report")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	esp := storage.Mountpoint{DeviceName: "/dev/sda1", Path: path.Join(dir, "sda1"), FsType: "vfat"}
+	root := storage.Mountpoint{DeviceName: "/dev/sda2", Path: path.Join(dir, "sda2"), FsType: "ext4"}
+	writeTestFile(t, esp.Path, "EFI/BOOT/BOOTX64.EFI", "")
+	writeTestFile(t, root.Path, "boot/vmlinuz", "")
+	defer func() { reportState.failed = nil }()
+	reportState.failed = []failedBoot{{Name: "Linux 5.4", Kernel: "/mnt/sda2/boot/vmlinuz-5.4", Error: "kexec failed"}}
+
+	cfg := bootconfig.BootConfig{Name: "Linux 5.3", Kernel: "/mnt/sda2/boot/vmlinuz-5.3", KernelArgs: "root=/dev/sda2", Device: root.DeviceName}
+	targets := reportTargets([]storage.Mountpoint{root, esp}, cfg.Device)
+	require.Equal(t, []reportTarget{{esp, espReportPath}, {root, diskReportPath}}, targets)
+
+	calls, restore := fakeRemount()
+	defer restore()
+	report := newBootReport(cfg, started.Add(1500*time.Millisecond))
+	require.NoError(t, writeBootReport(report, targets, time.Now().Add(time.Minute)))
+	require.Equal(t, []string{"/dev/sda1 rw", "/dev/sda1 ro"}, *calls)
+
+	data, err := ioutil.ReadFile(path.Join(esp.Path, espReportPath))
+	require.NoError(t, err)
+	var written bootReport
+	require.NoError(t, json.Unmarshal(data, &written))
+	require.Equal(t, cfg, written.Entry)
+	require.Equal(t, reportState.failed, written.Failed)
+	require.Equal(t, int64(1500), written.Timings.BootMs)
+	require.Equal(t, "SHA-256", written.Measurements.Hash)
+	// no temporary file is left behind
+	files, err := ioutil.ReadDir(path.Join(esp.Path, "EFI/systemboot"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+}
+
+func TestReportPCRs(t *testing.T) {
+	defer func(orig func([]int, gocrypto.Hash) (map[int][]byte, error)) { readPCRs = orig }(readPCRs)
+	var selection []int
+	readPCRs = func(pcrs []int, alg gocrypto.Hash) (map[int][]byte, error) {
+		selection = pcrs
+		return map[int][]byte{8: {0xab, 0xcd}, 9: {0x01}}, nil
+	}
+	require.Nil(t, reportPCRs(nil))
+	require.Nil(t, selection)
+	events := []crypto.Event{{PCR: 8}, {PCR: 9}, {PCR: 8}}
+	require.Equal(t, map[int]string{8: "abcd", 9: "01"}, reportPCRs(events))
+	require.Equal(t, []int{8, 9}, selection)
+
+	readPCRs = func(pcrs []int, alg gocrypto.Hash) (map[int][]byte, error) {
+		return nil, errors.New("no TPM")
+	}
+	require.Nil(t, reportPCRs(events))
+}
+
+func TestWriteBootReportFallback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "report")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	esp := storage.Mountpoint{DeviceName: "/dev/sda1", Path: path.Join(dir, "sda1"), FsType: "vfat"}
+	root := storage.Mountpoint{DeviceName: "/dev/sda2", Path: path.Join(dir, "sda2"), FsType: "ext4"}
+	writeTestFile(t, esp.Path, "EFI/BOOT/BOOTX64.EFI", "")
+	targets := []reportTarget{{esp, espReportPath}, {root, diskReportPath}}
+	report := newBootReport(bootconfig.BootConfig{Name: "Linux", Device: root.DeviceName}, time.Now())
+
+	// the ESP cannot be written
+	calls, restore := fakeRemount(esp.DeviceName)
+	defer restore()
+	require.NoError(t, writeBootReport(report, targets, time.Now().Add(time.Minute)))
+	require.Equal(t, []string{"/dev/sda1 rw", "/dev/sda2 rw", "/dev/sda2 ro"}, *calls)
+	_, err = os.Stat(path.Join(root.Path, diskReportPath))
+	require.NoError(t, err)
+
+	// too late: nothing is remounted, without error
+	require.NoError(t, os.RemoveAll(root.Path))
+	calls, restore = fakeRemount()
+	defer restore()
+	require.NoError(t, writeBootReport(report, targets, time.Now().Add(-time.Second)))
+	require.Empty(t, *calls)
+	_, err = os.Stat(path.Join(root.Path, diskReportPath))
+	require.True(t, os.IsNotExist(err))
+
+	// no writable partition: nothing is written, without error
+	require.NoError(t, os.RemoveAll(root.Path))
+	_, restore = fakeRemount(esp.DeviceName, root.DeviceName)
+	defer restore()
+	require.NoError(t, writeBootReport(report, targets, time.Now().Add(time.Minute)))
+	_, err = os.Stat(path.Join(root.Path, diskReportPath))
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"time"
+
+	"github.com/systemboot/systemboot/pkg/bootconfig"
+	"github.com/systemboot/systemboot/pkg/bootscan"
+	"github.com/systemboot/systemboot/pkg/crypto"
+	"github.com/systemboot/systemboot/pkg/safemode"
+	"github.com/systemboot/systemboot/pkg/slot"
+	"github.com/systemboot/systemboot/pkg/storage"
+)
+
+// findSlotDisk returns the disk that holds the A/B slot partitions.
+func findSlotDisk(devices []storage.BlockDev) (string, error) {
+	for _, dev := range devices {
+		disk := path.Join("/dev", dev.Name)
+		if _, _, err := storage.GPTPartitionAttributes(disk, slot.NameA); err == nil {
+			return disk, nil
+		}
+	}
+	return "", fmt.Errorf("no disk with a %s partition found", slot.NameA)
+}
+
+// BootSlotMode boots the active slot of an A/B partition scheme. The slot
+// state is read from the GPT partition attributes or from VPD, depending on
+// storeKind. The active slot's tries are used up right before kexec, and if
+// it ran out of tries without the OS having marked it as successfully booted,
+// the other slot is booted instead.
+func BootSlotMode(devices []storage.BlockDev, baseMountpoint, storeKind string, dryrun bool) error {
+	disk, err := findSlotDisk(devices)
+	if err != nil {
+		return err
+	}
+	gptStore := slot.NewGPTStore(disk)
+	var store slot.Store
+	switch storeKind {
+	case "gpt":
+		store = gptStore
+	case "vpd":
+		store = slot.NewVPDStore()
+	default:
+		return fmt.Errorf("unknown slot marker %q, must be gpt or vpd", storeKind)
+	}
+	slots, err := store.Slots()
+	if err != nil {
+		return fmt.Errorf("cannot read the slot marker: %v", err)
+	}
+	debug("Slots: %v", slots)
+	sel, err := slot.SelectWithCooldown(slots, time.Now(), *flagSlotCooldown)
+	if err != nil {
+		return err
+	}
+	if sel.CooledDown != "" {
+		msg := fmt.Sprintf("A/B cooldown: skipping %s, booting %s", sel.CooledDown, sel.Slot.Name)
+		log.Printf("%s: %s was tried less than %v ago", msg, sel.CooledDown, *flagSlotCooldown)
+		crypto.TryMeasureData(crypto.BootConfig, []byte(msg), msg)
+	}
+	if sel.RolledBackFrom != "" {
+		msg := fmt.Sprintf("A/B rollback from %s to %s", sel.RolledBackFrom, sel.Slot.Name)
+		log.Printf("%s: %s ran out of tries without booting successfully", msg, sel.RolledBackFrom)
+		crypto.TryMeasureData(crypto.BootConfig, []byte(msg), msg)
+	}
+	log.Printf("Booting slot %v", sel.Slot)
+
+	devname, err := gptStore.Device(sel.Slot.Name)
+	if err != nil {
+		return err
+	}
+	filesystems, err := storage.GetSupportedFilesystems()
+	if err != nil {
+		return err
+	}
+	mountpoint, err := storage.Mount(devname, path.Join(baseMountpoint, path.Base(devname)), filesystems)
+	if err != nil {
+		return fmt.Errorf("cannot mount slot %s (%s): %v", sel.Slot.Name, devname, err)
+	}
+	defer func() {
+		for _, err := range storage.UnmountAll([]storage.Mountpoint{*mountpoint}) {
+			log.Printf("Not cleanly unmounted: %v", err)
+		}
+	}()
+	entries := bootscan.Scan(mountpoint.Path, scanOptions())
+	// before the slot partition the images are on
+	defer loopback.unmountAll()
+	bootscan.SetDevice(entries, devname)
+	bootconfigs := applyPolicy(bootscan.BootConfigs(entries))
+	if *flagAddConsoles {
+		consoles := bootconfig.DetectConsoles()
+		for idx := range bootconfigs {
+			addConsoles(&bootconfigs[idx], consoles)
+		}
+	}
+	bootconfigs = expandTemplates(bootconfigs)
+	if len(bootconfigs) == 0 {
+		return fmt.Errorf("no boot configuration found in slot %s", sel.Slot.Name)
+	}
+	if dryrun {
+		if safemode.Enabled() {
+			reportMenu(bootconfigs)
+			return nil
+		}
+		log.Printf("Dry-run mode: will not update slot %s nor boot %+v", sel.Slot.Name, bootconfigs[0])
+		return nil
+	}
+	if err := sel.Commit(store); err != nil {
+		return err
+	}
+	for idx, cfg := range bootconfigs {
+		if skipAction(idx, cfg) {
+			continue
+		}
+		if cfg.Protected {
+			log.Printf("Skipping boot configuration %q, it is password-protected", cfg.Name)
+			continue
+		}
+		debug("Trying boot configuration %+v", cfg)
+		if err := bootVerified(cfg); err != nil {
+			log.Printf("Failed to boot kernel %s: %v", cfg.Kernel, err)
+		}
+	}
+	return fmt.Errorf("no boot configuration of slot %s succeeded", sel.Slot.Name)
+}
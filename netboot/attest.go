@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/systemboot/systemboot/pkg/fetch"
+	"github.com/systemboot/systemboot/pkg/policy"
+)
+
+// attestTimeout is how long each request to the -attest server may take.
+const attestTimeout = 30 * time.Second
+
+// attestChallenge is what the -attest server replies to a GET: a nonce, in
+// hexadecimal, and the PCRs it wants quoted, all the ones allowed by the
+// policy if empty.
+type attestChallenge struct {
+	Nonce string `json:"nonce"`
+	PCRs  []int  `json:"pcrs,omitempty"`
+}
+
+// attest sends a TPM quote to the provisioning server at rawurl: it gets a
+// challenge with a GET, and POSTs the quote, signed with the attestation key
+// in akContext, back to the same URL. The quoted PCRs are restricted by the
+// quote_pcrs of the boot policy, and a request for any other PCR is refused.
+func attest(rawurl, akContext string, p *policy.Policy) error {
+	client := &http.Client{Timeout: attestTimeout}
+	resp, err := client.Get(rawurl)
+	if err != nil {
+		return fmt.Errorf("cannot get the attestation challenge: %v", err)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("cannot read the attestation challenge: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &fetch.StatusError{URL: fetch.Redact(rawurl), StatusCode: resp.StatusCode}
+	}
+	var challenge attestChallenge
+	if err := json.Unmarshal(data, &challenge); err != nil {
+		return fmt.Errorf("invalid attestation challenge: %v", err)
+	}
+	nonce, err := hex.DecodeString(challenge.Nonce)
+	if err != nil || len(nonce) == 0 {
+		return fmt.Errorf("invalid attestation nonce %q", challenge.Nonce)
+	}
+	quote, err := p.Quote(akContext, challenge.PCRs, nonce)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(quote)
+	if err != nil {
+		return err
+	}
+	resp, err = client.Post(rawurl, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("cannot send the TPM quote: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &fetch.StatusError{URL: fetch.Redact(rawurl), StatusCode: resp.StatusCode}
+	}
+	log.Printf("Attestation: sent a quote of PCRs %v to %s", quote.Selection, fetch.Redact(rawurl))
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/systemboot/systemboot/pkg/duid"
+	"github.com/systemboot/systemboot/pkg/vpd"
+)
+
+// duidVPDKey is the VPD key selecting the DHCPv6 DUID when -duid is not set.
+const duidVPDKey = "systemboot_duid"
+
+// vpdGet and smbiosUUIDPath are variables so they can be overridden for
+// testing.
+var (
+	vpdGet         = vpd.Get
+	smbiosUUIDPath = "/sys/class/dmi/id/product_uuid"
+)
+
+// duidSpec returns the DUID selected with -duid, or else with the VPD, first
+// read-write then read-only. An empty string means that the DHCP library picks
+// the DUID.
+func duidSpec() string {
+	if *duidType != "" {
+		return *duidType
+	}
+	for _, readOnly := range []bool{false, true} {
+		if value, err := vpdGet(duidVPDKey, readOnly); err == nil {
+			if spec := strings.TrimSpace(string(value)); spec != "" {
+				return spec
+			}
+		}
+	}
+	return ""
+}
+
+// generateDUID returns the DUID for an interface given its spec: `ll`, `llt`,
+// `uuid`, or an explicit DUID in hexadecimal.
+func generateDUID(spec, ifname string) ([]byte, error) {
+	switch spec {
+	case "ll", "llt":
+		hwaddr, err := permanentHardwareAddr(ifname)
+		if err != nil {
+			return nil, err
+		}
+		hwtype, err := hardwareType(ifname)
+		if err != nil {
+			return nil, err
+		}
+		if spec == "ll" {
+			return duid.LL(hwtype, hwaddr)
+		}
+		// a time that is not persisted would give a new DUID on every boot,
+		// and the initramfs is not persistent
+		if *duidTimeFile == "" {
+			return nil, errors.New("a DUID-LLT requires -duid-time-file on persistent storage")
+		}
+		t, err := duid.PersistentTime(*duidTimeFile, time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("cannot persist the DUID-LLT time in %s: %v", *duidTimeFile, err)
+		}
+		return duid.LLT(hwtype, hwaddr, t)
+	case "uuid":
+		uuid, err := ioutil.ReadFile(smbiosUUIDPath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read the SMBIOS system UUID: %v", err)
+		}
+		return duid.UUID(string(uuid))
+	}
+	return duid.Parse(spec)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"time"
+
+	"github.com/systemboot/systemboot/pkg/bootconfig"
+	"github.com/systemboot/systemboot/pkg/bootscan"
+	"github.com/systemboot/systemboot/pkg/crypto"
+	"github.com/systemboot/systemboot/pkg/fetch"
+	"github.com/systemboot/systemboot/pkg/storage"
+)
+
+// FlashImageSignatureExt is appended to the URL of a disk image to get the URL
+// of its detached signature, see -flash-image-key.
+const FlashImageSignatureExt = ".sig"
+
+// flashRescanTimeout is how long to wait for the partitions of a flashed
+// device to show up.
+const flashRescanTimeout = 10 * time.Second
+
+// fetchImage downloads the disk image at rawurl, and verifies it against the
+// checksum set with -flash-image-checksum, or else its sidecar checksum file,
+// and against its signature if -flash-image-key is set. Without a key, the
+// image is rejected if it has no checksum. It is held in memory, so it is
+// rejected if it is larger than -flash-image-max-size.
+func fetchImage(fetcher *fetch.Fetcher, rawurl string) ([]byte, error) {
+	var checksum *fetch.Checksum
+	if *flashChecksum != "" {
+		var err error
+		if checksum, err = fetch.ParseChecksum(*flashChecksum); err != nil {
+			return nil, err
+		}
+	}
+	f := *fetcher
+	f.MaxSize = *flashMaxSize
+	if *flashKey == "" {
+		// the image erases the device, it is never written unverified
+		f.RequireChecksums = true
+	}
+	image, err := f.Fetch(rawurl, checksum)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch the disk image: %v", err)
+	}
+	if *flashKey == "" {
+		log.Printf("No public key specified, the signature of the disk image %s is not verified", rawurl)
+		return image, nil
+	}
+	verifier, err := crypto.LoadVerifierFromFile(*flashKey)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load the disk image key: %v", err)
+	}
+	signature, err := f.Fetch(rawurl+FlashImageSignatureExt, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch the disk image signature: %v", err)
+	}
+	if err := crypto.VerifySignature(image, signature, verifier); err != nil {
+		return nil, fmt.Errorf("disk image: %v", err)
+	}
+	return image, nil
+}
+
+// flashAndBoot writes the disk image set with -flash-image to -flash-device,
+// then boots the first boot configuration found on the partitions of the
+// device, like localboot.
+func flashAndBoot(fetcher *fetch.Fetcher, rawurl, devpath string) error {
+	log.Printf("Flash: fetching the disk image %s", rawurl)
+	image, err := fetchImage(fetcher, rawurl)
+	if err != nil {
+		return fmt.Errorf("Flash: %v", err)
+	}
+	if *dryRun {
+		log.Printf("Dry-run, will not write the %d-byte disk image to %s", len(image), devpath)
+		return nil
+	}
+	log.Printf("Flash: writing the %d-byte disk image to %s", len(image), devpath)
+	// the progress is logged every 10%
+	var next int64
+	err = storage.FlashImage(image, devpath, func(written, total int64) {
+		if percent := written * 100 / total; percent >= next {
+			log.Printf("Flash: %d%% written", percent)
+			next = percent/10*10 + 10
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("Flash: %v", err)
+	}
+	devices, err := storage.RescanDevice(devpath, flashRescanTimeout)
+	if err != nil {
+		return fmt.Errorf("Flash: cannot rescan %s: %v", devpath, err)
+	}
+	debug("Flash: block devices after the rescan: %+v", devices)
+	return bootFlashed(devices)
+}
+
+// bootFlashed scans the partitions of a freshly written device for boot
+// configurations, and boots the first one that boots.
+func bootFlashed(devices []storage.BlockDev) error {
+	filesystems, err := storage.GetSupportedFilesystems()
+	if err != nil {
+		return err
+	}
+	mountDir, err := ioutil.TempDir(os.TempDir(), "flash")
+	if err != nil {
+		return err
+	}
+	opts := bootscan.Options{
+		Measure: func(cfgpath string, data []byte) {
+			crypto.TryMeasureData(crypto.ConfigData, data, cfgpath)
+		},
+		Logf:   log.Printf,
+		Debugf: debug,
+	}
+	var bootconfigs []bootconfig.BootConfig
+	for _, dev := range devices {
+		devpath := "/dev/" + dev.Name
+		mp, err := storage.Mount(devpath, path.Join(mountDir, dev.Name), filesystems)
+		if err != nil {
+			debug("Flash: cannot mount %s: %v", devpath, err)
+			continue
+		}
+		entries := bootscan.Scan(mp.Path, opts)
+		bootscan.SetDevice(entries, mp.DeviceName)
+		bootconfigs = append(bootconfigs, bootscan.BootConfigs(entries)...)
+	}
+	if len(bootconfigs) == 0 {
+		return fmt.Errorf("Flash: no boot configuration found on the flashed device")
+	}
+	for _, cfg := range bootconfigs {
+		if !cfg.IsValid() {
+			continue
+		}
+		if cfg.Protected {
+			log.Printf("Flash: skipping %q, it is password-protected", cfg.Name)
+			continue
+		}
+		if cfg.Action != "" {
+			debug("Flash: skipping %q, its action is %s", cfg.Name, cfg.Action)
+			continue
+		}
+		log.Printf("Flash: booting %q, kernel %s", cfg.Name, cfg.Kernel)
+		if err := cfg.Boot(); err != nil {
+			log.Printf("Flash: failed to boot %q: %v", cfg.Name, err)
+		}
+	}
+	return fmt.Errorf("Flash: no boot configuration of the flashed device could be booted")
+}
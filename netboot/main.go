@@ -3,10 +3,10 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net"
-	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -18,7 +18,14 @@ import (
 	"github.com/insomniacslk/dhcp/iana"
 	"github.com/insomniacslk/dhcp/interfaces"
 	"github.com/insomniacslk/dhcp/netboot"
+	"github.com/systemboot/systemboot/pkg/bootconfig"
+	"github.com/systemboot/systemboot/pkg/booter"
 	"github.com/systemboot/systemboot/pkg/crypto"
+	"github.com/systemboot/systemboot/pkg/duid"
+	"github.com/systemboot/systemboot/pkg/fetch"
+	"github.com/systemboot/systemboot/pkg/policy"
+	"github.com/systemboot/systemboot/pkg/remotelog"
+	"github.com/systemboot/systemboot/pkg/slaac"
 	"github.com/u-root/u-root/pkg/kexec"
 )
 
@@ -29,16 +36,39 @@ var (
 	dryRun             = flag.Bool("dryrun", false, "Do everything except assigning IP addresses, changing DNS, and kexec")
 	doDebug            = flag.Bool("d", false, "Print debug output")
 	skipDHCP           = flag.Bool("skip-dhcp", false, "Skip DHCP and rely on SLAAC for network configuration. This requires -netboot-url")
+	useSLAAC           = flag.Bool("slaac", false, "Instead of getting a DHCPv6 lease, configure the interface with SLAAC and get the boot file URL with a stateless DHCPv6 information request")
+	slaacTimeout       = flag.Int("slaac-timeout", 10, "How long to wait, in seconds, for SLAAC to configure a global address, and then for a reply to the DHCPv6 information request")
 	overrideNetbootURL = flag.String("netboot-url", "", "Override the netboot URL normally obtained via DHCP")
 	readTimeout        = flag.Int("timeout", 3, "Read timeout in seconds")
 	dhcpRetries        = flag.Int("retries", 3, "Number of times a DHCP request is retried")
 	userClass          = flag.String("userclass", "", "Override DHCP User Class option")
+	remoteConfig       = flag.Bool("manifest", false, "Treat the boot file as a JSON manifest listing kernel, initrd and device tree URLs, regardless of its Content-Type")
+	manifestKey        = flag.String("manifest-key", "", "Public key file. If set, the manifest must have a valid ed25519 signature appended, like signed boot configuration ZIP files")
+	requireChecksums   = flag.Bool("require-checksums", false, "Refuse to boot files that cannot be verified against a checksum, e.g. a .sha256 sidecar file")
+	strictTemplate     = flag.Bool("strict-template", false, "Refuse to boot a manifest whose kernel command line has unknown ${sb:NAME} placeholders, instead of replacing them with empty strings")
+	duidType           = flag.String("duid", "", "DHCPv6 DUID: ll (from the permanent MAC address), llt (with a timestamp persisted in -duid-time-file), uuid (from the SMBIOS system UUID), or a hex DUID. Defaults to the systemboot_duid VPD key, if set")
+	duidTimeFile       = flag.String("duid-time-file", "", "File on persistent storage, e.g. a scratch partition, where the DUID-LLT timestamp is persisted. Required by -duid llt")
+	sftpUser           = flag.String("sftp-user", "", "User name for sftp:// URLs, unless the URL has one")
+	sftpKeyFile        = flag.String("sftp-key", "", "PEM-encoded SSH private key file for sftp:// URLs")
+	sftpPasswordFile   = flag.String("sftp-password-file", "", "File containing the SSH password for sftp:// URLs")
+	sftpKnownHosts     = flag.String("sftp-known-hosts", "", "known_hosts file used to verify the host key of SFTP servers")
+	sftpHostKeyPin     = flag.String("sftp-host-key", "", "SHA256 fingerprint of the host key of the SFTP server, e.g. SHA256:...")
+	resultFile         = flag.String("result", "", "Write the outcome of the boot attempts as JSON to this file, for diagnostics")
+	syslogURL          = flag.String("syslog", "", "Also send the logs to this remote syslog server, in RFC 5424 format, e.g. udp://10.0.0.1 or tcp://logs.example.com:6514. Logs that cannot be sent, e.g. before the network is configured, are dropped")
+	addConsoles        = flag.Bool("add-consoles", false, "Append console= parameters for the consoles of the running kernel and the ACPI SPCR serial port, if missing from the manifest's kernel command line")
+	preserveCrash      = flag.Bool("preserve-crashkernel", false, "Add the crashkernel= arguments of systemboot's own command line to the command line of the booted kernel when it has none, so that kdump keeps working after the kexec")
+	flashImageURL      = flag.String("flash-image", "", "Download this disk image, write it to -flash-device, and boot the configuration found on the device instead of the boot file. This erases the device")
+	flashDevice        = flag.String("flash-device", "", "Block device that the -flash-image is written to, e.g. /dev/sda. Required by -flash-image")
+	flashChecksum      = flag.String("flash-image-checksum", "", "Checksum that the -flash-image must match, e.g. sha256:<hex>. Without it, the .sha256 or .sha512 sidecar file of the image is used, if any")
+	flashKey           = flag.String("flash-image-key", "", "Public key file. If set, the -flash-image must have a valid signature at its URL with a .sig suffix. Without it, the image must match -flash-image-checksum or a sidecar checksum file")
+	policyFile         = flag.String("policy", "", "Boot policy file, whose quote_pcrs restrict the PCRs that -attest can reveal, and whose measurement_hash selects their bank")
+	attestURL          = flag.String("attest", "", "URL of a provisioning server to send a TPM quote to before fetching the boot file: a GET returns a JSON challenge with a hex nonce and the PCRs to quote, and the quote is POSTed back. Requires -policy and -attest-ak")
+	attestAK           = flag.String("attest-ak", "", "tpm2-tools context file of the attestation key that signs the -attest quote")
+	flashMaxSize       = flag.Int64("flash-image-max-size", 1<<30, "Maximum size in bytes of the -flash-image, which is held in memory while it is downloaded and verified")
 )
 
 const (
 	interfaceUpTimeout = 10 * time.Second
-	maxHTTPAttempts    = 3
-	retryInterval      = time.Second
 )
 
 var banner = `
@@ -55,15 +85,38 @@ var banner = `
 `
 var debug = func(string, ...interface{}) {}
 
+// bootPolicy is the policy loaded from -policy, if any.
+var bootPolicy *policy.Policy
+
 func main() {
 	flag.Parse()
 	if *skipDHCP && *overrideNetbootURL == "" {
 		log.Fatal("-skip-dhcp requires -netboot-url")
 	}
+	if *flashImageURL != "" && *flashDevice == "" {
+		log.Fatal("-flash-image requires -flash-device")
+	}
+	if *attestURL != "" && (*policyFile == "" || *attestAK == "") {
+		log.Fatal("-attest requires -policy and -attest-ak")
+	}
 	if *doDebug {
 		debug = log.Printf
 	}
+	bootconfig.SetPreserveCrashKernel(*preserveCrash)
+	if *syslogURL != "" {
+		w, err := remotelog.New(*syslogURL, "netboot")
+		if err != nil {
+			log.Fatalf("Invalid syslog URL: %v", err)
+		}
+		log.SetOutput(io.MultiWriter(os.Stderr, w))
+	}
 	log.Print(banner)
+	if *policyFile != "" {
+		var err error
+		if bootPolicy, err = policy.Load(*policyFile); err != nil {
+			log.Fatalf("Cannot load the boot policy: %v", err)
+		}
+	}
 
 	if !*useV6 && !*useV4 {
 		log.Fatal("At least one of DHCPv6 and DHCPv4 is required")
@@ -84,57 +137,54 @@ func main() {
 		}
 	}
 
+	var result booter.NetbootResult
 	for _, iface := range iflist {
 		log.Printf("Waiting for network interface %s to come up", iface.Name)
 		start := time.Now()
 		_, err := netboot.IfUp(iface.Name, interfaceUpTimeout)
 		if err != nil {
 			log.Printf("IfUp failed: %v", err)
+			result.NewAttempt(iface.Name, "").Error = fmt.Sprintf("IfUp failed: %v", err)
 			continue
 		}
 		debug("Interface %s is up after %v", iface.Name, time.Since(start))
 
-		var dhcp []dhcpFunc
+		var methods []dhcpMethod
 		if *useV6 {
-			dhcp = append(dhcp, dhcp6)
+			if *useSLAAC {
+				methods = append(methods, dhcpMethod{slaac.MechanismSLAAC, slaac6})
+			} else {
+				methods = append(methods, dhcpMethod{"dhcpv6", dhcp6})
+			}
 		}
 		if *useV4 {
-			dhcp = append(dhcp, dhcp4)
+			methods = append(methods, dhcpMethod{"dhcpv4", dhcp4})
 		}
-		for _, d := range dhcp {
-			if err := boot(iface.Name, d); err != nil {
+		for _, m := range methods {
+			protocol := m.protocol
+			if *skipDHCP {
+				protocol = "none"
+			}
+			attempt := result.NewAttempt(iface.Name, protocol)
+			if err := boot(iface.Name, m.request, attempt); err != nil {
 				log.Printf("Could not boot from %s: %v", iface.Name, err)
+				attempt.Error = err.Error()
 			}
 		}
 	}
 
-	log.Fatalln("Could not boot from any interfaces")
-}
-
-func retryableNetError(err error) bool {
-	if err == nil {
-		return false
-	}
-	switch err := err.(type) {
-	case net.Error:
-		if err.Timeout() {
-			return true
+	result.Error = "Could not boot from any interfaces"
+	if *resultFile != "" {
+		if err := result.WriteFile(*resultFile); err != nil {
+			log.Printf("Cannot write the result to %s: %v", *resultFile, err)
 		}
 	}
-	return false
-}
-
-func retryableHTTPError(resp *http.Response) bool {
-	if resp == nil {
-		return false
-	}
-	if resp.StatusCode == 500 || resp.StatusCode == 502 {
-		return true
-	}
-	return false
+	log.Fatalln(result.Error)
 }
 
-func boot(ifname string, dhcp dhcpFunc) error {
+// boot boots through an interface, recording the outcome of each step in
+// attempt.
+func boot(ifname string, dhcp dhcpFunc, attempt *booter.NetbootAttempt) error {
 	var (
 		netconf  *netboot.NetConf
 		bootfile string
@@ -144,8 +194,9 @@ func boot(ifname string, dhcp dhcpFunc) error {
 		log.Print("Skipping DHCP")
 	} else {
 		// send a netboot request via DHCP
-		netconf, bootfile, err = dhcp(ifname)
+		netconf, bootfile, err = dhcp(ifname, attempt)
 		if err != nil {
+			attempt.DHCPError = err.Error()
 			return fmt.Errorf("DHCPv6: netboot request for interface %s failed: %v", ifname, err)
 		}
 		debug("DHCP: network configuration: %+v", netconf)
@@ -160,39 +211,42 @@ func boot(ifname string, dhcp dhcpFunc) error {
 		}
 		log.Printf("DHCP: boot file for interface %s is %s", ifname, bootfile)
 	}
+	if *attestURL != "" {
+		if err := attest(*attestURL, *attestAK, bootPolicy); err != nil {
+			return fmt.Errorf("Attestation: %v", err)
+		}
+	}
+	if *flashImageURL != "" {
+		fetcher, err := newFetcher(*flashImageURL, attempt)
+		if err != nil {
+			return err
+		}
+		return flashAndBoot(fetcher, *flashImageURL, *flashDevice)
+	}
 	if *overrideNetbootURL != "" {
 		bootfile = *overrideNetbootURL
 	}
 	debug("DHCP: boot file URL is %s", bootfile)
+	attempt.BootFileURL = bootfile
 	// check for supported schemes
-	if !strings.HasPrefix(bootfile, "http://") {
-		return fmt.Errorf("DHCP: can only handle http scheme")
+	if !strings.HasPrefix(bootfile, "http://") && !strings.HasPrefix(bootfile, "sftp://") {
+		return fmt.Errorf("DHCP: can only handle http and sftp schemes")
 	}
 
 	log.Printf("DHCP: fetching boot file URL: %s", bootfile)
-	var resp *http.Response
-	for attempt := 0; attempt < maxHTTPAttempts; attempt++ {
-		log.Printf("netboot: attempt %d for http.Get", attempt+1)
-		resp, err = http.Get(bootfile)
-		if err != nil && retryableNetError(err) || retryableHTTPError(resp) {
-			time.Sleep(retryInterval)
-			continue
-		}
-		if err == nil {
-			break
-		}
-		return fmt.Errorf("DHCP: http.Get of %s failed: %v", bootfile, err)
-	}
-	// FIXME this will not be called if something fails after this point
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("Status code is not 200 OK: %d", resp.StatusCode)
+	fetcher, err := newFetcher(bootfile, attempt)
+	if err != nil {
+		return err
 	}
-	body, err := ioutil.ReadAll(resp.Body)
+	file, err := fetcher.FetchFile(bootfile, nil)
 	if err != nil {
-		return fmt.Errorf("DHCP: cannot read boot file from the network: %v", err)
+		return fmt.Errorf("DHCP: cannot fetch boot file: %v", err)
 	}
+	body := file.Data
 	crypto.TryMeasureData(crypto.BootConfig, body, bootfile)
+	if *remoteConfig || bootconfig.IsRemoteConfigContentType(file.ContentType) {
+		return bootRemoteConfig(bootfile, body, fetcher, templateVars(ifname, netconf))
+	}
 	u, err := url.Parse(bootfile)
 	if err != nil {
 		return fmt.Errorf("DHCP: cannot parse URL %s: %v", bootfile, err)
@@ -225,9 +279,148 @@ func boot(ifname string, dhcp dhcpFunc) error {
 	return nil
 }
 
-type dhcpFunc func(string) (*netboot.NetConf, string, error)
+// newFetcher returns a fetcher for rawurl that records its downloads in
+// attempt.
+func newFetcher(rawurl string, attempt *booter.NetbootAttempt) (*fetch.Fetcher, error) {
+	fetcher := fetch.NewFetcher()
+	fetcher.RequireChecksums = *requireChecksums
+	fetcher.OnFetch = attempt.RecordFetch
+	if strings.HasPrefix(rawurl, "sftp://") {
+		sftpConfig, err := sftpConfigFromFlags()
+		if err != nil {
+			return nil, fmt.Errorf("DHCP: %v", err)
+		}
+		fetcher.SFTP = sftpConfig
+	}
+	return fetcher, nil
+}
+
+// templateVars returns the values for the command line placeholders of a
+// manifest fetched through the given interface. netconf is nil if DHCP was
+// skipped.
+func templateVars(ifname string, netconf *netboot.NetConf) bootconfig.TemplateVars {
+	vars := make(bootconfig.TemplateVars)
+	if mac, err := permanentHardwareAddr(ifname); err == nil {
+		vars[bootconfig.TemplateMAC] = mac.String()
+	} else {
+		debug("Cannot get the hardware address of %s: %v", ifname, err)
+	}
+	if serial, err := bootconfig.SMBIOSSerial(); err == nil {
+		vars[bootconfig.TemplateSerial] = serial
+	} else {
+		debug("Cannot read the SMBIOS serial number: %v", err)
+	}
+	if netconf != nil && len(netconf.Addresses) > 0 {
+		vars[bootconfig.TemplateIP] = netconf.Addresses[0].IPNet.IP.String()
+	}
+	return vars
+}
 
-func dhcp6(ifname string) (*netboot.NetConf, string, error) {
+// sftpConfigFromFlags returns the SFTP configuration set on the command line.
+func sftpConfigFromFlags() (*fetch.SFTPConfig, error) {
+	config := fetch.SFTPConfig{
+		User:           *sftpUser,
+		KnownHostsFile: *sftpKnownHosts,
+		HostKeyPin:     *sftpHostKeyPin,
+		Timeout:        time.Duration(*readTimeout) * time.Second,
+	}
+	if *sftpKeyFile != "" {
+		key, err := ioutil.ReadFile(*sftpKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read SSH private key: %v", err)
+		}
+		config.PrivateKey = key
+	}
+	if *sftpPasswordFile != "" {
+		password, err := ioutil.ReadFile(*sftpPasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read SSH password: %v", err)
+		}
+		config.Password = strings.TrimRight(string(password), "\r\n")
+	}
+	return &config, nil
+}
+
+// bootRemoteConfig boots from a boot file that is a JSON manifest pointing to
+// the actual kernel, initrds and device tree. The remote boot configurations
+// of the manifest are tried in order until the files of one can be downloaded.
+func bootRemoteConfig(bootfile string, body []byte, fetcher *fetch.Fetcher, vars bootconfig.TemplateVars) error {
+	name := fetch.Redact(bootfile)
+	if *manifestKey != "" {
+		var err error
+		if body, err = bootconfig.VerifyAppendedSignature(body, *manifestKey); err != nil {
+			return fmt.Errorf("DHCP: cannot verify manifest %s: %v", name, err)
+		}
+		log.Printf("DHCP: manifest signature is valid")
+	} else {
+		log.Printf("DHCP: no public key specified, the manifest %s is not verified", name)
+	}
+	manifest, err := bootconfig.RemoteManifestFromBytes(body)
+	if err != nil {
+		return fmt.Errorf("DHCP: invalid manifest %s: %v", name, err)
+	}
+	base, err := url.Parse(bootfile)
+	if err != nil {
+		return fmt.Errorf("DHCP: cannot parse URL %s: %v", name, err)
+	}
+	var (
+		cfg     *bootconfig.BootConfig
+		tempDir string
+	)
+	for _, rc := range manifest.Remote {
+		if tempDir, err = ioutil.TempDir(os.TempDir(), "netboot"); err != nil {
+			return err
+		}
+		if cfg, err = rc.Download(fetcher, base, tempDir); err == nil {
+			break
+		}
+		log.Printf("DHCP: cannot download files for %q of manifest %s: %v", rc.Name, name, err)
+		removeAll(tempDir)
+	}
+	if cfg == nil {
+		return fmt.Errorf("DHCP: cannot download files for manifest %s", name)
+	}
+	// a successful kexec does not return, so this only runs on failure or in
+	// a dry run
+	defer removeAll(tempDir)
+	if *addConsoles {
+		if added := cfg.AddConsoles(bootconfig.DetectConsoles()); len(added) > 0 {
+			log.Printf("DHCP: added console parameters: %v", added)
+		}
+	}
+	// placeholders are expanded last, so they are visible in the dry-run
+	// output and measured along with the rest of the command line
+	if err := cfg.ExpandTemplate(vars, *strictTemplate); err != nil {
+		return fmt.Errorf("DHCP: cannot expand the kernel command line of manifest %s: %v", name, err)
+	}
+	debug("DHCP: boot configuration from manifest: %+v", cfg)
+	if *dryRun {
+		log.Printf("Dry-run, will not actually boot %+v", cfg)
+		return nil
+	}
+	log.Printf("DHCP: kexec'ing into %s", cfg.Kernel)
+	return cfg.Boot()
+}
+
+// removeAll removes a temporary directory, logging failures.
+func removeAll(dir string) {
+	if err := os.RemoveAll(dir); err != nil {
+		log.Printf("Cannot remove %s: %v", dir, err)
+	}
+}
+
+// dhcpFunc gets the network configuration and the boot file URL of an
+// interface. It can update the protocol of attempt, e.g. to report the
+// mechanism that configured the interface, and records the DHCPv6 DUID in it.
+type dhcpFunc func(string, *booter.NetbootAttempt) (*netboot.NetConf, string, error)
+
+// dhcpMethod is a DHCP request function along with its protocol name.
+type dhcpMethod struct {
+	protocol string
+	request  dhcpFunc
+}
+
+func dhcp6(ifname string, attempt *booter.NetbootAttempt) (*netboot.NetConf, string, error) {
 	log.Printf("Trying to obtain a DHCPv6 lease on %s", ifname)
 	modifiers := []dhcpv6.Modifier{
 		dhcpv6.WithArchType(iana.EFI_X86_64),
@@ -235,6 +428,19 @@ func dhcp6(ifname string) (*netboot.NetConf, string, error) {
 	if *userClass != "" {
 		modifiers = append(modifiers, dhcpv6.WithUserClass([]byte(*userClass)))
 	}
+	if spec := duidSpec(); spec != "" {
+		clientID, err := generateDUID(spec, ifname)
+		if err != nil {
+			return nil, "", fmt.Errorf("DHCPv6: cannot generate DUID %q for interface %s: %v", spec, ifname, err)
+		}
+		d, err := dhcpv6.DuidFromBytes(clientID)
+		if err != nil {
+			return nil, "", fmt.Errorf("DHCPv6: invalid DUID %s: %v", duid.String(clientID), err)
+		}
+		log.Printf("DHCPv6: using DUID %s (%s) on interface %s", duid.String(clientID), spec, ifname)
+		attempt.DUID = duid.String(clientID)
+		modifiers = append(modifiers, dhcpv6.WithClientID(*d))
+	}
 	conversation, err := netboot.RequestNetbootv6(ifname, time.Duration(*readTimeout)*time.Second, *dhcpRetries, modifiers...)
 	for _, m := range conversation {
 		debug(m.Summary())
@@ -245,7 +451,7 @@ func dhcp6(ifname string) (*netboot.NetConf, string, error) {
 	return netboot.ConversationToNetconf(conversation)
 }
 
-func dhcp4(ifname string) (*netboot.NetConf, string, error) {
+func dhcp4(ifname string, _ *booter.NetbootAttempt) (*netboot.NetConf, string, error) {
 	log.Printf("Trying to obtain a DHCPv4 lease on %s", ifname)
 	var modifiers []dhcpv4.Modifier
 	if *userClass != "" {
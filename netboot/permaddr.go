@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// ethtool constants, from linux/ethtool.h and linux/sockios.h
+const (
+	siocEthtool        = 0x8946
+	ethtoolGPermAddr   = 0x20
+	maxHardwareAddrLen = 32
+	// ifreqUnionSize is the size of the union of struct ifreq, from
+	// linux/if.h: its largest member is struct ifmap
+	ifreqUnionSize = 24
+)
+
+// sysClassNetPath is where the kernel exposes the attributes of the network
+// interfaces. It is a variable so it can be overridden for testing.
+var sysClassNetPath = "/sys/class/net"
+
+type ethtoolPermAddr struct {
+	cmd  uint32
+	size uint32
+	data [maxHardwareAddrLen]byte
+}
+
+// ifreqData is a struct ifreq with the ifr_data member of its union, padded
+// to the size of the union so that the kernel can copy the whole struct.
+type ifreqData struct {
+	name [syscall.IFNAMSIZ]byte
+	data uintptr
+	_    [ifreqUnionSize - unsafe.Sizeof(uintptr(0))]byte
+}
+
+// permanentHardwareAddr returns the permanent hardware address of a network
+// interface, i.e. the one burnt into the NIC, which is not affected by MAC
+// address changes. If the driver doesn't report it, the current hardware
+// address is returned instead.
+func permanentHardwareAddr(ifname string) (net.HardwareAddr, error) {
+	iface, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.Close(fd)
+	permAddr := ethtoolPermAddr{cmd: ethtoolGPermAddr, size: maxHardwareAddrLen}
+	var ifr ifreqData
+	copy(ifr.name[:], ifname)
+	ifr.data = uintptr(unsafe.Pointer(&permAddr))
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), siocEthtool, uintptr(unsafe.Pointer(&ifr)))
+	if errno != 0 || permAddr.size == 0 || permAddr.size > maxHardwareAddrLen {
+		if len(iface.HardwareAddr) == 0 {
+			return nil, fmt.Errorf("cannot get the hardware address of %s", ifname)
+		}
+		debug("Cannot get the permanent hardware address of %s (%v), using the current one", ifname, errno)
+		return iface.HardwareAddr, nil
+	}
+	addr := net.HardwareAddr(permAddr.data[:permAddr.size])
+	for _, b := range addr {
+		if b != 0 {
+			return addr, nil
+		}
+	}
+	// some drivers report an all-zero permanent address
+	return iface.HardwareAddr, nil
+}
+
+// hardwareType returns the hardware type of a network interface, e.g. 1 for
+// Ethernet or 32 for InfiniBand. The ARPHRD_* type reported by the kernel is
+// the IANA hardware type for the usual link layers.
+func hardwareType(ifname string) (uint16, error) {
+	data, err := ioutil.ReadFile(path.Join(sysClassNetPath, ifname, "type"))
+	if err != nil {
+		return 0, fmt.Errorf("cannot get the hardware type of %s: %v", ifname, err)
+	}
+	hwtype, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid hardware type of %s: %v", ifname, err)
+	}
+	return uint16(hwtype), nil
+}
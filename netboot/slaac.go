@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/insomniacslk/dhcp/netboot"
+	"github.com/systemboot/systemboot/pkg/booter"
+	"github.com/systemboot/systemboot/pkg/duid"
+	"github.com/systemboot/systemboot/pkg/slaac"
+)
+
+// slaac6 configures an interface with SLAAC and gets the boot file URL with a
+// DHCPv6 information request, and reports the mechanism that configured the
+// interface as the protocol of attempt, along with the DUID. The addresses and routes are set by
+// the kernel, so the returned configuration only has the DNS servers.
+func slaac6(ifname string, attempt *booter.NetbootAttempt) (*netboot.NetConf, string, error) {
+	log.Printf("Waiting for SLAAC on %s", ifname)
+	var clientID []byte
+	if spec := duidSpec(); spec != "" {
+		var err error
+		if clientID, err = generateDUID(spec, ifname); err != nil {
+			return nil, "", fmt.Errorf("DHCPv6: cannot generate DUID %q for interface %s: %v", spec, ifname, err)
+		}
+		log.Printf("DHCPv6: using DUID %s (%s) on interface %s", duid.String(clientID), spec, ifname)
+		attempt.DUID = duid.String(clientID)
+	}
+	cfg, err := slaac.Configure(slaac.Kernel, ifname, clientID, time.Duration(*slaacTimeout)*time.Second, *overrideNetbootURL == "")
+	if err != nil {
+		return nil, "", err
+	}
+	attempt.Protocol = cfg.Mechanism
+	for _, addr := range cfg.Addresses {
+		debug("SLAAC: address %s on interface %s", addr.String(), ifname)
+	}
+	log.Printf("Interface %s configured by %s", ifname, cfg.Mechanism)
+	return &netboot.NetConf{DNSServers: cfg.DNSServers}, cfg.BootFileURL, nil
+}
@@ -0,0 +1,40 @@
+package bootconfig
+
+import (
+	"fmt"
+	"log"
+	"syscall"
+
+	"github.com/systemboot/systemboot/pkg/safemode"
+)
+
+// rebootCmds maps the actions to the commands of the reboot system call.
+var rebootCmds = map[string]int{
+	ActionHalt:     syscall.LINUX_REBOOT_CMD_HALT,
+	ActionReboot:   syscall.LINUX_REBOOT_CMD_RESTART,
+	ActionPoweroff: syscall.LINUX_REBOOT_CMD_POWER_OFF,
+}
+
+// reboot syncs the file systems and calls the reboot system call. It is a
+// variable so it can be overridden for testing.
+var reboot = func(cmd int) error {
+	syscall.Sync()
+	return syscall.Reboot(cmd)
+}
+
+// performAction halts, reboots or powers off the machine, as requested by the
+// action of the BootConfig.
+func (bc *BootConfig) performAction() error {
+	cmd, ok := rebootCmds[bc.Action]
+	if !ok {
+		return fmt.Errorf("unsupported boot config action %q", bc.Action)
+	}
+	if err := safemode.Check(bc.Action); err != nil {
+		return err
+	}
+	log.Printf("Performing action %s of %q", bc.Action, bc.Name)
+	if err := reboot(cmd); err != nil {
+		return fmt.Errorf("%s failed: %v", bc.Action, err)
+	}
+	return fmt.Errorf("unexpectedly returned from %s without error", bc.Action)
+}
@@ -0,0 +1,56 @@
+package bootconfig
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/systemboot/systemboot/pkg/safemode"
+)
+
+func fakeReboot() (*[]int, func()) {
+	var cmds []int
+	saved := reboot
+	reboot = func(cmd int) error {
+		cmds = append(cmds, cmd)
+		return nil
+	}
+	return &cmds, func() { reboot = saved }
+}
+
+func TestNewBootConfigAction(t *testing.T) {
+	c, err := NewBootConfig([]byte(`{"name": "Reboot", "action": "reboot"}`))
+	require.NoError(t, err)
+	require.Equal(t, ActionReboot, c.Action)
+	require.True(t, c.IsValid())
+
+	c, err = NewBootConfig([]byte(`{"name": "Suspend", "action": "suspend"}`))
+	require.NoError(t, err)
+	require.False(t, c.IsValid())
+}
+
+func TestBootAction(t *testing.T) {
+	cmds, restore := fakeReboot()
+	defer restore()
+	kexecCalls, restoreKexec := fakeKexec()
+	defer restoreKexec()
+
+	bc := BootConfig{Name: "Shutdown", Action: ActionPoweroff}
+	require.Error(t, bc.Boot())
+	bc = BootConfig{Name: "Reboot", Action: ActionReboot}
+	require.Error(t, bc.Boot())
+	require.Equal(t, []int{syscall.LINUX_REBOOT_CMD_POWER_OFF, syscall.LINUX_REBOOT_CMD_RESTART}, *cmds)
+	require.Empty(t, *kexecCalls)
+}
+
+func TestBootActionSafeMode(t *testing.T) {
+	cmds, restore := fakeReboot()
+	defer restore()
+	safemode.Enable()
+	defer safemode.Disable()
+
+	bc := BootConfig{Name: "Reboot", Action: ActionReboot}
+	err := bc.Boot()
+	require.IsType(t, &safemode.Error{}, err)
+	require.Empty(t, *cmds)
+}
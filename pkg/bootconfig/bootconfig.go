@@ -6,8 +6,10 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"strconv"
 
 	"github.com/systemboot/systemboot/pkg/crypto"
+	"github.com/systemboot/systemboot/pkg/safemode"
 	"github.com/u-root/u-root/pkg/kexec"
 	"github.com/u-root/u-root/pkg/kexecbin"
 )
@@ -21,23 +23,153 @@ type BootConfig struct {
 	Initramfs  string `json:"initramfs,omitempty"`
 	KernelArgs string `json:"kernel_args,omitempty"`
 	DeviceTree string `json:"devicetree,omitempty"`
+	// Subvolume is the btrfs subvolume that Kernel and Initramfs were
+	// resolved against, if any
+	Subvolume string `json:"subvolume,omitempty"`
+	// Device is the block device where Kernel and Initramfs were found, if
+	// any
+	Device string `json:"device,omitempty"`
+	// KexecConsole is the serial console of the kexec purgatory, e.g.
+	// `ttyS0,115200`, if any. See KexecConsoleArg
+	KexecConsole string `json:"kexec_console,omitempty"`
+	// Source is where the boot configuration was defined, if known
+	Source *Source `json:"source,omitempty"`
+	// Action, if set, is performed instead of booting a kernel, e.g. for
+	// the Reboot and Shutdown entries of a GRUB menu. See ActionReboot
+	Action string `json:"action,omitempty"`
+	// Protected is true if the boot loader requires a password to boot the
+	// configuration, so it must not be booted automatically
+	Protected bool `json:"protected,omitempty"`
+	// Unsupported are the boot loader features the configuration uses that
+	// systemboot does not support, e.g. FeatureCommandSubst, so it may not
+	// boot as intended
+	Unsupported []string `json:"unsupported,omitempty"`
+	// Firmware is the firmware type the boot loader entry is meant for,
+	// FirmwareUEFI or FirmwareBIOS, e.g. for the `linuxefi` and `linux16`
+	// GRUB commands, or empty if it is meant for any
+	Firmware string `json:"firmware,omitempty"`
 }
 
-// IsValid returns true if a BootConfig object has valid content, and false
-// otherwise
+// FeatureCommandSubst is a GRUB command substitution `$(...)`, which is kept
+// verbatim as systemboot cannot run the command.
+const FeatureCommandSubst = "command substitution"
+
+// FeatureConditionalSuperusers is a GRUB `set superusers` in an `if` block,
+// whose condition systemboot cannot evaluate, so the configuration is
+// considered password-protected.
+const FeatureConditionalSuperusers = "conditional superusers"
+
+// AddUnsupported records that the boot configuration uses a boot loader
+// feature that systemboot does not support.
+func (bc *BootConfig) AddUnsupported(feature string) {
+	for _, f := range bc.Unsupported {
+		if f == feature {
+			return
+		}
+	}
+	bc.Unsupported = append(bc.Unsupported, feature)
+}
+
+// Actions that a BootConfig can perform instead of booting a kernel.
+const (
+	ActionHalt     = "halt"
+	ActionReboot   = "reboot"
+	ActionPoweroff = "poweroff"
+)
+
+// IsAction returns true if the given string is a supported action.
+func IsAction(action string) bool {
+	switch action {
+	case ActionHalt, ActionReboot, ActionPoweroff:
+		return true
+	}
+	return false
+}
+
+// Source is the location of the config file entry that defined a boot
+// configuration.
+type Source struct {
+	// Path is the path of the config file
+	Path string `json:"path,omitempty"`
+	// Device is the block device holding the config file, if any
+	Device string `json:"device,omitempty"`
+	// Line is the line number, starting at 1, where the entry begins in the
+	// config file, or 0 if the entry is the whole file
+	Line int `json:"line,omitempty"`
+}
+
+// String returns the source as `device:path:line`, omitting the unknown parts.
+func (s *Source) String() string {
+	if s == nil {
+		return "unknown"
+	}
+	ret := s.Path
+	if s.Device != "" {
+		ret = s.Device + ":" + ret
+	}
+	if s.Line > 0 {
+		ret += ":" + strconv.Itoa(s.Line)
+	}
+	return ret
+}
+
+// IsValid returns true if a BootConfig object has valid content, i.e. a
+// kernel or a supported action, and false otherwise
 func (bc *BootConfig) IsValid() bool {
-	return bc.Kernel != ""
+	return bc.Kernel != "" || IsAction(bc.Action)
 }
 
 // Boot tries to boot the kernel with optional initramfs and command line
-// options. If a device-tree is specified, that will be used too
+// options. If a device-tree is specified, that will be used too. If a
+// dm-verity root hash sidecar file is found next to the kernel, the root hash
+// is passed to the kernel too, and so are the crash kernel reservations of
+// the running kernel if enabled with SetPreserveCrashKernel. A random seed is appended to the
+// initramfs if set with SetRandomSeed. The registered pre-boot hooks run once
+// the kernel is loaded, right before the kexec. If the BootConfig has an
+// action, the action is performed instead
 func (bc *BootConfig) Boot() error {
+	if bc.Action != "" {
+		return bc.performAction()
+	}
+	if err := safemode.Check("kexec " + bc.Kernel); err != nil {
+		return err
+	}
+	// the root hash is merged into the kernel arguments before measuring them
+	if err := bc.ApplyRootHashSidecar(); err != nil {
+		return err
+	}
+	if preserveCrashKernel {
+		bc.PreserveCrashKernel()
+	}
 	crypto.TryMeasureBootConfig(bc.Name, bc.Kernel, bc.Initramfs, bc.KernelArgs, bc.DeviceTree)
+	if err := bc.MeasureDeviceTree(); err != nil {
+		return err
+	}
+	// the random seed, if any, is appended once the initramfs is measured,
+	// to a copy that is only used to load the kernel
+	initramfs, cleanup, err := bc.seededInitramfs()
+	if err != nil {
+		log.Printf("Booting without a random seed: %v", err)
+		initramfs, cleanup = bc.Initramfs, func() {}
+	}
+	defer cleanup()
+
+	// the purgatory console is only supported by the kexec executable
+	if options := bc.kexecConsoleOptions(); options != nil {
+		log.Printf("Booting with kexec console %s", bc.kexecConsole())
+		return bc.kexecWithOptions(initramfs, options)
+	}
+
+	// kexecbin loads and executes in one go, so the pre-boot hooks need the
+	// kexec executable to run in between
+	if len(preBootHooks) > 0 && kexecAvailable() {
+		return bc.kexecWithOptions(initramfs, nil)
+	}
 
 	// kexec: try the kexecbin executable first
 	// if it is not available fallback to the Go implementation of kexec from u-root
 	log.Printf("Trying KexecBin on %+v", bc)
-	if err := kexecbin.KexecBin(bc.Kernel, bc.KernelArgs, bc.Initramfs, bc.DeviceTree); err != nil {
+	if err := kexecbin.KexecBin(bc.Kernel, bc.KernelArgs, initramfs, bc.DeviceTree); err != nil {
 		// If it was found nowhere in PATH it will be exec.Error{exec.ErrNotFound}, which we have to unpack
 		execErr, ok := err.(*exec.Error)
 		if (ok && execErr.Err == exec.ErrNotFound) || os.IsNotExist(err) {
@@ -51,9 +183,9 @@ func (bc *BootConfig) Boot() error {
 	if err != nil {
 		return err
 	}
-	var initramfs *os.File
-	if bc.Initramfs != "" {
-		initramfs, err = os.Open(bc.Initramfs)
+	var initramfsFile *os.File
+	if initramfs != "" {
+		initramfsFile, err = os.Open(initramfs)
 		if err != nil {
 			return err
 		}
@@ -65,13 +197,16 @@ func (bc *BootConfig) Boot() error {
 				log.Printf("Error closing kernel file descriptor: %v", err)
 			}
 		}
-		if initramfs != nil {
-			if err := initramfs.Close(); err != nil {
+		if initramfsFile != nil {
+			if err := initramfsFile.Close(); err != nil {
 				log.Printf("Error closing initramfs file descriptor: %v", err)
 			}
 		}
 	}()
-	if err := kexec.FileLoad(kernel, initramfs, bc.KernelArgs); err != nil {
+	if err := kexecLoad(kernel, initramfsFile, bc.KernelArgs); err != nil {
+		return err
+	}
+	if err := bc.runPreBootHooks(); err != nil {
 		return err
 	}
 
@@ -0,0 +1,158 @@
+package bootconfig
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// Arguments of the running kernel's command line that set a boot
+// configuration directly, without scanning for one, e.g.
+// `systemboot.kernel=/boot/vmlinuz systemboot.initrd=/boot/initrd.img
+// systemboot.args="root=/dev/sda2 ro" systemboot.device=sda1`.
+const (
+	CmdlineKernelArg = "systemboot.kernel"
+	CmdlineInitrdArg = "systemboot.initrd"
+	CmdlineArgsArg   = "systemboot.args"
+	CmdlineDeviceArg = "systemboot.device"
+)
+
+// BootUUIDArg is the argument of the running kernel's command line that
+// restricts the scan for boot configurations to the partition with the given
+// file system or partition UUID, e.g. `systemboot.bootuuid=0f1e2d3c-01`.
+const BootUUIDArg = "systemboot.bootuuid"
+
+// ReadBootUUID returns the UUID set on the running kernel's command line with
+// BootUUIDArg, if any.
+func ReadBootUUID() string {
+	data, err := ioutil.ReadFile(procCmdlinePath)
+	if err != nil {
+		return ""
+	}
+	return cmdlineValue(&BootConfig{KernelArgs: string(data)}, BootUUIDArg)
+}
+
+// BootLabelArg is the argument of the running kernel's command line that
+// restricts the scan for boot configurations to the partition with the given
+// file system label, e.g. `systemboot.bootlabel="Boot Disk"`.
+const BootLabelArg = "systemboot.bootlabel"
+
+// ReadBootLabel returns the label set on the running kernel's command line
+// with BootLabelArg, if any, without the quotes around it.
+func ReadBootLabel() string {
+	data, err := ioutil.ReadFile(procCmdlinePath)
+	if err != nil {
+		return ""
+	}
+	return cmdlineValue(&BootConfig{KernelArgs: string(data)}, BootLabelArg)
+}
+
+// BootFileArg is the argument of the running kernel's command line that
+// restricts the scan for boot configurations to a disk image file, set up on a
+// loop device, e.g. `systemboot.bootfile=/images/disk.img` for development.
+const BootFileArg = "systemboot.bootfile"
+
+// ReadBootFile returns the disk image file set on the running kernel's command
+// line with BootFileArg, if any.
+func ReadBootFile() string {
+	data, err := ioutil.ReadFile(procCmdlinePath)
+	if err != nil {
+		return ""
+	}
+	return cmdlineValue(&BootConfig{KernelArgs: string(data)}, BootFileArg)
+}
+
+// CmdlineConfig is a boot configuration set on the running kernel's command
+// line. Kernel and Initrd are either URLs, or paths on Device, or on the
+// running system if there is no Device.
+type CmdlineConfig struct {
+	Kernel string
+	Initrd string
+	Args   string
+	// Device is the block device to mount, e.g. /dev/sda1, if any
+	Device string
+}
+
+// cmdlineValue returns the last value of a command line argument, without the
+// double quotes around it.
+func cmdlineValue(running *BootConfig, key string) string {
+	values := running.GetArgs(key)
+	if len(values) == 0 {
+		return ""
+	}
+	value := values[len(values)-1]
+	if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+		value = value[1 : len(value)-1]
+	}
+	return value
+}
+
+// ReadCmdlineConfig reads the boot configuration set on the running kernel's
+// command line with CmdlineKernelArg and the related arguments. It returns nil
+// if CmdlineKernelArg is not set.
+func ReadCmdlineConfig() (*CmdlineConfig, error) {
+	data, err := ioutil.ReadFile(procCmdlinePath)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("cannot read the kernel command line, is proc mounted? %v", err)
+	} else if err != nil {
+		return nil, err
+	}
+	running := BootConfig{KernelArgs: string(data)}
+	cc := CmdlineConfig{
+		Kernel: cmdlineValue(&running, CmdlineKernelArg),
+		Initrd: cmdlineValue(&running, CmdlineInitrdArg),
+		Args:   cmdlineValue(&running, CmdlineArgsArg),
+		Device: cmdlineValue(&running, CmdlineDeviceArg),
+	}
+	if cc.Kernel == "" {
+		return nil, nil
+	}
+	if cc.Device != "" && !strings.HasPrefix(cc.Device, "/") {
+		cc.Device = path.Join("/dev", cc.Device)
+	}
+	if cc.IsRemote() {
+		if cc.Device != "" {
+			return nil, errors.New("a kernel URL cannot be on " + cc.Device)
+		}
+	} else if !path.IsAbs(cc.Kernel) || (cc.Initrd != "" && !path.IsAbs(cc.Initrd)) {
+		return nil, errors.New("the kernel and initrd must be absolute paths or URLs")
+	}
+	return &cc, nil
+}
+
+// IsRemote returns true if the kernel is a URL, e.g. http://server/vmlinuz.
+func (cc *CmdlineConfig) IsRemote() bool {
+	u, err := url.Parse(cc.Kernel)
+	return err == nil && u.Scheme != ""
+}
+
+// RemoteConfig returns the RemoteConfig of a remote CmdlineConfig, see
+// IsRemote, to download it with RemoteConfig.Download. The initrd URL can be
+// relative to the kernel URL.
+func (cc *CmdlineConfig) RemoteConfig() *RemoteConfig {
+	rc := RemoteConfig{Name: CmdlineKernelArg, Kernel: cc.Kernel, Cmdline: cc.Args}
+	if cc.Initrd != "" {
+		rc.Initrd = []string{cc.Initrd}
+	}
+	return &rc
+}
+
+// BootConfig returns the BootConfig of a local CmdlineConfig, whose Device,
+// if any, is mounted at basedir.
+func (cc *CmdlineConfig) BootConfig(basedir string) *BootConfig {
+	bc := BootConfig{
+		Name:       CmdlineKernelArg,
+		Kernel:     path.Join(basedir, cc.Kernel),
+		KernelArgs: cc.Args,
+		Device:     cc.Device,
+		Source:     &Source{Path: procCmdlinePath},
+	}
+	if cc.Initrd != "" {
+		bc.Initramfs = path.Join(basedir, cc.Initrd)
+	}
+	return &bc
+}
@@ -0,0 +1,89 @@
+package bootconfig
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadCmdlineConfig(t *testing.T) {
+	defer setRunningCmdline(t, `console=ttyS0 systemboot.kernel=/boot/vmlinuz systemboot.initrd=/boot/initrd.img systemboot.args="root=/dev/sda2 ro quiet" systemboot.device=sda1`)()
+	cc, err := ReadCmdlineConfig()
+	require.NoError(t, err)
+	require.Equal(t, &CmdlineConfig{
+		Kernel: "/boot/vmlinuz",
+		Initrd: "/boot/initrd.img",
+		Args:   "root=/dev/sda2 ro quiet",
+		Device: "/dev/sda1",
+	}, cc)
+	require.False(t, cc.IsRemote())
+	require.Equal(t, &BootConfig{
+		Name:       CmdlineKernelArg,
+		Kernel:     "/mnt/sda1/boot/vmlinuz",
+		Initramfs:  "/mnt/sda1/boot/initrd.img",
+		KernelArgs: "root=/dev/sda2 ro quiet",
+		Device:     "/dev/sda1",
+		Source:     &Source{Path: procCmdlinePath},
+	}, cc.BootConfig("/mnt/sda1"))
+}
+
+func TestReadCmdlineConfigRemote(t *testing.T) {
+	defer setRunningCmdline(t, `systemboot.kernel=http://10.0.0.1/boot/vmlinuz systemboot.initrd=initrd.img systemboot.args=console=ttyS0`)()
+	cc, err := ReadCmdlineConfig()
+	require.NoError(t, err)
+	require.True(t, cc.IsRemote())
+	require.Equal(t, &RemoteConfig{
+		Name:    CmdlineKernelArg,
+		Kernel:  "http://10.0.0.1/boot/vmlinuz",
+		Initrd:  []string{"initrd.img"},
+		Cmdline: "console=ttyS0",
+	}, cc.RemoteConfig())
+}
+
+func TestReadCmdlineConfigNone(t *testing.T) {
+	restore := setRunningCmdline(t, "console=ttyS0 systemboot.initrd=/boot/initrd.img")
+	cc, err := ReadCmdlineConfig()
+	require.NoError(t, err)
+	require.Nil(t, cc)
+	restore()
+
+	restore = setRunningCmdline(t, "systemboot.kernel=boot/vmlinuz")
+	_, err = ReadCmdlineConfig()
+	require.Error(t, err)
+	restore()
+
+	defer setRunningCmdline(t, "systemboot.kernel=http://10.0.0.1/vmlinuz systemboot.device=sda1")()
+	_, err = ReadCmdlineConfig()
+	require.Error(t, err)
+}
+
+func TestReadBootUUID(t *testing.T) {
+	restore := setRunningCmdline(t, "console=ttyS0 systemboot.bootuuid=0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0 quiet")
+	require.Equal(t, "0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0", ReadBootUUID())
+	restore()
+
+	defer setRunningCmdline(t, "console=ttyS0")()
+	require.Equal(t, "", ReadBootUUID())
+}
+
+func TestReadBootLabel(t *testing.T) {
+	restore := setRunningCmdline(t, `console=ttyS0 systemboot.bootlabel="Boot Disk" quiet`)
+	require.Equal(t, "Boot Disk", ReadBootLabel())
+	restore()
+
+	restore = setRunningCmdline(t, "systemboot.bootlabel=BOOT")
+	require.Equal(t, "BOOT", ReadBootLabel())
+	restore()
+
+	defer setRunningCmdline(t, "console=ttyS0")()
+	require.Equal(t, "", ReadBootLabel())
+}
+
+func TestReadBootFile(t *testing.T) {
+	restore := setRunningCmdline(t, "console=ttyS0 systemboot.bootfile=/images/disk.img")
+	require.Equal(t, "/images/disk.img", ReadBootFile())
+	restore()
+
+	defer setRunningCmdline(t, "console=ttyS0")()
+	require.Equal(t, "", ReadBootFile())
+}
@@ -0,0 +1,207 @@
+package bootconfig
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Paths used to detect the consoles of the running kernel. They are variables
+// so they can be overridden for testing.
+var (
+	procConsolesPath  = "/proc/consoles"
+	consoleActivePath = "/sys/class/tty/console/active"
+	procCmdlinePath   = "/proc/cmdline"
+	spcrPath          = "/sys/firmware/acpi/tables/SPCR"
+)
+
+// SPCR (Serial Port Console Redirection) table offsets and values, see
+// https://docs.microsoft.com/en-us/windows-hardware/drivers/serports/serial-port-console-redirection-table
+const (
+	spcrMinLength       = 80
+	spcrInterfaceType   = 36
+	spcrAddrSpaceID     = 40
+	spcrAccessSize      = 43
+	spcrAddress         = 44
+	spcrBaudRate        = 58
+	spcrType16550       = 0x00
+	spcrType16450       = 0x01
+	spcrType16550Compat = 0x12
+	acpiAddrSpaceMMIO   = 0
+	acpiAddrSpaceIO     = 1
+)
+
+var spcrBaudRates = map[byte]int{
+	3: 9600,
+	4: 19200,
+	6: 57600,
+	7: 115200,
+}
+
+// legacy PC serial ports, as enumerated by the 8250 driver
+var legacySerialPorts = map[uint64]string{
+	0x3f8: "ttyS0",
+	0x2f8: "ttyS1",
+	0x3e8: "ttyS2",
+	0x2e8: "ttyS3",
+}
+
+// ParseSPCR parses an ACPI SPCR table and returns the corresponding `console=`
+// value, e.g. `ttyS1,115200`, or `uart8250,mmio32,0xfe215040,115200` for
+// memory-mapped UARTs. Only 16550-compatible UARTs are supported.
+func ParseSPCR(data []byte) (string, error) {
+	if len(data) < spcrMinLength || string(data[:4]) != "SPCR" {
+		return "", errors.New("not a SPCR table")
+	}
+	length := binary.LittleEndian.Uint32(data[4:8])
+	if int(length) > len(data) {
+		return "", fmt.Errorf("truncated SPCR table: %d bytes, header says %d", len(data), length)
+	}
+	var sum byte
+	for _, b := range data[:length] {
+		sum += b
+	}
+	if sum != 0 {
+		return "", errors.New("invalid SPCR table checksum")
+	}
+	switch data[spcrInterfaceType] {
+	case spcrType16550, spcrType16450, spcrType16550Compat:
+	default:
+		return "", fmt.Errorf("unsupported SPCR interface type 0x%02x", data[spcrInterfaceType])
+	}
+	address := binary.LittleEndian.Uint64(data[spcrAddress : spcrAddress+8])
+	var console string
+	switch data[spcrAddrSpaceID] {
+	case acpiAddrSpaceIO:
+		if name, ok := legacySerialPorts[address]; ok {
+			console = name
+		} else {
+			console = fmt.Sprintf("uart8250,io,0x%x", address)
+		}
+	case acpiAddrSpaceMMIO:
+		iotype := "mmio"
+		if data[spcrAccessSize] == 3 {
+			iotype = "mmio32"
+		}
+		console = fmt.Sprintf("uart8250,%s,0x%x", iotype, address)
+	default:
+		return "", fmt.Errorf("unsupported SPCR address space %d", data[spcrAddrSpaceID])
+	}
+	// a baud rate of 0 means "as is", i.e. already configured by the firmware
+	if baud, ok := spcrBaudRates[data[spcrBaudRate]]; ok {
+		console = fmt.Sprintf("%s,%d", console, baud)
+	}
+	return console, nil
+}
+
+// consoleDevice returns the device part of a `console=` value, i.e. without
+// the options, e.g. `ttyS1` for `ttyS1,115200n8`. For `uart8250` consoles the
+// I/O type and address are part of the device.
+func consoleDevice(console string) string {
+	fields := strings.Split(console, ",")
+	if strings.HasPrefix(fields[0], "uart") && len(fields) >= 3 {
+		return strings.Join(fields[:3], ",")
+	}
+	return fields[0]
+}
+
+// parseProcConsoles parses /proc/consoles and returns the console devices,
+// with the one that /dev/console points to (flag `C`) last.
+func parseProcConsoles(data string) []string {
+	var consoles []string
+	preferred := ""
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		name := fields[0]
+		// skip consoles without a tty, e.g. netconsole or pstore
+		if !strings.HasPrefix(name, "tty") && !strings.HasPrefix(name, "hvc") {
+			continue
+		}
+		// the flags are between parentheses, e.g. `ttyS0 -W- (EC p a) 4:64`
+		start, end := strings.Index(line, "("), strings.Index(line, ")")
+		if start != -1 && end > start && strings.Contains(line[start:end], "C") {
+			preferred = name
+			continue
+		}
+		consoles = append(consoles, name)
+	}
+	if preferred != "" {
+		consoles = append(consoles, preferred)
+	}
+	return consoles
+}
+
+// DetectConsoles returns the `console=` values that reproduce the consoles
+// of the running kernel, with their options taken from the running kernel's
+// command line, or from the SPCR table for the serial port it describes. If
+// the SPCR serial port is not an active console, it is returned first, so
+// that the console /dev/console points to stays the same.
+func DetectConsoles() []string {
+	var devices []string
+	if data, err := ioutil.ReadFile(procConsolesPath); err == nil {
+		devices = parseProcConsoles(string(data))
+	} else if data, err := ioutil.ReadFile(consoleActivePath); err == nil {
+		devices = strings.Fields(string(data))
+	}
+
+	// options passed to the running kernel, by device
+	options := make(map[string]string)
+	if data, err := ioutil.ReadFile(procCmdlinePath); err == nil {
+		running := BootConfig{KernelArgs: string(data)}
+		for _, console := range running.GetArgs("console") {
+			options[consoleDevice(console)] = console
+		}
+	}
+	spcr := ""
+	if data, err := ioutil.ReadFile(spcrPath); err == nil {
+		if spcr, err = ParseSPCR(data); err != nil {
+			spcr = ""
+		}
+	}
+
+	var consoles []string
+	spcrActive := false
+	for _, device := range devices {
+		console := device
+		if opts, ok := options[device]; ok {
+			console = opts
+		} else if spcr != "" && consoleDevice(spcr) == device {
+			console = spcr
+		}
+		if spcr != "" && consoleDevice(spcr) == device {
+			spcrActive = true
+		}
+		consoles = append(consoles, console)
+	}
+	if spcr != "" && !spcrActive {
+		consoles = append([]string{spcr}, consoles...)
+	}
+	return consoles
+}
+
+// AddConsoles appends to the kernel arguments the given `console=` values
+// whose device is not already used by a `console=` argument. Existing
+// `console=` arguments are never removed or reordered, since their order
+// determines what /dev/console points to. It returns the added values.
+func (bc *BootConfig) AddConsoles(consoles []string) []string {
+	present := make(map[string]bool)
+	for _, console := range bc.GetArgs("console") {
+		present[consoleDevice(console)] = true
+	}
+	var added []string
+	for _, console := range consoles {
+		device := consoleDevice(console)
+		if present[device] {
+			continue
+		}
+		bc.AppendArg("console", console)
+		present[device] = true
+		added = append(added, console)
+	}
+	return added
+}
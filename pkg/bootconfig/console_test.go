@@ -0,0 +1,77 @@
+package bootconfig
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSPCR(t *testing.T) {
+	for fixture, expected := range map[string]string{
+		"testdata/spcr_ttyS1.bin":  "ttyS1,115200",
+		"testdata/spcr_mmio32.bin": "uart8250,mmio32,0xfe215040",
+	} {
+		data, err := ioutil.ReadFile(fixture)
+		require.NoError(t, err)
+		console, err := ParseSPCR(data)
+		require.NoError(t, err, fixture)
+		require.Equal(t, expected, console, fixture)
+	}
+}
+
+func TestParseSPCRInvalid(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/spcr_ttyS1.bin")
+	require.NoError(t, err)
+	_, err = ParseSPCR(data[:40])
+	require.Error(t, err)
+	// a corrupted byte makes the checksum invalid
+	corrupted := append([]byte{}, data...)
+	corrupted[58] = 6
+	_, err = ParseSPCR(corrupted)
+	require.Error(t, err)
+}
+
+func TestParseProcConsoles(t *testing.T) {
+	data := "tty0                 -WU (E  p  )    4:7\n" +
+		"ttyS1                -W- (EC p a)    4:65\n" +
+		"netcon0              -W- (E     )\n"
+	require.Equal(t, []string{"tty0", "ttyS1"}, parseProcConsoles(data))
+	data = "ttyS1                -W- (EC p a)    4:65\n" +
+		"tty0                 -WU (E  p  )    4:7\n"
+	require.Equal(t, []string{"tty0", "ttyS1"}, parseProcConsoles(data))
+}
+
+func TestAddConsoles(t *testing.T) {
+	bc := BootConfig{KernelArgs: "console=ttyS1,9600 root=/dev/sda1 console=tty0 -- single"}
+	added := bc.AddConsoles([]string{"ttyS0,115200", "ttyS1,115200", "tty0"})
+	require.Equal(t, []string{"ttyS0,115200"}, added)
+	// existing consoles are left alone, new ones are appended
+	require.Equal(t, "console=ttyS1,9600 root=/dev/sda1 console=tty0 console=ttyS0,115200 -- single", bc.KernelArgs)
+}
+
+func TestDetectConsoles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "consoles")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(a, b, c, d string) {
+		procConsolesPath, consoleActivePath, procCmdlinePath, spcrPath = a, b, c, d
+	}(procConsolesPath, consoleActivePath, procCmdlinePath, spcrPath)
+	procConsolesPath = path.Join(dir, "consoles")
+	consoleActivePath = path.Join(dir, "active")
+	procCmdlinePath = path.Join(dir, "cmdline")
+	spcrPath = "testdata/spcr_ttyS1.bin"
+
+	// ttyS1 is described by SPCR but not an active console
+	require.NoError(t, ioutil.WriteFile(consoleActivePath, []byte("tty0\n"), 0644))
+	require.Equal(t, []string{"ttyS1,115200", "tty0"}, DetectConsoles())
+
+	// options from the running kernel's command line take precedence
+	require.NoError(t, ioutil.WriteFile(procConsolesPath, []byte(
+		"ttyS1                -W- (EC p a)    4:65\n"+
+			"tty0                 -WU (E  p  )    4:7\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(procCmdlinePath, []byte("console=tty0 console=ttyS1,57600n8 quiet\n"), 0644))
+	require.Equal(t, []string{"tty0", "ttyS1,57600n8"}, DetectConsoles())
+}
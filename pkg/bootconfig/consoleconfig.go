@@ -0,0 +1,117 @@
+package bootconfig
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/systemboot/systemboot/pkg/crypto"
+	"golang.org/x/crypto/ed25519"
+)
+
+// DefaultConsoleConfigMaxSize is the default maximum size of a boot
+// configuration pasted on the console, before decoding.
+const DefaultConsoleConfigMaxSize = 64 * 1024
+
+// ConsoleConfigSource is the source path of the boot configurations read with
+// ReadConsoleConfig.
+const ConsoleConfigSource = "console"
+
+// readBlob reads lines from r until an empty line or the end of the input,
+// and returns them without their line endings. At most maxSize bytes are read.
+func readBlob(r io.Reader, maxSize int) ([]byte, error) {
+	reader := bufio.NewReader(io.LimitReader(r, int64(maxSize)+1))
+	var (
+		blob bytes.Buffer
+		read int
+	)
+	for {
+		line, err := reader.ReadString('\n')
+		read += len(line)
+		if read > maxSize {
+			return nil, fmt.Errorf("boot configuration is larger than %d bytes", maxSize)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" && (err != nil || blob.Len() > 0) {
+			// an empty line ends the blob, unless nothing was pasted yet
+			return blob.Bytes(), nil
+		}
+		blob.WriteString(line)
+		if err == io.EOF {
+			return blob.Bytes(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// decodeConsoleConfig decodes a boot configuration pasted on the console. It
+// is either plain JSON, or base64-encoded JSON followed by its ed25519
+// signature, if any, appended like for FromZip. If pubkey is set, a valid
+// signature is required, so the configuration must be base64-encoded.
+func decodeConsoleConfig(blob []byte, pubkey []byte) (*BootConfig, error) {
+	blob = bytes.TrimSpace(blob)
+	if len(blob) == 0 {
+		return nil, errors.New("empty boot configuration")
+	}
+	data := blob
+	if blob[0] != '{' {
+		decoded, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(blob)), ""))
+		if err != nil {
+			return nil, fmt.Errorf("boot configuration is neither JSON nor base64: %v", err)
+		}
+		data = decoded
+	}
+	if pubkey != nil {
+		if blob[0] == '{' || len(data) < ed25519.SignatureSize {
+			return nil, errors.New("boot configuration is not signed")
+		}
+		signature := data[len(data)-ed25519.SignatureSize:]
+		data = data[:len(data)-ed25519.SignatureSize]
+		if !ed25519.Verify(pubkey, data, signature) {
+			return nil, errors.New("invalid ed25519 signature for the boot configuration")
+		}
+	}
+	crypto.TryMeasureData(crypto.ConfigData, data, ConsoleConfigSource)
+	bc, err := NewBootConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	if !bc.IsValid() {
+		return nil, errors.New("boot configuration has no kernel")
+	}
+	bc.Source = &Source{Path: ConsoleConfigSource}
+	return bc, nil
+}
+
+// ReadConsoleConfig reads a boot configuration pasted on the console r, in
+// JSON or base64 format, and terminated by an empty line. It fails if more
+// than maxSize bytes are pasted, or if nothing is pasted within timeout. If
+// pubkey is set, the base64-encoded configuration must be followed by its
+// ed25519 signature. On timeout, r is still being read in the background.
+func ReadConsoleConfig(r io.Reader, maxSize int, timeout time.Duration, pubkey []byte) (*BootConfig, error) {
+	type result struct {
+		blob []byte
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		blob, err := readBlob(r, maxSize)
+		done <- result{blob, err}
+	}()
+	select {
+	case res := <-done:
+		if res.err != nil {
+			return nil, res.err
+		}
+		return decodeConsoleConfig(res.blob, pubkey)
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("no boot configuration received within %v", timeout)
+	}
+}
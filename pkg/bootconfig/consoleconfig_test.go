@@ -0,0 +1,76 @@
+package bootconfig
+
+import (
+	"encoding/base64"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+)
+
+const testConsoleConfig = `{"name": "bringup", "kernel": "/mnt/sda1/vmlinuz", "kernel_args": "console=ttyS0,115200"}`
+
+func TestReadConsoleConfigJSON(t *testing.T) {
+	console := strings.NewReader("\n" + testConsoleConfig + "\n\nignored\n")
+	bc, err := ReadConsoleConfig(console, DefaultConsoleConfigMaxSize, time.Second, nil)
+	require.NoError(t, err)
+	require.Equal(t, "bringup", bc.Name)
+	require.Equal(t, "/mnt/sda1/vmlinuz", bc.Kernel)
+	require.Equal(t, "console=ttyS0,115200", bc.KernelArgs)
+	require.Equal(t, ConsoleConfigSource, bc.Source.Path)
+}
+
+func TestReadConsoleConfigBase64(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString([]byte(testConsoleConfig))
+	// pasted over several lines, ended by the end of the input
+	console := strings.NewReader(encoded[:20] + "\r\n" + encoded[20:] + "\r\n")
+	bc, err := ReadConsoleConfig(console, DefaultConsoleConfigMaxSize, time.Second, nil)
+	require.NoError(t, err)
+	require.Equal(t, "/mnt/sda1/vmlinuz", bc.Kernel)
+}
+
+func TestReadConsoleConfigSigned(t *testing.T) {
+	pubkey, privkey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	signed := append([]byte(testConsoleConfig), ed25519.Sign(privkey, []byte(testConsoleConfig))...)
+
+	console := strings.NewReader(base64.StdEncoding.EncodeToString(signed) + "\n\n")
+	bc, err := ReadConsoleConfig(console, DefaultConsoleConfigMaxSize, time.Second, pubkey)
+	require.NoError(t, err)
+	require.Equal(t, "/mnt/sda1/vmlinuz", bc.Kernel)
+
+	// tampered
+	signed[10] ^= 0xff
+	console = strings.NewReader(base64.StdEncoding.EncodeToString(signed) + "\n\n")
+	_, err = ReadConsoleConfig(console, DefaultConsoleConfigMaxSize, time.Second, pubkey)
+	require.Error(t, err)
+
+	// plain JSON cannot be signed
+	console = strings.NewReader(testConsoleConfig + "\n\n")
+	_, err = ReadConsoleConfig(console, DefaultConsoleConfigMaxSize, time.Second, pubkey)
+	require.Error(t, err)
+}
+
+func TestReadConsoleConfigTooLarge(t *testing.T) {
+	console := strings.NewReader(testConsoleConfig + "\n\n")
+	_, err := ReadConsoleConfig(console, 16, time.Second, nil)
+	require.Error(t, err)
+}
+
+func TestReadConsoleConfigTimeout(t *testing.T) {
+	// nothing is ever pasted on this console
+	console, w := io.Pipe()
+	defer w.Close()
+	_, err := ReadConsoleConfig(console, DefaultConsoleConfigMaxSize, 10*time.Millisecond, nil)
+	require.Error(t, err)
+}
+
+func TestReadConsoleConfigInvalid(t *testing.T) {
+	for _, pasted := range []string{"", "\n\n", "not base64!\n\n", `{"name": "no kernel"}` + "\n\n"} {
+		_, err := ReadConsoleConfig(strings.NewReader(pasted), DefaultConsoleConfigMaxSize, time.Second, nil)
+		require.Error(t, err, pasted)
+	}
+}
@@ -0,0 +1,46 @@
+package bootconfig
+
+import (
+	"io/ioutil"
+	"log"
+)
+
+// CrashKernelArg is the kernel argument that reserves memory for a crash
+// kernel, e.g. `crashkernel=256M` or `crashkernel=512M-2G:64M,2G-:128M`.
+const CrashKernelArg = "crashkernel"
+
+var preserveCrashKernel bool
+
+// SetPreserveCrashKernel sets whether Boot preserves the crash kernel
+// reservations of the running kernel, see PreserveCrashKernel. It is off by
+// default, as the reservations of systemboot's kernel may not suit the booted
+// one.
+func SetPreserveCrashKernel(enabled bool) {
+	preserveCrashKernel = enabled
+}
+
+// PreserveCrashKernel copies the crash kernel reservations of the running
+// kernel's command line to KernelArgs if it has none, so that the booted kernel
+// reserves the same memory for kdump. The memory reserved by the running
+// kernel is not used for the kexec load either way. The crash kernel itself is
+// not reloaded: once the booted kernel runs, systemboot is gone, so it is up to
+// the booted system, e.g. its kdump service, to load it into the reserved
+// memory. It returns true if the kernel arguments were changed.
+func (bc *BootConfig) PreserveCrashKernel() bool {
+	if bc.Kernel == "" || len(bc.GetArgs(CrashKernelArg)) > 0 {
+		return false
+	}
+	data, err := ioutil.ReadFile(procCmdlinePath)
+	if err != nil {
+		return false
+	}
+	running := BootConfig{KernelArgs: string(data)}
+	reservations := running.GetArgs(CrashKernelArg)
+	for _, reservation := range reservations {
+		bc.AppendArg(CrashKernelArg, reservation)
+	}
+	if len(reservations) > 0 {
+		log.Printf("Preserving the crash kernel reservation: %s=%v", CrashKernelArg, reservations)
+	}
+	return len(reservations) > 0
+}
@@ -0,0 +1,51 @@
+package bootconfig
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreserveCrashKernel(t *testing.T) {
+	defer setRunningCmdline(t, "console=ttyS0 crashkernel=512M-2G:64M,2G-:128M crashkernel=256M,high")()
+
+	bc := BootConfig{Kernel: "/boot/vmlinuz", KernelArgs: "root=/dev/sda1 -- single"}
+	require.True(t, bc.PreserveCrashKernel())
+	require.Equal(t, "root=/dev/sda1 crashkernel=512M-2G:64M,2G-:128M crashkernel=256M,high -- single", bc.KernelArgs)
+
+	// the reservation of the boot configuration wins
+	bc = BootConfig{Kernel: "/boot/vmlinuz", KernelArgs: "root=/dev/sda1 crashkernel=1G"}
+	require.False(t, bc.PreserveCrashKernel())
+	require.Equal(t, "root=/dev/sda1 crashkernel=1G", bc.KernelArgs)
+
+	bc = BootConfig{Action: ActionReboot}
+	require.False(t, bc.PreserveCrashKernel())
+	require.Equal(t, "", bc.KernelArgs)
+}
+
+func TestPreserveCrashKernelNone(t *testing.T) {
+	defer setRunningCmdline(t, "console=ttyS0")()
+	bc := BootConfig{Kernel: "/boot/vmlinuz", KernelArgs: "root=/dev/sda1"}
+	require.False(t, bc.PreserveCrashKernel())
+	require.Equal(t, "root=/dev/sda1", bc.KernelArgs)
+}
+
+func TestBootPreservesCrashKernel(t *testing.T) {
+	defer setRunningCmdline(t, "crashkernel=256M systemboot.kexec_console=ttyS0,115200")()
+	calls, restore := fakeKexec()
+	defer restore()
+
+	// off by default
+	bc := BootConfig{Kernel: "/boot/vmlinuz", KernelArgs: "root=/dev/sda1"}
+	require.Error(t, bc.Boot())
+	require.Equal(t, 2, len(*calls))
+	require.Contains(t, (*calls)[0], "--command-line=root=/dev/sda1")
+
+	defer SetPreserveCrashKernel(false)
+	SetPreserveCrashKernel(true)
+	*calls = nil
+	bc = BootConfig{Kernel: "/boot/vmlinuz", KernelArgs: "root=/dev/sda1"}
+	require.Error(t, bc.Boot())
+	require.Equal(t, 2, len(*calls))
+	require.Contains(t, (*calls)[0], "--command-line=root=/dev/sda1 crashkernel=256M")
+}
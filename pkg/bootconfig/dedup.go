@@ -0,0 +1,77 @@
+package bootconfig
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// DedupOptions controls how Dedup decides that two boot configurations are
+// the same.
+type DedupOptions struct {
+	// ByContent compares the content of the kernel and initramfs files
+	// instead of their paths, so the same kernel found on different devices,
+	// e.g. on a cloned disk, is only booted once. This reads every file in
+	// full, so it is slow on large or remote devices.
+	ByContent bool
+}
+
+// fileHasher hashes files, reading each path only once.
+type fileHasher map[string]string
+
+func (h fileHasher) hash(filename string) (string, error) {
+	if filename == "" {
+		return "", nil
+	}
+	if sum, ok := h[filename]; ok {
+		return sum, nil
+	}
+	fd, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, fd); err != nil {
+		return "", err
+	}
+	sum := fmt.Sprintf("%x", hasher.Sum(nil))
+	h[filename] = sum
+	return sum, nil
+}
+
+// Dedup removes duplicate boot configurations, keeping the first occurrence
+// of each. Two configurations are duplicates if they have the same kernel,
+// initramfs, device tree, kernel arguments and action. With opts.ByContent,
+// the kernel and initramfs are compared by the hash of their content; if a
+// file cannot be read, its path is used instead.
+func Dedup(bootconfigs []BootConfig, opts DedupOptions) []BootConfig {
+	hasher := make(fileHasher)
+	seen := make(map[string]bool)
+	deduped := make([]BootConfig, 0, len(bootconfigs))
+	for _, bc := range bootconfigs {
+		kernel, initramfs := bc.Kernel, bc.Initramfs
+		if opts.ByContent {
+			if sum, err := hasher.hash(bc.Kernel); err == nil {
+				kernel = "sha256:" + sum
+			} else {
+				log.Printf("Dedup: cannot hash kernel %s: %v", bc.Kernel, err)
+			}
+			if sum, err := hasher.hash(bc.Initramfs); err == nil {
+				initramfs = "sha256:" + sum
+			} else {
+				log.Printf("Dedup: cannot hash initramfs %s: %v", bc.Initramfs, err)
+			}
+		}
+		key := fmt.Sprintf("%q %q %q %q %q", kernel, initramfs, bc.DeviceTree, bc.KernelArgs, bc.Action)
+		if seen[key] {
+			log.Printf("Dedup: skipping %q, duplicate of a previous boot configuration", bc.Name)
+			continue
+		}
+		seen[key] = true
+		deduped = append(deduped, bc)
+	}
+	return deduped
+}
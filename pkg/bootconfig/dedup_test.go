@@ -0,0 +1,46 @@
+package bootconfig
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDedupByPath(t *testing.T) {
+	cfgs := []BootConfig{
+		{Name: "first", Kernel: "/mnt/sda1/vmlinuz", KernelArgs: "ro"},
+		{Name: "second", Kernel: "/mnt/sda1/vmlinuz", KernelArgs: "ro"},
+		{Name: "recovery", Kernel: "/mnt/sda1/vmlinuz", KernelArgs: "ro single"},
+	}
+	deduped := Dedup(cfgs, DedupOptions{})
+	require.Equal(t, 2, len(deduped))
+	require.Equal(t, "first", deduped[0].Name)
+	require.Equal(t, "recovery", deduped[1].Name)
+}
+
+func TestDedupByContent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dedup")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for _, dev := range []string{"sda1", "sdb1"} {
+		require.NoError(t, os.Mkdir(path.Join(dir, dev), 0755))
+		require.NoError(t, ioutil.WriteFile(path.Join(dir, dev, "vmlinuz"), []byte("kernel"), 0644))
+		require.NoError(t, ioutil.WriteFile(path.Join(dir, dev, "initrd"), []byte("initrd"), 0644))
+	}
+	require.NoError(t, ioutil.WriteFile(path.Join(dir, "sdb1", "vmlinuz.old"), []byte("old kernel"), 0644))
+	cfgs := []BootConfig{
+		{Name: "sda1", Kernel: path.Join(dir, "sda1/vmlinuz"), Initramfs: path.Join(dir, "sda1/initrd")},
+		{Name: "sdb1", Kernel: path.Join(dir, "sdb1/vmlinuz"), Initramfs: path.Join(dir, "sdb1/initrd")},
+		{Name: "sdb1 old", Kernel: path.Join(dir, "sdb1/vmlinuz.old"), Initramfs: path.Join(dir, "sdb1/initrd")},
+	}
+
+	// different paths are not duplicates unless the content is compared
+	require.Equal(t, 3, len(Dedup(cfgs, DedupOptions{})))
+	deduped := Dedup(cfgs, DedupOptions{ByContent: true})
+	require.Equal(t, 2, len(deduped))
+	require.Equal(t, "sda1", deduped[0].Name)
+	require.Equal(t, "sdb1 old", deduped[1].Name)
+}
@@ -0,0 +1,26 @@
+package bootconfig
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/systemboot/systemboot/pkg/crypto"
+)
+
+// measureData is a variable so it can be overridden for testing.
+var measureData = crypto.TryMeasureData
+
+// MeasureDeviceTree measures the content of the device tree blob, if any, into
+// the dedicated DeviceTree PCR, so that attestation covers the full boot
+// payload on platforms where the device tree is as relevant as the kernel.
+func (bc *BootConfig) MeasureDeviceTree() error {
+	if bc.DeviceTree == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(bc.DeviceTree)
+	if err != nil {
+		return fmt.Errorf("cannot read device tree: %v", err)
+	}
+	measureData(crypto.DeviceTree, data, bc.DeviceTree)
+	return nil
+}
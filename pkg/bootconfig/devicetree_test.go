@@ -0,0 +1,56 @@
+package bootconfig
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/systemboot/systemboot/pkg/crypto"
+)
+
+type measurement struct {
+	pcr  uint32
+	data []byte
+	info string
+}
+
+func recordMeasurements() (*[]measurement, func()) {
+	var measured []measurement
+	saved := measureData
+	measureData = func(pcr uint32, data []byte, info string) {
+		measured = append(measured, measurement{pcr, data, info})
+	}
+	return &measured, func() { measureData = saved }
+}
+
+func TestMeasureDeviceTree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "devicetree")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dtb := filepath.Join(dir, "board.dtb")
+	blob := []byte{0xd0, 0x0d, 0xfe, 0xed, 0x00, 0x00, 0x00, 0x38}
+	require.NoError(t, ioutil.WriteFile(dtb, blob, 0644))
+
+	measured, restore := recordMeasurements()
+	defer restore()
+	bc := BootConfig{Kernel: "vmlinuz", DeviceTree: dtb}
+	require.NoError(t, bc.MeasureDeviceTree())
+	require.Equal(t, []measurement{{crypto.DeviceTree, blob, dtb}}, *measured)
+}
+
+func TestMeasureDeviceTreeNotSet(t *testing.T) {
+	measured, restore := recordMeasurements()
+	defer restore()
+	bc := BootConfig{Kernel: "vmlinuz"}
+	require.NoError(t, bc.MeasureDeviceTree())
+	require.Empty(t, *measured)
+}
+
+func TestMeasureDeviceTreeMissing(t *testing.T) {
+	_, restore := recordMeasurements()
+	defer restore()
+	bc := BootConfig{Kernel: "vmlinuz", DeviceTree: "/nonexistent/board.dtb"}
+	require.Error(t, bc.MeasureDeviceTree())
+}
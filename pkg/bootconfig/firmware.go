@@ -0,0 +1,41 @@
+package bootconfig
+
+import (
+	"os"
+)
+
+// Firmware types that boot configurations are meant for, see
+// BootConfig.Firmware.
+const (
+	FirmwareUEFI = "uefi"
+	FirmwareBIOS = "bios"
+)
+
+// efiPath only exists when the running kernel was booted by UEFI firmware.
+// efivarfs is mounted in its efivars directory.
+var efiPath = "/sys/firmware/efi"
+
+// DetectFirmware returns the type of the firmware the machine booted with,
+// FirmwareUEFI or FirmwareBIOS. A kernel started by kexec, e.g. from a
+// LinuxBoot firmware, has no efiPath and is reported as FirmwareBIOS.
+func DetectFirmware() string {
+	if _, err := os.Stat(efiPath); err == nil {
+		return FirmwareUEFI
+	}
+	return FirmwareBIOS
+}
+
+// FilterByFirmware returns the boot configurations meant for the given
+// firmware type, or for any, and the other ones, e.g. the entries of the BIOS
+// boot loader of a dual-boot stick when running under UEFI.
+func FilterByFirmware(bootconfigs []BootConfig, firmware string) ([]BootConfig, []BootConfig) {
+	var matching, other []BootConfig
+	for _, bc := range bootconfigs {
+		if bc.Firmware != "" && bc.Firmware != firmware {
+			other = append(other, bc)
+			continue
+		}
+		matching = append(matching, bc)
+	}
+	return matching, other
+}
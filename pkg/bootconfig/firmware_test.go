@@ -0,0 +1,42 @@
+package bootconfig
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectFirmware(t *testing.T) {
+	dir, err := ioutil.TempDir("", "firmware")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(orig string) { efiPath = orig }(efiPath)
+	efiPath = path.Join(dir, "efi")
+	require.Equal(t, FirmwareBIOS, DetectFirmware())
+	require.NoError(t, os.MkdirAll(path.Join(efiPath, "efivars"), 0755))
+	require.Equal(t, FirmwareUEFI, DetectFirmware())
+}
+
+func TestFilterByFirmware(t *testing.T) {
+	dir, err := ioutil.TempDir("", "firmware")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(orig string) { efiPath = orig }(efiPath)
+	efiPath = path.Join(dir, "efi")
+	require.NoError(t, os.MkdirAll(path.Join(efiPath, "efivars"), 0755))
+
+	uefi := BootConfig{Name: "Live (UEFI)", Kernel: "/casper/vmlinuz", Firmware: FirmwareUEFI}
+	bios := BootConfig{Name: "Live (BIOS)", Kernel: "/casper/vmlinuz", Firmware: FirmwareBIOS}
+	generic := BootConfig{Name: "Live", Kernel: "/casper/vmlinuz"}
+	reboot := BootConfig{Name: "Reboot", Action: "reboot"}
+	matching, other := FilterByFirmware([]BootConfig{bios, uefi, generic, reboot}, DetectFirmware())
+	require.Equal(t, []BootConfig{uefi, generic, reboot}, matching)
+	require.Equal(t, []BootConfig{bios}, other)
+
+	matching, other = FilterByFirmware([]BootConfig{bios, uefi, generic}, FirmwareBIOS)
+	require.Equal(t, []BootConfig{bios, generic}, matching)
+	require.Equal(t, []BootConfig{uefi}, other)
+}
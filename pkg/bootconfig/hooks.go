@@ -0,0 +1,66 @@
+package bootconfig
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// DefaultPreBootHookTimeout is how long a PreBootHook without a timeout can
+// run before the boot goes on without it.
+const DefaultPreBootHookTimeout = 5 * time.Second
+
+// PreBootHook is custom logic run right before the kexec into a boot
+// configuration, once its kernel is loaded, e.g. to notify a controller,
+// flush logs or set an LED.
+type PreBootHook struct {
+	Name string
+	Run  func(bc *BootConfig) error
+	// Timeout is how long Run can take, DefaultPreBootHookTimeout if zero.
+	// A hook that times out keeps running in the background until the kexec
+	Timeout time.Duration
+	// Required makes an error or a timeout of the hook abort the boot
+	// instead of only being logged
+	Required bool
+}
+
+var preBootHooks []PreBootHook
+
+// RegisterPreBootHook adds a hook to run before every kexec, after the ones
+// already registered.
+func RegisterPreBootHook(hook PreBootHook) {
+	preBootHooks = append(preBootHooks, hook)
+}
+
+// runHook runs a hook with its timeout.
+func (bc *BootConfig) runHook(hook PreBootHook) error {
+	timeout := hook.Timeout
+	if timeout == 0 {
+		timeout = DefaultPreBootHookTimeout
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- hook.Run(bc)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("timed out after %v", timeout)
+	}
+}
+
+// runPreBootHooks runs the registered hooks in order. It returns an error if a
+// required hook fails, and only logs the errors of the other ones.
+func (bc *BootConfig) runPreBootHooks() error {
+	for _, hook := range preBootHooks {
+		log.Printf("Running pre-boot hook %s", hook.Name)
+		if err := bc.runHook(hook); err != nil {
+			if hook.Required {
+				return fmt.Errorf("pre-boot hook %s failed: %v", hook.Name, err)
+			}
+			log.Printf("Pre-boot hook %s failed: %v", hook.Name, err)
+		}
+	}
+	return nil
+}
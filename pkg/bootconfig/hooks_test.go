@@ -0,0 +1,63 @@
+package bootconfig
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakePreBootHooks replaces the registered hooks until restored.
+func fakePreBootHooks() func() {
+	saved := preBootHooks
+	preBootHooks = nil
+	return func() { preBootHooks = saved }
+}
+
+func TestPreBootHooksRunInOrder(t *testing.T) {
+	defer setRunningCmdline(t, "systemboot.kexec_console=ttyS0,115200")()
+	defer fakePreBootHooks()()
+	var events []string
+	_, restore := fakeKexec()
+	defer restore()
+	runKexec = func(args ...string) error {
+		events = append(events, "kexec "+args[0])
+		return nil
+	}
+	hook := func(name string, err error) PreBootHook {
+		return PreBootHook{Name: name, Run: func(bc *BootConfig) error {
+			events = append(events, name+" "+bc.Name)
+			return err
+		}}
+	}
+	RegisterPreBootHook(hook("notify", nil))
+	// not required, so its error is only logged
+	RegisterPreBootHook(hook("led", errors.New("no LED")))
+	RegisterPreBootHook(PreBootHook{Name: "slow", Timeout: 10 * time.Millisecond, Run: func(bc *BootConfig) error {
+		time.Sleep(time.Minute)
+		return nil
+	}})
+	RegisterPreBootHook(hook("flush", nil))
+
+	bc := BootConfig{Name: "Linux", Kernel: "/boot/vmlinuz"}
+	require.Error(t, bc.Boot())
+	require.Equal(t, []string{"kexec -l", "notify Linux", "led Linux", "flush Linux", "kexec -e"}, events)
+}
+
+func TestPreBootHookRequired(t *testing.T) {
+	defer setRunningCmdline(t, "systemboot.kexec_console=ttyS0,115200")()
+	defer fakePreBootHooks()()
+	calls, restore := fakeKexec()
+	defer restore()
+	RegisterPreBootHook(PreBootHook{Name: "controller", Required: true, Run: func(bc *BootConfig) error {
+		return errors.New("unreachable")
+	}})
+
+	bc := BootConfig{Name: "Linux", Kernel: "/boot/vmlinuz"}
+	err := bc.Boot()
+	require.EqualError(t, err, "pre-boot hook controller failed: unreachable")
+	// loaded, but not executed
+	require.Equal(t, 1, len(*calls))
+	require.Equal(t, "-l", (*calls)[0][0])
+}
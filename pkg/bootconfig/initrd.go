@@ -0,0 +1,147 @@
+package bootconfig
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Compression algorithms for RepackInitrd.
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+)
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+	xzMagic    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	lz4Magic   = []byte{0x02, 0x21, 0x4c, 0x18}
+)
+
+// cpioHeaderLen is the length of the header of a newc cpio entry.
+const cpioHeaderLen = 110
+
+// cpioAlign returns off rounded up to the 4-byte alignment of the newc cpio
+// entries.
+func cpioAlign(off int) int {
+	return (off + 3) &^ 3
+}
+
+// cpioLen returns the length of the uncompressed newc cpio archive at the
+// start of data, up to the end of its trailer entry.
+func cpioLen(data []byte) (int, error) {
+	for off := 0; ; {
+		if len(data)-off < cpioHeaderLen {
+			return 0, errors.New("truncated cpio header")
+		}
+		header := data[off : off+cpioHeaderLen]
+		if !bytes.HasPrefix(header, []byte("070701")) && !bytes.HasPrefix(header, []byte("070702")) {
+			return 0, fmt.Errorf("invalid cpio magic %q", header[:6])
+		}
+		size, err := strconv.ParseUint(string(header[54:62]), 16, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid cpio file size: %v", err)
+		}
+		namesize, err := strconv.ParseUint(string(header[94:102]), 16, 32)
+		if err != nil || namesize == 0 {
+			return 0, fmt.Errorf("invalid cpio name size %q", header[94:102])
+		}
+		nameEnd := off + cpioHeaderLen + int(namesize)
+		dataEnd := cpioAlign(nameEnd) + int(size)
+		if dataEnd > len(data) {
+			return 0, errors.New("truncated cpio entry")
+		}
+		name := string(data[off+cpioHeaderLen : nameEnd-1])
+		off = cpioAlign(dataEnd)
+		if name == "TRAILER!!!" {
+			if off > len(data) {
+				off = len(data)
+			}
+			return off, nil
+		}
+	}
+}
+
+// decompressInitrd returns the uncompressed content of an initrd, made of
+// concatenated segments, e.g. an uncompressed early cpio archive with CPU
+// microcode followed by the compressed main archive. Like the kernel, it walks
+// the segments, skipping the zeros that pad them: uncompressed cpio archives
+// are copied as is, and gzip and bzip2 segments are decompressed. As
+// compress/bzip2 cannot tell where a stream ends, a bzip2 segment must be the
+// last one.
+func decompressInitrd(data []byte) ([]byte, error) {
+	var out bytes.Buffer
+	for off := 0; off < len(data); {
+		segment := data[off:]
+		switch {
+		case segment[0] == 0:
+			off++
+		case segment[0] == '0':
+			n, err := cpioLen(segment)
+			if err != nil {
+				return nil, fmt.Errorf("at offset %d: %v", off, err)
+			}
+			out.Write(segment[:n])
+			off += n
+		case bytes.HasPrefix(segment, gzipMagic):
+			// bytes.Reader is an io.ByteReader, so gzip does not read past
+			// the end of the stream
+			r := bytes.NewReader(segment)
+			zr, err := gzip.NewReader(r)
+			if err != nil {
+				return nil, fmt.Errorf("at offset %d: %v", off, err)
+			}
+			zr.Multistream(false)
+			if _, err := io.Copy(&out, zr); err != nil {
+				return nil, fmt.Errorf("at offset %d: %v", off, err)
+			}
+			off += len(segment) - r.Len()
+		case bytes.HasPrefix(segment, bzip2Magic):
+			if _, err := io.Copy(&out, bzip2.NewReader(bytes.NewReader(segment))); err != nil {
+				return nil, fmt.Errorf("at offset %d: %v", off, err)
+			}
+			off = len(data)
+		case bytes.HasPrefix(segment, xzMagic), bytes.HasPrefix(segment, zstdMagic), bytes.HasPrefix(segment, lz4Magic):
+			return nil, fmt.Errorf("unsupported initrd compression at offset %d, magic %x", off, segment[:4])
+		default:
+			return nil, fmt.Errorf("junk in initrd at offset %d", off)
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// RepackInitrd decompresses the given initrd segments, e.g. a compressed base
+// initrd and an overlay cpio archive, concatenates them, and compresses the
+// result as a single segment with the given algorithm. This is needed for
+// kernels that cannot unpack multiple compressed segments.
+func RepackInitrd(segments [][]byte, compression string) ([]byte, error) {
+	var archive bytes.Buffer
+	for idx, segment := range segments {
+		data, err := decompressInitrd(segment)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decompress initrd segment %d: %v", idx, err)
+		}
+		archive.Write(data)
+	}
+	switch compression {
+	case CompressionNone:
+		return archive.Bytes(), nil
+	case CompressionGzip:
+		var out bytes.Buffer
+		zw := gzip.NewWriter(&out)
+		if _, err := zw.Write(archive.Bytes()); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return out.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unsupported initrd compression %q", compression)
+}
@@ -0,0 +1,83 @@
+package bootconfig
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func gzipData(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+// cpioArchive returns a newc cpio archive with a single file.
+func cpioArchive(name, content string) []byte {
+	var archive bytes.Buffer
+	writeCpioEntry(&archive, 1, name, 0100644, []byte(content))
+	writeCpioEntry(&archive, 0, "TRAILER!!!", 0, nil)
+	return archive.Bytes()
+}
+
+func TestRepackInitrdGzip(t *testing.T) {
+	base := gzipData(t, cpioArchive("init", "base"))
+	overlay := cpioArchive("etc/overlay", "overlay")
+	repacked, err := RepackInitrd([][]byte{base, overlay}, CompressionGzip)
+	require.NoError(t, err)
+	// a single gzip member, so kernels that don't support multiple
+	// compressed segments can unpack it
+	r := bytes.NewReader(repacked)
+	zr, err := gzip.NewReader(r)
+	require.NoError(t, err)
+	zr.Multistream(false)
+	content, err := ioutil.ReadAll(zr)
+	require.NoError(t, err)
+	require.Equal(t, append(cpioArchive("init", "base"), overlay...), content)
+	require.Equal(t, 0, r.Len())
+}
+
+func TestRepackInitrdNone(t *testing.T) {
+	repacked, err := RepackInitrd([][]byte{gzipData(t, []byte("base")), gzipData(t, []byte("overlay"))}, CompressionNone)
+	require.NoError(t, err)
+	require.Equal(t, []byte("baseoverlay"), repacked)
+}
+
+func TestRepackInitrdEarlyCpio(t *testing.T) {
+	// the layout of distro initrds: an uncompressed early cpio with the CPU
+	// microcode, then the compressed main archive, in a single file, with
+	// zero padding in between
+	microcode := cpioArchive("kernel/x86/microcode/GenuineIntel.bin", "microcode")
+	initrd := append([]byte{}, microcode...)
+	initrd = append(initrd, make([]byte, 512)...)
+	initrd = append(initrd, gzipData(t, cpioArchive("init", "main"))...)
+	initrd = append(initrd, gzipData(t, cpioArchive("etc/extra", "extra"))...)
+	repacked, err := RepackInitrd([][]byte{initrd}, CompressionNone)
+	require.NoError(t, err)
+	expected := append([]byte{}, microcode...)
+	expected = append(expected, cpioArchive("init", "main")...)
+	expected = append(expected, cpioArchive("etc/extra", "extra")...)
+	require.Equal(t, expected, repacked)
+}
+
+func TestRepackInitrdJunk(t *testing.T) {
+	_, err := RepackInitrd([][]byte{append(cpioArchive("init", "base"), "junk"...)}, CompressionNone)
+	require.Error(t, err)
+	// truncated archive
+	_, err = RepackInitrd([][]byte{cpioArchive("init", "base")[:120]}, CompressionNone)
+	require.Error(t, err)
+}
+
+func TestRepackInitrdUnsupported(t *testing.T) {
+	_, err := RepackInitrd([][]byte{[]byte("base")}, "lzma")
+	require.Error(t, err)
+	xz := []byte{0xfd, '7', 'z', 'X', 'Z', 0x00, 0x00}
+	_, err = RepackInitrd([][]byte{xz}, CompressionGzip)
+	require.Error(t, err)
+}
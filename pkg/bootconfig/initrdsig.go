@@ -0,0 +1,98 @@
+package bootconfig
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/systemboot/systemboot/pkg/crypto"
+)
+
+// InitrdSignatureExt is the extension of the sidecar file, next to the
+// initramfs, that holds its detached PKCS7 signature.
+const InitrdSignatureExt = ".p7s"
+
+// appendedSignatureMagic ends a signature appended to a file in the format
+// used for kernel modules: the PKCS7 signature, a 12-byte trailer whose last 4
+// bytes are the big-endian length of the signature, then this magic string.
+const appendedSignatureMagic = "~Module signature appended~\n"
+
+// splitAppendedSignature splits a file with an appended signature into its
+// content and signature. ok is false if there is no appended signature.
+func splitAppendedSignature(data []byte) (content, signature []byte, ok bool) {
+	if !bytes.HasSuffix(data, []byte(appendedSignatureMagic)) {
+		return nil, nil, false
+	}
+	end := len(data) - len(appendedSignatureMagic) - 12
+	if end < 0 {
+		return nil, nil, false
+	}
+	sigLen := int(binary.BigEndian.Uint32(data[end+8 : end+12]))
+	if sigLen == 0 || sigLen > end {
+		return nil, nil, false
+	}
+	return data[:end-sigLen], data[end-sigLen : end], true
+}
+
+// VerifyInitrdSignature verifies the PKCS7 signature of the initramfs against
+// the trusted certificates. The signature is read from a sidecar file, e.g.
+// `initrd.img.p7s`, or else from the end of the initramfs itself. A boot
+// config without an initramfs has nothing to verify, but an unsigned
+// initramfs is an error.
+//
+// The kernel would choke on an appended signature, so in that case the
+// verified content, without the signature, is written to a temporary file
+// that replaces the initramfs of the BootConfig.
+func (bc *BootConfig) VerifyInitrdSignature(trusted []*x509.Certificate) error {
+	if bc.Initramfs == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(bc.Initramfs)
+	if err != nil {
+		return err
+	}
+	sidecar := bc.Initramfs + InitrdSignatureExt
+	signature, err := ioutil.ReadFile(sidecar)
+	appended := false
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		data, signature, appended = splitAppendedSignature(data)
+		if !appended {
+			return fmt.Errorf("initramfs %s is not signed", bc.Initramfs)
+		}
+	}
+	if err := crypto.VerifyDetachedPKCS7(data, signature, trusted); err != nil {
+		return fmt.Errorf("cannot verify initramfs %s: %v", bc.Initramfs, err)
+	}
+	log.Printf("Verified the signature of initramfs %s", bc.Initramfs)
+	if appended {
+		return bc.stripInitrdSignature(data)
+	}
+	return nil
+}
+
+// stripInitrdSignature replaces the initramfs with a temporary file holding
+// only its verified content.
+func (bc *BootConfig) stripInitrdSignature(content []byte) error {
+	tmp, err := ioutil.TempFile("", "initramfs")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	bc.Initramfs = tmp.Name()
+	return nil
+}
@@ -0,0 +1,105 @@
+package bootconfig
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/systemboot/systemboot/pkg/crypto"
+)
+
+const (
+	// testInitrd is a fixture initramfs, signed in testInitrd.p7s by a
+	// certificate issued by testInitrdCA, the CA of the crypto PKCS7 tests
+	testInitrd   = "testdata/initrd.cpio"
+	testInitrdCA = "../crypto/tests/pkcs7_ca.pem"
+	// testInitrdInvalidSignature is a signature by the same certificate over
+	// another initramfs
+	testInitrdInvalidSignature = "testdata/initrd_invalid.p7s"
+)
+
+// setupSignedInitrd copies the fixture initramfs and the given signature
+// file, if any, into a temporary directory, and returns the initramfs path.
+func setupSignedInitrd(t *testing.T, dir, signatureFile string) string {
+	data, err := ioutil.ReadFile(testInitrd)
+	require.NoError(t, err)
+	initrd := filepath.Join(dir, "initrd.img")
+	require.NoError(t, ioutil.WriteFile(initrd, data, 0644))
+	if signatureFile != "" {
+		signature, err := ioutil.ReadFile(signatureFile)
+		require.NoError(t, err)
+		require.NoError(t, ioutil.WriteFile(initrd+InitrdSignatureExt, signature, 0644))
+	}
+	return initrd
+}
+
+func TestVerifyInitrdSignature(t *testing.T) {
+	dir, err := ioutil.TempDir("", "initrdsig")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	trusted, err := crypto.LoadCertificatesFromFile(testInitrdCA)
+	require.NoError(t, err)
+
+	bc := BootConfig{Kernel: "vmlinuz", Initramfs: setupSignedInitrd(t, dir, testInitrd+InitrdSignatureExt)}
+	require.NoError(t, bc.VerifyInitrdSignature(trusted))
+}
+
+func TestVerifyInitrdSignatureInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "initrdsig")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	trusted, err := crypto.LoadCertificatesFromFile(testInitrdCA)
+	require.NoError(t, err)
+
+	bc := BootConfig{Kernel: "vmlinuz", Initramfs: setupSignedInitrd(t, dir, testInitrdInvalidSignature)}
+	require.Error(t, bc.VerifyInitrdSignature(trusted))
+}
+
+func TestVerifyInitrdSignatureUnsigned(t *testing.T) {
+	dir, err := ioutil.TempDir("", "initrdsig")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	trusted, err := crypto.LoadCertificatesFromFile(testInitrdCA)
+	require.NoError(t, err)
+
+	bc := BootConfig{Kernel: "vmlinuz", Initramfs: setupSignedInitrd(t, dir, "")}
+	require.Error(t, bc.VerifyInitrdSignature(trusted))
+	// nothing to verify without an initramfs
+	bc.Initramfs = ""
+	require.NoError(t, bc.VerifyInitrdSignature(trusted))
+}
+
+func TestVerifyInitrdSignatureAppended(t *testing.T) {
+	dir, err := ioutil.TempDir("", "initrdsig")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	trusted, err := crypto.LoadCertificatesFromFile(testInitrdCA)
+	require.NoError(t, err)
+
+	appendSignature := func(signatureFile string) string {
+		data, err := ioutil.ReadFile(testInitrd)
+		require.NoError(t, err)
+		signature, err := ioutil.ReadFile(signatureFile)
+		require.NoError(t, err)
+		trailer := make([]byte, 12)
+		binary.BigEndian.PutUint32(trailer[8:], uint32(len(signature)))
+		data = append(append(append(data, signature...), trailer...), appendedSignatureMagic...)
+		initrd := filepath.Join(dir, filepath.Base(signatureFile)+".img")
+		require.NoError(t, ioutil.WriteFile(initrd, data, 0644))
+		return initrd
+	}
+	bc := BootConfig{Kernel: "vmlinuz", Initramfs: appendSignature(testInitrd + InitrdSignatureExt)}
+	require.NoError(t, bc.VerifyInitrdSignature(trusted))
+	// the signature is stripped from the initramfs that gets booted
+	defer os.Remove(bc.Initramfs)
+	stripped, err := ioutil.ReadFile(bc.Initramfs)
+	require.NoError(t, err)
+	content, err := ioutil.ReadFile(testInitrd)
+	require.NoError(t, err)
+	require.Equal(t, content, stripped)
+	bc.Initramfs = appendSignature(testInitrdInvalidSignature)
+	require.Error(t, bc.VerifyInitrdSignature(trusted))
+}
@@ -0,0 +1,102 @@
+package bootconfig
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Offsets and magics of the kernel image headers, see
+// Documentation/x86/boot.rst, Documentation/arm64/booting.rst,
+// Documentation/riscv/boot-image-header.rst and arch/arm/boot/compressed/head.S
+// in the Linux sources.
+const (
+	bzImageXLoadFlagsOffset = 0x236
+	// the kernel has a 64-bit entry point
+	bzImageXLFKernel64    = 0x1
+	arm64ImageMagicOffset = 0x38
+	riscvImageMagicOffset = 0x38
+	zImageMagicOffset     = 0x24
+	zImageMagic           = 0x016f2818
+	kernelArchHeaderSize  = bzImageXLoadFlagsOffset + 2
+)
+
+var (
+	arm64ImageMagic = []byte("ARM\x64")
+	riscvImageMagic = []byte("RSC\x05")
+)
+
+// compatibleArchs lists the kernel architectures that can be booted on a
+// GOARCH other than their own.
+var compatibleArchs = map[string][]string{
+	"amd64": {"386"},
+}
+
+// ReadKernelArch reads the target architecture of a kernel image from its
+// header, as a GOARCH name: 386 or amd64 for a bzImage, arm64, arm for a
+// zImage, or riscv64. Compressed images, e.g. vmlinuz.gz, are not recognized.
+func ReadKernelArch(kernel string) (string, error) {
+	fd, err := os.Open(kernel)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+	header := make([]byte, kernelArchHeaderSize)
+	if _, err := io.ReadFull(fd, header); err != nil {
+		return "", fmt.Errorf("cannot read the header of %s: %v", kernel, err)
+	}
+	switch {
+	case bytes.Equal(header[bzImageHeaderMagicOffset:bzImageHeaderMagicOffset+4], bzImageHeaderMagic):
+		if binary.LittleEndian.Uint16(header[bzImageXLoadFlagsOffset:])&bzImageXLFKernel64 != 0 {
+			return "amd64", nil
+		}
+		return "386", nil
+	case bytes.Equal(header[arm64ImageMagicOffset:arm64ImageMagicOffset+4], arm64ImageMagic):
+		return "arm64", nil
+	case bytes.Equal(header[riscvImageMagicOffset:riscvImageMagicOffset+4], riscvImageMagic):
+		return "riscv64", nil
+	case binary.LittleEndian.Uint32(header[zImageMagicOffset:]) == zImageMagic:
+		return "arm", nil
+	}
+	return "", fmt.Errorf("unknown kernel image format for %s", kernel)
+}
+
+// KernelArch returns the target architecture of the kernel, see
+// ReadKernelArch, or an empty string if it is not known.
+func (bc *BootConfig) KernelArch() string {
+	arch, err := ReadKernelArch(bc.Kernel)
+	if err != nil {
+		return ""
+	}
+	return arch
+}
+
+// IsArchCompatible returns true if a kernel for arch can be booted on goarch.
+func IsArchCompatible(arch, goarch string) bool {
+	if arch == goarch {
+		return true
+	}
+	for _, compatible := range compatibleArchs[goarch] {
+		if arch == compatible {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterByArch returns the boot configurations whose kernel can be booted on
+// goarch, e.g. runtime.GOARCH, and the other ones. Boot configurations whose
+// kernel architecture is unknown, or that have no kernel, are kept.
+func FilterByArch(bootconfigs []BootConfig, goarch string) ([]BootConfig, []BootConfig) {
+	var compatible, hidden []BootConfig
+	for _, bc := range bootconfigs {
+		if arch := bc.KernelArch(); arch != "" && !IsArchCompatible(arch, goarch) {
+			hidden = append(hidden, bc)
+			continue
+		}
+		compatible = append(compatible, bc)
+	}
+	return compatible, hidden
+}
@@ -0,0 +1,76 @@
+package bootconfig
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeKernel writes a fake kernel image with magic at offset in its header.
+func writeKernel(t *testing.T, dir, name string, offset int, magic []byte) string {
+	image := make([]byte, 4096)
+	copy(image[offset:], magic)
+	kernel := path.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(kernel, image, 0644))
+	return kernel
+}
+
+func TestReadKernelArch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kernelarch")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	bzImage64 := make([]byte, 4096)
+	copy(bzImage64[bzImageHeaderMagicOffset:], bzImageHeaderMagic)
+	binary.LittleEndian.PutUint16(bzImage64[bzImageXLoadFlagsOffset:], bzImageXLFKernel64)
+	zImage := make([]byte, 4)
+	binary.LittleEndian.PutUint32(zImage, zImageMagic)
+	require.NoError(t, ioutil.WriteFile(path.Join(dir, "bzImage64"), bzImage64, 0644))
+
+	for _, tt := range []struct {
+		kernel, arch string
+	}{
+		{"testdata/bzImage", "386"},
+		{path.Join(dir, "bzImage64"), "amd64"},
+		{writeKernel(t, dir, "Image", arm64ImageMagicOffset, arm64ImageMagic), "arm64"},
+		{writeKernel(t, dir, "Image.riscv", riscvImageMagicOffset, riscvImageMagic), "riscv64"},
+		{writeKernel(t, dir, "zImage", zImageMagicOffset, zImage), "arm"},
+	} {
+		arch, err := ReadKernelArch(tt.kernel)
+		require.NoError(t, err)
+		require.Equal(t, tt.arch, arch, tt.kernel)
+	}
+	_, err = ReadKernelArch(writeKernel(t, dir, "vmlinuz.gz", 0, []byte{0x1f, 0x8b}))
+	require.Error(t, err)
+	_, err = ReadKernelArch("testdata/initrd.cpio")
+	require.Error(t, err)
+}
+
+func TestFilterByArch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kernelarch")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	bzImage64 := make([]byte, 4096)
+	copy(bzImage64[bzImageHeaderMagicOffset:], bzImageHeaderMagic)
+	binary.LittleEndian.PutUint16(bzImage64[bzImageXLoadFlagsOffset:], bzImageXLFKernel64)
+	require.NoError(t, ioutil.WriteFile(path.Join(dir, "vmlinuz-x86_64"), bzImage64, 0644))
+
+	amd64 := BootConfig{Name: "x86_64", Kernel: path.Join(dir, "vmlinuz-x86_64")}
+	i386 := BootConfig{Name: "i386", Kernel: "testdata/bzImage"}
+	arm64 := BootConfig{Name: "arm64", Kernel: writeKernel(t, dir, "vmlinuz-arm64", arm64ImageMagicOffset, arm64ImageMagic)}
+	unknown := BootConfig{Name: "compressed", Kernel: writeKernel(t, dir, "vmlinuz.gz", 0, []byte{0x1f, 0x8b})}
+	reboot := BootConfig{Name: "Reboot", Action: "reboot"}
+	cfgs := []BootConfig{arm64, amd64, unknown, i386, reboot}
+
+	compatible, hidden := FilterByArch(cfgs, "amd64")
+	require.Equal(t, []BootConfig{amd64, unknown, i386, reboot}, compatible)
+	require.Equal(t, []BootConfig{arm64}, hidden)
+
+	compatible, hidden = FilterByArch(cfgs, "arm64")
+	require.Equal(t, []BootConfig{arm64, unknown, reboot}, compatible)
+	require.Equal(t, []BootConfig{amd64, i386}, hidden)
+}
@@ -0,0 +1,167 @@
+package bootconfig
+
+import (
+	"strings"
+)
+
+// InitArgsSeparator separates kernel arguments from the arguments that the
+// kernel passes on to init, e.g. `ro quiet -- single`.
+const InitArgsSeparator = "--"
+
+// splitArgs splits a kernel command line into whitespace-separated arguments.
+// Double-quoted sections are kept together, so `foo="a b"` is a single
+// argument. Quotes are preserved in the returned arguments.
+func splitArgs(cmdline string) []string {
+	var (
+		args     []string
+		current  strings.Builder
+		inQuotes bool
+	)
+	for _, r := range cmdline {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			current.WriteRune(r)
+		case (r == ' ' || r == '\t' || r == '\n') && !inQuotes:
+			if current.Len() > 0 {
+				args = append(args, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		args = append(args, current.String())
+	}
+	return args
+}
+
+// splitInitArgs splits a kernel command line in two parts: the kernel
+// arguments, and everything from the `--` separator onwards. The latter is
+// returned verbatim and is empty if there is no separator.
+func splitInitArgs(cmdline string) ([]string, string) {
+	args := splitArgs(cmdline)
+	for idx, arg := range args {
+		if arg == InitArgsSeparator {
+			// find where the separator starts in the original string, so the
+			// init arguments are returned untouched
+			offset := 0
+			for _, a := range args[:idx] {
+				offset = strings.Index(cmdline[offset:], a) + offset + len(a)
+			}
+			sep := strings.Index(cmdline[offset:], InitArgsSeparator) + offset
+			return args[:idx], cmdline[sep:]
+		}
+	}
+	return args, ""
+}
+
+// argKey returns the key of a `key=value` argument, or the whole argument if
+// it has no value.
+func argKey(arg string) string {
+	if idx := strings.Index(arg, "="); idx != -1 {
+		return arg[:idx]
+	}
+	return arg
+}
+
+func joinArgs(args []string, initArgs string) string {
+	cmdline := strings.Join(args, " ")
+	if initArgs != "" {
+		if cmdline != "" {
+			cmdline += " "
+		}
+		cmdline += initArgs
+	}
+	return cmdline
+}
+
+// SetArg sets a kernel argument in KernelArgs. If the argument is already
+// present, its first occurrence is replaced and any further occurrence is
+// removed, otherwise it is appended. An empty value sets a flag-style argument
+// without `=`. Arguments after the `--` separator are passed to init and are
+// never modified.
+func (bc *BootConfig) SetArg(key, value string) {
+	arg := key
+	if value != "" {
+		arg = key + "=" + value
+	}
+	args, initArgs := splitInitArgs(bc.KernelArgs)
+	newArgs := make([]string, 0, len(args)+1)
+	found := false
+	for _, a := range args {
+		if argKey(a) == key {
+			if !found {
+				newArgs = append(newArgs, arg)
+				found = true
+			}
+			continue
+		}
+		newArgs = append(newArgs, a)
+	}
+	if !found {
+		newArgs = append(newArgs, arg)
+	}
+	bc.KernelArgs = joinArgs(newArgs, initArgs)
+}
+
+// RemoveArg removes every occurrence of a kernel argument from KernelArgs,
+// whether it is a flag or a `key=value` argument. Arguments after the `--`
+// separator are passed to init and are never modified.
+func (bc *BootConfig) RemoveArg(key string) {
+	args, initArgs := splitInitArgs(bc.KernelArgs)
+	newArgs := make([]string, 0, len(args))
+	for _, a := range args {
+		if argKey(a) != key {
+			newArgs = append(newArgs, a)
+		}
+	}
+	bc.KernelArgs = joinArgs(newArgs, initArgs)
+}
+
+// GetArg returns the value of the first occurrence of a kernel argument, and
+// whether it was found. Arguments after the `--` separator are not considered.
+func (bc *BootConfig) GetArg(key string) (string, bool) {
+	args, _ := splitInitArgs(bc.KernelArgs)
+	for _, a := range args {
+		if argKey(a) == key {
+			return strings.TrimPrefix(a[len(key):], "="), true
+		}
+	}
+	return "", false
+}
+
+// AppendArg appends a kernel argument to KernelArgs, before the `--`
+// separator if any, without touching existing occurrences. This is meant for
+// arguments that can be repeated, like `console=`.
+func (bc *BootConfig) AppendArg(key, value string) {
+	arg := key
+	if value != "" {
+		arg = key + "=" + value
+	}
+	args, initArgs := splitInitArgs(bc.KernelArgs)
+	bc.KernelArgs = joinArgs(append(args, arg), initArgs)
+}
+
+// SetDefaultArgs sets KernelArgs to cmdline if it is empty, e.g. for minimal
+// entries without a root= or console= argument. Boot configurations without a
+// kernel, like halt or reboot actions, are left alone.
+func (bc *BootConfig) SetDefaultArgs(cmdline string) {
+	if bc.Kernel != "" && strings.TrimSpace(bc.KernelArgs) == "" {
+		bc.KernelArgs = cmdline
+	}
+}
+
+// GetArgs returns the values of every occurrence of a kernel argument, in
+// order. Arguments after the `--` separator are not considered.
+func (bc *BootConfig) GetArgs(key string) []string {
+	args, _ := splitInitArgs(bc.KernelArgs)
+	var values []string
+	for _, a := range args {
+		if argKey(a) == key {
+			values = append(values, strings.TrimPrefix(a[len(key):], "="))
+		}
+	}
+	return values
+}
@@ -0,0 +1,72 @@
+package bootconfig
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitArgsQuoted(t *testing.T) {
+	args := splitArgs(`root=/dev/sda1  foo="a b" quiet`)
+	require.Equal(t, []string{"root=/dev/sda1", `foo="a b"`, "quiet"}, args)
+}
+
+func TestSetArgReplace(t *testing.T) {
+	bc := BootConfig{KernelArgs: "root=/dev/sda1 console=ttyS0 quiet"}
+	bc.SetArg("console", "ttyS1,115200")
+	require.Equal(t, "root=/dev/sda1 console=ttyS1,115200 quiet", bc.KernelArgs)
+}
+
+func TestSetArgAppend(t *testing.T) {
+	bc := BootConfig{KernelArgs: "root=/dev/sda1"}
+	bc.SetArg("ro", "")
+	require.Equal(t, "root=/dev/sda1 ro", bc.KernelArgs)
+}
+
+func TestSetArgPreservesInitArgs(t *testing.T) {
+	bc := BootConfig{KernelArgs: "ro quiet -- single  foo=bar quiet"}
+	bc.SetArg("quiet", "")
+	bc.SetArg("foo", "baz")
+	require.Equal(t, "ro quiet foo=baz -- single  foo=bar quiet", bc.KernelArgs)
+}
+
+func TestRemoveArgPreservesInitArgs(t *testing.T) {
+	bc := BootConfig{KernelArgs: "ro single -- single"}
+	bc.RemoveArg("single")
+	require.Equal(t, "ro -- single", bc.KernelArgs)
+	bc.RemoveArg("ro")
+	require.Equal(t, "-- single", bc.KernelArgs)
+}
+
+func TestGetArg(t *testing.T) {
+	bc := BootConfig{KernelArgs: "ro root=/dev/sda1 -- root=/dev/sdb1"}
+	value, ok := bc.GetArg("root")
+	require.True(t, ok)
+	require.Equal(t, "/dev/sda1", value)
+	value, ok = bc.GetArg("ro")
+	require.True(t, ok)
+	require.Equal(t, "", value)
+	_, ok = bc.GetArg("rootfstype")
+	require.False(t, ok)
+}
+
+func TestAppendArg(t *testing.T) {
+	bc := BootConfig{KernelArgs: "console=tty0 ro -- single"}
+	bc.AppendArg("console", "ttyS1,115200")
+	require.Equal(t, "console=tty0 ro console=ttyS1,115200 -- single", bc.KernelArgs)
+	require.Equal(t, []string{"tty0", "ttyS1,115200"}, bc.GetArgs("console"))
+}
+
+func TestSetDefaultArgs(t *testing.T) {
+	argless := BootConfig{Kernel: "/vmlinuz", KernelArgs: " "}
+	argless.SetDefaultArgs("root=/dev/sda1 console=ttyS0")
+	require.Equal(t, "root=/dev/sda1 console=ttyS0", argless.KernelArgs)
+
+	withArgs := BootConfig{Kernel: "/vmlinuz", KernelArgs: "root=/dev/sdb1"}
+	withArgs.SetDefaultArgs("root=/dev/sda1 console=ttyS0")
+	require.Equal(t, "root=/dev/sdb1", withArgs.KernelArgs)
+
+	action := BootConfig{Action: "reboot"}
+	action.SetDefaultArgs("root=/dev/sda1")
+	require.Equal(t, "", action.KernelArgs)
+}
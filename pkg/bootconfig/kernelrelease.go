@@ -0,0 +1,196 @@
+package bootconfig
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Offsets in the x86 boot protocol setup header, see
+// Documentation/x86/boot.rst in the Linux sources.
+const (
+	bzImageHeaderMagicOffset   = 0x202
+	bzImageVersionOffset       = 0x206
+	bzImageKernelVersionOffset = 0x20e
+	// the kernel_version pointer is relative to the setup header
+	bzImageKernelVersionBase = 0x200
+	// the version string is in the setup code, at most 64 sectors long
+	bzImageMaxSetupSize = 0x200 + 64*512
+)
+
+var bzImageHeaderMagic = []byte("HdrS")
+
+// ReadKernelRelease reads the release of a bzImage kernel, e.g.
+// `5.15.0-91-generic`, from the kernel_version field of its setup header.
+func ReadKernelRelease(kernel string) (string, error) {
+	fd, err := os.Open(kernel)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+	header := make([]byte, bzImageKernelVersionOffset+2)
+	if _, err := io.ReadFull(fd, header); err != nil {
+		return "", fmt.Errorf("cannot read the setup header of %s: %v", kernel, err)
+	}
+	if !bytes.Equal(header[bzImageHeaderMagicOffset:bzImageHeaderMagicOffset+4], bzImageHeaderMagic) {
+		return "", fmt.Errorf("%s is not a bzImage", kernel)
+	}
+	if binary.LittleEndian.Uint16(header[bzImageVersionOffset:]) < 0x200 {
+		return "", fmt.Errorf("%s has no kernel version, boot protocol is too old", kernel)
+	}
+	pointer := binary.LittleEndian.Uint16(header[bzImageKernelVersionOffset:])
+	if pointer == 0 {
+		return "", fmt.Errorf("%s has no kernel version", kernel)
+	}
+	offset := int64(pointer) + bzImageKernelVersionBase
+	if offset >= bzImageMaxSetupSize {
+		return "", fmt.Errorf("%s has a kernel version pointer outside the setup code", kernel)
+	}
+	buf := make([]byte, 256)
+	n, err := fd.ReadAt(buf, offset)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	if offset+int64(n) > bzImageMaxSetupSize {
+		n = int(bzImageMaxSetupSize - offset)
+	}
+	buf = buf[:n]
+	// the version string looks like `5.15.0-91-generic (buildd@...) #101 ...`
+	if idx := bytes.IndexByte(buf, 0); idx != -1 {
+		buf = buf[:idx]
+	}
+	fields := strings.Fields(string(buf))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("%s has an empty kernel version", kernel)
+	}
+	return fields[0], nil
+}
+
+// kernelReleaseFromName guesses the release of a kernel from its file name,
+// e.g. `5.15.0-91-generic` for `vmlinuz-5.15.0-91-generic`.
+func kernelReleaseFromName(kernel string) (string, error) {
+	name := path.Base(kernel)
+	idx := strings.Index(name, "-")
+	if idx == -1 || idx == len(name)-1 || !unicode.IsDigit(rune(name[idx+1])) {
+		return "", errors.New("no kernel release in file name " + name)
+	}
+	return name[idx+1:], nil
+}
+
+// KernelRelease returns the release of the kernel, read from its bzImage
+// header, or guessed from its file name if the header is not readable, e.g.
+// for non-x86 kernels. It returns an empty string if both fail.
+func (bc *BootConfig) KernelRelease() string {
+	release, err := ReadKernelRelease(bc.Kernel)
+	if err == nil {
+		return release
+	}
+	if release, err = kernelReleaseFromName(bc.Kernel); err == nil {
+		return release
+	}
+	return ""
+}
+
+// splitVersion splits a version string into runs of digits and runs of other
+// characters, ignoring the separators `.`, `-`, `_` and `+`.
+func splitVersion(version string) []string {
+	var (
+		parts   []string
+		current []rune
+	)
+	flush := func() {
+		if len(current) > 0 {
+			parts = append(parts, string(current))
+			current = nil
+		}
+	}
+	for _, r := range version {
+		switch {
+		case strings.ContainsRune(".-_+", r):
+			flush()
+		case len(current) > 0 && unicode.IsDigit(r) != unicode.IsDigit(current[0]):
+			flush()
+			current = append(current, r)
+		default:
+			current = append(current, r)
+		}
+	}
+	flush()
+	return parts
+}
+
+// CompareKernelReleases compares two kernel releases like `sort -V`, so that
+// e.g. 5.10 is newer than 5.9. It returns -1, 0 or 1 if a is older than,
+// the same as or newer than b.
+func CompareKernelReleases(a, b string) int {
+	pa, pb := splitVersion(a), splitVersion(b)
+	for idx := 0; idx < len(pa) && idx < len(pb); idx++ {
+		na, erra := strconv.ParseUint(pa[idx], 10, 64)
+		nb, errb := strconv.ParseUint(pb[idx], 10, 64)
+		switch {
+		case erra == nil && errb == nil:
+			if na != nb {
+				if na < nb {
+					return -1
+				}
+				return 1
+			}
+		case erra == nil:
+			// numbers are newer than suffixes, e.g. 5.10.1 > 5.10-rc1
+			return 1
+		case errb == nil:
+			return -1
+		default:
+			if c := strings.Compare(pa[idx], pb[idx]); c != 0 {
+				return c
+			}
+		}
+	}
+	// a longer version is newer, unless it continues with a suffix, e.g.
+	// 5.10-rc1 is older than 5.10
+	switch {
+	case len(pa) < len(pb):
+		if isNumber(pb[len(pa)]) {
+			return -1
+		}
+		return 1
+	case len(pa) > len(pb):
+		if isNumber(pa[len(pb)]) {
+			return 1
+		}
+		return -1
+	}
+	return 0
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.ParseUint(s, 10, 64)
+	return err == nil
+}
+
+// SortByKernelRelease sorts boot configurations by kernel release, newest
+// first. Configurations whose kernel release is unknown come last, and the
+// order is otherwise preserved.
+func SortByKernelRelease(bootconfigs []BootConfig) {
+	releases := make(map[string]string)
+	for _, bc := range bootconfigs {
+		if _, ok := releases[bc.Kernel]; !ok {
+			releases[bc.Kernel] = bc.KernelRelease()
+		}
+	}
+	sort.SliceStable(bootconfigs, func(i, j int) bool {
+		ri, rj := releases[bootconfigs[i].Kernel], releases[bootconfigs[j].Kernel]
+		if ri == "" || rj == "" {
+			return ri != "" && rj == ""
+		}
+		return CompareKernelReleases(ri, rj) > 0
+	})
+}
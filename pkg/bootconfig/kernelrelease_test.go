@@ -0,0 +1,80 @@
+package bootconfig
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadKernelRelease(t *testing.T) {
+	release, err := ReadKernelRelease("testdata/bzImage")
+	require.NoError(t, err)
+	require.Equal(t, "5.15.0-91-generic", release)
+
+	_, err = ReadKernelRelease("testdata/initrd.cpio")
+	require.Error(t, err)
+
+	// a kernel_version pointer past the setup code
+	data, err := ioutil.ReadFile("testdata/bzImage")
+	require.NoError(t, err)
+	binary.LittleEndian.PutUint16(data[bzImageKernelVersionOffset:], 0xffff)
+	dir, err := ioutil.TempDir("", "kernelrelease")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	kernel := path.Join(dir, "bzImage")
+	require.NoError(t, ioutil.WriteFile(kernel, data, 0644))
+	_, err = ReadKernelRelease(kernel)
+	require.Error(t, err)
+}
+
+func TestKernelReleaseFallback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kernelrelease")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	kernel := path.Join(dir, "vmlinuz-6.1.0-13-arm64")
+	require.NoError(t, ioutil.WriteFile(kernel, []byte("not a bzImage"), 0644))
+
+	bc := BootConfig{Kernel: kernel}
+	require.Equal(t, "6.1.0-13-arm64", bc.KernelRelease())
+	bc = BootConfig{Kernel: path.Join(dir, "vmlinuz")}
+	require.Equal(t, "", bc.KernelRelease())
+	// the header wins over the file name
+	bc = BootConfig{Kernel: "testdata/bzImage"}
+	require.Equal(t, "5.15.0-91-generic", bc.KernelRelease())
+}
+
+func TestCompareKernelReleases(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		want int
+	}{
+		{"5.10.0", "5.9.0", 1},
+		{"5.15.0-91-generic", "5.15.0-101-generic", -1},
+		{"5.10.1", "5.10-rc1", 1},
+		{"5.10", "5.10-rc1", 1},
+		{"5.10.1", "5.10", 1},
+		{"6.1.0-13-amd64", "6.1.0-13-amd64", 0},
+	} {
+		require.Equal(t, tt.want, CompareKernelReleases(tt.a, tt.b), "%s vs %s", tt.a, tt.b)
+		require.Equal(t, -tt.want, CompareKernelReleases(tt.b, tt.a), "%s vs %s", tt.b, tt.a)
+	}
+}
+
+func TestSortByKernelRelease(t *testing.T) {
+	cfgs := []BootConfig{
+		{Name: "unknown", Kernel: "/nonexistent/vmlinuz"},
+		{Name: "old", Kernel: "/nonexistent/vmlinuz-5.9.0"},
+		{Name: "header", Kernel: "testdata/bzImage"},
+		{Name: "new", Kernel: "/nonexistent/vmlinuz-5.15.0-101-generic"},
+	}
+	SortByKernelRelease(cfgs)
+	var names []string
+	for _, bc := range cfgs {
+		names = append(names, bc.Name)
+	}
+	require.Equal(t, []string{"new", "header", "old", "unknown"}, names)
+}
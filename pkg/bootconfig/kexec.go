@@ -0,0 +1,144 @@
+package bootconfig
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// KexecConsoleArg is the argument of the running kernel's command line that
+// sets the serial console of the kexec purgatory, e.g.
+// `systemboot.kexec_console=ttyS0,115200`. It takes precedence over the
+// KexecConsole of a BootConfig.
+const KexecConsoleArg = "systemboot.kexec_console"
+
+// runKexec runs the kexec-tools executable with the given arguments. If it
+// fails, the last line of its error output, e.g. `kexec_file_load failed:
+// Device or resource busy`, is added to the error. It is a variable so it can
+// be overridden for testing.
+var runKexec = func(args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command("kexec", args...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, io.MultiWriter(os.Stderr, &stderr)
+	if err := cmd.Run(); err != nil {
+		lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+		if last := lines[len(lines)-1]; last != "" {
+			return fmt.Errorf("%v: %s", err, last)
+		}
+		return err
+	}
+	return nil
+}
+
+// kexecAvailable tells whether the kexec-tools executable is in the PATH, and
+// hostArch is the architecture systemboot runs on. They are variables so they
+// can be overridden for testing.
+var (
+	kexecAvailable = func() bool {
+		_, err := exec.LookPath("kexec")
+		return err == nil
+	}
+	hostArch = runtime.GOARCH
+)
+
+// kexecConsole returns the serial console of the kexec purgatory, if any.
+func (bc *BootConfig) kexecConsole() string {
+	if data, err := ioutil.ReadFile(procCmdlinePath); err == nil {
+		running := BootConfig{KernelArgs: string(data)}
+		if consoles := running.GetArgs(KexecConsoleArg); len(consoles) > 0 {
+			return consoles[len(consoles)-1]
+		}
+	}
+	return bc.KexecConsole
+}
+
+// kexecConsoleArgs returns the kexec-tools options to send the purgatory
+// output to a legacy serial console, e.g. `ttyS0,115200n8`.
+func kexecConsoleArgs(console string) ([]string, error) {
+	device := consoleDevice(console)
+	var port uint64
+	for p, name := range legacySerialPorts {
+		if name == device {
+			port = p
+		}
+	}
+	if port == 0 {
+		return nil, fmt.Errorf("unsupported kexec console %q, only ttyS0 to ttyS3 are", console)
+	}
+	args := []string{"--console-serial", fmt.Sprintf("--serial=%#x", port)}
+	if idx := strings.IndexByte(console, ','); idx != -1 {
+		options := console[idx+1:]
+		end := 0
+		for end < len(options) && options[end] >= '0' && options[end] <= '9' {
+			end++
+		}
+		baud, err := strconv.Atoi(options[:end])
+		if err != nil {
+			return nil, fmt.Errorf("invalid baud rate in kexec console %q", console)
+		}
+		args = append(args, fmt.Sprintf("--serial-baud=%d", baud))
+	}
+	return args, nil
+}
+
+// kexecConsoleOptions returns the kexec-tools options to send the purgatory
+// output to the kexec console, or nil if there is none or it cannot be used.
+// The purgatory console is best effort: a console that is not a legacy x86
+// serial port, or a missing kexec executable, is logged and the BootConfig is
+// booted without it.
+func (bc *BootConfig) kexecConsoleOptions() []string {
+	console := bc.kexecConsole()
+	if console == "" {
+		return nil
+	}
+	if hostArch != "amd64" && hostArch != "386" {
+		log.Printf("Ignoring kexec console %s, the purgatory console is only supported on x86", console)
+		return nil
+	}
+	options, err := kexecConsoleArgs(console)
+	if err != nil {
+		log.Printf("Ignoring kexec console: %v", err)
+		return nil
+	}
+	if !kexecAvailable() {
+		log.Printf("Ignoring kexec console %s, the kexec executable is not available", console)
+		return nil
+	}
+	return options
+}
+
+// kexecWithOptions boots with the kexec-tools executable, passing it the given
+// initramfs, e.g. with a random seed, and options in addition to the boot
+// configuration. The load is retried on transient errors, like with the
+// pure-Go kexec.
+func (bc *BootConfig) kexecWithOptions(initramfs string, options []string) error {
+	args := []string{"-l", bc.Kernel}
+	if bc.KernelArgs != "" {
+		args = append(args, "--command-line="+bc.KernelArgs)
+	}
+	if initramfs != "" {
+		args = append(args, "--initrd="+initramfs)
+	}
+	if bc.DeviceTree != "" {
+		args = append(args, "--dtb="+bc.DeviceTree)
+	}
+	args = append(args, options...)
+	if err := retryKexecLoad(func() error { return runKexec(args...) }); err != nil {
+		return fmt.Errorf("kexec load failed: %v", err)
+	}
+	if err := bc.runPreBootHooks(); err != nil {
+		return err
+	}
+	if err := runKexec("-e"); err != nil {
+		return fmt.Errorf("kexec execute failed: %v", err)
+	}
+	return errors.New("Unexpectedly returned from kexec -e without error. The system did not reboot")
+}
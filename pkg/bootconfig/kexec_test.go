@@ -0,0 +1,107 @@
+package bootconfig
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/systemboot/systemboot/pkg/safemode"
+)
+
+// fakeKexec records the kexec invocations, on x86, until restored.
+func fakeKexec() (*[][]string, func()) {
+	var calls [][]string
+	saved, savedAvailable, savedArch := runKexec, kexecAvailable, hostArch
+	runKexec = func(args ...string) error {
+		calls = append(calls, args)
+		return nil
+	}
+	kexecAvailable = func() bool { return true }
+	hostArch = "amd64"
+	return &calls, func() { runKexec, kexecAvailable, hostArch = saved, savedAvailable, savedArch }
+}
+
+func setRunningCmdline(t *testing.T, cmdline string) func() {
+	dir, err := ioutil.TempDir("", "kexec")
+	require.NoError(t, err)
+	saved := procCmdlinePath
+	procCmdlinePath = path.Join(dir, "cmdline")
+	require.NoError(t, ioutil.WriteFile(procCmdlinePath, []byte(cmdline+"\n"), 0644))
+	return func() {
+		procCmdlinePath = saved
+		os.RemoveAll(dir)
+	}
+}
+
+func TestBootKexecConsoleFromCmdline(t *testing.T) {
+	defer setRunningCmdline(t, "quiet systemboot.kexec_console=ttyS1,57600n8")()
+	calls, restore := fakeKexec()
+	defer restore()
+
+	bc := BootConfig{Kernel: "/boot/vmlinuz", Initramfs: "/boot/initrd", KernelArgs: "console=ttyS1", KexecConsole: "ttyS0,9600"}
+	// the fake kexec returns, as if it didn't boot
+	require.Error(t, bc.Boot())
+	require.Equal(t, [][]string{
+		{"-l", "/boot/vmlinuz", "--command-line=console=ttyS1", "--initrd=/boot/initrd",
+			"--console-serial", "--serial=0x2f8", "--serial-baud=57600"},
+		{"-e"},
+	}, *calls)
+}
+
+func TestBootKexecConsoleFromBootConfig(t *testing.T) {
+	defer setRunningCmdline(t, "quiet")()
+	calls, restore := fakeKexec()
+	defer restore()
+
+	bc := BootConfig{Kernel: "/boot/vmlinuz", KexecConsole: "ttyS0,115200"}
+	require.Error(t, bc.Boot())
+	require.Equal(t, 2, len(*calls))
+	require.Equal(t, []string{"-l", "/boot/vmlinuz", "--console-serial", "--serial=0x3f8", "--serial-baud=115200"}, (*calls)[0])
+}
+
+func TestKexecConsoleOptionsBestEffort(t *testing.T) {
+	defer setRunningCmdline(t, "quiet")()
+	_, restore := fakeKexec()
+	defer restore()
+
+	bc := BootConfig{Kernel: "/boot/vmlinuz", KexecConsole: "ttyS0,115200"}
+	require.NotNil(t, bc.kexecConsoleOptions())
+	// not a legacy serial port
+	require.Nil(t, (&BootConfig{KexecConsole: "ttyAMA0,115200"}).kexecConsoleOptions())
+	require.Nil(t, (&BootConfig{KexecConsole: "ttyS4"}).kexecConsoleOptions())
+	// not on x86
+	hostArch = "arm64"
+	require.Nil(t, bc.kexecConsoleOptions())
+	hostArch = "amd64"
+	// no kexec executable
+	kexecAvailable = func() bool { return false }
+	require.Nil(t, bc.kexecConsoleOptions())
+}
+
+func TestKexecConsoleArgs(t *testing.T) {
+	args, err := kexecConsoleArgs("ttyS3")
+	require.NoError(t, err)
+	require.Equal(t, []string{"--console-serial", "--serial=0x2e8"}, args)
+	_, err = kexecConsoleArgs("ttyAMA0,115200")
+	require.Error(t, err)
+	_, err = kexecConsoleArgs("ttyS0,fast")
+	require.Error(t, err)
+}
+
+func TestBootSafeMode(t *testing.T) {
+	defer setRunningCmdline(t, "quiet")()
+	calls, restore := fakeKexec()
+	defer restore()
+	measurements, restoreMeasure := recordMeasurements()
+	defer restoreMeasure()
+	safemode.Enable()
+	defer safemode.Disable()
+
+	bc := BootConfig{Kernel: "/boot/vmlinuz", KexecConsole: "ttyS0,115200"}
+	err := bc.Boot()
+	require.IsType(t, &safemode.Error{}, err)
+	require.Equal(t, 0, len(*calls))
+	require.Equal(t, 0, len(*measurements))
+}
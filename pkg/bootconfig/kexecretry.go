@@ -0,0 +1,71 @@
+package bootconfig
+
+import (
+	"log"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/u-root/u-root/pkg/kexec"
+)
+
+// DefaultKexecLoadRetries is how many times a kexec load that failed
+// transiently is retried, see SetKexecLoadRetries.
+const DefaultKexecLoadRetries = 2
+
+// DefaultKexecLoadRetryDelay is how long to wait before retrying a kexec load.
+const DefaultKexecLoadRetryDelay = 500 * time.Millisecond
+
+var (
+	kexecLoadRetries    = DefaultKexecLoadRetries
+	kexecLoadRetryDelay = DefaultKexecLoadRetryDelay
+)
+
+// kexecFileLoad is kexec.FileLoad. It is a variable so it can be overridden
+// for testing.
+var kexecFileLoad = kexec.FileLoad
+
+// SetKexecLoadRetries sets how many times a kexec load that failed
+// transiently, e.g. with ENOMEM because of memory fragmentation, is retried,
+// and how long to wait before each retry. Zero retries fails on the first
+// error.
+func SetKexecLoadRetries(retries int, delay time.Duration) {
+	kexecLoadRetries, kexecLoadRetryDelay = retries, delay
+}
+
+// isTransientLoadError returns true if a kexec load failed with EBUSY or
+// ENOMEM, which may not happen again. u-root and the kexec executable only
+// report the errno in the message of their errors, hence the fallback on it.
+func isTransientLoadError(err error) bool {
+	if e, ok := err.(*os.SyscallError); ok {
+		err = e.Err
+	}
+	for _, errno := range []syscall.Errno{syscall.EBUSY, syscall.ENOMEM} {
+		if err == errno || strings.HasSuffix(strings.ToLower(err.Error()), strings.ToLower(errno.Error())) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryKexecLoad calls load, and retries it on transient errors, see
+// SetKexecLoadRetries. Other errors fail right away.
+func retryKexecLoad(load func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := load()
+		if err == nil || attempt >= kexecLoadRetries || !isTransientLoadError(err) {
+			return err
+		}
+		log.Printf("kexec load failed, retrying in %v (%d/%d): %v", kexecLoadRetryDelay, attempt+1, kexecLoadRetries, err)
+		time.Sleep(kexecLoadRetryDelay)
+	}
+}
+
+// kexecLoad loads a kernel with kexec_file_load, retrying on transient
+// errors.
+func kexecLoad(kernel, initramfs *os.File, cmdline string) error {
+	return retryKexecLoad(func() error {
+		return kexecFileLoad(kernel, initramfs, cmdline)
+	})
+}
@@ -0,0 +1,96 @@
+package bootconfig
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKexecLoad makes the kexec loads fail with the given errors, in order,
+// and then succeed, without waiting between retries.
+func fakeKexecLoad(errs ...error) (*int, func()) {
+	saved, savedRetries, savedDelay := kexecFileLoad, kexecLoadRetries, kexecLoadRetryDelay
+	calls := 0
+	kexecFileLoad = func(kernel, ramfs *os.File, cmdline string) error {
+		calls++
+		if calls <= len(errs) {
+			return errs[calls-1]
+		}
+		return nil
+	}
+	kexecLoadRetryDelay = 0
+	return &calls, func() {
+		kexecFileLoad, kexecLoadRetries, kexecLoadRetryDelay = saved, savedRetries, savedDelay
+	}
+}
+
+func TestKexecLoadRetry(t *testing.T) {
+	calls, restore := fakeKexecLoad(syscall.EBUSY)
+	defer restore()
+	require.NoError(t, kexecLoad(nil, nil, "quiet"))
+	require.Equal(t, 2, *calls)
+}
+
+func TestKexecLoadRetryWrappedErrno(t *testing.T) {
+	// as reported by u-root
+	calls, restore := fakeKexecLoad(fmt.Errorf("sys_kexec(3, 4, quiet, 0) = %v", syscall.ENOMEM))
+	defer restore()
+	require.NoError(t, kexecLoad(nil, nil, "quiet"))
+	require.Equal(t, 2, *calls)
+}
+
+func TestKexecLoadNoRetry(t *testing.T) {
+	// non-transient errors fail fast
+	calls, restore := fakeKexecLoad(syscall.EPERM)
+	defer restore()
+	require.Equal(t, syscall.EPERM, kexecLoad(nil, nil, "quiet"))
+	require.Equal(t, 1, *calls)
+
+	// the retries are bounded
+	busy := errors.New("sys_kexec = " + syscall.EBUSY.Error())
+	calls, restore = fakeKexecLoad(busy, busy, busy, busy)
+	defer restore()
+	require.Equal(t, busy, kexecLoad(nil, nil, "quiet"))
+	require.Equal(t, 1+DefaultKexecLoadRetries, *calls)
+
+	SetKexecLoadRetries(0, time.Second)
+	calls, restore = fakeKexecLoad(syscall.EBUSY)
+	defer restore()
+	require.Equal(t, syscall.EBUSY, kexecLoad(nil, nil, "quiet"))
+	require.Equal(t, 1, *calls)
+}
+
+func TestKexecWithOptionsRetry(t *testing.T) {
+	calls, restore := fakeKexec()
+	defer restore()
+	// no delay between the retries
+	_, restoreLoad := fakeKexecLoad()
+	defer restoreLoad()
+	busy := errors.New("exit status 255: kexec_file_load failed: Device or resource busy")
+	fakeRunKexec := runKexec
+	failures := 1
+	runKexec = func(args ...string) error {
+		if args[0] == "-l" && failures > 0 {
+			failures--
+			return busy
+		}
+		return fakeRunKexec(args...)
+	}
+
+	bc := BootConfig{Kernel: "/boot/vmlinuz"}
+	// the fake kexec returns, as if it didn't boot
+	require.Contains(t, bc.kexecWithOptions("", nil).Error(), "Unexpectedly returned from kexec -e")
+	require.Equal(t, [][]string{{"-l", "/boot/vmlinuz"}, {"-e"}}, *calls)
+
+	// non-transient errors fail fast
+	*calls = nil
+	failures = 1
+	busy = errors.New("exit status 255: kexec_file_load failed: Operation not permitted")
+	require.Contains(t, bc.kexecWithOptions("", nil).Error(), "kexec load failed")
+	require.Empty(t, *calls)
+}
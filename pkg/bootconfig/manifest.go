@@ -18,6 +18,10 @@ type Manifest struct {
 	// Manifest interface.
 	Version int          `json:"version"`
 	Configs []BootConfig `json:"configs"`
+	// Remote lists boot configurations whose files are referenced by URL
+	// and downloaded, rather than shipped along with the Manifest, e.g. in a
+	// manifest fetched by netboot. See RemoteConfig
+	Remote []RemoteConfig `json:"remote,omitempty"`
 }
 
 // NewManifest returns a new empty Manifest structure with the current version
@@ -0,0 +1,95 @@
+package bootconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/systemboot/systemboot/pkg/crypto"
+	"github.com/systemboot/systemboot/pkg/fetch"
+)
+
+// OverlaySignatureExt is appended to the URL of an overlay config to get the
+// URL of its detached signature.
+const OverlaySignatureExt = ".sig"
+
+// Overlay is a remote config listing extra boot entries, e.g. rescue tools or
+// memtest, that are appended to the boot menu found on the local disks. Each
+// entry is a RemoteConfig, and its URLs are relative to the overlay's own URL.
+type Overlay struct {
+	Entries []RemoteConfig `json:"entries"`
+}
+
+// OverlayFromBytes parses and validates an Overlay in JSON format.
+func OverlayFromBytes(data []byte) (*Overlay, error) {
+	var o Overlay
+	if err := json.Unmarshal(data, &o); err != nil {
+		return nil, err
+	}
+	for idx := range o.Entries {
+		if err := o.Entries[idx].Validate(); err != nil {
+			return nil, fmt.Errorf("invalid overlay entry %d: %v", idx, err)
+		}
+	}
+	return &o, nil
+}
+
+// FetchOverlay downloads the overlay config at rawurl and the files of its
+// entries, saving them to subdirectories of dir, and returns the entries as
+// BootConfigs. If verifier is set, the overlay must have a valid signature at
+// rawurl+OverlaySignatureExt. The overlay is measured before it
+// is parsed. Entries whose files cannot be downloaded are skipped.
+func FetchOverlay(f *fetch.Fetcher, rawurl string, verifier crypto.Verifier, dir string) ([]BootConfig, error) {
+	base, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	data, err := f.Fetch(rawurl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch overlay: %v", err)
+	}
+	if verifier != nil {
+		signature, err := f.Fetch(rawurl+OverlaySignatureExt, nil)
+		if err != nil {
+			return nil, fmt.Errorf("cannot fetch overlay signature: %v", err)
+		}
+		if err := crypto.VerifySignature(data, signature, verifier); err != nil {
+			return nil, fmt.Errorf("overlay: %v", err)
+		}
+	} else {
+		log.Printf("No public key specified, the overlay %s is not verified", rawurl)
+	}
+	crypto.TryMeasureData(crypto.ConfigData, data, rawurl)
+	overlay, err := OverlayFromBytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid overlay: %v", err)
+	}
+	bootconfigs := make([]BootConfig, 0, len(overlay.Entries))
+	for idx, rc := range overlay.Entries {
+		entryDir := path.Join(dir, strconv.Itoa(idx))
+		if err := os.MkdirAll(entryDir, 0700); err != nil {
+			return nil, err
+		}
+		bc, err := rc.Download(f, base, entryDir)
+		if err != nil {
+			log.Printf("Skipping overlay entry %q: %v", rc.Name, err)
+			continue
+		}
+		bc.Source = &Source{Path: rawurl}
+		bootconfigs = append(bootconfigs, *bc)
+	}
+	return bootconfigs, nil
+}
+
+// MergeOverlay appends the overlay entries to the local boot configurations,
+// and removes the duplicates with Dedup, so that an entry that is both local
+// and in the overlay is kept at its local position.
+func MergeOverlay(local, overlay []BootConfig, opts DedupOptions) []BootConfig {
+	merged := make([]BootConfig, 0, len(local)+len(overlay))
+	merged = append(merged, local...)
+	return Dedup(append(merged, overlay...), opts)
+}
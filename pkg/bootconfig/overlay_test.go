@@ -0,0 +1,87 @@
+package bootconfig
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/systemboot/systemboot/pkg/crypto"
+	"github.com/systemboot/systemboot/pkg/fetch/fetchtest"
+	"golang.org/x/crypto/ed25519"
+)
+
+const testOverlay = `{
+	"entries": [
+		{"name": "memtest", "kernel": "memtest.bin"},
+		{"name": "rescue", "kernel": "rescue/vmlinuz", "initrd": ["rescue/initrd.img"], "cmdline": "rescue"},
+		{"name": "missing", "kernel": "missing/vmlinuz"}
+	]
+}`
+
+func newOverlayServer(signature []byte) *httptest.Server {
+	return fetchtest.NewFileServer(map[string]string{
+		"/overlay.json":      testOverlay,
+		"/overlay.json.sig":  string(signature),
+		"/memtest.bin":       "memtest",
+		"/rescue/vmlinuz":    "rescue kernel",
+		"/rescue/initrd.img": "rescue initrd",
+	})
+}
+
+func TestMergeOverlay(t *testing.T) {
+	pubkey, privkey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	ts := newOverlayServer(ed25519.Sign(privkey, []byte(testOverlay)))
+	defer ts.Close()
+	dir, err := ioutil.TempDir("", "overlay")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	overlay, err := FetchOverlay(fetchtest.NewFetcher(), ts.URL+"/overlay.json", &crypto.Ed25519Verifier{Key: pubkey}, path.Join(dir, "overlay"))
+	require.NoError(t, err)
+	// the entry whose kernel is missing is skipped
+	require.Equal(t, 2, len(overlay))
+	require.Equal(t, "rescue", overlay[1].Name)
+	require.Equal(t, "rescue", overlay[1].KernelArgs)
+	require.Equal(t, ts.URL+"/overlay.json", overlay[1].Source.Path)
+	initramfs, err := ioutil.ReadFile(overlay[1].Initramfs)
+	require.NoError(t, err)
+	require.Equal(t, []byte("rescue initrd"), initramfs)
+
+	// the local disk already has the same memtest entry
+	memtest := path.Join(dir, "memtest86+.bin")
+	require.NoError(t, ioutil.WriteFile(memtest, []byte("memtest"), 0644))
+	local := []BootConfig{
+		{Name: "linux", Kernel: "/mnt/sda1/boot/vmlinuz", KernelArgs: "ro"},
+		{Name: "local memtest", Kernel: memtest},
+	}
+	merged := MergeOverlay(local, overlay, DedupOptions{ByContent: true})
+	var names []string
+	for _, bc := range merged {
+		names = append(names, bc.Name)
+	}
+	require.Equal(t, []string{"linux", "local memtest", "rescue"}, names)
+
+	// without overlay, only the local entries are left
+	require.Equal(t, local, MergeOverlay(local, nil, DedupOptions{}))
+}
+
+func TestFetchOverlayInvalidSignature(t *testing.T) {
+	pubkey, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	_, otherkey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	ts := newOverlayServer(ed25519.Sign(otherkey, []byte(testOverlay)))
+	defer ts.Close()
+	dir, err := ioutil.TempDir("", "overlay")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = FetchOverlay(fetchtest.NewFetcher(), ts.URL+"/overlay.json", &crypto.Ed25519Verifier{Key: pubkey}, dir)
+	require.Error(t, err)
+	_, err = FetchOverlay(fetchtest.NewFetcher(), ts.URL+"/nonexistent.json", nil, dir)
+	require.Error(t, err)
+}
@@ -0,0 +1,61 @@
+package bootconfig
+
+import (
+	"errors"
+	"io/ioutil"
+)
+
+const (
+	// BootPreviousArg is the argument of the running kernel's command line
+	// that forces booting the previous kernel, e.g. to test a rollback after
+	// a failed upgrade: `systemboot.boot_previous=1`.
+	BootPreviousArg = "systemboot.boot_previous"
+	// BootedPreviousArg is added to the command line of the kernel booted
+	// because of BootPreviousArg, so that the booted system can tell.
+	BootedPreviousArg = "systemboot.booted_previous"
+)
+
+// BootPreviousRequested returns true if BootPreviousArg is set to 1 on the
+// running kernel's command line.
+func BootPreviousRequested() bool {
+	data, err := ioutil.ReadFile(procCmdlinePath)
+	if err != nil {
+		return false
+	}
+	running := BootConfig{KernelArgs: string(data)}
+	values := running.GetArgs(BootPreviousArg)
+	return len(values) > 0 && values[len(values)-1] == "1"
+}
+
+// SelectPrevious returns the boot configurations of the second-newest kernel
+// release, see KernelRelease, regardless of their order, e.g. the default
+// entry. The configurations are marked with BootedPreviousArg. It returns an
+// error if there are less than two kernel releases.
+func SelectPrevious(bootconfigs []BootConfig) ([]BootConfig, error) {
+	var newest, previous string
+	releases := make([]string, len(bootconfigs))
+	for idx, bc := range bootconfigs {
+		release := bc.KernelRelease()
+		releases[idx] = release
+		if release == "" {
+			continue
+		}
+		switch {
+		case newest == "" || CompareKernelReleases(release, newest) > 0:
+			newest, previous = release, newest
+		case CompareKernelReleases(release, newest) < 0 && (previous == "" || CompareKernelReleases(release, previous) > 0):
+			previous = release
+		}
+	}
+	if previous == "" {
+		return nil, errors.New("there is no previous kernel release to boot")
+	}
+	var selected []BootConfig
+	for idx, bc := range bootconfigs {
+		if releases[idx] != "" && CompareKernelReleases(releases[idx], previous) == 0 {
+			bc.SetArg(BootedPreviousArg, "1")
+			selected = append(selected, bc)
+		}
+	}
+	return selected, nil
+}
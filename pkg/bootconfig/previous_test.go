@@ -0,0 +1,43 @@
+package bootconfig
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBootPreviousRequested(t *testing.T) {
+	restore := setRunningCmdline(t, "quiet systemboot.boot_previous=1")
+	require.True(t, BootPreviousRequested())
+	restore()
+	restore = setRunningCmdline(t, "quiet systemboot.boot_previous=0")
+	require.False(t, BootPreviousRequested())
+	restore()
+	defer setRunningCmdline(t, "quiet")()
+	require.False(t, BootPreviousRequested())
+}
+
+func TestSelectPrevious(t *testing.T) {
+	cfgs := []BootConfig{
+		// the default entry is the newest kernel
+		{Name: "default", Kernel: "/nonexistent/vmlinuz-6.1.0-13-amd64", KernelArgs: "ro"},
+		{Name: "oldest", Kernel: "/nonexistent/vmlinuz-5.10.0-26-amd64"},
+		{Name: "unknown", Kernel: "/nonexistent/vmlinuz"},
+		{Name: "previous", Kernel: "/nonexistent/vmlinuz-6.1.0-12-amd64", KernelArgs: "ro"},
+		{Name: "previous recovery", Kernel: "/nonexistent/vmlinuz-6.1.0-12-amd64", KernelArgs: "ro single"},
+		{Name: "newest recovery", Kernel: "/nonexistent/vmlinuz-6.1.0-13-amd64", KernelArgs: "ro single"},
+	}
+	selected, err := SelectPrevious(cfgs)
+	require.NoError(t, err)
+	require.Equal(t, []BootConfig{
+		{Name: "previous", Kernel: "/nonexistent/vmlinuz-6.1.0-12-amd64", KernelArgs: "ro systemboot.booted_previous=1"},
+		{Name: "previous recovery", Kernel: "/nonexistent/vmlinuz-6.1.0-12-amd64", KernelArgs: "ro single systemboot.booted_previous=1"},
+	}, selected)
+	// the boot configurations are not modified
+	require.Equal(t, "ro", cfgs[3].KernelArgs)
+
+	_, err = SelectPrevious(cfgs[:1])
+	require.Error(t, err)
+	_, err = SelectPrevious([]BootConfig{cfgs[0], cfgs[5]})
+	require.Error(t, err)
+}
@@ -0,0 +1,183 @@
+package bootconfig
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/systemboot/systemboot/pkg/crypto"
+)
+
+// DefaultRandomSeedSize is the size of the random seed appended to the
+// initramfs, the size of the seed files of systemd.
+const DefaultRandomSeedSize = 512
+
+// RandomSeed is a random seed appended to the initramfs of the booted entries
+// as an extra cpio segment, so that the booted OS can seed its RNG early.
+type RandomSeed struct {
+	// Name is the path of the seed file in the initramfs, e.g.
+	// var/lib/systemd/random-seed
+	Name string
+	// Size is the size of the seed, DefaultRandomSeedSize if zero
+	Size int
+	// Files are seed files, e.g. on the ESP, that are mixed into the seed
+	// if they exist
+	Files []string
+}
+
+var randomSeed *RandomSeed
+
+// SetRandomSeed appends a random seed to the initramfs of the booted entries,
+// or stops appending one if rs is nil. The seed is appended after the
+// initramfs is measured, so that the PCRs do not depend on it.
+func SetRandomSeed(rs *RandomSeed) {
+	randomSeed = rs
+}
+
+// randomSource is where the seed comes from, the kernel RNG. It is a variable
+// so it can be overridden for testing.
+var randomSource = rand.Reader
+
+// tpmRandom is crypto.TPMRandom. It is a variable so it can be overridden for
+// testing.
+var tpmRandom = crypto.TPMRandom
+
+// mixSeed XORs a stream derived from data into seed, with SHA-256 in counter
+// mode, so that data adds entropy to the seed without weakening it.
+func mixSeed(seed, data []byte) {
+	var counter [4]byte
+	for off := 0; off < len(seed); off += sha256.Size {
+		binary.BigEndian.PutUint32(counter[:], uint32(off/sha256.Size))
+		h := sha256.New()
+		h.Write(counter[:])
+		h.Write(data)
+		block := h.Sum(nil)
+		for idx := 0; idx < len(block) && off+idx < len(seed); idx++ {
+			seed[off+idx] ^= block[idx]
+		}
+	}
+}
+
+// newSeed returns a seed of the given size from the kernel RNG, mixed with
+// the RNG of the TPM, if any, and the given seed files that exist.
+func (rs *RandomSeed) newSeed() ([]byte, error) {
+	size := rs.Size
+	if size == 0 {
+		size = DefaultRandomSeedSize
+	}
+	seed := make([]byte, size)
+	if _, err := io.ReadFull(randomSource, seed); err != nil {
+		return nil, err
+	}
+	if data, err := tpmRandom(sha256.Size); err == nil {
+		mixSeed(seed, data)
+	} else {
+		log.Printf("Random seed: not mixing in the TPM RNG: %v", err)
+	}
+	for _, file := range rs.Files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		mixSeed(seed, data)
+	}
+	return seed, nil
+}
+
+// writeCpioEntry writes a file or directory to a cpio archive, in the newc
+// format that the kernel unpacks.
+func writeCpioEntry(w *bytes.Buffer, ino int, name string, mode uint32, data []byte) {
+	fmt.Fprintf(w, "070701%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X",
+		ino, mode, 0, 0, 1, 0, len(data), 0, 0, 0, 0, len(name)+1, 0)
+	w.WriteString(name)
+	w.WriteByte(0)
+	for w.Len()%4 != 0 {
+		w.WriteByte(0)
+	}
+	w.Write(data)
+	for w.Len()%4 != 0 {
+		w.WriteByte(0)
+	}
+}
+
+// randomSeedCpio returns a cpio archive with the seed as the file name, only
+// readable by root, and its parent directories.
+func randomSeedCpio(name string, seed []byte) []byte {
+	var archive bytes.Buffer
+	name = strings.Trim(path.Clean("/"+name), "/")
+	ino := 1
+	parts := strings.Split(name, "/")
+	for idx := 1; idx < len(parts); idx++ {
+		writeCpioEntry(&archive, ino, strings.Join(parts[:idx], "/"), 040755, nil)
+		ino++
+	}
+	writeCpioEntry(&archive, ino, name, 0100600, seed)
+	writeCpioEntry(&archive, 0, "TRAILER!!!", 0, nil)
+	return archive.Bytes()
+}
+
+// seededInitramfs returns the initramfs to load: a temporary copy of the
+// initramfs with the seed set with SetRandomSeed appended as a separate cpio
+// segment, or the initramfs itself if there is no seed. The initramfs is
+// streamed into the copy, never held in memory whole, and the BootConfig is
+// left untouched, so that e.g. the pre-boot hooks see the original
+// initramfs. It returns a function that removes the copy. The seed itself is
+// never logged.
+func (bc *BootConfig) seededInitramfs() (string, func(), error) {
+	if randomSeed == nil || randomSeed.Name == "" {
+		return bc.Initramfs, func() {}, nil
+	}
+	seed, err := randomSeed.newSeed()
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot generate a random seed: %v", err)
+	}
+	segment := randomSeedCpio(randomSeed.Name, seed)
+	tmp, err := ioutil.TempFile("", "initramfs")
+	if err != nil {
+		return "", nil, err
+	}
+	remove := func() { os.Remove(tmp.Name()) }
+	if err := bc.copyInitramfs(tmp, segment); err != nil {
+		tmp.Close()
+		remove()
+		return "", nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		remove()
+		return "", nil, err
+	}
+	log.Printf("Appending a %d-byte random seed to the initramfs as %s", len(seed), randomSeed.Name)
+	return tmp.Name(), remove, nil
+}
+
+// copyInitramfs writes the initramfs, if any, then the given cpio segment,
+// aligned as the kernel expects, to w.
+func (bc *BootConfig) copyInitramfs(w io.Writer, segment []byte) error {
+	var size int64
+	if bc.Initramfs != "" {
+		fd, err := os.Open(bc.Initramfs)
+		if err != nil {
+			return err
+		}
+		defer fd.Close()
+		if size, err = io.Copy(w, fd); err != nil {
+			return err
+		}
+	}
+	// the kernel skips the zeros aligning the segments
+	padding := make([]byte, (4-size%4)%4)
+	for _, data := range [][]byte{padding, segment} {
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package bootconfig
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// cpioFile is a file of a newc cpio archive.
+type cpioFile struct {
+	name string
+	mode uint64
+	data []byte
+}
+
+// readCpio parses a newc cpio archive, up to its trailer.
+func readCpio(t *testing.T, archive []byte) []cpioFile {
+	var files []cpioFile
+	align := func(off int) int { return (off + 3) &^ 3 }
+	field := func(off, idx int) uint64 {
+		v, err := strconv.ParseUint(string(archive[off+6+8*idx:off+14+8*idx]), 16, 32)
+		require.NoError(t, err)
+		return v
+	}
+	for off := 0; ; {
+		require.Equal(t, "070701", string(archive[off:off+6]))
+		size, namesize := int(field(off, 6)), int(field(off, 11))
+		name := string(archive[off+110 : off+110+namesize-1])
+		data := align(off + 110 + namesize)
+		if name == "TRAILER!!!" {
+			return files
+		}
+		files = append(files, cpioFile{name: name, mode: field(off, 1), data: archive[data : data+size]})
+		off = align(data + size)
+	}
+}
+
+func TestSeededInitramfs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "randomseed")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	base := []byte("070701 compressed base initramfs")
+	initramfs := filepath.Join(dir, "initramfs")
+	require.NoError(t, ioutil.WriteFile(initramfs, base, 0644))
+	espSeed := filepath.Join(dir, "random-seed")
+	require.NoError(t, ioutil.WriteFile(espSeed, []byte("ESP seed"), 0600))
+
+	defer func(orig func(int) ([]byte, error)) { tpmRandom = orig }(tpmRandom)
+	tpmRandom = func(int) ([]byte, error) { return nil, errors.New("no TPM") }
+	defer func(orig *RandomSeed) { randomSeed = orig }(randomSeed)
+	SetRandomSeed(&RandomSeed{Name: "/var/lib/systemd/random-seed", Files: []string{espSeed, filepath.Join(dir, "missing")}})
+
+	bc := BootConfig{Kernel: "/boot/vmlinuz", Initramfs: initramfs}
+	appended, cleanup, err := bc.seededInitramfs()
+	require.NoError(t, err)
+	require.NotEqual(t, initramfs, appended)
+	// the boot configuration keeps the original initramfs
+	require.Equal(t, initramfs, bc.Initramfs)
+	data, err := ioutil.ReadFile(appended)
+	require.NoError(t, err)
+	// the base initramfs, aligned, then the seed segment
+	require.True(t, bytes.HasPrefix(data, base))
+	files := readCpio(t, data[len(base)+(4-len(base)%4)%4:])
+	require.Len(t, files, 4)
+	require.Equal(t, "var/lib/systemd", files[2].name)
+	seed := files[3]
+	require.Equal(t, "var/lib/systemd/random-seed", seed.name)
+	require.Equal(t, uint64(0100600), seed.mode)
+	require.Len(t, seed.data, DefaultRandomSeedSize)
+	require.NotEqual(t, make([]byte, DefaultRandomSeedSize), seed.data)
+
+	cleanup()
+	_, err = os.Stat(appended)
+	require.True(t, os.IsNotExist(err))
+
+	// every boot gets another seed, even without an initramfs
+	bc.Initramfs = ""
+	appended, cleanup, err = bc.seededInitramfs()
+	require.NoError(t, err)
+	defer cleanup()
+	data, err = ioutil.ReadFile(appended)
+	require.NoError(t, err)
+	require.NotEqual(t, seed.data, readCpio(t, data)[3].data)
+}
+
+func TestSeededInitramfsDisabled(t *testing.T) {
+	defer func(orig *RandomSeed) { randomSeed = orig }(randomSeed)
+	SetRandomSeed(nil)
+	bc := BootConfig{Kernel: "/boot/vmlinuz", Initramfs: "/boot/initrd.img"}
+	initramfs, cleanup, err := bc.seededInitramfs()
+	require.NoError(t, err)
+	cleanup()
+	require.Equal(t, "/boot/initrd.img", initramfs)
+}
+
+func TestSeededInitramfsHiddenFromHooks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "randomseed")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	initramfs := filepath.Join(dir, "initramfs")
+	require.NoError(t, ioutil.WriteFile(initramfs, []byte("070701"), 0644))
+	defer func(orig func(int) ([]byte, error)) { tpmRandom = orig }(tpmRandom)
+	tpmRandom = func(int) ([]byte, error) { return nil, errors.New("no TPM") }
+	defer func(orig *RandomSeed) { randomSeed = orig }(randomSeed)
+	SetRandomSeed(&RandomSeed{Name: "random-seed"})
+	defer setRunningCmdline(t, "quiet")()
+	defer fakePreBootHooks()()
+	calls, restore := fakeKexec()
+	defer restore()
+
+	// the hooks see the initramfs of the boot configuration, and kexec
+	// loads the seeded copy
+	var seen string
+	RegisterPreBootHook(PreBootHook{Name: "report", Run: func(bc *BootConfig) error {
+		seen = bc.Initramfs
+		return nil
+	}})
+	bc := BootConfig{Kernel: "/boot/vmlinuz", Initramfs: initramfs}
+	require.Error(t, bc.Boot())
+	require.Equal(t, initramfs, seen)
+	require.Len(t, *calls, 2)
+	require.NotContains(t, (*calls)[0], "--initrd="+initramfs)
+}
@@ -0,0 +1,232 @@
+package bootconfig
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+
+	"github.com/systemboot/systemboot/pkg/crypto"
+	"github.com/systemboot/systemboot/pkg/fetch"
+)
+
+// RemoteConfigMediaType is the content type a server can use to mark a
+// Manifest listing RemoteConfigs. Plain application/json is accepted too.
+const RemoteConfigMediaType = "application/vnd.systemboot.bootconfig+json"
+
+// RemoteConfig is a boot configuration whose kernel, initrds and device tree
+// are referenced by URL rather than by path. It is listed in the Remote field
+// of a Manifest, like the one contained in a signed boot configuration ZIP
+// file, and it is converted to a BootConfig once the referenced files are
+// downloaded. URLs can be relative to the Manifest's own URL.
+type RemoteConfig struct {
+	Name    string   `json:"name,omitempty"`
+	Kernel  string   `json:"kernel"`
+	Initrd  []string `json:"initrd,omitempty"`
+	Cmdline string   `json:"cmdline,omitempty"`
+	DTB     string   `json:"dtb,omitempty"`
+	// Digests maps each URL, as written in the RemoteConfig, to its expected
+	// checksum in `algorithm:hexdigest` format, e.g. `sha256:abcd...`
+	Digests map[string]string `json:"digests,omitempty"`
+	// InitrdCompression, if set, makes Download decompress the initrds and
+	// repack them as a single segment compressed with this algorithm, see
+	// RepackInitrd. Otherwise they are just concatenated.
+	InitrdCompression string `json:"initrd_compression,omitempty"`
+	// Mirrors, if set, lists base URLs that the relative URLs are resolved
+	// against instead of the RemoteConfig's own URL. Each file is downloaded
+	// from the fastest mirror that has it, see fetch.Fetcher.FetchMirrors.
+	Mirrors []string `json:"mirrors,omitempty"`
+}
+
+// IsRemoteConfigContentType returns true if the given Content-Type header
+// value designates a Manifest listing RemoteConfigs.
+func IsRemoteConfigContentType(contentType string) bool {
+	mediatype, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediatype == RemoteConfigMediaType || mediatype == "application/json"
+}
+
+// RemoteManifestFromBytes parses a Manifest in JSON format, like
+// ManifestFromBytes, and validates its version and its RemoteConfigs. It
+// returns an error if the Manifest has no RemoteConfig.
+func RemoteManifestFromBytes(data []byte) (*Manifest, error) {
+	manifest, err := ManifestFromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	if manifest.Version < 1 || manifest.Version > CurrentManifestVersion {
+		return nil, fmt.Errorf("unsupported manifest version %d", manifest.Version)
+	}
+	if len(manifest.Remote) == 0 {
+		return nil, errors.New("manifest has no remote boot configuration")
+	}
+	for idx := range manifest.Remote {
+		if err := manifest.Remote[idx].Validate(); err != nil {
+			return nil, fmt.Errorf("invalid remote boot configuration %d: %v", idx, err)
+		}
+	}
+	return manifest, nil
+}
+
+// urls returns all the URLs referenced by the RemoteConfig.
+func (rc *RemoteConfig) urls() []string {
+	urls := []string{rc.Kernel}
+	urls = append(urls, rc.Initrd...)
+	if rc.DTB != "" {
+		urls = append(urls, rc.DTB)
+	}
+	return urls
+}
+
+// Validate returns an error if the RemoteConfig has no kernel, or contains
+// URLs or digests that cannot be parsed.
+func (rc *RemoteConfig) Validate() error {
+	if rc.Kernel == "" {
+		return errors.New("remote config has no kernel")
+	}
+	for _, u := range append(rc.urls(), rc.Mirrors...) {
+		if _, err := url.Parse(u); err != nil {
+			return fmt.Errorf("invalid URL in remote config: %v", err)
+		}
+	}
+	switch rc.InitrdCompression {
+	case "", CompressionNone, CompressionGzip:
+	default:
+		return fmt.Errorf("unsupported initrd compression %q", rc.InitrdCompression)
+	}
+	for u, digest := range rc.Digests {
+		if _, err := fetch.ParseChecksum(digest); err != nil {
+			return fmt.Errorf("invalid digest for %s: %v", u, err)
+		}
+	}
+	return nil
+}
+
+// Resolve returns the absolute URL of a reference in the RemoteConfig,
+// resolved against base, which is the URL the RemoteConfig was fetched from.
+func (rc *RemoteConfig) Resolve(base *url.URL, ref string) (*url.URL, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+	return base.ResolveReference(u), nil
+}
+
+// candidates returns the URLs a reference in the RemoteConfig can be
+// downloaded from: one per mirror, or just the one resolved against base.
+func (rc *RemoteConfig) candidates(base *url.URL, ref string) ([]string, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+	if len(rc.Mirrors) == 0 || u.IsAbs() {
+		return []string{base.ResolveReference(u).String()}, nil
+	}
+	urls := make([]string, 0, len(rc.Mirrors))
+	for _, mirror := range rc.Mirrors {
+		m, err := rc.Resolve(base, mirror)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, m.ResolveReference(u).String())
+	}
+	return urls, nil
+}
+
+// download fetches a single file referenced by the RemoteConfig, from the
+// fastest mirror if there are several, verifying it against its digest if one
+// is specified.
+func (rc *RemoteConfig) download(f *fetch.Fetcher, base *url.URL, ref string) ([]byte, error) {
+	urls, err := rc.candidates(base, ref)
+	if err != nil {
+		return nil, err
+	}
+	var checksum *fetch.Checksum
+	if digest, ok := rc.Digests[ref]; ok {
+		if checksum, err = fetch.ParseChecksum(digest); err != nil {
+			return nil, err
+		}
+	}
+	if len(urls) > 1 {
+		return f.FetchMirrors(urls, checksum)
+	}
+	return f.Fetch(urls[0], checksum)
+}
+
+// Download fetches the kernel, initrds and device tree referenced by the
+// RemoteConfig, resolving relative URLs against base, and saves them to dir.
+// Multiple initrds are concatenated into a single initramfs, or repacked if
+// InitrdCompression is set. It returns a BootConfig pointing to the downloaded
+// files.
+func (rc *RemoteConfig) Download(f *fetch.Fetcher, base *url.URL, dir string) (*BootConfig, error) {
+	if err := rc.Validate(); err != nil {
+		return nil, err
+	}
+	bc := BootConfig{
+		Name:       rc.Name,
+		Kernel:     path.Join(dir, "kernel"),
+		KernelArgs: rc.Cmdline,
+	}
+	data, err := rc.download(f, base, rc.Kernel)
+	if err != nil {
+		return nil, fmt.Errorf("cannot download kernel: %v", err)
+	}
+	if err := ioutil.WriteFile(bc.Kernel, data, 0400); err != nil {
+		return nil, err
+	}
+	if len(rc.Initrd) > 0 && rc.InitrdCompression != "" {
+		bc.Initramfs = path.Join(dir, "initramfs")
+		var segments [][]byte
+		for _, initrd := range rc.Initrd {
+			data, err := rc.download(f, base, initrd)
+			if err != nil {
+				return nil, fmt.Errorf("cannot download initrd: %v", err)
+			}
+			segments = append(segments, data)
+		}
+		data, err := RepackInitrd(segments, rc.InitrdCompression)
+		if err != nil {
+			return nil, fmt.Errorf("cannot repack initrds: %v", err)
+		}
+		crypto.TryMeasureData(crypto.Blob, data, "repacked initramfs "+bc.Initramfs)
+		if err := ioutil.WriteFile(bc.Initramfs, data, 0400); err != nil {
+			return nil, err
+		}
+	} else if len(rc.Initrd) > 0 {
+		bc.Initramfs = path.Join(dir, "initramfs")
+		fd, err := os.OpenFile(bc.Initramfs, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0400)
+		if err != nil {
+			return nil, err
+		}
+		defer fd.Close()
+		// initramfs archives can be concatenated, the kernel unpacks them
+		// in order
+		for _, initrd := range rc.Initrd {
+			data, err := rc.download(f, base, initrd)
+			if err != nil {
+				return nil, fmt.Errorf("cannot download initrd: %v", err)
+			}
+			if _, err := fd.Write(data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if rc.DTB != "" {
+		bc.DeviceTree = path.Join(dir, "devicetree")
+		data, err := rc.download(f, base, rc.DTB)
+		if err != nil {
+			return nil, fmt.Errorf("cannot download device tree: %v", err)
+		}
+		if err := ioutil.WriteFile(bc.DeviceTree, data, 0400); err != nil {
+			return nil, err
+		}
+	}
+	log.Printf("Downloaded remote config %q to %s", rc.Name, dir)
+	return &bc, nil
+}
@@ -0,0 +1,211 @@
+package bootconfig
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/systemboot/systemboot/pkg/fetch"
+	"github.com/systemboot/systemboot/pkg/fetch/fetchtest"
+)
+
+func TestRemoteManifestFromBytes(t *testing.T) {
+	data := []byte(`{
+	"version": 1,
+	"remote": [{
+		"name": "remote",
+		"kernel": "vmlinuz",
+		"initrd": ["initrd.img", "/overlay.cpio"],
+		"cmdline": "console=ttyS0",
+		"digests": {
+			"vmlinuz": "sha256:6923dd1bc0460082c5d55a831908c24a282860b7f1cd6c2b79cf1bc8857c639c"
+		}
+	}]
+}`)
+	manifest, err := RemoteManifestFromBytes(data)
+	require.NoError(t, err)
+	require.Len(t, manifest.Remote, 1)
+	require.Equal(t, "remote", manifest.Remote[0].Name)
+	require.Equal(t, []string{"initrd.img", "/overlay.cpio"}, manifest.Remote[0].Initrd)
+}
+
+func TestRemoteManifestFromBytesInvalid(t *testing.T) {
+	_, err := RemoteManifestFromBytes([]byte(`{"version": 1, "remote": [{"name": "no kernel"}]}`))
+	require.Error(t, err)
+	_, err = RemoteManifestFromBytes([]byte(`{"version": 1, "remote": [{"kernel": "vmlinuz", "digests": {"vmlinuz": "md5:abcd"}}]}`))
+	require.Error(t, err)
+	// not a manifest
+	_, err = RemoteManifestFromBytes([]byte(`{"kernel": "vmlinuz"}`))
+	require.Error(t, err)
+	_, err = RemoteManifestFromBytes([]byte(`{"version": 2, "remote": [{"kernel": "vmlinuz"}]}`))
+	require.Error(t, err)
+	_, err = RemoteManifestFromBytes([]byte(`{"version": 1, "configs": [{"kernel": "vmlinuz"}]}`))
+	require.Error(t, err)
+}
+
+func TestIsRemoteConfigContentType(t *testing.T) {
+	require.True(t, IsRemoteConfigContentType("application/json; charset=utf-8"))
+	require.True(t, IsRemoteConfigContentType(RemoteConfigMediaType))
+	require.False(t, IsRemoteConfigContentType("application/octet-stream"))
+}
+
+func TestRemoteConfigResolve(t *testing.T) {
+	base, err := url.Parse("http://example.com/releases/latest/config.json")
+	require.NoError(t, err)
+	rc := RemoteConfig{}
+	for ref, expected := range map[string]string{
+		"vmlinuz":                         "http://example.com/releases/latest/vmlinuz",
+		"../v1/initrd.img":                "http://example.com/releases/v1/initrd.img",
+		"/common/overlay.cpio":            "http://example.com/common/overlay.cpio",
+		"https://cdn.example.net/vmlinuz": "https://cdn.example.net/vmlinuz",
+	} {
+		u, err := rc.Resolve(base, ref)
+		require.NoError(t, err)
+		require.Equal(t, expected, u.String())
+	}
+}
+
+func TestRemoteConfigDownload(t *testing.T) {
+	ts := fetchtest.NewFileServer(map[string]string{
+		"/releases/v1/vmlinuz":    "kernel",
+		"/releases/v1/initrd.img": "initrd",
+		"/common/overlay.cpio":    "overlay",
+	})
+	defer ts.Close()
+	base, err := url.Parse(ts.URL + "/releases/v1/config.json")
+	require.NoError(t, err)
+	rc := RemoteConfig{
+		Name:    "remote",
+		Kernel:  "vmlinuz",
+		Initrd:  []string{"initrd.img", "../../common/overlay.cpio"},
+		Cmdline: "console=ttyS0",
+		Digests: map[string]string{
+			"vmlinuz": fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("kernel"))),
+		},
+	}
+	dir, err := ioutil.TempDir("", "remoteconfig")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	f := fetch.NewFetcher()
+	f.RetryInterval = 0
+	bc, err := rc.Download(f, base, dir)
+	require.NoError(t, err)
+	require.Equal(t, "remote", bc.Name)
+	require.Equal(t, "console=ttyS0", bc.KernelArgs)
+	kernel, err := ioutil.ReadFile(bc.Kernel)
+	require.NoError(t, err)
+	require.Equal(t, []byte("kernel"), kernel)
+	initramfs, err := ioutil.ReadFile(bc.Initramfs)
+	require.NoError(t, err)
+	require.Equal(t, []byte("initrdoverlay"), initramfs)
+	require.Equal(t, "", bc.DeviceTree)
+}
+
+func TestRemoteConfigDownloadMirrors(t *testing.T) {
+	// only the second mirror has the initrd
+	mirror1 := fetchtest.NewFileServer(map[string]string{"/m1/vmlinuz": "kernel"})
+	defer mirror1.Close()
+	mirror2 := fetchtest.NewFileServer(map[string]string{"/m2/vmlinuz": "kernel", "/m2/initrd.img": "initrd"})
+	defer mirror2.Close()
+	base, err := url.Parse("http://unreachable.invalid/config.json")
+	require.NoError(t, err)
+	rc := RemoteConfig{
+		Kernel:  "vmlinuz",
+		Initrd:  []string{"initrd.img"},
+		Mirrors: []string{mirror1.URL + "/m1/", mirror2.URL + "/m2/"},
+	}
+	dir, err := ioutil.TempDir("", "remoteconfig")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	f := fetch.NewFetcher()
+	f.RetryInterval = 0
+	bc, err := rc.Download(f, base, dir)
+	require.NoError(t, err)
+	kernel, err := ioutil.ReadFile(bc.Kernel)
+	require.NoError(t, err)
+	require.Equal(t, []byte("kernel"), kernel)
+	initramfs, err := ioutil.ReadFile(bc.Initramfs)
+	require.NoError(t, err)
+	require.Equal(t, []byte("initrd"), initramfs)
+}
+
+func TestRemoteConfigDownloadRepackInitrd(t *testing.T) {
+	base := gzipData(t, []byte("base"))
+	overlay := cpioArchive("etc/overlay", "overlay")
+	ts := fetchtest.NewFileServer(map[string]string{
+		"/vmlinuz":      "kernel",
+		"/initrd.gz":    string(base),
+		"/overlay.cpio": string(overlay),
+	})
+	defer ts.Close()
+	u, err := url.Parse(ts.URL + "/config.json")
+	require.NoError(t, err)
+	rc := RemoteConfig{
+		Kernel:            "vmlinuz",
+		Initrd:            []string{"initrd.gz", "overlay.cpio"},
+		InitrdCompression: CompressionGzip,
+	}
+	dir, err := ioutil.TempDir("", "remoteconfig")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	f := fetch.NewFetcher()
+	f.RetryInterval = 0
+	bc, err := rc.Download(f, u, dir)
+	require.NoError(t, err)
+	initramfs, err := ioutil.ReadFile(bc.Initramfs)
+	require.NoError(t, err)
+	repacked, err := RepackInitrd([][]byte{initramfs}, CompressionNone)
+	require.NoError(t, err)
+	require.Equal(t, append([]byte("base"), overlay...), repacked)
+}
+
+func TestRemoteConfigInvalidInitrdCompression(t *testing.T) {
+	rc := RemoteConfig{Kernel: "vmlinuz", InitrdCompression: "lzma"}
+	require.Error(t, rc.Validate())
+}
+
+func TestRemoteConfigDownloadDigestMismatch(t *testing.T) {
+	ts := fetchtest.NewFileServer(map[string]string{
+		"/vmlinuz": "tampered kernel",
+	})
+	defer ts.Close()
+	base, err := url.Parse(ts.URL + "/config.json")
+	require.NoError(t, err)
+	rc := RemoteConfig{
+		Kernel: "vmlinuz",
+		Digests: map[string]string{
+			"vmlinuz": fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("kernel"))),
+		},
+	}
+	dir, err := ioutil.TempDir("", "remoteconfig")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	_, err = rc.Download(fetch.NewFetcher(), base, dir)
+	require.Error(t, err)
+}
+
+func TestRemoteConfigDownloadMissingFile(t *testing.T) {
+	ts := fetchtest.NewFileServer(map[string]string{
+		"/vmlinuz": "kernel",
+	})
+	defer ts.Close()
+	base, err := url.Parse(ts.URL + "/config.json")
+	require.NoError(t, err)
+	rc := RemoteConfig{
+		Kernel: "vmlinuz",
+		Initrd: []string{"missing-initrd.img"},
+	}
+	dir, err := ioutil.TempDir("", "remoteconfig")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	_, err = rc.Download(fetch.NewFetcher(), base, dir)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "missing-initrd.img")
+}
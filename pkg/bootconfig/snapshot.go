@@ -0,0 +1,81 @@
+package bootconfig
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// rootSubvol returns the btrfs subvolume of the root file system, from the
+// subvol= option in the rootflags= argument, and the kernel arguments without
+// it, or an empty subvolume if there is none.
+func (bc *BootConfig) rootSubvol() (string, string) {
+	rootflags, ok := bc.GetArg("rootflags")
+	if !ok {
+		return "", bc.KernelArgs
+	}
+	var subvol string
+	var options []string
+	for _, option := range strings.Split(rootflags, ",") {
+		switch {
+		case strings.HasPrefix(option, "subvol="):
+			subvol = strings.TrimPrefix(option, "subvol=")
+		case strings.HasPrefix(option, "subvolid="):
+			// the ID of the same subvolume
+		default:
+			options = append(options, option)
+		}
+	}
+	stripped := *bc
+	if len(options) == 0 {
+		stripped.RemoveArg("rootflags")
+	} else {
+		stripped.SetArg("rootflags", strings.Join(options, ","))
+	}
+	return subvol, stripped.KernelArgs
+}
+
+// isSnapshotSubvol returns true if a subvolume looks like a snapshot, e.g.
+// `@/.snapshots/42/snapshot` for Snapper or `timeshift-btrfs/snapshots/...`.
+func isSnapshotSubvol(subvol string) bool {
+	for _, elem := range strings.Split(subvol, "/") {
+		if elem == ".snapshots" || elem == "snapshot" || elem == "snapshots" {
+			return true
+		}
+	}
+	return false
+}
+
+// CollapseSnapshots hides the boot configurations of btrfs snapshots, e.g. the
+// ones grub-btrfs adds for every Snapper snapshot. Configurations that only
+// differ by the subvol= option of their rootflags= argument, and by the
+// subvolume their kernel and initramfs are in, are the same system: their
+// snapshots are hidden, and only the first snapshot is kept if none of them is
+// on a live subvolume. Configurations on live subvolumes are always kept, e.g.
+// the @ and @fedora subvolumes of two systems. It returns the kept and the
+// hidden configurations, in order.
+func CollapseSnapshots(bootconfigs []BootConfig) ([]BootConfig, []BootConfig) {
+	keys := make([]string, len(bootconfigs))
+	subvols := make([]string, len(bootconfigs))
+	live := make(map[string]int)
+	for idx, bc := range bootconfigs {
+		subvol, args := bc.rootSubvol()
+		if subvol == "" {
+			continue
+		}
+		keys[idx] = fmt.Sprintf("%q %q %q %q", path.Base(bc.Kernel), path.Base(bc.Initramfs), args, bc.Device)
+		subvols[idx] = subvol
+		if chosen, ok := live[keys[idx]]; !ok || (!isSnapshotSubvol(subvol) && isSnapshotSubvol(subvols[chosen])) {
+			live[keys[idx]] = idx
+		}
+	}
+	var kept, hidden []BootConfig
+	for idx, bc := range bootconfigs {
+		if keys[idx] != "" && live[keys[idx]] != idx && isSnapshotSubvol(subvols[idx]) {
+			hidden = append(hidden, bc)
+			continue
+		}
+		kept = append(kept, bc)
+	}
+	return kept, hidden
+}
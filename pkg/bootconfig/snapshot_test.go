@@ -0,0 +1,48 @@
+package bootconfig
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollapseSnapshots(t *testing.T) {
+	snapshot := func(n string) BootConfig {
+		return BootConfig{
+			Name:       "openSUSE snapshot " + n,
+			Kernel:     "/mnt/sda2/@/.snapshots/" + n + "/snapshot/boot/vmlinuz-6.4.0",
+			Initramfs:  "/mnt/sda2/@/.snapshots/" + n + "/snapshot/boot/initrd-6.4.0",
+			KernelArgs: "root=UUID=1234 rootflags=subvol=@/.snapshots/" + n + "/snapshot,compress=zstd quiet",
+			Device:     "/dev/sda2",
+		}
+	}
+	live := BootConfig{
+		Name:       "openSUSE",
+		Kernel:     "/mnt/sda2/@/boot/vmlinuz-6.4.0",
+		Initramfs:  "/mnt/sda2/@/boot/initrd-6.4.0",
+		KernelArgs: "root=UUID=1234 rootflags=compress=zstd,subvol=@ quiet",
+		Device:     "/dev/sda2",
+	}
+	// a different kernel command line is not a snapshot of the live entry
+	recovery := live
+	recovery.Name, recovery.KernelArgs = "openSUSE recovery", "root=UUID=1234 rootflags=subvol=@ single"
+	other := BootConfig{Name: "Debian", Kernel: "/mnt/sdb1/vmlinuz", KernelArgs: "root=/dev/sdb1", Device: "/dev/sdb1"}
+
+	// grub-btrfs snapshots can come before the live entry
+	kept, hidden := CollapseSnapshots([]BootConfig{snapshot("41"), live, snapshot("42"), recovery, other, snapshot("43")})
+	require.Equal(t, []BootConfig{live, recovery, other}, kept)
+	require.Equal(t, []BootConfig{snapshot("41"), snapshot("42"), snapshot("43")}, hidden)
+
+	// without a live entry, the first snapshot is kept
+	kept, hidden = CollapseSnapshots([]BootConfig{snapshot("42"), snapshot("43")})
+	require.Equal(t, []BootConfig{snapshot("42")}, kept)
+	require.Equal(t, []BootConfig{snapshot("43")}, hidden)
+
+	// two live subvolumes are two systems, whatever their kernel
+	fedora := live
+	fedora.Name = "Fedora"
+	fedora.KernelArgs = "root=UUID=1234 rootflags=compress=zstd,subvol=@fedora quiet"
+	kept, hidden = CollapseSnapshots([]BootConfig{live, fedora, snapshot("42")})
+	require.Equal(t, []BootConfig{live, fedora}, kept)
+	require.Equal(t, []BootConfig{snapshot("42")}, hidden)
+}
@@ -0,0 +1,110 @@
+package bootconfig
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+// Names of the machine-specific values that can be used in kernel arguments
+// as `${sb:NAME}` placeholders.
+const (
+	TemplateMAC          = "MAC"
+	TemplateSerial       = "SERIAL"
+	TemplateBootUUID     = "BOOT_UUID"
+	TemplateBootPartUUID = "BOOT_PARTUUID"
+	TemplateIP           = "IP"
+)
+
+// templatePrefix starts a placeholder. Writing it with a doubled dollar sign,
+// i.e. `$${`, produces a literal `${`.
+const templatePrefix = "${sb:"
+
+// TemplateVars maps placeholder names to their values.
+type TemplateVars map[string]string
+
+// Where the kernel exposes the SMBIOS system serial number, manufacturer and
+// product name. They are variables so they can be overridden for testing.
+var (
+	smbiosSerialPath  = "/sys/class/dmi/id/product_serial"
+	smbiosVendorPath  = "/sys/class/dmi/id/sys_vendor"
+	smbiosProductPath = "/sys/class/dmi/id/product_name"
+)
+
+func readSMBIOSField(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// SMBIOSSerial returns the system serial number from SMBIOS.
+func SMBIOSSerial() (string, error) {
+	return readSMBIOSField(smbiosSerialPath)
+}
+
+// SMBIOSVendor returns the system manufacturer from SMBIOS.
+func SMBIOSVendor() (string, error) {
+	return readSMBIOSField(smbiosVendorPath)
+}
+
+// SMBIOSProduct returns the system product name from SMBIOS.
+func SMBIOSProduct() (string, error) {
+	return readSMBIOSField(smbiosProductPath)
+}
+
+// ExpandTemplate replaces the `${sb:NAME}` placeholders in a kernel command
+// line with the corresponding values. Values containing whitespace are
+// double-quoted, unless the placeholder already is, so they stay a single
+// argument. `$${` is replaced with a literal `${`. In strict mode an unknown
+// placeholder is an error, otherwise it expands to an empty string and a
+// warning is logged.
+func ExpandTemplate(cmdline string, vars TemplateVars, strict bool) (string, error) {
+	var out strings.Builder
+	inQuotes := false
+	for i := 0; i < len(cmdline); i++ {
+		switch {
+		case strings.HasPrefix(cmdline[i:], "$${"):
+			out.WriteString("${")
+			i += 2
+			continue
+		case strings.HasPrefix(cmdline[i:], templatePrefix):
+			end := strings.IndexByte(cmdline[i:], '}')
+			if end == -1 {
+				return "", fmt.Errorf("unterminated placeholder at offset %d in %q", i, cmdline)
+			}
+			name := cmdline[i+len(templatePrefix) : i+end]
+			value, ok := vars[name]
+			if !ok {
+				if strict {
+					return "", fmt.Errorf("unknown placeholder ${sb:%s}", name)
+				}
+				log.Printf("Warning: unknown placeholder ${sb:%s}, replacing it with an empty string", name)
+			}
+			if !inQuotes && strings.ContainsAny(value, " \t\n") {
+				value = `"` + value + `"`
+			}
+			out.WriteString(value)
+			i += end
+			continue
+		case cmdline[i] == '"':
+			inQuotes = !inQuotes
+		}
+		out.WriteByte(cmdline[i])
+	}
+	return out.String(), nil
+}
+
+// ExpandTemplate replaces the `${sb:NAME}` placeholders in the kernel
+// arguments, see the ExpandTemplate function. KernelArgs is left untouched on
+// error.
+func (bc *BootConfig) ExpandTemplate(vars TemplateVars, strict bool) error {
+	cmdline, err := ExpandTemplate(bc.KernelArgs, vars, strict)
+	if err != nil {
+		return err
+	}
+	bc.KernelArgs = cmdline
+	return nil
+}
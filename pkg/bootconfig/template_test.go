@@ -0,0 +1,82 @@
+package bootconfig
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testTemplateVars = TemplateVars{
+	TemplateMAC:          "52:54:00:12:34:56",
+	TemplateSerial:       "To Be Filled By O.E.M.",
+	TemplateBootUUID:     "1234-ABCD",
+	TemplateBootPartUUID: "0f1e2d3c-01",
+	TemplateIP:           "10.0.0.2",
+}
+
+func TestExpandTemplate(t *testing.T) {
+	for cmdline, expected := range map[string]string{
+		"":                                     "",
+		"ro quiet":                             "ro quiet",
+		"root=PARTUUID=${sb:BOOT_PARTUUID} ro": "root=PARTUUID=0f1e2d3c-01 ro",
+		"ip=${sb:IP} BOOTIF=${sb:MAC}":         "ip=10.0.0.2 BOOTIF=52:54:00:12:34:56",
+		"uuid=${sb:BOOT_UUID}":                 "uuid=1234-ABCD",
+		// adjacent placeholders
+		"id=${sb:MAC}${sb:IP}": "id=52:54:00:12:34:5610.0.0.2",
+		"${sb:IP}${sb:IP}":     "10.0.0.210.0.0.2",
+		// values with spaces stay a single argument
+		"serial=${sb:SERIAL} ro":   `serial="To Be Filled By O.E.M." ro`,
+		`serial="${sb:SERIAL}" ro`: `serial="To Be Filled By O.E.M." ro`,
+		// escaping
+		"literal=$${sb:MAC}":         "literal=${sb:MAC}",
+		"literal=$${sb:MAC}${sb:IP}": "literal=${sb:MAC}10.0.0.2",
+		// other variables are not placeholders
+		"foo=${MAC} bar=$sb:MAC": "foo=${MAC} bar=$sb:MAC",
+		"ro -- init=${sb:IP}":    "ro -- init=10.0.0.2",
+	} {
+		expanded, err := ExpandTemplate(cmdline, testTemplateVars, true)
+		require.NoError(t, err, cmdline)
+		require.Equal(t, expected, expanded, cmdline)
+	}
+}
+
+func TestExpandTemplateUnknown(t *testing.T) {
+	_, err := ExpandTemplate("ip=${sb:IP} foo=${sb:FOO}", testTemplateVars, true)
+	require.Error(t, err)
+	expanded, err := ExpandTemplate("ip=${sb:IP} foo=${sb:FOO} bar", testTemplateVars, false)
+	require.NoError(t, err)
+	require.Equal(t, "ip=10.0.0.2 foo= bar", expanded)
+	// a known placeholder without a value also expands to empty
+	expanded, err = ExpandTemplate("ip=${sb:IP}", TemplateVars{}, false)
+	require.NoError(t, err)
+	require.Equal(t, "ip=", expanded)
+}
+
+func TestExpandTemplateUnterminated(t *testing.T) {
+	_, err := ExpandTemplate("ip=${sb:IP", testTemplateVars, false)
+	require.Error(t, err)
+}
+
+func TestBootConfigExpandTemplate(t *testing.T) {
+	bc := BootConfig{KernelArgs: "root=PARTUUID=${sb:BOOT_PARTUUID} foo=${sb:FOO}"}
+	require.Error(t, bc.ExpandTemplate(testTemplateVars, true))
+	// left untouched on error
+	require.Equal(t, "root=PARTUUID=${sb:BOOT_PARTUUID} foo=${sb:FOO}", bc.KernelArgs)
+	require.NoError(t, bc.ExpandTemplate(testTemplateVars, false))
+	require.Equal(t, "root=PARTUUID=0f1e2d3c-01 foo=", bc.KernelArgs)
+}
+
+func TestSMBIOSSerial(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smbios")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(p string) { smbiosSerialPath = p }(smbiosSerialPath)
+	smbiosSerialPath = path.Join(dir, "product_serial")
+	require.NoError(t, ioutil.WriteFile(smbiosSerialPath, []byte("ABC123\n"), 0400))
+	serial, err := SMBIOSSerial()
+	require.NoError(t, err)
+	require.Equal(t, "ABC123", serial)
+}
@@ -0,0 +1,68 @@
+package bootconfig
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/systemboot/systemboot/pkg/crypto"
+)
+
+// RootHashSidecarExt is the extension of the sidecar file, next to the
+// kernel, that holds the dm-verity root hash of the root file system.
+const RootHashSidecarExt = ".roothash"
+
+// RootHashArg is the kernel argument used to pass the dm-verity root hash,
+// as understood by systemd-veritysetup-generator.
+var RootHashArg = "roothash"
+
+// SystemdRootHashArg is the kernel argument used by the systemd-based
+// initrds, like dracut's, to pass the dm-verity root hash. It is used instead
+// of RootHashArg if the kernel arguments already have it.
+var SystemdRootHashArg = "systemd.verity_root_hash"
+
+// ReadRootHash reads a dm-verity root hash, in hex format, from a file.
+func ReadRootHash(filename string) (string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	rootHash := strings.TrimSpace(string(data))
+	if _, err := hex.DecodeString(rootHash); err != nil || rootHash == "" {
+		return "", fmt.Errorf("invalid root hash in %s", filename)
+	}
+	return strings.ToLower(rootHash), nil
+}
+
+// SetRootHash measures the given dm-verity root hash and merges it into the
+// kernel arguments, replacing any root hash already there. It is passed as
+// SystemdRootHashArg if the kernel arguments have it, or RootHashArg
+// otherwise.
+func (bc *BootConfig) SetRootHash(rootHash string) {
+	arg := RootHashArg
+	if _, ok := bc.GetArg(SystemdRootHashArg); ok {
+		arg = SystemdRootHashArg
+	}
+	crypto.TryMeasureData(crypto.BootConfig, []byte(rootHash), arg)
+	bc.SetArg(arg, rootHash)
+}
+
+// ApplyRootHashSidecar looks for a root hash sidecar file next to the kernel,
+// e.g. `vmlinuz.roothash`, and if found passes the root hash to the kernel
+// with SetRootHash. A missing sidecar is not an error.
+func (bc *BootConfig) ApplyRootHashSidecar() error {
+	sidecar := bc.Kernel + RootHashSidecarExt
+	rootHash, err := ReadRootHash(sidecar)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	log.Printf("Using dm-verity root hash from %s", sidecar)
+	bc.SetRootHash(rootHash)
+	return nil
+}
@@ -0,0 +1,48 @@
+package bootconfig
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testRootHash = "2b4b1c3f5e3f4d3c8e1a6f0e0d9c8b7a6f5e4d3c2b1a09f8e7d6c5b4a3928170"
+
+func TestSetRootHash(t *testing.T) {
+	bc := BootConfig{KernelArgs: "root=/dev/dm-0 roothash=0000 ro -- single"}
+	bc.SetRootHash(testRootHash)
+	require.Equal(t, "root=/dev/dm-0 roothash="+testRootHash+" ro -- single", bc.KernelArgs)
+}
+
+func TestSetRootHashSystemd(t *testing.T) {
+	bc := BootConfig{KernelArgs: "ro systemd.verity_root_hash=0000"}
+	bc.SetRootHash(testRootHash)
+	require.Equal(t, "ro systemd.verity_root_hash="+testRootHash, bc.KernelArgs)
+}
+
+func TestApplyRootHashSidecar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "verity")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	bc := BootConfig{Kernel: path.Join(dir, "vmlinuz"), KernelArgs: "ro"}
+
+	// no sidecar, nothing changes
+	require.NoError(t, bc.ApplyRootHashSidecar())
+	require.Equal(t, "ro", bc.KernelArgs)
+
+	require.NoError(t, ioutil.WriteFile(bc.Kernel+RootHashSidecarExt, []byte(testRootHash+"\n"), 0644))
+	require.NoError(t, bc.ApplyRootHashSidecar())
+	require.Equal(t, "ro roothash="+testRootHash, bc.KernelArgs)
+}
+
+func TestApplyRootHashSidecarInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "verity")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	bc := BootConfig{Kernel: path.Join(dir, "vmlinuz")}
+	require.NoError(t, ioutil.WriteFile(bc.Kernel+RootHashSidecarExt, []byte("not a hash"), 0644))
+	require.Error(t, bc.ApplyRootHashSidecar())
+}
@@ -35,6 +35,29 @@ func (r *memoryZipReader) ReadAt(p []byte, offset int64) (n int, err error) {
 	return n, err
 }
 
+// VerifyAppendedSignature verifies the ed25519 signature appended to data,
+// with the public key in pubkeyfile, and returns data without the signature.
+// It is the signature of both the boot configuration ZIP files and the remote
+// manifests.
+func VerifyAppendedSignature(data []byte, pubkeyfile string) ([]byte, error) {
+	pubkey, err := crypto.LoadPublicKeyFromFile(pubkeyfile)
+	if err != nil {
+		return nil, err
+	}
+	// The signature is appended to the data and has length
+	// `ed25519.SignatureSize`. We read these bytes from the end of the data
+	// and treat them as the attached signature.
+	if len(data) < ed25519.SignatureSize {
+		return nil, fmt.Errorf("Short read when reading signature: want %d bytes, got %d", ed25519.SignatureSize, len(data))
+	}
+	signed := data[:len(data)-ed25519.SignatureSize]
+	signature := data[len(data)-ed25519.SignatureSize:]
+	if ok := ed25519.Verify(pubkey, signed, signature); !ok {
+		return nil, errors.New("Invalid ed25519 signature")
+	}
+	return signed, nil
+}
+
 // FromZip tries to extract a boot configuration from a ZIP file after verifying
 // its signature with the provided public key file. The signature is expected to
 // be appended to the ZIP file and have fixed length `ed25519.SignatureSize` .
@@ -52,29 +75,11 @@ func FromZip(filename string, pubkeyfile *string) (*Manifest, string, error) {
 	}
 	crypto.TryMeasureData(crypto.Blob, data, filename)
 	zipbytes := data
-	// Load the public key and, if a valid one is specified, match the
-	// signature. The signature is appended to the ZIP file, and can be present
-	// or not. A ZIP file is still valid if arbitrary content is appended after
-	// its end.
+	// The signature is appended to the ZIP file, and can be present or not. A
+	// ZIP file is still valid if arbitrary content is appended after its end.
 	if pubkeyfile != nil {
-		zipbytes = data[:len(data)-ed25519.SignatureSize]
-		pubkey, err := crypto.LoadPublicKeyFromFile(*pubkeyfile)
-		if err != nil {
-			return nil, "", err
-		}
-
-		// Load the signature.
-		// The signature is appended to the zip file and has length
-		// `ed25519.SignatureSize`. We read these bytes from the end of the file and
-		// treat them as the attached signature.
-		signature := data[len(data)-ed25519.SignatureSize : len(data)]
-		if len(signature) != ed25519.SignatureSize {
-			return nil, "", fmt.Errorf("Short read when reading signature: want %d bytes, got %d", ed25519.SignatureSize, len(signature))
-		}
-
-		// Verify the signature against the public key and the zip file bytes
-		if ok := ed25519.Verify(pubkey, zipbytes, signature); !ok {
-			return nil, "", fmt.Errorf("Invalid ed25519 signature for file %s", filename)
+		if zipbytes, err = VerifyAppendedSignature(data, *pubkeyfile); err != nil {
+			return nil, "", fmt.Errorf("%s: %v", filename, err)
 		}
 		log.Printf("Signature is valid")
 	} else {
@@ -3,9 +3,11 @@ package booter
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 )
 
@@ -17,6 +19,9 @@ type NetBooter struct {
 	MAC         string  `json:"mac"`
 	OverrideURL *string `json:"override_url,omitempty"`
 	Retries     *int    `json:"retries,omitempty"`
+	// LastResult is the outcome of the last failed Boot, if netboot reported
+	// one
+	LastResult *NetbootResult `json:"-"`
 }
 
 // NewNetBooter parses a boot entry config and returns a Booter instance, or an
@@ -78,15 +83,38 @@ func (nb *NetBooter) Boot() error {
 	if nb.Retries != nil {
 		bootcmd = append(bootcmd, "-retries", strconv.Itoa(*nb.Retries))
 	}
+	resultDir, err := ioutil.TempDir("", "netbooter")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(resultDir)
+	resultFile := filepath.Join(resultDir, "result.json")
+	bootcmd = append(bootcmd, "-result", resultFile)
 	log.Printf("Executing command: %v", bootcmd)
 	cmd := exec.Command(bootcmd[0], bootcmd[1:]...)
 	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("Error executing %v: %v", cmd, err)
+		result, rerr := ReadNetbootResult(resultFile)
+		if rerr != nil {
+			return fmt.Errorf("Error executing %v: %v", cmd, err)
+		}
+		nb.LastResult = result
+		logNetbootResult(result)
+		return fmt.Errorf("Error executing %v: %v: %s", cmd, err, result.Error)
 	}
 	return nil
 }
 
+// logNetbootResult logs a summary of a failed netboot run.
+func logNetbootResult(result *NetbootResult) {
+	for _, attempt := range result.Attempts {
+		log.Printf("NetBooter: %s/%s: boot file %q, error: %s", attempt.Interface, attempt.Protocol, attempt.BootFileURL, attempt.Error)
+		for _, f := range attempt.Fetches {
+			log.Printf("NetBooter:   fetch %s: status %d, %d bytes, verified %v %s", f.URL, f.StatusCode, f.Size, f.Verified, f.Error)
+		}
+	}
+}
+
 // TypeName returns the name of the booter type
 func (nb *NetBooter) TypeName() string {
 	return nb.Type
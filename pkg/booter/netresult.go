@@ -0,0 +1,71 @@
+package booter
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/systemboot/systemboot/pkg/fetch"
+)
+
+// NetbootResult is the outcome of a run of the netboot program, which writes
+// it as JSON with the -result flag. It collects what would otherwise be
+// scattered across the logs, so that failures can be diagnosed remotely.
+type NetbootResult struct {
+	Attempts []*NetbootAttempt `json:"attempts"`
+	// Error is the final error, empty on success
+	Error string `json:"error,omitempty"`
+}
+
+// NetbootAttempt is an attempt to boot through one interface with one DHCP
+// protocol.
+type NetbootAttempt struct {
+	Interface string `json:"interface"`
+	// Protocol is "dhcpv6" or "dhcpv4", "none" if DHCP was skipped, or with
+	// -slaac the mechanism that configured the interface, "slaac" or
+	// "slaac+dhcpv6-stateless"
+	Protocol string `json:"protocol"`
+	// DUID is the DHCPv6 client DUID set with -duid, e.g.
+	// "00:03:00:01:de:ad:be:ef:00:01"
+	DUID      string `json:"duid,omitempty"`
+	DHCPError string `json:"dhcp_error,omitempty"`
+	// BootFileURL is the boot file URL obtained via DHCP or overridden
+	BootFileURL string `json:"boot_file_url,omitempty"`
+	// Fetches are the downloads, including their verification, in order
+	Fetches []fetch.Report `json:"fetches,omitempty"`
+	Error   string         `json:"error,omitempty"`
+}
+
+// NewAttempt adds an attempt to the result and returns it.
+func (r *NetbootResult) NewAttempt(ifname, protocol string) *NetbootAttempt {
+	attempt := NetbootAttempt{Interface: ifname, Protocol: protocol}
+	r.Attempts = append(r.Attempts, &attempt)
+	return &attempt
+}
+
+// RecordFetch adds a download to the attempt. It can be used as the OnFetch
+// hook of a fetch.Fetcher.
+func (a *NetbootAttempt) RecordFetch(report fetch.Report) {
+	a.Fetches = append(a.Fetches, report)
+}
+
+// WriteFile writes the result to a file as JSON.
+func (r *NetbootResult) WriteFile(filename string) error {
+	data, err := json.MarshalIndent(r, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, data, 0644)
+}
+
+// ReadNetbootResult reads a result written with WriteFile.
+func ReadNetbootResult(filename string) (*NetbootResult, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var r NetbootResult
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
@@ -0,0 +1,62 @@
+package booter
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/systemboot/systemboot/pkg/fetch"
+)
+
+func TestNetbootResultCapturesFetch404(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+	bootfile := ts.URL + "/boot/vmlinuz"
+
+	var result NetbootResult
+	attempt := result.NewAttempt("eth0", "dhcpv6")
+	attempt.BootFileURL = bootfile
+	fetcher := fetch.NewFetcher()
+	fetcher.RetryInterval = 0
+	fetcher.OnFetch = attempt.RecordFetch
+	_, err := fetcher.Fetch(bootfile, nil)
+	require.Error(t, err)
+	attempt.Error = err.Error()
+	result.Error = "could not boot from any interfaces"
+
+	dir, err := ioutil.TempDir("", "netresult")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "result.json")
+	require.NoError(t, result.WriteFile(filename))
+	read, err := ReadNetbootResult(filename)
+	require.NoError(t, err)
+
+	require.Equal(t, result, *read)
+	require.Len(t, read.Attempts, 1)
+	require.Equal(t, "eth0", read.Attempts[0].Interface)
+	require.Len(t, read.Attempts[0].Fetches, 1)
+	fetched := read.Attempts[0].Fetches[0]
+	require.Equal(t, bootfile, fetched.URL)
+	require.Equal(t, http.StatusNotFound, fetched.StatusCode)
+	require.False(t, fetched.Verified)
+	require.NotEmpty(t, fetched.Error)
+}
+
+func TestNetbootResultDUID(t *testing.T) {
+	var result NetbootResult
+	attempt := result.NewAttempt("eth0", "dhcpv6")
+	attempt.DUID = "00:03:00:01:de:ad:be:ef:00:01"
+	data, err := json.Marshal(attempt)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"duid":"00:03:00:01:de:ad:be:ef:00:01"`)
+	// it is omitted without -duid
+	data, err = json.Marshal(result.NewAttempt("eth0", "dhcpv4"))
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "duid")
+}
@@ -0,0 +1,238 @@
+package bootscan
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/systemboot/systemboot/pkg/bootconfig"
+	"github.com/systemboot/systemboot/pkg/crypto"
+)
+
+// Locations of the Boot Loader Specification entries, relative to the root of
+// the partition. BLSIndexPath is a JSON index precomputed from the entries,
+// see BLSIndex, and BLSIndexSignaturePath its optional detached
+// signature, see crypto.VerifySignature.
+const (
+	BLSEntriesDir         = "loader/entries"
+	BLSIndexPath          = "loader/entries.json"
+	BLSIndexSignaturePath = "loader/entries.json.sig"
+)
+
+// BLSIndexEntry is a boot entry in a BLS index. The fields have the same
+// meaning as the keys of a BLS entry file.
+type BLSIndexEntry struct {
+	Title      string   `json:"title,omitempty"`
+	Linux      string   `json:"linux"`
+	Initrd     []string `json:"initrd,omitempty"`
+	Options    string   `json:"options,omitempty"`
+	DeviceTree string   `json:"devicetree,omitempty"`
+}
+
+// BLSIndex lists the BLS entries of a partition in a single JSON file, so that
+// the entries directory doesn't need to be scanned. The entries are in boot
+// order.
+type BLSIndex struct {
+	Entries []BLSIndexEntry `json:"entries"`
+}
+
+// blsBootConfig returns the boot config of a BLS entry. Only the first initrd
+// is used.
+func blsBootConfig(e BLSIndexEntry, resolver Resolver) bootconfig.BootConfig {
+	cfg := bootconfig.BootConfig{Name: e.Title, KernelArgs: e.Options, Source: &bootconfig.Source{}}
+	if e.Linux != "" {
+		cfg.Kernel, cfg.Subvolume = resolver.Resolve(e.Linux, e.Options, nil)
+	}
+	if len(e.Initrd) > 0 {
+		cfg.Initramfs, _ = resolver.Resolve(e.Initrd[0], e.Options, nil)
+	}
+	if e.DeviceTree != "" {
+		cfg.DeviceTree, _ = resolver.Resolve(e.DeviceTree, e.Options, nil)
+	}
+	return cfg
+}
+
+// ParseBLSEntry parses a BLS entry file, e.g. loader/entries/linux.conf. It
+// returns at most one boot config.
+func ParseBLSEntry(r io.Reader, resolver Resolver) ([]bootconfig.BootConfig, error) {
+	var (
+		entry   BLSIndexEntry
+		options []string
+	)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		value := argsAfterFields(line, 1)
+		switch fields[0] {
+		case "title":
+			entry.Title = value
+		case "linux":
+			entry.Linux = value
+		case "initrd":
+			entry.Initrd = append(entry.Initrd, value)
+		case "options":
+			options = append(options, value)
+		case "devicetree":
+			entry.DeviceTree = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	entry.Options = strings.Join(options, " ")
+	cfg := blsBootConfig(entry, resolver)
+	if !cfg.IsValid() {
+		return nil, nil
+	}
+	return []bootconfig.BootConfig{cfg}, nil
+}
+
+// ParseBLSIndex parses a BLS index in JSON format, see BLSIndex.
+func ParseBLSIndex(r io.Reader, resolver Resolver) ([]bootconfig.BootConfig, error) {
+	var index BLSIndex
+	if err := json.NewDecoder(r).Decode(&index); err != nil {
+		return nil, fmt.Errorf("invalid BLS index: %v", err)
+	}
+	bootconfigs := make([]bootconfig.BootConfig, 0, len(index.Entries))
+	for _, e := range index.Entries {
+		if cfg := blsBootConfig(e, resolver); cfg.IsValid() {
+			bootconfigs = append(bootconfigs, cfg)
+		}
+	}
+	return bootconfigs, nil
+}
+
+// readBLSIndex reads and measures the BLS index under basedir, and verifies
+// its signature. If key is set, a valid signature is required.
+func readBLSIndex(basedir string, key crypto.Verifier, opts Options) ([]byte, error) {
+	indexPath := path.Join(basedir, BLSIndexPath)
+	data, err := ioutil.ReadFile(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Measure != nil {
+		opts.Measure(indexPath, data)
+	}
+	signature, err := ioutil.ReadFile(path.Join(basedir, BLSIndexSignaturePath))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if key == nil {
+		if signature != nil {
+			opts.logf("No key to verify the signature of %s, ignoring it", indexPath)
+		}
+		return data, nil
+	}
+	if signature == nil {
+		return nil, fmt.Errorf("%s is not signed", indexPath)
+	}
+	if err := crypto.VerifySignature(data, signature, key); err != nil {
+		return nil, fmt.Errorf("invalid signature for %s: %v", indexPath, err)
+	}
+	return data, nil
+}
+
+// blsEntryFormat is used to scan individual BLS entry files. It is not the
+// "bls" format of Formats, which refers to scanBLS.
+var blsEntryFormat = Format{Name: "bls", Parse: ParseBLSEntry}
+
+// scanBLS reads the boot entries from the BLS index under basedir if there is
+// one, and otherwise from the individual entry files, in reverse name order so
+// that the newest versions come first. With opts.BLSIndexKey, only the signed
+// index is used.
+func scanBLS(basedir string, resolver Resolver, opts Options) ([]Entry, error) {
+	indexPath := path.Join(basedir, BLSIndexPath)
+	opts.logf("Trying to read %s", indexPath)
+	data, err := readBLSIndex(basedir, opts.BLSIndexKey, opts)
+	if err == nil {
+		if data, err = normalizeConfig(data); err != nil {
+			return nil, fmt.Errorf("%s: %v", indexPath, err)
+		}
+		bootconfigs, err := ParseBLSIndex(bytes.NewReader(data), resolver)
+		if err != nil {
+			return nil, err
+		}
+		entries := make([]Entry, 0, len(bootconfigs))
+		for _, bc := range bootconfigs {
+			bc.Source.Path = indexPath
+			entries = append(entries, Entry{BootConfig: bc, Format: "bls", ConfigPath: indexPath})
+		}
+		return entries, nil
+	}
+	if !os.IsNotExist(err) {
+		// a broken or tampered index must not be silently bypassed
+		return nil, err
+	}
+	if opts.BLSIndexKey != nil {
+		// the entry files are not signed
+		return nil, fmt.Errorf("no signed BLS index %s", indexPath)
+	}
+	names, err := ioutil.ReadDir(path.Join(basedir, BLSEntriesDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.New("no BLS entries")
+		}
+		return nil, err
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i].Name() > names[j].Name() })
+	var cfgpaths []string
+	for _, info := range names {
+		if info.Mode().IsRegular() && path.Ext(info.Name()) == ".conf" {
+			cfgpaths = append(cfgpaths, path.Join(basedir, BLSEntriesDir, info.Name()))
+		}
+	}
+	if opts.MeasureBatch != nil {
+		return scanBLSEntriesBatch(cfgpaths, resolver, opts), nil
+	}
+	var entries []Entry
+	for _, cfgpath := range cfgpaths {
+		found, err := ScanFile(&blsEntryFormat, cfgpath, resolver, opts)
+		if err != nil {
+			opts.logf("cannot open %s: %v", cfgpath, err)
+			continue
+		}
+		entries = append(entries, found...)
+	}
+	return entries, nil
+}
+
+// scanBLSEntriesBatch reads all the BLS entry files first, measures them at
+// once with opts.MeasureBatch, and then parses them.
+func scanBLSEntriesBatch(cfgpaths []string, resolver Resolver, opts Options) []Entry {
+	var (
+		read []string
+		data [][]byte
+	)
+	for _, cfgpath := range cfgpaths {
+		content, err := ioutil.ReadFile(cfgpath)
+		if err != nil {
+			opts.logf("cannot open %s: %v", cfgpath, err)
+			continue
+		}
+		read = append(read, cfgpath)
+		data = append(data, content)
+	}
+	opts.MeasureBatch(read, data)
+	var entries []Entry
+	for idx, cfgpath := range read {
+		found, err := parseFile(&blsEntryFormat, cfgpath, data[idx], resolver, opts, nil)
+		if err != nil {
+			opts.logf("cannot parse %s: %v", cfgpath, err)
+			continue
+		}
+		entries = append(entries, found...)
+	}
+	return entries
+}
@@ -0,0 +1,140 @@
+package bootscan
+
+import (
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/systemboot/systemboot/pkg/crypto"
+	"golang.org/x/crypto/ed25519"
+)
+
+const sampleBLSIndex = `{
+	"entries": [
+		{
+			"title": "Fedora 5.0",
+			"linux": "/vmlinuz-5.0",
+			"initrd": ["/initramfs-5.0.img"],
+			"options": "root=/dev/sda2 ro"
+		},
+		{
+			"title": "Fedora 4.20",
+			"linux": "/vmlinuz-4.20",
+			"initrd": ["/initramfs-4.20.img"],
+			"options": "root=/dev/sda2 ro"
+		}
+	]
+}`
+
+func TestParseBLSEntry(t *testing.T) {
+	cfgs, err := ParseBLSEntry(strings.NewReader(`
+# comment
+title      Fedora 5.0
+linux      /vmlinuz-5.0
+initrd     /initramfs-5.0.img
+options    root=/dev/sda2
+options    quiet
+devicetree /board.dtb
+`), BasedirResolver("/mnt/sda1"))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(cfgs))
+	require.Equal(t, "Fedora 5.0", cfgs[0].Name)
+	require.Equal(t, "/mnt/sda1/vmlinuz-5.0", cfgs[0].Kernel)
+	require.Equal(t, "/mnt/sda1/initramfs-5.0.img", cfgs[0].Initramfs)
+	require.Equal(t, "root=/dev/sda2 quiet", cfgs[0].KernelArgs)
+	require.Equal(t, "/mnt/sda1/board.dtb", cfgs[0].DeviceTree)
+}
+
+func TestScanBLSIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeTestFile(t, dir, BLSIndexPath, sampleBLSIndex)
+	// the entries directory is not scanned when there is an index
+	writeTestFile(t, dir, "loader/entries/other.conf", "linux /vmlinuz-other\ninitrd /initrd-other\n")
+
+	entries := Scan(dir, Options{})
+	require.Equal(t, 2, len(entries))
+	require.Equal(t, "Fedora 5.0", entries[0].Name)
+	require.Equal(t, path.Join(dir, "vmlinuz-5.0"), entries[0].Kernel)
+	require.Equal(t, path.Join(dir, "initramfs-5.0.img"), entries[0].Initramfs)
+	require.Equal(t, "root=/dev/sda2 ro", entries[0].KernelArgs)
+	require.Equal(t, "bls", entries[0].Format)
+	require.Equal(t, path.Join(dir, BLSIndexPath), entries[0].ConfigPath)
+	require.Equal(t, "Fedora 4.20", entries[1].Name)
+}
+
+func TestScanBLSIndexSignature(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	pubkey, privkey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	writeTestFile(t, dir, BLSIndexPath, sampleBLSIndex)
+	opts := Options{BLSIndexKey: &crypto.Ed25519Verifier{Key: pubkey}}
+
+	// a signature is required when there is a key
+	require.Equal(t, 0, len(Scan(dir, opts)))
+
+	// and so is the index, the entry files are not signed
+	writeTestFile(t, dir, "loader/entries/other.conf", "linux /vmlinuz-other\ninitrd /initrd-other\n")
+	require.NoError(t, os.Remove(path.Join(dir, BLSIndexPath)))
+	require.Equal(t, 1, len(Scan(dir, Options{})))
+	require.Equal(t, 0, len(Scan(dir, opts)))
+	writeTestFile(t, dir, BLSIndexPath, sampleBLSIndex)
+
+	writeTestFile(t, dir, BLSIndexSignaturePath, string(ed25519.Sign(privkey, []byte(sampleBLSIndex))))
+	require.Equal(t, 2, len(Scan(dir, opts)))
+
+	// a tampered index is refused, without falling back to the entries
+	writeTestFile(t, dir, BLSIndexPath, strings.Replace(sampleBLSIndex, "ro", "rw", -1))
+	require.Equal(t, 0, len(Scan(dir, opts)))
+}
+
+func TestScanBLSEntriesWithoutIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeTestFile(t, dir, "loader/entries/fedora-4.20.conf", "title Fedora 4.20\nlinux /vmlinuz-4.20\ninitrd /initramfs-4.20.img\n")
+	writeTestFile(t, dir, "loader/entries/fedora-5.0.conf", "title Fedora 5.0\nlinux /vmlinuz-5.0\ninitrd /initramfs-5.0.img\n")
+	writeTestFile(t, dir, "loader/entries/README", "not an entry")
+
+	entries := Scan(dir, Options{})
+	require.Equal(t, 2, len(entries))
+	require.Equal(t, "Fedora 5.0", entries[0].Name)
+	require.Equal(t, path.Join(dir, "loader/entries/fedora-5.0.conf"), entries[0].ConfigPath)
+	require.Equal(t, "Fedora 4.20", entries[1].Name)
+}
+
+func TestScanBLSEntriesMeasureBatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeTestFile(t, dir, "loader/entries/fedora-4.20.conf", "title Fedora 4.20\nlinux /vmlinuz-4.20\n")
+	writeTestFile(t, dir, "loader/entries/fedora-5.0.conf", "title Fedora 5.0\nlinux /vmlinuz-5.0\n")
+
+	var (
+		batches  [][]string
+		measured []string
+	)
+	opts := Options{
+		Measure: func(path string, data []byte) { measured = append(measured, path) },
+		MeasureBatch: func(paths []string, data [][]byte) {
+			batches = append(batches, paths)
+			require.Equal(t, "title Fedora 5.0\nlinux /vmlinuz-5.0\n", string(data[0]))
+		},
+	}
+	entries := Scan(dir, opts)
+	require.Equal(t, 2, len(entries))
+	require.Equal(t, "Fedora 5.0", entries[0].Name)
+	// the entries are measured at once, in the order they are parsed
+	require.Equal(t, [][]string{{
+		path.Join(dir, "loader/entries/fedora-5.0.conf"),
+		path.Join(dir, "loader/entries/fedora-4.20.conf"),
+	}}, batches)
+	require.Empty(t, measured)
+}
@@ -0,0 +1,399 @@
+// Package bootscan finds and parses bootloader configurations, e.g. grub.cfg,
+// on mounted file systems, and turns them into boot configurations. It does
+// not log or measure anything by itself: callers can opt in via Options.
+package bootscan
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/systemboot/systemboot/pkg/bootconfig"
+	"github.com/systemboot/systemboot/pkg/crypto"
+)
+
+// Resolver returns the full path of a kernel or initrd path referenced by a
+// boot entry, and the btrfs subvolume it was resolved against, if any. cmdline
+// is the kernel command line of the entry, and vars are the variables set in
+// the config file so far.
+type Resolver interface {
+	Resolve(p, cmdline string, vars map[string]string) (string, string)
+}
+
+// ResolverFunc is an adapter to use an ordinary function as a Resolver.
+type ResolverFunc func(p, cmdline string, vars map[string]string) (string, string)
+
+// Resolve calls f(p, cmdline, vars).
+func (f ResolverFunc) Resolve(p, cmdline string, vars map[string]string) (string, string) {
+	return f(p, cmdline, vars)
+}
+
+// BasedirResolver returns a Resolver that resolves every path relative to
+// basedir, typically the mount point of the partition holding the config.
+func BasedirResolver(basedir string) Resolver {
+	return ResolverFunc(func(p, cmdline string, vars map[string]string) (string, string) {
+		return path.Join(basedir, p), ""
+	})
+}
+
+// Options controls the side effects of scanning. The zero value is valid and
+// scans silently.
+type Options struct {
+	// Measure, if set, is called with the path and content of every config
+	// file that is read, before parsing it.
+	Measure func(path string, data []byte)
+	// MeasureBatch, if set, is used instead of Measure where many config
+	// files are read at once, e.g. BLS entries, so they can be measured
+	// faster. It is called with the paths and contents of the files, in
+	// the order they are parsed.
+	MeasureBatch func(paths []string, data [][]byte)
+	// Logf, if set, is used to report config files that are found, or that
+	// cannot be read or parsed.
+	Logf func(format string, v ...interface{})
+	// Debugf, if set, is used for more verbose messages.
+	Debugf func(format string, v ...interface{})
+	// Tracef, if set, traces the parsing of the config files that ask for
+	// debugging themselves, e.g. with GRUB `set debug=all`, from that
+	// directive on. Only the formats with a TraceParse function support it.
+	Tracef func(format string, v ...interface{})
+	// Disabled lists the names of the formats that are not scanned, see
+	// Formats.
+	Disabled []string
+	// BLSIndexKey, if set, is the verifier of the key the BLS index must be
+	// signed with, see BLSIndex. The unsigned BLS entry files are then
+	// ignored.
+	BLSIndexKey crypto.Verifier
+	// GrubConfigKey, if set, is the verifier of the key grub configs can be
+	// signed with, see GrubConfigSignatureExt. If a grub config in the
+	// standard locations of a partition has a valid signature, the ones
+	// without are ignored, and so are the files it includes that have no
+	// valid signature.
+	GrubConfigKey crypto.Verifier
+	// ImageMounter, if set, is used to mount the images of GRUB loopback
+	// devices, so that the kernel and initrd paths on them can be resolved.
+	// See LoopResolver.
+	ImageMounter ImageMounter
+}
+
+// resolver wraps a Resolver so that it follows loopback devices, if enabled.
+func (o Options) resolver(base Resolver) Resolver {
+	if o.ImageMounter == nil {
+		return base
+	}
+	return LoopResolver(base, o.ImageMounter, DefaultMaxLoopDepth)
+}
+
+// enabled returns true if the format with the given name is scanned.
+func (o Options) enabled(name string) bool {
+	for _, disabled := range o.Disabled {
+		if disabled == name {
+			return false
+		}
+	}
+	return true
+}
+
+func (o Options) logf(format string, v ...interface{}) {
+	if o.Logf != nil {
+		o.Logf(format, v...)
+	}
+}
+
+func (o Options) debugf(format string, v ...interface{}) {
+	if o.Debugf != nil {
+		o.Debugf(format, v...)
+	}
+}
+
+// Entry is a boot configuration along with where it was found.
+type Entry struct {
+	bootconfig.BootConfig
+	// Format is the name of the config format the entry was parsed from,
+	// e.g. "grub2"
+	Format string
+	// ConfigPath is the path of the config file the entry was parsed from
+	ConfigPath string
+}
+
+// BootConfigs returns the boot configurations of the given entries.
+func BootConfigs(entries []Entry) []bootconfig.BootConfig {
+	bootconfigs := make([]bootconfig.BootConfig, 0, len(entries))
+	for _, entry := range entries {
+		bootconfigs = append(bootconfigs, entry.BootConfig)
+	}
+	return bootconfigs
+}
+
+// Format describes a kind of bootloader configuration file.
+type Format struct {
+	// Name identifies the format, e.g. "grub2"
+	Name string
+	// Paths are the standard locations of the config files, relative to the
+	// root of the partition.
+	Paths []string
+	// Match returns true if the file at relpath, relative to the root of the
+	// partition, is a config file of this format, regardless of Paths.
+	Match func(relpath string) bool
+	// Parse parses a config file. It is nil for formats that are recognized
+	// but not supported yet.
+	Parse func(r io.Reader, resolver Resolver) ([]bootconfig.BootConfig, error)
+	// TraceParse, if set, is used instead of Parse when Options.Tracef is
+	// set, and traces the parsing with tracef where the config asks for it.
+	TraceParse func(r io.Reader, resolver Resolver, tracef func(format string, v ...interface{})) ([]bootconfig.BootConfig, error)
+	// ScanDir, if set, looks for config files under basedir, the root of the
+	// partition, in addition to Paths. It is used by formats whose files are
+	// not in fixed locations.
+	ScanDir func(basedir string, resolver Resolver, opts Options) ([]Entry, error)
+}
+
+// Formats lists the known config formats, in the order they are scanned.
+var Formats = []Format{
+	{
+		Name:  "grub2",
+		Paths: Grub2Paths,
+		Match: func(relpath string) bool {
+			return isGrubCfg(relpath) && strings.Contains(relpath, "grub2")
+		},
+		Parse: func(r io.Reader, resolver Resolver) ([]bootconfig.BootConfig, error) {
+			return ParseGrub(r, 2, resolver)
+		},
+		TraceParse: func(r io.Reader, resolver Resolver, tracef func(string, ...interface{})) ([]bootconfig.BootConfig, error) {
+			return ParseGrubTraced(r, 2, resolver, tracef)
+		},
+	},
+	{
+		Name:  "grub",
+		Paths: GrubLegacyPaths,
+		Match: func(relpath string) bool {
+			return isGrubCfg(relpath) && !strings.Contains(relpath, "grub2")
+		},
+		Parse: func(r io.Reader, resolver Resolver) ([]bootconfig.BootConfig, error) {
+			return ParseGrub(r, 1, resolver)
+		},
+		TraceParse: func(r io.Reader, resolver Resolver, tracef func(string, ...interface{})) ([]bootconfig.BootConfig, error) {
+			return ParseGrubTraced(r, 1, resolver, tracef)
+		},
+	},
+	{
+		Name:  "menulst",
+		Paths: MenuLstPaths,
+		Match: func(relpath string) bool {
+			return filepath.Base(relpath) == "menu.lst"
+		},
+		Parse: ParseMenuLst,
+	},
+	{
+		Name: "syslinux",
+		Match: func(relpath string) bool {
+			name := filepath.Base(relpath)
+			return name == "isolinux.cfg" || name == "syslinux.cfg"
+		},
+	},
+	{
+		Name: "bls",
+		Match: func(relpath string) bool {
+			return filepath.Ext(relpath) == ".conf" && filepath.Base(filepath.Dir(relpath)) == "entries" &&
+				filepath.Base(filepath.Dir(filepath.Dir(relpath))) == "loader"
+		},
+		Parse:   ParseBLSEntry,
+		ScanDir: scanBLS,
+	},
+}
+
+// FormatNames returns the names of the known config formats, in order.
+func FormatNames() []string {
+	names := make([]string, 0, len(Formats))
+	for _, format := range Formats {
+		names = append(names, format.Name)
+	}
+	return names
+}
+
+// CheckFormatNames returns an error if any of the given names is not the name
+// of a known config format.
+func CheckFormatNames(names []string) error {
+	for _, name := range names {
+		found := false
+		for _, format := range Formats {
+			if format.Name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown config format %q, known formats are %s", name, strings.Join(FormatNames(), ", "))
+		}
+	}
+	return nil
+}
+
+// FormatOf returns the format of the config file at relpath, relative to the
+// root of the partition, or nil if it is not a known config file.
+func FormatOf(relpath string) *Format {
+	for idx := range Formats {
+		if Formats[idx].Match(relpath) {
+			return &Formats[idx]
+		}
+	}
+	return nil
+}
+
+// ScanFile reads, measures and parses the config file at cfgpath with the
+// given format.
+func ScanFile(format *Format, cfgpath string, resolver Resolver, opts Options) ([]Entry, error) {
+	if format.Parse == nil {
+		return nil, fmt.Errorf("there is no scanner for %s configs yet", format.Name)
+	}
+	data, err := ioutil.ReadFile(cfgpath)
+	if err != nil {
+		return nil, err
+	}
+	return measureAndParse(format, cfgpath, data, resolver, opts, nil)
+}
+
+// measureAndParse measures the content of the config file at cfgpath, then
+// parses it. If verifier is not nil, the config files it includes must be
+// signed for it too, see GrubConfigSignatureExt.
+func measureAndParse(format *Format, cfgpath string, data []byte, resolver Resolver, opts Options, verifier crypto.Verifier) ([]Entry, error) {
+	if opts.Measure != nil {
+		opts.Measure(cfgpath, data)
+	}
+	return parseFile(format, cfgpath, data, resolver, opts, verifier)
+}
+
+// includeResolver is a Resolver that also reads the config files included by
+// the one being parsed, e.g. with GRUB `source`, so that they are measured
+// like it.
+type includeResolver struct {
+	Resolver
+	// cfgpath is the path of the config file being parsed
+	cfgpath     string
+	readInclude func(cfgpath string) ([]byte, error)
+}
+
+// readIncludeWith returns a function that reads and measures the config files
+// included by another one. If verifier is not nil, the included files without
+// a valid signature are refused.
+func readIncludeWith(opts Options, verifier crypto.Verifier) func(cfgpath string) ([]byte, error) {
+	return func(cfgpath string) ([]byte, error) {
+		data, err := ioutil.ReadFile(cfgpath)
+		if err != nil {
+			return nil, err
+		}
+		if verifier != nil {
+			if err := verifyGrubConfig(cfgpath, data, verifier); err != nil {
+				opts.logf("Refusing to include %s: %v", cfgpath, err)
+				return nil, err
+			}
+		}
+		if opts.Measure != nil {
+			opts.Measure(cfgpath, data)
+		}
+		return data, nil
+	}
+}
+
+// parseFile parses the content of the config file at cfgpath, which was
+// already measured. The config files it includes are measured when they are
+// read, and must be signed for verifier if it is not nil.
+func parseFile(format *Format, cfgpath string, data []byte, resolver Resolver, opts Options, verifier crypto.Verifier) ([]Entry, error) {
+	resolver = includeResolver{Resolver: resolver, cfgpath: cfgpath, readInclude: readIncludeWith(opts, verifier)}
+	data, err := normalizeConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", cfgpath, err)
+	}
+	var bootconfigs []bootconfig.BootConfig
+	if opts.Tracef != nil && format.TraceParse != nil {
+		tracef := func(f string, v ...interface{}) {
+			opts.Tracef(cfgpath+": "+f, v...)
+		}
+		bootconfigs, err = format.TraceParse(bytes.NewReader(data), resolver, tracef)
+	} else {
+		bootconfigs, err = format.Parse(bytes.NewReader(data), resolver)
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, 0, len(bootconfigs))
+	for _, bc := range bootconfigs {
+		if bc.Source == nil {
+			bc.Source = &bootconfig.Source{}
+		}
+		if bc.Source.Path == "" {
+			bc.Source.Path = cfgpath
+		}
+		entries = append(entries, Entry{BootConfig: bc, Format: format.Name, ConfigPath: cfgpath})
+	}
+	return entries, nil
+}
+
+// SetDevice records the block device the entries were found on, both as the
+// device of their kernel and initramfs and as the device of their source.
+func SetDevice(entries []Entry, device string) {
+	for idx := range entries {
+		entries[idx].Device = device
+		if entries[idx].Source != nil {
+			entries[idx].Source.Device = device
+		}
+	}
+}
+
+// scanPaths looks for config files in the standard locations under basedir.
+func scanPaths(basedir string, resolver Resolver, opts Options) []Entry {
+	resolver = opts.resolver(resolver)
+	entries := make([]Entry, 0)
+	signed := signedGrubConfigs(basedir, opts)
+	for idx := range Formats {
+		format := &Formats[idx]
+		if !opts.enabled(format.Name) {
+			opts.debugf("Not looking for %s configs: disabled", format.Name)
+			continue
+		}
+		for _, cfgpath := range format.Paths {
+			cfgpath = path.Join(basedir, cfgpath)
+			if signed != nil && isGrubFormat(format) {
+				data, ok := signed[cfgpath]
+				if !ok {
+					opts.debugf("Ignoring %s: a signed grub config was found", cfgpath)
+					continue
+				}
+				// the verified content, not the file again
+				opts.logf("Reading the signed %s", cfgpath)
+				found, err := measureAndParse(format, cfgpath, data, resolver, opts, opts.GrubConfigKey)
+				if err != nil {
+					opts.logf("cannot parse %s: %v", cfgpath, err)
+					continue
+				}
+				entries = append(entries, found...)
+				continue
+			}
+			opts.logf("Trying to read %s", cfgpath)
+			found, err := ScanFile(format, cfgpath, resolver, opts)
+			if err != nil {
+				opts.logf("cannot open %s: %v", cfgpath, err)
+				continue
+			}
+			entries = append(entries, found...)
+		}
+		if format.ScanDir != nil {
+			found, err := format.ScanDir(basedir, resolver, opts)
+			if err != nil {
+				opts.logf("cannot scan %s for %s configs: %v", basedir, format.Name, err)
+				continue
+			}
+			entries = append(entries, found...)
+		}
+	}
+	return entries
+}
+
+// Scan looks for config files of every supported format in their standard
+// locations under basedir, and returns the boot entries they define, in
+// order. Kernel and initrd paths are relative to basedir.
+func Scan(basedir string, opts Options) []Entry {
+	return scanPaths(basedir, BasedirResolver(basedir), opts)
+}
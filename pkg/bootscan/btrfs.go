@@ -0,0 +1,64 @@
+package bootscan
+
+import (
+	"path"
+	"strings"
+
+	"github.com/systemboot/systemboot/pkg/bootconfig"
+)
+
+// rootflagsSubvol returns the subvolume passed via `rootflags=subvol=...` in
+// the given kernel command line, if any.
+func rootflagsSubvol(cmdline string) string {
+	bc := bootconfig.BootConfig{KernelArgs: cmdline}
+	rootflags, ok := bc.GetArg("rootflags")
+	if !ok {
+		return ""
+	}
+	for _, flag := range strings.Split(strings.Trim(rootflags, `"`), ",") {
+		if strings.HasPrefix(flag, "subvol=") {
+			return strings.TrimPrefix(strings.TrimPrefix(flag, "subvol="), "/")
+		}
+	}
+	return ""
+}
+
+// isGrubTrue returns true if a grub variable value means "enabled".
+func isGrubTrue(value string) bool {
+	switch strings.ToLower(value) {
+	case "y", "yes", "1", "true":
+		return true
+	}
+	return false
+}
+
+// BtrfsResolver returns a Resolver for a btrfs file system whose top-level
+// subvolume is mounted on topLevel. GRUB resolves paths relative to the
+// top-level subvolume, unless `btrfs_relative_path` is set, in which case they
+// are relative to the subvolume the kernel will use as root, i.e. the one
+// passed with `rootflags=subvol=` or the default subvolume.
+func BtrfsResolver(topLevel, defaultSubvol string) Resolver {
+	return ResolverFunc(func(p, cmdline string, vars map[string]string) (string, string) {
+		subvol := rootflagsSubvol(cmdline)
+		if !isGrubTrue(vars["btrfs_relative_path"]) {
+			return path.Join(topLevel, p), subvol
+		}
+		if subvol == "" {
+			subvol = defaultSubvol
+		}
+		return path.Join(topLevel, subvol, p), subvol
+	})
+}
+
+// ScanBtrfs looks for config files on a btrfs file system whose top-level
+// subvolume is mounted on topLevel. The configs are looked up in the default
+// subvolume first, then in the top-level one, and the kernel and initrd paths
+// are resolved against the appropriate subvolume.
+func ScanBtrfs(topLevel, defaultSubvol string, opts Options) []Entry {
+	resolver := BtrfsResolver(topLevel, defaultSubvol)
+	entries := scanPaths(path.Join(topLevel, defaultSubvol), resolver, opts)
+	if defaultSubvol != "" {
+		entries = append(entries, scanPaths(topLevel, resolver, opts)...)
+	}
+	return entries
+}
@@ -0,0 +1,53 @@
+package bootscan
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRootflagsSubvol(t *testing.T) {
+	require.Equal(t, "@/.snapshots/2/snapshot", rootflagsSubvol("root=UUID=abcd rootflags=subvol=@/.snapshots/2/snapshot quiet"))
+	require.Equal(t, "@", rootflagsSubvol("rootflags=compress=zstd,subvol=/@"))
+	require.Equal(t, "", rootflagsSubvol("root=/dev/sda1 ro"))
+}
+
+var btrfsGrubCfg = `
+set btrfs_relative_path="y"
+menuentry 'openSUSE' {
+	linux /boot/vmlinuz root=UUID=abcd
+	initrd /boot/initrd
+}
+menuentry 'openSUSE snapshot 2' {
+	linux /boot/vmlinuz root=UUID=abcd rootflags=subvol=@/.snapshots/2/snapshot
+	initrd /boot/initrd
+}
+`
+
+var grubBtrfsSnapshotCfg = `
+menuentry 'Arch Linux snapshot 1' {
+	linux /@snapshots/1/snapshot/boot/vmlinuz-linux root=UUID=abcd rootflags=subvol=@snapshots/1/snapshot
+	initrd /@snapshots/1/snapshot/boot/initramfs-linux.img
+}
+`
+
+func TestBtrfsRelativePath(t *testing.T) {
+	cfgs := parseGrubCfg(btrfsGrubCfg, 2, BtrfsResolver("/mnt/sda2", "@/.snapshots/1/snapshot"))
+	require.Equal(t, 2, len(cfgs))
+	// the default subvolume is used if no subvolume is passed to the kernel
+	require.Equal(t, "/mnt/sda2/@/.snapshots/1/snapshot/boot/vmlinuz", cfgs[0].Kernel)
+	require.Equal(t, "/mnt/sda2/@/.snapshots/1/snapshot/boot/initrd", cfgs[0].Initramfs)
+	require.Equal(t, "@/.snapshots/1/snapshot", cfgs[0].Subvolume)
+	// each snapshot entry resolves against its own subvolume
+	require.Equal(t, "/mnt/sda2/@/.snapshots/2/snapshot/boot/vmlinuz", cfgs[1].Kernel)
+	require.Equal(t, "/mnt/sda2/@/.snapshots/2/snapshot/boot/initrd", cfgs[1].Initramfs)
+	require.Equal(t, "@/.snapshots/2/snapshot", cfgs[1].Subvolume)
+}
+
+func TestBtrfsTopLevelPath(t *testing.T) {
+	cfgs := parseGrubCfg(grubBtrfsSnapshotCfg, 2, BtrfsResolver("/mnt/sda2", "@"))
+	require.Equal(t, 1, len(cfgs))
+	// without btrfs_relative_path, paths are relative to the top-level
+	require.Equal(t, "/mnt/sda2/@snapshots/1/snapshot/boot/vmlinuz-linux", cfgs[0].Kernel)
+	require.Equal(t, "@snapshots/1/snapshot", cfgs[0].Subvolume)
+}
@@ -0,0 +1,42 @@
+package bootscan
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"unicode/utf16"
+)
+
+var (
+	utf8BOM    = []byte{0xef, 0xbb, 0xbf}
+	utf16BEBOM = []byte{0xfe, 0xff}
+	utf16LEBOM = []byte{0xff, 0xfe}
+)
+
+// normalizeConfig prepares the content of a config file for parsing: it
+// strips a leading UTF-8 byte order mark, as written by some Windows editors,
+// and transcodes UTF-16 text with a byte order mark to UTF-8. Other bytes are
+// left untouched, including invalid UTF-8 sequences, e.g. Latin-1 accented
+// letters, which may be part of a file name. It fails on binary data.
+func normalizeConfig(data []byte) ([]byte, error) {
+	if bytes.HasPrefix(data, utf16BEBOM) {
+		data = decodeUTF16(data[len(utf16BEBOM):], binary.BigEndian)
+	} else if bytes.HasPrefix(data, utf16LEBOM) {
+		data = decodeUTF16(data[len(utf16LEBOM):], binary.LittleEndian)
+	}
+	data = bytes.TrimPrefix(data, utf8BOM)
+	if idx := bytes.IndexByte(data, 0); idx != -1 {
+		return nil, fmt.Errorf("config file is not a text file, it has a NUL byte at offset %d", idx)
+	}
+	return data, nil
+}
+
+// decodeUTF16 transcodes UTF-16 text in the given byte order to UTF-8. A
+// trailing odd byte is dropped.
+func decodeUTF16(data []byte, order binary.ByteOrder) []byte {
+	units := make([]uint16, len(data)/2)
+	for idx := range units {
+		units[idx] = order.Uint16(data[2*idx:])
+	}
+	return []byte(string(utf16.Decode(units)))
+}
@@ -0,0 +1,42 @@
+package bootscan
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanBOMGrubCfg(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bootscan")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeTestFile(t, dir, "boot/grub/grub.cfg", "\xef\xbb\xbf"+sampleGrubCfg[1:])
+
+	var measured []byte
+	opts := Options{Measure: func(path string, data []byte) { measured = data }}
+	entries, err := ScanFile(FormatOf("boot/grub/grub.cfg"), dir+"/boot/grub/grub.cfg", BasedirResolver(dir), opts)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(entries))
+	require.Equal(t, "Linux", entries[0].Name)
+	require.Equal(t, 1, entries[0].Source.Line)
+	// the file is measured as is
+	require.Equal(t, []byte("\xef\xbb\xbf"), measured[:3])
+}
+
+func TestNormalizeConfig(t *testing.T) {
+	// invalid UTF-8, e.g. in a file name, is kept as is
+	data, err := normalizeConfig([]byte("\xef\xbb\xbflinux /vmlinuz-d\xe9bian\n"))
+	require.NoError(t, err)
+	require.Equal(t, "linux /vmlinuz-d\xe9bian\n", string(data))
+
+	data, err = normalizeConfig([]byte("\xff\xfem\x00e\x00n\x00\xe9\x00"))
+	require.NoError(t, err)
+	require.Equal(t, "men\u00e9", string(data))
+	data, err = normalizeConfig([]byte("\xfe\xff\x00m\x00e\x00n\x00u"))
+	require.NoError(t, err)
+	require.Equal(t, "menu", string(data))
+	_, err = normalizeConfig([]byte("\x7fELF\x02\x01\x01\x00"))
+	require.Error(t, err)
+}
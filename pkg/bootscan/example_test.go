@@ -0,0 +1,57 @@
+package bootscan_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/systemboot/systemboot/pkg/bootscan"
+)
+
+func ExampleParseGrub() {
+	grubcfg := `
+menuentry 'Linux' {
+	linux /boot/vmlinuz root=/dev/sda1 quiet
+	initrd /boot/initrd.img
+}
+`
+	cfgs, err := bootscan.ParseGrub(strings.NewReader(grubcfg), 2, bootscan.BasedirResolver("/mnt/sda1"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, cfg := range cfgs {
+		fmt.Println(cfg.Kernel, cfg.Initramfs, cfg.KernelArgs)
+	}
+	// Output: /mnt/sda1/boot/vmlinuz /mnt/sda1/boot/initrd.img root=/dev/sda1 quiet
+}
+
+func ExampleScan() {
+	// a partition with a GRUB 2 config, e.g. mounted on /mnt/sda1
+	root, err := ioutil.TempDir("", "sda1")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	grubcfg := "menuentry 'Linux' {\n\tlinux /boot/vmlinuz\n}\n"
+	if err := os.MkdirAll(path.Join(root, "boot/grub2"), 0755); err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(root, "boot/grub2/grub.cfg"), []byte(grubcfg), 0644); err != nil {
+		log.Fatal(err)
+	}
+
+	opts := bootscan.Options{
+		// measure or verify every config file before it is parsed
+		Measure: func(path string, data []byte) {
+			log.Printf("read %d bytes from %s", len(data), path)
+		},
+		Logf: log.Printf,
+	}
+	for _, entry := range bootscan.Scan(root, opts) {
+		fmt.Printf("%s entry in %s: %s\n", entry.Format, strings.TrimPrefix(entry.ConfigPath, root), strings.TrimPrefix(entry.Kernel, root))
+	}
+	// Output: grub2 entry in /boot/grub2/grub.cfg: /boot/vmlinuz
+}
@@ -0,0 +1,458 @@
+package bootscan
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/systemboot/systemboot/pkg/bootconfig"
+)
+
+// List of paths where to look for grub config files. Grub2Paths will look for
+// files with grub2-compatible syntax, GrubLegacyPaths similarly will treat
+// these as grub-legacy config files.
+var (
+	Grub2Paths = []string{
+		// grub2
+		"boot/grub2/grub.cfg",
+		"boot/grub2.cfg",
+		"grub2/grub.cfg",
+		"grub2.cfg",
+	}
+	GrubLegacyPaths = []string{
+		// grub legacy
+		"boot/grub/grub.cfg",
+		"boot/grub.cfg",
+		"grub/grub.cfg",
+		"grub.cfg",
+	}
+)
+
+// isGrubCfg returns true if relpath is named like a grub config file.
+func isGrubCfg(relpath string) bool {
+	name := path.Base(relpath)
+	return name == "grub.cfg" || name == "grub2.cfg"
+}
+
+// ParseGrub parses a grub config and returns a list of BootConfig
+// structures, one for each menuentry. The entry selected by the `default`
+// setting comes first, followed by the `fallback` ones, and then the others in
+// the order they appear in the config. grubVersion is 2 for grub2, and 1 for
+// grub legacy. The kernel and initrd paths are resolved with resolver.
+func ParseGrub(r io.Reader, grubVersion int, resolver Resolver) ([]bootconfig.BootConfig, error) {
+	return ParseGrubTraced(r, grubVersion, resolver, nil)
+}
+
+// ParseGrubTraced is like ParseGrub, but if the config sets the `debug`
+// variable, e.g. `set debug=all`, every following line of the file is traced
+// with tracef, along with the boot configs it defines. tracef may be nil.
+func ParseGrubTraced(r io.Reader, grubVersion int, resolver Resolver, tracef func(format string, v ...interface{})) ([]bootconfig.BootConfig, error) {
+	if grubVersion != 1 && grubVersion != 2 {
+		return nil, fmt.Errorf("invalid GRUB version: %d", grubVersion)
+	}
+	return parseGrubFile(r, grubVersion, resolver, make(map[string]string), tracef, newGrubIncludes(resolver))
+}
+
+// maxGrubIncludeDepth is the maximum nesting of config files included with
+// `source`, `configfile` or `normal`.
+const maxGrubIncludeDepth = 8
+
+// grubIncludes tracks the config files included by a grub config.
+type grubIncludes struct {
+	// depth is the nesting level of the config file being parsed
+	depth int
+	// visited are the config files parsed so far, which are not included
+	// again, e.g. when a config loops back on itself with `normal`
+	visited map[string]bool
+}
+
+// newGrubIncludes returns the include tracking of a top-level grub config,
+// which counts as visited if the resolver knows its path.
+func newGrubIncludes(resolver Resolver) grubIncludes {
+	inc := grubIncludes{visited: make(map[string]bool)}
+	if ir, ok := resolver.(includeResolver); ok && ir.cfgpath != "" {
+		inc.visited[ir.cfgpath] = true
+	}
+	return inc
+}
+
+func parseGrubCfg(grubcfg string, grubVersion int, resolver Resolver) []bootconfig.BootConfig {
+	// reading a string cannot fail
+	bootconfigs, _ := parseGrubFile(strings.NewReader(grubcfg), grubVersion, resolver, make(map[string]string), nil, newGrubIncludes(resolver))
+	return bootconfigs
+}
+
+// readGrubInclude reads a config file included by another one, and measures
+// it if the resolver comes from a scan, see includeResolver.
+func readGrubInclude(resolver Resolver, cfgpath string) ([]byte, error) {
+	if ir, ok := resolver.(includeResolver); ok {
+		return ir.readInclude(cfgpath)
+	}
+	return ioutil.ReadFile(cfgpath)
+}
+
+// parseGrubInclude parses a config file included with `source`, `configfile`
+// or `normal`. Only the exported variables of the including file are visible
+// to it, and its own variables stay local to it. The boot configs it defines
+// are attributed to it. A config file is only parsed once.
+func parseGrubInclude(relpath string, grubVersion int, resolver Resolver, vars map[string]string, exported map[string]bool, tracef func(string, ...interface{}), inc grubIncludes) []bootconfig.BootConfig {
+	if inc.depth >= maxGrubIncludeDepth {
+		return nil
+	}
+	cfgpath, _ := resolver.Resolve(relpath, "", vars)
+	if inc.visited[cfgpath] {
+		return nil
+	}
+	inc.visited[cfgpath] = true
+	data, err := readGrubInclude(resolver, cfgpath)
+	if err != nil {
+		return nil
+	}
+	if data, err = normalizeConfig(data); err != nil {
+		return nil
+	}
+	included := make(map[string]string)
+	for name := range exported {
+		if value, ok := vars[name]; ok {
+			included[name] = value
+		}
+	}
+	var includedTracef func(string, ...interface{})
+	if tracef != nil {
+		includedTracef = func(format string, v ...interface{}) {
+			tracef(cfgpath+": "+format, v...)
+		}
+	}
+	inc.depth++
+	bootconfigs, _ := parseGrubFile(bytes.NewReader(data), grubVersion, resolver, included, includedTracef, inc)
+	for idx := range bootconfigs {
+		if bootconfigs[idx].Source.Path == "" {
+			bootconfigs[idx].Source.Path = cfgpath
+		}
+	}
+	return bootconfigs
+}
+
+// parseGrubFile parses a grub config with the given initial variables, line by
+// line as it is read from r, without buffering r whole. The config files found
+// by Scan, and the ones they include, are still read whole first, since they
+// are measured, verified and normalized before being parsed. tracef, if not
+// nil, traces the lines that follow a `set debug=` directive. inc tracks the
+// included config files. It only fails if r does.
+func parseGrubFile(r io.Reader, grubVersion int, resolver Resolver, vars map[string]string, tracef func(string, ...interface{}), inc grubIncludes) ([]bootconfig.BootConfig, error) {
+	// This parser sucks. It's not even a parser, it just looks for lines
+	// starting with menuentry, linux or initrd.
+	// TODO use a parser, e.g. https://github.com/alecthomas/participle
+	bootconfigs := make([]bootconfig.BootConfig, 0)
+	inMenuEntry := false
+	var (
+		cfg            *bootconfig.BootConfig
+		kernel, initrd string
+	)
+	// exported variables are passed on to included config files
+	exported := make(map[string]bool)
+	var serial grubSerial
+	// nesting level of the top-level `if` blocks, whose conditions are not
+	// evaluated, and whether one of them may set superusers
+	var (
+		conditional           int
+		conditionalSuperusers bool
+	)
+	// menu index of each boot config, used for `default` and `fallback`
+	var (
+		indices   []int
+		menuIndex = -1
+	)
+	// trace is a no-op until the config asks for debugging, like GRUB does
+	// with `set debug=all` or specific facilities
+	trace := func(format string, v ...interface{}) {
+		if tracef != nil && vars["debug"] != "" {
+			tracef(format, v...)
+		}
+	}
+	// save the current boot config, if any. Paths are resolved only now,
+	// because they may depend on the kernel command line
+	save := func() {
+		if cfg == nil {
+			return
+		}
+		if kernel != "" {
+			cfg.Kernel, cfg.Subvolume = resolver.Resolve(kernel, cfg.KernelArgs, vars)
+		}
+		if initrd != "" {
+			cfg.Initramfs, _ = resolver.Resolve(initrd, cfg.KernelArgs, vars)
+		}
+		if cfg.IsValid() {
+			// only consider valid boot configs, i.e. the ones that have
+			// both kernel and initramfs
+			trace("menuentry %q: kernel=%s initramfs=%s cmdline=%q", cfg.Name, cfg.Kernel, cfg.Initramfs, cfg.KernelArgs)
+			bootconfigs = append(bootconfigs, *cfg)
+			indices = append(indices, menuIndex)
+		} else {
+			trace("menuentry %q: skipped, no kernel or action", cfg.Name)
+		}
+	}
+	lines := bufio.NewReader(r)
+	for lineno, eof := 0, false; !eof; lineno++ {
+		line, err := lines.ReadString('\n')
+		if err == io.EOF {
+			eof = true
+		} else if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		// remove all leading spaces and tabs as they are not relevant for
+		// the config line
+		line = strings.TrimLeft(line, " \t")
+		sline := strings.Fields(line)
+		if len(sline) == 0 {
+			continue
+		}
+		if grubVersion == 2 && expandsVars(sline[0]) {
+			line = expandVars(line, vars)
+			sline = grubFields(line)
+			if len(sline) == 0 {
+				continue
+			}
+		}
+		trace("line %d: %s", lineno+1, line)
+		if sline[0] == "menuentry" {
+			// if a "menuentry", start a new boot config
+			save()
+			inMenuEntry = true
+			cfg = &bootconfig.BootConfig{
+				Name:      menuEntryTitle(line),
+				Source:    &bootconfig.Source{Line: lineno + 1},
+				Protected: menuEntryProtected(sline, vars["superusers"] != ""),
+			}
+			if !cfg.Protected && conditionalSuperusers && menuEntryProtected(sline, true) {
+				// GRUB may ask for a password, so err on the safe side
+				cfg.Protected = true
+				cfg.AddUnsupported(bootconfig.FeatureConditionalSuperusers)
+			}
+			kernel, initrd = "", ""
+			menuIndex++
+		} else if sline[0] == "loopback" {
+			parseLoopback(sline, vars)
+		} else if sline[0] == "export" && !inMenuEntry {
+			for _, name := range sline[1:] {
+				exported[name] = true
+			}
+		} else if (sline[0] == "source" || sline[0] == "configfile" || sline[0] == "normal") && len(sline) > 1 && !inMenuEntry {
+			for _, included := range parseGrubInclude(sline[1], grubVersion, resolver, vars, exported, tracef, inc) {
+				menuIndex++
+				bootconfigs = append(bootconfigs, included)
+				indices = append(indices, menuIndex)
+			}
+		} else if sline[0] == "}" && inMenuEntry {
+			// end of the menuentry
+			save()
+			cfg = nil
+			inMenuEntry = false
+		} else if sline[0] == "if" && sline[len(sline)-1] != "fi" && !inMenuEntry {
+			conditional++
+		} else if sline[0] == "fi" && conditional > 0 && !inMenuEntry {
+			conditional--
+		} else if sline[0] == "set" && len(sline) > 1 && !inMenuEntry {
+			// only top-level variables are tracked for now
+			kv := strings.SplitN(argsAfterFields(line, 1), "=", 2)
+			if len(kv) == 2 && kv[0] == "superusers" && conditional > 0 {
+				// the condition is unknown: superusers set in an `if`
+				// block may protect the menu entries, and an empty one
+				// does not lift an unconditional protection
+				conditionalSuperusers = conditionalSuperusers || strings.Trim(kv[1], `"'`) != ""
+			} else if len(kv) == 2 {
+				vars[kv[0]] = strings.Trim(kv[1], `"'`)
+			}
+		} else if !inMenuEntry && serial.parse(sline) {
+			continue
+		} else if inMenuEntry && (sline[0] == "halt" || sline[0] == "reboot" || sline[0] == "poweroff") {
+			// e.g. the Reboot and Shutdown entries of a menu
+			cfg.Action = sline[0]
+		} else if inMenuEntry {
+			// otherwise look for kernel and initramfs configuration
+			if len(sline) < 2 {
+				// surely not a valid linux or initrd directive, skip it
+				continue
+			}
+			isLinux := sline[0] == "linux" || sline[0] == "linux16" || sline[0] == "linuxefi"
+			isInitrd := sline[0] == "initrd" || sline[0] == "initrd16" || sline[0] == "initrdefi"
+			if grubVersion == 2 && (isLinux || isInitrd) && hasCommandSubst(line) {
+				// kept verbatim, as systemboot cannot run the command
+				cfg.AddUnsupported(bootconfig.FeatureCommandSubst)
+			}
+			switch sline[0] {
+			case "linuxefi", "initrdefi":
+				cfg.Firmware = bootconfig.FirmwareUEFI
+			case "linux16", "initrd16":
+				cfg.Firmware = bootconfig.FirmwareBIOS
+			}
+			if isLinux {
+				kernel = sline[1]
+				// keep the command line verbatim, including anything after a
+				// `--` separator, which is passed on to init
+				cmdline := argsAfterFields(line, 2)
+				if grubVersion == 2 {
+					// if grub2, unquote the string, as directives could be quoted
+					// https://www.gnu.org/software/grub/manual/grub/grub.html#Quoting
+					// TODO unquote everything, not just \$
+					cmdline = strings.Replace(cmdline, `\$`, "$", -1)
+				}
+				cfg.KernelArgs = cmdline
+			} else if isInitrd {
+				initrd = sline[1]
+			}
+		}
+	}
+	// append last kernel config if it wasn't already
+	if inMenuEntry {
+		save()
+	}
+	if console := serial.console(); console != "" {
+		for idx := range bootconfigs {
+			bootconfigs[idx].KexecConsole = console
+		}
+	}
+	return orderByDefault(bootconfigs, indices, vars["default"], vars["fallback"]), nil
+}
+
+// grubSerial tracks the serial terminal settings of a grub config.
+type grubSerial struct {
+	unit, speed string
+	enabled     bool
+}
+
+// parse handles the `serial` and `terminal_output` (or grub legacy `terminal`)
+// directives, and returns false for any other directive.
+func (s *grubSerial) parse(sline []string) bool {
+	switch sline[0] {
+	case "serial":
+		for _, arg := range sline[1:] {
+			if strings.HasPrefix(arg, "--unit=") {
+				s.unit = strings.TrimPrefix(arg, "--unit=")
+			} else if strings.HasPrefix(arg, "--speed=") {
+				s.speed = strings.TrimPrefix(arg, "--speed=")
+			}
+		}
+	case "terminal_output", "terminal":
+		s.enabled = false
+		for _, arg := range sline[1:] {
+			if arg == "serial" || strings.HasPrefix(arg, "serial_") {
+				s.enabled = true
+			}
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// console returns the kexec console matching the serial terminal, e.g.
+// `ttyS0,115200`, or an empty string if grub doesn't output to a serial port.
+// The defaults are the ones of grub: unit 0, 9600 bauds.
+func (s *grubSerial) console() string {
+	if !s.enabled {
+		return ""
+	}
+	unit, speed := s.unit, s.speed
+	if unit == "" {
+		unit = "0"
+	}
+	if speed == "" {
+		speed = "9600"
+	}
+	return fmt.Sprintf("ttyS%s,%s", unit, speed)
+}
+
+// menuEntryTitle returns the title of a menuentry line, e.g. `Linux` for
+// `menuentry 'Linux' --class os {`.
+func menuEntryTitle(line string) string {
+	rest := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "menuentry"))
+	if rest == "" {
+		return ""
+	}
+	if quote := rest[0]; quote == '\'' || quote == '"' {
+		if end := strings.IndexByte(rest[1:], quote); end != -1 {
+			return rest[1 : end+1]
+		}
+	}
+	return strings.Fields(rest)[0]
+}
+
+// menuEntryProtected returns true if booting the menuentry requires a
+// password: it is restricted to some `--users`, or there are superusers and
+// it is not `--unrestricted`.
+func menuEntryProtected(sline []string, superusers bool) bool {
+	for _, arg := range sline[1:] {
+		if arg == "--unrestricted" {
+			return false
+		}
+		if arg == "--users" || strings.HasPrefix(arg, "--users=") {
+			return true
+		}
+	}
+	return superusers
+}
+
+// orderByDefault moves the default boot config first, followed by the
+// fallback ones, so they are tried in the order intended by the config file.
+// indices are the menu indices of the boot configs. defaultEntry is the value
+// of the `default` setting, a menu index or title, and fallback is the value of
+// the `fallback` setting, a space-separated list of them. Entries that don't
+// match anything, like `saved`, are ignored.
+func orderByDefault(bootconfigs []bootconfig.BootConfig, indices []int, defaultEntry, fallback string) []bootconfig.BootConfig {
+	specs := append([]string{defaultEntry}, strings.Fields(fallback)...)
+	ordered := make([]bootconfig.BootConfig, 0, len(bootconfigs))
+	used := make([]bool, len(bootconfigs))
+	for _, spec := range specs {
+		if spec == "" {
+			continue
+		}
+		for idx, cfg := range bootconfigs {
+			if used[idx] {
+				continue
+			}
+			if strconv.Itoa(indices[idx]) == spec || (cfg.Name != "" && cfg.Name == spec) {
+				ordered = append(ordered, cfg)
+				used[idx] = true
+				break
+			}
+		}
+	}
+	for idx, cfg := range bootconfigs {
+		if !used[idx] {
+			ordered = append(ordered, cfg)
+		}
+	}
+	return ordered
+}
+
+// expandsVars returns true if the variables of a grub2 directive are expanded
+// before parsing it.
+func expandsVars(directive string) bool {
+	switch directive {
+	case "set", "linux", "linux16", "linuxefi", "initrd", "initrd16", "initrdefi", "loopback", "source", "configfile", "normal":
+		return true
+	}
+	return false
+}
+
+// argsAfterFields returns what follows the first n fields of line, as split by
+// grubFields, with surrounding whitespace removed but otherwise untouched.
+func argsAfterFields(line string, n int) string {
+	rest := line
+	for i := 0; i < n; i++ {
+		rest = strings.TrimLeft(rest, " \t")
+		fields := grubFields(rest)
+		if len(fields) < 2 {
+			return ""
+		}
+		rest = rest[len(fields[0]):]
+	}
+	return strings.TrimSpace(rest)
+}
@@ -0,0 +1,378 @@
+package bootscan
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/require"
+	"github.com/systemboot/systemboot/pkg/bootconfig"
+)
+
+func TestParseGrub(t *testing.T) {
+	cfgs, err := ParseGrub(strings.NewReader(sampleGrubCfg), 2, BasedirResolver("/mnt/sda1"))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(cfgs))
+	require.Equal(t, "/mnt/sda1/boot/vmlinuz", cfgs[0].Kernel)
+	require.Equal(t, "/mnt/sda1/boot/initrd.img", cfgs[0].Initramfs)
+	require.Equal(t, "root=/dev/sda1", cfgs[0].KernelArgs)
+}
+
+func TestParseGrubInvalidVersion(t *testing.T) {
+	_, err := ParseGrub(strings.NewReader(sampleGrubCfg), 3, BasedirResolver("/"))
+	require.Error(t, err)
+}
+
+func TestParseGrubCustomResolver(t *testing.T) {
+	resolver := ResolverFunc(func(p, cmdline string, vars map[string]string) (string, string) {
+		return "/dev/disk/by-label/boot" + p, ""
+	})
+	cfgs, err := ParseGrub(strings.NewReader(sampleGrubCfg), 1, resolver)
+	require.NoError(t, err)
+	require.Equal(t, "/dev/disk/by-label/boot/boot/vmlinuz", cfgs[0].Kernel)
+}
+
+func TestParseGrubKeepsInitArgs(t *testing.T) {
+	grubcfg := `
+menuentry 'Linux' {
+	linux /vmlinuz root=/dev/sda1 \$foo -- single
+}
+`
+	cfgs, err := ParseGrub(strings.NewReader(grubcfg), 2, BasedirResolver("/"))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(cfgs))
+	require.Equal(t, "root=/dev/sda1 $foo -- single", cfgs[0].KernelArgs)
+}
+
+func TestParseGrubTabIndented(t *testing.T) {
+	grubcfg := "menuentry 'Linux' {\n" +
+		"\tlinux\t/vmlinuz root=/dev/sda1 ro\n" +
+		" \t initrd\t/initrd.img\n" +
+		"\t}\n"
+	for _, version := range []int{1, 2} {
+		cfgs, err := ParseGrub(strings.NewReader(grubcfg), version, BasedirResolver("/mnt/sda1"))
+		require.NoError(t, err)
+		require.Equal(t, 1, len(cfgs), "GRUB %d", version)
+		require.Equal(t, "Linux", cfgs[0].Name)
+		require.Equal(t, "/mnt/sda1/vmlinuz", cfgs[0].Kernel)
+		require.Equal(t, "/mnt/sda1/initrd.img", cfgs[0].Initramfs)
+		require.Equal(t, "root=/dev/sda1 ro", cfgs[0].KernelArgs)
+	}
+}
+
+func TestParseGrubDefault(t *testing.T) {
+	grubcfg := `
+set default="2"
+set fallback="1 0"
+menuentry 'Linux' --class os {
+	linux /vmlinuz root=/dev/sda1
+}
+menuentry "Linux recovery" {
+	linux /vmlinuz root=/dev/sda1 single
+}
+menuentry 'Linux new' {
+	linux /vmlinuz-new root=/dev/sda1
+}
+`
+	cfgs, err := ParseGrub(strings.NewReader(grubcfg), 2, BasedirResolver("/"))
+	require.NoError(t, err)
+	require.Equal(t, 3, len(cfgs))
+	require.Equal(t, "Linux new", cfgs[0].Name)
+	require.Equal(t, "Linux recovery", cfgs[1].Name)
+	require.Equal(t, "Linux", cfgs[2].Name)
+}
+
+func TestParseGrubSerialConsole(t *testing.T) {
+	grubcfg := `
+serial --unit=1 --speed=115200 --word=8 --parity=no --stop=1
+terminal_input serial console
+terminal_output serial console
+` + sampleGrubCfg
+	cfgs, err := ParseGrub(strings.NewReader(grubcfg), 2, BasedirResolver("/"))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(cfgs))
+	require.Equal(t, "ttyS1,115200", cfgs[0].KexecConsole)
+
+	// no serial terminal, no kexec console
+	cfgs, err = ParseGrub(strings.NewReader("serial --unit=1 --speed=115200\nterminal_output console\n"+sampleGrubCfg), 2, BasedirResolver("/"))
+	require.NoError(t, err)
+	require.Equal(t, "", cfgs[0].KexecConsole)
+
+	// grub legacy, with the default unit and speed
+	cfgs, err = ParseGrub(strings.NewReader("terminal --timeout=5 serial console\n"+sampleGrubCfg), 1, BasedirResolver("/"))
+	require.NoError(t, err)
+	require.Equal(t, "ttyS0,9600", cfgs[0].KexecConsole)
+}
+
+func TestScanGrubSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localboot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeTestFile(t, dir, "boot/grub2/grub.cfg", `set timeout=5
+
+menuentry 'Linux' {
+	linux /vmlinuz root=/dev/sda1
+}
+menuentry 'Linux recovery' {
+	linux /vmlinuz root=/dev/sda1 single
+}
+`)
+
+	entries := Scan(dir, Options{})
+	SetDevice(entries, "/dev/sda1")
+	require.Equal(t, 2, len(entries))
+	cfgpath := path.Join(dir, "boot/grub2/grub.cfg")
+	require.Equal(t, &bootconfig.Source{Path: cfgpath, Device: "/dev/sda1", Line: 3}, entries[0].Source)
+	require.Equal(t, &bootconfig.Source{Path: cfgpath, Device: "/dev/sda1", Line: 6}, entries[1].Source)
+	require.Equal(t, "/dev/sda1:"+cfgpath+":6", entries[1].Source.String())
+}
+
+func TestParseGrubExportedVariables(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localboot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeTestFile(t, dir, "boot/grub/custom.cfg", `
+menuentry 'Custom' {
+	linux /vmlinuz-$kver root=/dev/sda1 $extra
+}
+`)
+	grubcfg := `
+set kver=4.19
+export kver
+set extra=quiet
+menuentry 'Linux' {
+	linux /vmlinuz root=/dev/sda1 $extra
+}
+source /boot/grub/custom.cfg
+`
+	cfgs, err := ParseGrub(strings.NewReader(grubcfg), 2, BasedirResolver(dir))
+	require.NoError(t, err)
+	require.Equal(t, 2, len(cfgs))
+	require.Equal(t, "root=/dev/sda1 quiet", cfgs[0].KernelArgs)
+	// kver is exported to the included file, extra is not and is left
+	// unexpanded
+	require.Equal(t, "Custom", cfgs[1].Name)
+	require.Equal(t, path.Join(dir, "vmlinuz-4.19"), cfgs[1].Kernel)
+	require.Equal(t, "root=/dev/sda1 $extra", cfgs[1].KernelArgs)
+	require.Equal(t, path.Join(dir, "boot/grub/custom.cfg"), cfgs[1].Source.Path)
+	require.Equal(t, 2, cfgs[1].Source.Line)
+}
+
+func TestScanGrubMeasuresIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localboot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeTestFile(t, dir, "boot/grub/custom.cfg", `
+menuentry 'Custom' {
+	linux /vmlinuz-custom root=/dev/sda1
+}
+`)
+	writeTestFile(t, dir, "boot/grub2/grub.cfg", `
+menuentry 'Linux' {
+	linux /vmlinuz root=/dev/sda1
+}
+source /boot/grub/custom.cfg
+`)
+	var measured []string
+	opts := Options{Measure: func(cfgpath string, data []byte) {
+		measured = append(measured, cfgpath)
+	}}
+	entries := Scan(dir, opts)
+	require.Equal(t, 2, len(entries))
+	require.Equal(t, []string{path.Join(dir, "boot/grub2/grub.cfg"), path.Join(dir, "boot/grub/custom.cfg")}, measured)
+}
+
+func TestParseGrubNormal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localboot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	// the included config loops back on itself, which must terminate
+	// without parsing it again
+	writeTestFile(t, dir, "boot/grub/real.cfg", `
+menuentry 'Real' {
+	linux /vmlinuz root=/dev/sda1
+}
+normal /boot/grub/real.cfg
+`)
+	grubcfg := `
+set prefix=/boot/grub
+normal $prefix/real.cfg
+`
+	cfgs, err := ParseGrub(strings.NewReader(grubcfg), 2, BasedirResolver(dir))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(cfgs))
+	require.Equal(t, "Real", cfgs[0].Name)
+	require.Equal(t, path.Join(dir, "vmlinuz"), cfgs[0].Kernel)
+	require.Equal(t, path.Join(dir, "boot/grub/real.cfg"), cfgs[0].Source.Path)
+	require.Equal(t, 2, cfgs[0].Source.Line)
+
+	// same with a scanned config that includes itself
+	writeTestFile(t, dir, "boot/grub2/grub.cfg", `
+menuentry 'Self' {
+	linux /vmlinuz root=/dev/sda1
+}
+configfile /boot/grub2/grub.cfg
+`)
+	entries := Scan(dir, Options{})
+	require.Equal(t, 1, len(entries))
+	require.Equal(t, "Self", entries[0].Name)
+}
+
+func TestParseGrubDebugTrace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localboot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeTestFile(t, dir, "boot/grub2/grub.cfg", `set timeout=5
+menuentry 'Quiet' {
+	linux /vmlinuz root=/dev/sda1
+}
+set debug=all
+set kver=4.19
+menuentry 'Traced' {
+	linux /vmlinuz-$kver root=/dev/sda1
+}
+`)
+	var traces []string
+	tracef := func(format string, v ...interface{}) {
+		traces = append(traces, fmt.Sprintf(format, v...))
+	}
+
+	entries := Scan(dir, Options{Tracef: tracef})
+	require.Equal(t, 2, len(entries))
+	cfgpath := path.Join(dir, "boot/grub2/grub.cfg")
+	require.Equal(t, []string{
+		cfgpath + ": line 6: set kver=4.19",
+		cfgpath + ": line 7: menuentry 'Traced' {",
+		cfgpath + ": line 8: linux /vmlinuz-4.19 root=/dev/sda1",
+		cfgpath + ": line 9: }",
+		fmt.Sprintf("%s: menuentry \"Traced\": kernel=%s initramfs= cmdline=\"root=/dev/sda1\"", cfgpath, path.Join(dir, "vmlinuz-4.19")),
+	}, traces)
+
+	// nothing is traced without `set debug=`
+	traces = nil
+	_, err = ParseGrubTraced(strings.NewReader(sampleGrubCfg), 2, BasedirResolver(dir), tracef)
+	require.NoError(t, err)
+	require.Empty(t, traces)
+}
+
+var sampleGrubCfgActions = `
+menuentry 'Linux' {
+	linux /boot/vmlinuz root=/dev/sda1
+}
+menuentry 'Reboot' {
+	reboot
+}
+menuentry 'Shutdown' --class shutdown {
+	halt
+}
+menuentry 'Nothing' {
+	echo "no kernel, no action"
+}
+`
+
+func TestParseGrubActions(t *testing.T) {
+	cfgs, err := ParseGrub(strings.NewReader(sampleGrubCfgActions), 2, BasedirResolver("/mnt/sda1"))
+	require.NoError(t, err)
+	require.Equal(t, 3, len(cfgs))
+	require.Equal(t, "", cfgs[0].Action)
+	require.Equal(t, "Reboot", cfgs[1].Name)
+	require.Equal(t, bootconfig.ActionReboot, cfgs[1].Action)
+	require.Equal(t, "", cfgs[1].Kernel)
+	require.Equal(t, "Shutdown", cfgs[2].Name)
+	require.Equal(t, bootconfig.ActionHalt, cfgs[2].Action)
+}
+
+func TestParseGrubProtected(t *testing.T) {
+	grubcfg := `
+menuentry 'Linux' --users alice {
+	linux /vmlinuz
+}
+menuentry 'Linux open' {
+	linux /vmlinuz
+}
+set superusers="root"
+menuentry 'Linux root only' {
+	linux /vmlinuz single
+}
+menuentry 'Linux unrestricted' --unrestricted {
+	linux /vmlinuz quiet
+}
+`
+	cfgs, err := ParseGrub(strings.NewReader(grubcfg), 2, BasedirResolver("/"))
+	require.NoError(t, err)
+	require.Equal(t, 4, len(cfgs))
+	require.Equal(t, []bool{true, false, true, false}, []bool{cfgs[0].Protected, cfgs[1].Protected, cfgs[2].Protected, cfgs[3].Protected})
+}
+
+func TestParseGrubConditionalSuperusers(t *testing.T) {
+	grubcfg := `
+menuentry 'Linux open' {
+	linux /vmlinuz
+}
+if [ -f ${prefix}/locked ]; then
+	set superusers="root"
+else
+	set superusers=""
+fi
+menuentry 'Linux maybe protected' {
+	if [ x$grub_platform = xefi ]; then insmod efi_gop; fi
+	linux /vmlinuz single
+}
+menuentry 'Linux unrestricted' --unrestricted {
+	linux /vmlinuz quiet
+}
+`
+	cfgs, err := ParseGrub(strings.NewReader(grubcfg), 2, BasedirResolver("/"))
+	require.NoError(t, err)
+	require.Equal(t, 3, len(cfgs))
+	require.Equal(t, []bool{false, true, false}, []bool{cfgs[0].Protected, cfgs[1].Protected, cfgs[2].Protected})
+	require.Equal(t, []string{bootconfig.FeatureConditionalSuperusers}, cfgs[1].Unsupported)
+	require.Empty(t, cfgs[2].Unsupported)
+}
+
+func TestParseGrubStreamed(t *testing.T) {
+	var grubcfg strings.Builder
+	grubcfg.WriteString("set pager=1\nset default=\"Linux 999\"\n")
+	for idx := 0; idx < 1000; idx++ {
+		fmt.Fprintf(&grubcfg, "menuentry 'Linux %d' {\n\tlinux /boot/vmlinuz-%d root=/dev/sda1 %s\n\tinitrd /boot/initrd-%d.img\n}\n", idx, idx, strings.Repeat("x", idx), idx)
+	}
+	// the config is read a few bytes at a time, like from a slow network
+	cfgs, err := ParseGrub(iotest.HalfReader(iotest.OneByteReader(strings.NewReader(grubcfg.String()))), 2, BasedirResolver("/mnt/sda1"))
+	require.NoError(t, err)
+	require.Equal(t, parseGrubCfg(grubcfg.String(), 2, BasedirResolver("/mnt/sda1")), cfgs)
+	require.Len(t, cfgs, 1000)
+	require.Equal(t, "Linux 999", cfgs[0].Name)
+	require.Equal(t, "/mnt/sda1/boot/vmlinuz-999", cfgs[0].Kernel)
+	require.Equal(t, 3999, cfgs[0].Source.Line)
+	require.Equal(t, "root=/dev/sda1 "+strings.Repeat("x", 999), cfgs[0].KernelArgs)
+
+	// a read error is not taken for the end of the config
+	_, err = ParseGrub(iotest.TimeoutReader(iotest.OneByteReader(strings.NewReader(grubcfg.String()))), 2, BasedirResolver("/mnt/sda1"))
+	require.Equal(t, iotest.ErrTimeout, err)
+}
+
+func TestParseGrubFirmware(t *testing.T) {
+	grubcfg := `
+menuentry 'Live (UEFI)' {
+	linuxefi /casper/vmlinuz boot=casper
+	initrdefi /casper/initrd
+}
+menuentry 'Live (BIOS)' {
+	linux16 /casper/vmlinuz boot=casper
+	initrd16 /casper/initrd
+}
+menuentry 'Live' {
+	linux /casper/vmlinuz boot=casper
+	initrd /casper/initrd
+}
+`
+	cfgs := parseGrubCfg(grubcfg, 2, BasedirResolver("/mnt/sdb1"))
+	require.Len(t, cfgs, 3)
+	require.Equal(t, bootconfig.FirmwareUEFI, cfgs[0].Firmware)
+	require.Equal(t, bootconfig.FirmwareBIOS, cfgs[1].Firmware)
+	require.Equal(t, "", cfgs[2].Firmware)
+}
@@ -0,0 +1,121 @@
+package bootscan
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/systemboot/systemboot/pkg/safemode"
+)
+
+// The GRUB environment block, e.g. boot/grub/grubenv, as read and written by
+// grub-editenv and the load_env and save_env commands.
+const (
+	GrubEnvSize   = 1024
+	grubEnvHeader = "# GRUB Environment Block\n"
+)
+
+// ParseGrubEnv parses a GRUB environment block into its variables. A newline
+// or a backslash in a value is escaped with a backslash.
+func ParseGrubEnv(data []byte) (map[string]string, error) {
+	if !bytes.HasPrefix(data, []byte(grubEnvHeader)) {
+		return nil, errors.New("not a GRUB environment block")
+	}
+	vars := make(map[string]string)
+	rest := string(data[len(grubEnvHeader):])
+	for rest != "" && rest[0] != '#' {
+		// find the end of the line, skipping escaped newlines
+		var line strings.Builder
+		idx := 0
+		for ; idx < len(rest) && rest[idx] != '\n'; idx++ {
+			if rest[idx] == '\\' && idx+1 < len(rest) {
+				idx++
+			}
+			line.WriteByte(rest[idx])
+		}
+		if idx == len(rest) {
+			return nil, errors.New("unterminated variable in GRUB environment block")
+		}
+		rest = rest[idx+1:]
+		kv := strings.SplitN(line.String(), "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid variable %q in GRUB environment block", line.String())
+		}
+		vars[kv[0]] = kv[1]
+	}
+	return vars, nil
+}
+
+// ReadGrubEnv reads and parses a GRUB environment block file.
+func ReadGrubEnv(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseGrubEnv(data)
+}
+
+// escapeGrubEnv escapes backslashes and newlines in a GRUB environment value.
+func escapeGrubEnv(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", "\\\n").Replace(value)
+}
+
+// FormatGrubEnv returns the GRUB environment block of vars: the header line,
+// the variables sorted by name, and `#` padding up to GrubEnvSize bytes. It
+// fails if they do not fit.
+func FormatGrubEnv(vars map[string]string) ([]byte, error) {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		if name == "" || strings.ContainsAny(name, "=\n\\") {
+			return nil, fmt.Errorf("invalid GRUB environment variable name %q", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	buf.WriteString(grubEnvHeader)
+	for _, name := range names {
+		buf.WriteString(name + "=" + escapeGrubEnv(vars[name]) + "\n")
+	}
+	if buf.Len() > GrubEnvSize {
+		return nil, fmt.Errorf("the GRUB environment variables take %d bytes, more than the %d bytes of the block", buf.Len(), GrubEnvSize)
+	}
+	buf.Write(bytes.Repeat([]byte("#"), GrubEnvSize-buf.Len()))
+	return buf.Bytes(), nil
+}
+
+// WriteGrubEnv writes vars as the GRUB environment block at path, see
+// FormatGrubEnv. The block is overwritten in place, since GRUB itself writes
+// to the disk blocks of the file, which must not move. Writing the same
+// variables again leaves the file unchanged. It is refused in safe mode.
+func WriteGrubEnv(path string, vars map[string]string) error {
+	if err := safemode.Check("write the GRUB environment " + path); err != nil {
+		return err
+	}
+	data, err := FormatGrubEnv(vars)
+	if err != nil {
+		return err
+	}
+	fd, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := fd.WriteAt(data, 0); err != nil {
+		fd.Close()
+		return err
+	}
+	// drop anything past the block, e.g. from a corrupted file
+	if err := fd.Truncate(GrubEnvSize); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Sync(); err != nil {
+		fd.Close()
+		return err
+	}
+	return fd.Close()
+}
@@ -0,0 +1,74 @@
+package bootscan
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/systemboot/systemboot/pkg/safemode"
+)
+
+func TestWriteGrubEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grubenv")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	grubenv := path.Join(dir, "grubenv")
+	// a longer, corrupted block is fixed
+	require.NoError(t, ioutil.WriteFile(grubenv, []byte(grubEnvHeader+strings.Repeat("#", 2000)), 0644))
+
+	vars := map[string]string{
+		"saved_entry": "Ubuntu, with Linux 5.15.0-91-generic",
+		"boot_tries":  "2",
+		"note":        "a\\b\nc",
+	}
+	require.NoError(t, WriteGrubEnv(grubenv, vars))
+	data, err := ioutil.ReadFile(grubenv)
+	require.NoError(t, err)
+	require.Equal(t, GrubEnvSize, len(data))
+	// the rest of the block is padding
+	require.Equal(t, grubEnvHeader+"boot_tries=2\nnote=a\\\\b\\\nc\nsaved_entry=Ubuntu, with Linux 5.15.0-91-generic\n", strings.TrimRight(string(data), "#"))
+
+	parsed, err := ReadGrubEnv(grubenv)
+	require.NoError(t, err)
+	require.Equal(t, vars, parsed)
+
+	// writing the same variables again does not change the block
+	require.NoError(t, WriteGrubEnv(grubenv, parsed))
+	again, err := ioutil.ReadFile(grubenv)
+	require.NoError(t, err)
+	require.Equal(t, data, again)
+}
+
+func TestWriteGrubEnvTooLarge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grubenv")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	grubenv := path.Join(dir, "grubenv")
+	require.NoError(t, WriteGrubEnv(grubenv, map[string]string{"saved_entry": "0"}))
+
+	err = WriteGrubEnv(grubenv, map[string]string{"saved_entry": strings.Repeat("x", GrubEnvSize)})
+	require.Error(t, err)
+	require.Error(t, WriteGrubEnv(grubenv, map[string]string{"a=b": "c"}))
+
+	safemode.Enable()
+	defer safemode.Disable()
+	err = WriteGrubEnv(grubenv, map[string]string{"saved_entry": "1"})
+	require.IsType(t, &safemode.Error{}, err)
+	// the block is left alone
+	vars, err := ReadGrubEnv(grubenv)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"saved_entry": "0"}, vars)
+}
+
+func TestParseGrubEnvInvalid(t *testing.T) {
+	_, err := ParseGrubEnv([]byte("saved_entry=0\n"))
+	require.Error(t, err)
+	_, err = ParseGrubEnv([]byte(grubEnvHeader + "saved_entry"))
+	require.Error(t, err)
+	vars, err := ParseGrubEnv([]byte(grubEnvHeader + "###"))
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{}, vars)
+}
@@ -0,0 +1,78 @@
+package bootscan
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/systemboot/systemboot/pkg/menu"
+)
+
+// GrubMenuSettings returns the menu settings of a grub config, from its
+// top-level `timeout` and `timeout_style` variables, starting from defaults
+// for the ones that are not set. Like for the other variables, the conditions
+// around them are ignored, and the last assignment wins. A negative timeout
+// waits forever, and an unknown timeout style is ignored. The `superusers`
+// variable and the `password` and `password_pbkdf2` commands set the users
+// allowed to edit the entries. Since the files read with `source`, e.g. the
+// user.cfg where grub2-setpassword stores the password hash, and the
+// variables exported by another config are not followed, nobody is allowed
+// to edit the entries of a config that sources a file, or whose superusers
+// refer to a variable.
+func GrubMenuSettings(grubcfg string, defaults menu.Settings) menu.Settings {
+	settings := defaults
+	inMenuEntry := false
+	var superusers *menu.Superusers
+	passwords := make(map[string]menu.Password)
+	unresolved := false
+	for _, line := range strings.Split(grubcfg, "\n") {
+		line = strings.TrimLeft(line, " \t")
+		sline := strings.Fields(line)
+		if len(sline) == 0 {
+			continue
+		}
+		switch {
+		case sline[0] == "menuentry" || sline[0] == "submenu":
+			inMenuEntry = true
+		case sline[0] == "}":
+			inMenuEntry = false
+		case (sline[0] == "password" || sline[0] == "password_pbkdf2") && len(sline) == 3 && !inMenuEntry:
+			passwords[sline[1]] = menu.Password{Value: sline[2], PBKDF2: sline[0] == "password_pbkdf2"}
+		case sline[0] == "source" && !inMenuEntry:
+			unresolved = true
+		case sline[0] == "set" && len(sline) > 1 && !inMenuEntry:
+			kv := strings.SplitN(argsAfterFields(line, 1), "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			value := strings.Trim(kv[1], `"'`)
+			switch kv[0] {
+			case "timeout":
+				if seconds, err := strconv.Atoi(value); err == nil {
+					settings.Timeout = time.Duration(seconds) * time.Second
+				}
+			case "timeout_style":
+				if menu.IsStyle(value) {
+					settings.Style = value
+				}
+			case "superusers":
+				superusers = &menu.Superusers{Users: strings.FieldsFunc(value, isSuperusersSeparator)}
+				unresolved = unresolved || strings.Contains(value, "$")
+			}
+		}
+	}
+	if unresolved {
+		settings.Superusers = &menu.Superusers{}
+	} else if superusers != nil {
+		superusers.Passwords = passwords
+		settings.Superusers = superusers
+	}
+	return settings
+}
+
+// isSuperusersSeparator returns true for the separators of the user names of
+// the GRUB `superusers` variable: spaces, commas, semicolons, pipes and
+// ampersands.
+func isSuperusersSeparator(r rune) bool {
+	return strings.ContainsRune(" \t,;|&", r)
+}
@@ -0,0 +1,72 @@
+package bootscan
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/systemboot/systemboot/pkg/menu"
+)
+
+func TestGrubMenuSettings(t *testing.T) {
+	defaults := menu.Settings{Timeout: 5 * time.Second, Style: menu.StyleMenu}
+	// as written by grub-mkconfig with GRUB_TIMEOUT_STYLE=hidden
+	grubcfg := `
+if [ x$feature_timeout_style = xy ] ; then
+  set timeout_style=hidden
+  set timeout=3
+fi
+menuentry 'Linux' {
+	set timeout=10
+	linux /vmlinuz
+}
+`
+	require.Equal(t, menu.Settings{Timeout: 3 * time.Second, Style: menu.StyleHidden}, GrubMenuSettings(grubcfg, defaults))
+	require.Equal(t, menu.Settings{Timeout: -time.Second, Style: menu.StyleCountdown}, GrubMenuSettings("set timeout=-1\nset timeout_style=countdown\n", defaults))
+	// invalid values are ignored
+	require.Equal(t, defaults, GrubMenuSettings("set timeout=soon\nset timeout_style=blink\n", defaults))
+}
+
+func TestGrubMenuSuperusers(t *testing.T) {
+	grubcfg := `
+set superusers="root,admin"
+password root secret
+password_pbkdf2 admin grub.pbkdf2.sha512.10000.00.00
+menuentry 'Linux' {
+	password guest guest
+	linux /vmlinuz
+}
+`
+	settings := GrubMenuSettings(grubcfg, menu.Settings{})
+	require.Equal(t, &menu.Superusers{
+		Users: []string{"root", "admin"},
+		Passwords: map[string]menu.Password{
+			"root":  {Value: "secret"},
+			"admin": {Value: "grub.pbkdf2.sha512.10000.00.00", PBKDF2: true},
+		},
+	}, settings.Superusers)
+	// passwords without superusers do not restrict anything
+	require.Nil(t, GrubMenuSettings("password root secret\n", menu.Settings{}).Superusers)
+}
+
+func TestGrubMenuSuperusersUnresolved(t *testing.T) {
+	// as written by grub2-mkconfig on Fedora, where grub2-setpassword stores
+	// the password hash in user.cfg
+	grubcfg := `
+if [ -f ${prefix}/user.cfg ]; then
+  source ${prefix}/user.cfg
+  if [ -n "${GRUB2_PASSWORD}" ]; then
+    set superusers="root"
+    export superusers
+    password_pbkdf2 root ${GRUB2_PASSWORD}
+  fi
+fi
+`
+	settings := GrubMenuSettings(grubcfg, menu.Settings{})
+	require.Equal(t, &menu.Superusers{}, settings.Superusers)
+	require.False(t, settings.Superusers.Authenticate("root", "${GRUB2_PASSWORD}"))
+	// even without a password set
+	require.Equal(t, &menu.Superusers{}, GrubMenuSettings("source $prefix/custom.cfg\n", menu.Settings{}).Superusers)
+	// and with superusers set from a variable
+	require.Equal(t, &menu.Superusers{}, GrubMenuSettings("set superusers=$admins\npassword root secret\n", menu.Settings{}).Superusers)
+}
@@ -0,0 +1,71 @@
+package bootscan
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+
+	"github.com/systemboot/systemboot/pkg/crypto"
+)
+
+// GrubConfigSignatureExt is appended to the path of a grub config to get the
+// path of its detached signature, see crypto.VerifySignature.
+const GrubConfigSignatureExt = ".sig"
+
+// isGrubFormat returns true for the formats of grub configs, which can be
+// signed.
+func isGrubFormat(format *Format) bool {
+	return format.Name == "grub2" || format.Name == "grub"
+}
+
+// verifyGrubConfig checks the detached signature of the grub config at
+// cfgpath, whose content is data.
+func verifyGrubConfig(cfgpath string, data []byte, verifier crypto.Verifier) error {
+	signature, err := ioutil.ReadFile(cfgpath + GrubConfigSignatureExt)
+	if err != nil {
+		return fmt.Errorf("no signature: %v", err)
+	}
+	return crypto.VerifySignature(data, signature, verifier)
+}
+
+// signedGrubConfigs returns the content of the grub configs in the standard
+// locations under basedir that have a valid signature for
+// opts.GrubConfigKey, by path. It returns nil if there is no key or no such
+// config, in which case the unsigned configs are used. The config files a
+// signed config includes, e.g. with `source`, must be signed too, or they are
+// ignored.
+func signedGrubConfigs(basedir string, opts Options) map[string][]byte {
+	if opts.GrubConfigKey == nil {
+		return nil
+	}
+	var signed map[string][]byte
+	for idx := range Formats {
+		format := &Formats[idx]
+		if !isGrubFormat(format) || !opts.enabled(format.Name) {
+			continue
+		}
+		for _, cfgpath := range format.Paths {
+			cfgpath = path.Join(basedir, cfgpath)
+			signature, err := ioutil.ReadFile(cfgpath + GrubConfigSignatureExt)
+			if err != nil {
+				continue
+			}
+			data, err := ioutil.ReadFile(cfgpath)
+			if err != nil {
+				continue
+			}
+			if err := crypto.VerifySignature(data, signature, opts.GrubConfigKey); err != nil {
+				opts.logf("Invalid signature for %s: %v", cfgpath, err)
+				continue
+			}
+			if signed == nil {
+				signed = make(map[string][]byte)
+			}
+			signed[cfgpath] = data
+		}
+	}
+	if signed == nil {
+		opts.debugf("No signed grub config under %s, using the unsigned ones", basedir)
+	}
+	return signed
+}
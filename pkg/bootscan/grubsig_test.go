@@ -0,0 +1,76 @@
+package bootscan
+
+import (
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/systemboot/systemboot/pkg/crypto"
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestScanPrefersSignedGrubConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grubsig")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	pubkey, privkey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signedCfg := "menuentry 'Signed' {\n\tlinux /vmlinuz-signed\n\tinitrd /initrd-signed\n}\n"
+	unsignedCfg := "menuentry 'Unsigned' {\n\tlinux /vmlinuz-unsigned\n\tinitrd /initrd-unsigned\n}\n"
+	writeTestFile(t, dir, "boot/grub2/grub.cfg", unsignedCfg)
+	writeTestFile(t, dir, "grub2/grub.cfg", signedCfg)
+	writeTestFile(t, dir, "grub2/grub.cfg"+GrubConfigSignatureExt, string(ed25519.Sign(privkey, []byte(signedCfg))))
+
+	// without a key, both are used
+	require.Equal(t, 2, len(Scan(dir, Options{})))
+
+	var measured []string
+	opts := Options{
+		GrubConfigKey: &crypto.Ed25519Verifier{Key: pubkey},
+		Measure:       func(p string, data []byte) { measured = append(measured, p) },
+	}
+	entries := Scan(dir, opts)
+	require.Equal(t, 1, len(entries))
+	require.Equal(t, "Signed", entries[0].Name)
+	require.Equal(t, path.Join(dir, "vmlinuz-signed"), entries[0].Kernel)
+	require.Equal(t, []string{path.Join(dir, "grub2/grub.cfg")}, measured)
+
+	// an invalid signature is like no signature
+	writeTestFile(t, dir, "grub2/grub.cfg", signedCfg+"\n")
+	entries = Scan(dir, opts)
+	require.Equal(t, 2, len(entries))
+	require.Equal(t, "Unsigned", entries[0].Name)
+}
+
+func TestSignedGrubConfigIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grubsig")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	pubkey, privkey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signedCfg := "menuentry 'Signed' {\n\tlinux /vmlinuz-signed\n}\nsource /boot/grub2/signed.cfg\nsource /boot/grub2/unsigned.cfg\n"
+	includedCfg := "menuentry 'Included' {\n\tlinux /vmlinuz-included\n}\n"
+	writeTestFile(t, dir, "grub2/grub.cfg", signedCfg)
+	writeTestFile(t, dir, "grub2/grub.cfg"+GrubConfigSignatureExt, string(ed25519.Sign(privkey, []byte(signedCfg))))
+	writeTestFile(t, dir, "boot/grub2/signed.cfg", includedCfg)
+	writeTestFile(t, dir, "boot/grub2/signed.cfg"+GrubConfigSignatureExt, string(ed25519.Sign(privkey, []byte(includedCfg))))
+	writeTestFile(t, dir, "boot/grub2/unsigned.cfg", "menuentry 'Unsigned' {\n\tlinux /vmlinuz-unsigned\n}\n")
+
+	var measured []string
+	opts := Options{
+		GrubConfigKey: &crypto.Ed25519Verifier{Key: pubkey},
+		Measure:       func(p string, data []byte) { measured = append(measured, p) },
+	}
+	entries := Scan(dir, opts)
+	require.Equal(t, 2, len(entries))
+	require.Equal(t, "Signed", entries[0].Name)
+	require.Equal(t, "Included", entries[1].Name)
+	require.Equal(t, []string{path.Join(dir, "grub2/grub.cfg"), path.Join(dir, "boot/grub2/signed.cfg")}, measured)
+
+	// without a signed config, the includes are not checked
+	require.NoError(t, os.Remove(path.Join(dir, "grub2/grub.cfg"+GrubConfigSignatureExt)))
+	require.Equal(t, 3, len(Scan(dir, opts)))
+}
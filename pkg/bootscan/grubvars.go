@@ -0,0 +1,168 @@
+package bootscan
+
+import (
+	"strings"
+)
+
+// isVarNameChar returns true if c can be part of a grub variable name.
+func isVarNameChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// expandVar expands the content of a `${...}` expression. It supports
+// `name`, `name:-default` (default if name is unset or empty) and
+// `name:+alt` (alt if name is set and not empty). The second return value is
+// false if the expression is not supported, or refers to an unknown variable,
+// in which case it should be left untouched.
+func expandVar(expr string, vars map[string]string) (string, bool) {
+	idx := 0
+	for idx < len(expr) && isVarNameChar(expr[idx]) {
+		idx++
+	}
+	name, op := expr[:idx], expr[idx:]
+	if name == "" {
+		return "", false
+	}
+	value, ok := vars[name]
+	switch {
+	case op == "":
+		return value, ok
+	case strings.HasPrefix(op, ":-"):
+		if value == "" {
+			return op[2:], true
+		}
+		return value, true
+	case strings.HasPrefix(op, ":+"):
+		if value == "" {
+			return "", true
+		}
+		return op[2:], true
+	}
+	return "", false
+}
+
+// commandSubstEnd returns the index of the parenthesis closing the command
+// substitution `$(...)` starting at line[start], or -1 if it is not closed.
+func commandSubstEnd(line string, start int) int {
+	depth := 0
+	for i := start + 1; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// isCommandSubst returns true if line[i] starts a command substitution
+// `$(...)`, which GRUB scripts can use but systemboot cannot run.
+func isCommandSubst(line string, i int) bool {
+	return line[i] == '$' && i+1 < len(line) && line[i+1] == '('
+}
+
+// hasCommandSubst returns true if a grub2 config line has a command
+// substitution, outside single quotes and not escaped.
+func hasCommandSubst(line string) bool {
+	inQuotes := false
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\':
+			i++
+		case line[i] == '\'':
+			inQuotes = !inQuotes
+		case !inQuotes && isCommandSubst(line, i):
+			return true
+		}
+	}
+	return false
+}
+
+// grubFields splits a grub2 config line into fields like strings.Fields, but
+// keeps each command substitution `$(...)` whole, even if it has spaces.
+func grubFields(line string) []string {
+	var fields []string
+	start := -1
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if c == ' ' || c == '\t' {
+			if start != -1 {
+				fields = append(fields, line[start:i])
+				start = -1
+			}
+			continue
+		}
+		if start == -1 {
+			start = i
+		}
+		if isCommandSubst(line, i) {
+			if end := commandSubstEnd(line, i); end != -1 {
+				i = end
+			}
+		}
+	}
+	if start != -1 {
+		fields = append(fields, line[start:])
+	}
+	return fields
+}
+
+// expandVars expands the variables referenced as `$name` or `${...}` in a
+// grub2 config line, with the variables set so far. Unknown variables and
+// unsupported expansion syntaxes are left literal, as are escaped dollar signs
+// (`\$`) and anything between single quotes. Command substitutions `$(...)`
+// are left verbatim, including the variables they reference.
+func expandVars(line string, vars map[string]string) string {
+	var out strings.Builder
+	inQuotes := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && i+1 < len(line):
+			out.WriteByte(c)
+			out.WriteByte(line[i+1])
+			i++
+			continue
+		case c == '\'':
+			inQuotes = !inQuotes
+		case !inQuotes && isCommandSubst(line, i):
+			end := commandSubstEnd(line, i)
+			if end == -1 {
+				// not closed, up to the end of the line
+				end = len(line) - 1
+			}
+			out.WriteString(line[i : end+1])
+			i = end
+			continue
+		case c == '$' && !inQuotes && i+1 < len(line):
+			if line[i+1] == '{' {
+				end := strings.IndexByte(line[i+2:], '}')
+				if end != -1 {
+					if value, ok := expandVar(line[i+2:i+2+end], vars); ok {
+						out.WriteString(value)
+						i += 2 + end
+						continue
+					}
+				}
+			} else {
+				end := i + 1
+				for end < len(line) && isVarNameChar(line[end]) {
+					end++
+				}
+				if value, ok := vars[line[i+1:end]]; ok && end > i+1 {
+					out.WriteString(value)
+					i = end - 1
+					continue
+				}
+			}
+		}
+		out.WriteByte(c)
+	}
+	return out.String()
+}
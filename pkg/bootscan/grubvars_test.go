@@ -0,0 +1,75 @@
+package bootscan
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/systemboot/systemboot/pkg/bootconfig"
+)
+
+func TestExpandVars(t *testing.T) {
+	vars := map[string]string{
+		"root_uuid": "abcd",
+		"extra":     "quiet",
+		"empty":     "",
+	}
+	for line, expected := range map[string]string{
+		"root=UUID=$root_uuid ro":           "root=UUID=abcd ro",
+		"root=UUID=${root_uuid}":            "root=UUID=abcd",
+		"${unset:-splash} ${extra:-splash}": "splash quiet",
+		"${empty:-splash}":                  "splash",
+		"${extra:+debug} ${unset:+debug}x":  "debug x",
+		"${empty:+debug}":                   "",
+		// unknown variables and unsupported syntaxes are left literal
+		"$unset ${unset}":              "$unset ${unset}",
+		"${extra#q} ${extra:=x} ${1a}": "${extra#q} ${extra:=x} ${1a}",
+		"${extra":                      "${extra",
+		`\$extra '$extra' $`:           `\$extra '$extra' $`,
+		// command substitutions are left verbatim, variables included
+		"$(uname -r) $extra":        "$(uname -r) quiet",
+		"$(cat $(echo ${extra})) x": "$(cat $(echo ${extra})) x",
+		"$(echo $extra":             "$(echo $extra",
+	} {
+		require.Equal(t, expected, expandVars(line, vars), line)
+	}
+}
+
+func TestParseGrubExpandsDefaults(t *testing.T) {
+	grubcfg := `
+set kernel_dir=/boot
+set extra_cmdline="${extra_cmdline:-quiet splash}"
+menuentry 'Linux' {
+	linux ${kernel_dir}/vmlinuz root=/dev/sda1 ${extra_cmdline} ${debug:+loglevel=7} \${literal}
+	initrd ${initrd_dir:-/boot}/initrd.img
+}
+`
+	cfgs, err := ParseGrub(strings.NewReader(grubcfg), 2, BasedirResolver("/mnt"))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(cfgs))
+	require.Equal(t, "/mnt/boot/vmlinuz", cfgs[0].Kernel)
+	require.Equal(t, "/mnt/boot/initrd.img", cfgs[0].Initramfs)
+	require.Equal(t, "root=/dev/sda1 quiet splash  ${literal}", cfgs[0].KernelArgs)
+}
+
+func TestParseGrubCommandSubst(t *testing.T) {
+	grubcfg := `
+set kernel_dir=/boot
+menuentry 'Linux' {
+	linux ${kernel_dir}/vmlinuz-$(uname -r) root=/dev/sda1 hostname=$(cat /etc/hostname $kernel_dir)
+	initrd ${kernel_dir}/initrd.img
+}
+menuentry 'Escaped' {
+	linux /vmlinuz \$(literal) '$(quoted)'
+	initrd /initrd.img
+}
+`
+	cfgs, err := ParseGrub(strings.NewReader(grubcfg), 2, BasedirResolver("/mnt"))
+	require.NoError(t, err)
+	require.Equal(t, 2, len(cfgs))
+	require.Equal(t, "/mnt/boot/vmlinuz-$(uname -r)", cfgs[0].Kernel)
+	require.Equal(t, "/mnt/boot/initrd.img", cfgs[0].Initramfs)
+	require.Equal(t, "root=/dev/sda1 hostname=$(cat /etc/hostname $kernel_dir)", cfgs[0].KernelArgs)
+	require.Equal(t, []string{bootconfig.FeatureCommandSubst}, cfgs[0].Unsupported)
+	require.Empty(t, cfgs[1].Unsupported)
+}
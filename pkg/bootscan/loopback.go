@@ -0,0 +1,92 @@
+package bootscan
+
+import (
+	"path"
+	"strings"
+)
+
+// DefaultMaxLoopDepth is the default maximum number of nested loopback
+// devices LoopResolver follows, e.g. 2 for a squashfs image inside an ISO.
+const DefaultMaxLoopDepth = 4
+
+// ImageMounter mounts a file system image, e.g. an ISO or squashfs file, and
+// returns the path it is mounted on.
+type ImageMounter interface {
+	MountImage(image string) (string, error)
+}
+
+// ImageMounterFunc is an adapter to use an ordinary function as an
+// ImageMounter.
+type ImageMounterFunc func(image string) (string, error)
+
+// MountImage calls f(image).
+func (f ImageMounterFunc) MountImage(image string) (string, error) {
+	return f(image)
+}
+
+// loopbackVar returns the key under which the parsers store the image of a
+// GRUB loopback device in the variables passed to resolvers. It cannot clash
+// with a GRUB variable name.
+func loopbackVar(device string) string {
+	return "loopback:" + device
+}
+
+// parseLoopback handles a GRUB `loopback [-d] DEVICE FILE` directive, where
+// FILE may itself be on another loopback device, e.g. `(loop)/image.squashfs`.
+func parseLoopback(sline []string, vars map[string]string) {
+	if len(sline) >= 3 && sline[1] == "-d" {
+		delete(vars, loopbackVar(sline[2]))
+	} else if len(sline) >= 3 {
+		vars[loopbackVar(sline[1])] = sline[2]
+	}
+}
+
+// splitGrubDevice splits a path like `(loop)/casper/vmlinuz` into its device
+// and path on that device. The device is empty if there is none.
+func splitGrubDevice(p string) (string, string) {
+	if !strings.HasPrefix(p, "(") {
+		return "", p
+	}
+	idx := strings.Index(p, ")")
+	if idx == -1 {
+		return "", p
+	}
+	return p[1:idx], p[idx+1:]
+}
+
+// LoopResolver returns a Resolver that follows paths on GRUB loopback devices
+// into the images they are backed by, mounting them with mounter, and passes
+// any other path on to base. Images can themselves be on loopback devices, up
+// to maxDepth levels deep, e.g. a kernel in a squashfs image in an ISO file.
+// Every image is mounted only once. Paths that cannot be followed resolve to
+// an empty string.
+func LoopResolver(base Resolver, mounter ImageMounter, maxDepth int) Resolver {
+	mounted := make(map[string]string)
+	var resolve func(p, cmdline string, vars map[string]string, depth int) (string, string)
+	resolve = func(p, cmdline string, vars map[string]string, depth int) (string, string) {
+		device, rest := splitGrubDevice(p)
+		image, ok := vars[loopbackVar(device)]
+		if device == "" || !ok {
+			return base.Resolve(p, cmdline, vars)
+		}
+		if depth >= maxDepth {
+			return "", ""
+		}
+		imagePath, _ := resolve(image, cmdline, vars, depth+1)
+		if imagePath == "" {
+			return "", ""
+		}
+		mountpoint, ok := mounted[imagePath]
+		if !ok {
+			var err error
+			if mountpoint, err = mounter.MountImage(imagePath); err != nil {
+				return "", ""
+			}
+			mounted[imagePath] = mountpoint
+		}
+		return path.Join(mountpoint, rest), ""
+	}
+	return ResolverFunc(func(p, cmdline string, vars map[string]string) (string, string) {
+		return resolve(p, cmdline, vars, 0)
+	})
+}
@@ -0,0 +1,90 @@
+package bootscan
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeImageMounter "mounts" images on directories prepared in advance, and
+// records the images it was asked to mount.
+type fakeImageMounter struct {
+	images  map[string]string
+	mounted []string
+}
+
+func (m *fakeImageMounter) MountImage(image string) (string, error) {
+	m.mounted = append(m.mounted, image)
+	dir, ok := m.images[image]
+	if !ok {
+		return "", os.ErrNotExist
+	}
+	return dir, nil
+}
+
+func TestLoopResolverNested(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loopback")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	// a kernel in a squashfs image in an ISO file on the partition
+	writeTestFile(t, dir, "part/boot/live.iso", "iso9660")
+	writeTestFile(t, dir, "iso/casper/filesystem.squashfs", "squashfs")
+	writeTestFile(t, dir, "squashfs/boot/vmlinuz", "kernel")
+	mounter := &fakeImageMounter{images: map[string]string{
+		path.Join(dir, "part/boot/live.iso"):             path.Join(dir, "iso"),
+		path.Join(dir, "iso/casper/filesystem.squashfs"): path.Join(dir, "squashfs"),
+	}}
+	grubcfg := `
+set isofile=/boot/live.iso
+loopback loop $isofile
+loopback inner (loop)/casper/filesystem.squashfs
+menuentry 'Live' {
+	linux (inner)/boot/vmlinuz boot=casper
+	initrd (loop)/casper/initrd
+}
+`
+	resolver := LoopResolver(BasedirResolver(path.Join(dir, "part")), mounter, DefaultMaxLoopDepth)
+	cfgs, err := ParseGrub(strings.NewReader(grubcfg), 2, resolver)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(cfgs))
+	require.Equal(t, path.Join(dir, "squashfs/boot/vmlinuz"), cfgs[0].Kernel)
+	require.Equal(t, path.Join(dir, "iso/casper/initrd"), cfgs[0].Initramfs)
+	// the ISO is mounted only once
+	require.Equal(t, []string{
+		path.Join(dir, "part/boot/live.iso"),
+		path.Join(dir, "iso/casper/filesystem.squashfs"),
+	}, mounter.mounted)
+}
+
+func TestLoopResolverMaxDepth(t *testing.T) {
+	mounter := &fakeImageMounter{images: map[string]string{
+		"/part/a.img": "/a",
+		"/a/b.img":    "/b",
+	}}
+	vars := map[string]string{
+		loopbackVar("a"): "/a.img",
+		loopbackVar("b"): "(a)/b.img",
+	}
+	resolver := LoopResolver(BasedirResolver("/part"), mounter, 2)
+	kernel, _ := resolver.Resolve("(b)/vmlinuz", "", vars)
+	require.Equal(t, "/b/vmlinuz", kernel)
+
+	resolver = LoopResolver(BasedirResolver("/part"), mounter, 1)
+	kernel, _ = resolver.Resolve("(b)/vmlinuz", "", vars)
+	require.Equal(t, "", kernel)
+
+	// a loop device backed by itself must not recurse forever
+	vars[loopbackVar("c")] = "(c)/c.img"
+	kernel, _ = resolver.Resolve("(c)/vmlinuz", "", vars)
+	require.Equal(t, "", kernel)
+}
+
+func TestLoopResolverPassthrough(t *testing.T) {
+	resolver := LoopResolver(BasedirResolver("/part"), &fakeImageMounter{}, DefaultMaxLoopDepth)
+	kernel, _ := resolver.Resolve("/boot/vmlinuz", "", map[string]string{})
+	require.Equal(t, "/part/boot/vmlinuz", kernel)
+}
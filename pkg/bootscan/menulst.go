@@ -0,0 +1,115 @@
+package bootscan
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	"github.com/systemboot/systemboot/pkg/bootconfig"
+)
+
+// MenuLstPaths lists where to look for grub legacy menu.lst files.
+var MenuLstPaths = []string{
+	"boot/grub/menu.lst",
+	"grub/menu.lst",
+	"menu.lst",
+}
+
+// stripGrubDevice removes a grub legacy device prefix from a path, e.g.
+// `(hd0,0)/vmlinuz` becomes `/vmlinuz`.
+func stripGrubDevice(p string) string {
+	if strings.HasPrefix(p, "(") {
+		if idx := strings.Index(p, ")"); idx != -1 {
+			return p[idx+1:]
+		}
+	}
+	return p
+}
+
+// ParseMenuLst parses a grub legacy menu.lst and returns a list of BootConfig
+// structures, one for each `title` entry with a kernel. The `default` entry
+// comes first, followed by the `fallback` ones, then by the remaining entries
+// in the order they appear. The kernel and initrd paths are resolved with
+// resolver.
+func ParseMenuLst(r io.Reader, resolver Resolver) ([]bootconfig.BootConfig, error) {
+	var (
+		bootconfigs    []bootconfig.BootConfig
+		indices        []int
+		cfg            *bootconfig.BootConfig
+		kernel, initrd string
+		defaultEntry   string
+		fallback       string
+		menuIndex      = -1
+		lineno         int
+	)
+	vars := make(map[string]string)
+	save := func() {
+		if cfg == nil {
+			return
+		}
+		if kernel != "" {
+			cfg.Kernel, cfg.Subvolume = resolver.Resolve(kernel, cfg.KernelArgs, vars)
+		}
+		if initrd != "" {
+			cfg.Initramfs, _ = resolver.Resolve(initrd, cfg.KernelArgs, vars)
+		}
+		if cfg.IsValid() {
+			bootconfigs = append(bootconfigs, *cfg)
+			indices = append(indices, menuIndex)
+		}
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// directives can be separated from their arguments by spaces or `=`
+		directive, args := line, ""
+		if idx := strings.IndexAny(line, " \t="); idx != -1 {
+			directive, args = line[:idx], strings.TrimSpace(line[idx+1:])
+		}
+		switch directive {
+		case "default":
+			if cfg == nil {
+				defaultEntry = args
+			}
+		case "fallback":
+			if cfg == nil {
+				fallback = args
+			}
+		case "title":
+			save()
+			cfg = &bootconfig.BootConfig{Name: args, Source: &bootconfig.Source{Line: lineno}}
+			kernel, initrd = "", ""
+			menuIndex++
+		case "kernel":
+			if cfg == nil || args == "" {
+				continue
+			}
+			fields := strings.Fields(args)
+			kernel = stripGrubDevice(fields[0])
+			cfg.KernelArgs = argsAfterFields(args, 1)
+		case "initrd":
+			if cfg == nil || args == "" {
+				continue
+			}
+			initrd = stripGrubDevice(strings.Fields(args)[0])
+		case "halt", "reboot":
+			if cfg != nil {
+				cfg.Action = directive
+			}
+		case "lock", "password":
+			// a global password only protects the interactive menu
+			if cfg != nil {
+				cfg.Protected = true
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	save()
+	return orderByDefault(bootconfigs, indices, defaultEntry, fallback), nil
+}
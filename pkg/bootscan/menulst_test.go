@@ -0,0 +1,109 @@
+package bootscan
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/systemboot/systemboot/pkg/bootconfig"
+)
+
+var sampleMenuLst = `
+# generated by a very old installer
+default 2
+fallback 0 1
+timeout 5
+
+title Debian 4.9
+root (hd0,0)
+kernel /vmlinuz-4.9 root=/dev/sda1 ro quiet
+initrd /initrd.img-4.9
+
+title Debian 4.9 (recovery)
+root (hd0,0)
+kernel (hd0,0)/vmlinuz-4.9 root=/dev/sda1 ro single
+initrd (hd0,0)/initrd.img-4.9
+
+title Debian 4.19
+	kernel=/vmlinuz-4.19 root=/dev/sda1 ro
+	initrd=/initrd.img-4.19
+
+title Memtest
+kernel /memtest86+.bin
+
+title Other OS
+rootnoverify (hd0,1)
+chainloader +1
+`
+
+func TestParseMenuLstDefaultAndFallback(t *testing.T) {
+	cfgs, err := ParseMenuLst(strings.NewReader(sampleMenuLst), BasedirResolver("/mnt/sda1"))
+	require.NoError(t, err)
+	names := make([]string, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		names = append(names, cfg.Name)
+	}
+	// the chainloader entry has no kernel and is skipped
+	require.Equal(t, []string{"Debian 4.19", "Debian 4.9", "Debian 4.9 (recovery)", "Memtest"}, names)
+	require.Equal(t, "/mnt/sda1/vmlinuz-4.19", cfgs[0].Kernel)
+	require.Equal(t, "/mnt/sda1/initrd.img-4.19", cfgs[0].Initramfs)
+	require.Equal(t, "root=/dev/sda1 ro", cfgs[0].KernelArgs)
+	require.Equal(t, "/mnt/sda1/vmlinuz-4.9", cfgs[2].Kernel)
+	require.Equal(t, "root=/dev/sda1 ro single", cfgs[2].KernelArgs)
+	require.Equal(t, "", cfgs[3].Initramfs)
+}
+
+func TestParseMenuLstNoDefault(t *testing.T) {
+	menulst := `
+title first
+kernel /vmlinuz-1
+title second
+kernel /vmlinuz-2
+`
+	cfgs, err := ParseMenuLst(strings.NewReader(menulst), BasedirResolver("/"))
+	require.NoError(t, err)
+	require.Equal(t, 2, len(cfgs))
+	require.Equal(t, "first", cfgs[0].Name)
+	// out of range and "saved" defaults are ignored
+	cfgs, err = ParseMenuLst(strings.NewReader("default saved\nfallback 7\n"+menulst), BasedirResolver("/"))
+	require.NoError(t, err)
+	require.Equal(t, "first", cfgs[0].Name)
+	require.Equal(t, "second", cfgs[1].Name)
+}
+
+func TestParseMenuLstActions(t *testing.T) {
+	menulst := `
+title Linux
+kernel /vmlinuz
+title Reboot
+reboot
+title Halt
+halt
+`
+	cfgs, err := ParseMenuLst(strings.NewReader(menulst), BasedirResolver("/"))
+	require.NoError(t, err)
+	require.Equal(t, 3, len(cfgs))
+	require.Equal(t, bootconfig.ActionReboot, cfgs[1].Action)
+	require.Equal(t, bootconfig.ActionHalt, cfgs[2].Action)
+}
+
+func TestParseMenuLstLocked(t *testing.T) {
+	menulst := `
+password --md5 $1$global$hash
+title Linux
+kernel /vmlinuz
+title Linux (single user)
+lock
+kernel /vmlinuz single
+title Rescue
+password secret
+kernel /vmlinuz-rescue
+`
+	cfgs, err := ParseMenuLst(strings.NewReader(menulst), BasedirResolver("/"))
+	require.NoError(t, err)
+	require.Equal(t, 3, len(cfgs))
+	// the global password alone doesn't protect the entries
+	require.False(t, cfgs[0].Protected)
+	require.True(t, cfgs[1].Protected)
+	require.True(t, cfgs[2].Protected)
+}
@@ -0,0 +1,103 @@
+package bootscan
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+
+	"github.com/systemboot/systemboot/pkg/crypto"
+	"github.com/systemboot/systemboot/pkg/fetch"
+)
+
+// RemoteSignatureExt is appended to the URL of a remote config to get the URL
+// of its detached signature.
+const RemoteSignatureExt = ".sig"
+
+// blsIndexFormat is used to parse a remote BLS index. It is not in Formats,
+// since local indexes are read by scanBLS.
+var blsIndexFormat = Format{Name: "bls", Parse: ParseBLSIndex}
+
+// RemoteFormat returns the format of the remote config at rawurl, from the
+// file name in its path, e.g. grub.cfg or loader/entries.json, or nil if it
+// is not a known config file. Unlike on disk, a grub.cfg is always a GRUB 2
+// config, since there is no directory name to tell the versions apart.
+func RemoteFormat(rawurl string) (*Format, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case path.Base(u.Path) == path.Base(BLSIndexPath):
+		return &blsIndexFormat, nil
+	case isGrubCfg(u.Path):
+		return FormatOf("grub2/grub.cfg"), nil
+	}
+	return FormatOf(u.Path), nil
+}
+
+// ConfinedResolver returns a Resolver that resolves every path relative to
+// basedir, like BasedirResolver, but never outside of it: `..` elements
+// cannot go above basedir. It is used for configs that do not come from the
+// partition at basedir, e.g. remote ones.
+func ConfinedResolver(basedir string) Resolver {
+	return ResolverFunc(func(p, cmdline string, vars map[string]string) (string, string) {
+		return path.Join(basedir, path.Clean("/"+p)), ""
+	})
+}
+
+// FetchRemote downloads the remote config at rawurl and measures it. If
+// verifier is set, the config must have a valid signature at
+// rawurl+RemoteSignatureExt, see crypto.VerifySignature.
+func FetchRemote(f *fetch.Fetcher, rawurl string, verifier crypto.Verifier, opts Options) ([]byte, error) {
+	data, err := f.Fetch(rawurl, nil)
+	if err != nil {
+		return nil, err
+	}
+	if verifier != nil {
+		signature, err := f.Fetch(rawurl+RemoteSignatureExt, nil)
+		if err != nil {
+			return nil, fmt.Errorf("cannot fetch the signature of %s: %v", rawurl, err)
+		}
+		if err := crypto.VerifySignature(data, signature, verifier); err != nil {
+			return nil, fmt.Errorf("invalid signature for %s: %v", rawurl, err)
+		}
+	} else {
+		opts.logf("No key to verify the remote config %s", rawurl)
+	}
+	if opts.Measure != nil {
+		opts.Measure(rawurl, data)
+	}
+	return data, nil
+}
+
+// ParseRemote parses a remote config downloaded from rawurl with FetchRemote,
+// in the format given by RemoteFormat, resolving its kernel and initrd paths,
+// as well as the config files it includes, against basedir with a
+// ConfinedResolver. The entries are attributed to rawurl.
+func ParseRemote(rawurl string, data []byte, basedir string, opts Options) ([]Entry, error) {
+	format, err := RemoteFormat(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if format == nil {
+		return nil, fmt.Errorf("unknown config format for %s", rawurl)
+	}
+	if !opts.enabled(format.Name) {
+		return nil, fmt.Errorf("%s configs are disabled", format.Name)
+	}
+	if format.Parse == nil {
+		return nil, fmt.Errorf("there is no scanner for %s configs yet", format.Name)
+	}
+	return parseFile(format, rawurl, data, opts.resolver(ConfinedResolver(basedir)), opts, nil)
+}
+
+// ScanRemote fetches, verifies and measures the remote config at rawurl with
+// FetchRemote, and parses it with ParseRemote, for kernels on the partition
+// mounted at basedir.
+func ScanRemote(f *fetch.Fetcher, rawurl string, verifier crypto.Verifier, basedir string, opts Options) ([]Entry, error) {
+	data, err := FetchRemote(f, rawurl, verifier, opts)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRemote(rawurl, data, basedir, opts)
+}
@@ -0,0 +1,90 @@
+package bootscan
+
+import (
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/systemboot/systemboot/pkg/crypto"
+	"github.com/systemboot/systemboot/pkg/fetch/fetchtest"
+	"golang.org/x/crypto/ed25519"
+)
+
+const remoteGrubCfg = `
+menuentry 'Linux 5.0' {
+	linux /boot/vmlinuz-5.0 root=/dev/sda2 ro
+	initrd /boot/initrd.img-5.0
+}
+menuentry 'Escape' {
+	linux ../../../etc/vmlinuz
+	initrd /../initrd.img
+}
+`
+
+func TestScanRemote(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remote")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	pubkey, privkey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	ts := fetchtest.NewFileServer(map[string]string{
+		"/hosts/web1/grub.cfg":     remoteGrubCfg,
+		"/hosts/web1/grub.cfg.sig": string(ed25519.Sign(privkey, []byte(remoteGrubCfg))),
+	})
+	defer ts.Close()
+	rawurl := ts.URL + "/hosts/web1/grub.cfg"
+
+	var measured []string
+	opts := Options{Measure: func(path string, data []byte) { measured = append(measured, path) }}
+	entries, err := ScanRemote(fetchtest.NewFetcher(), rawurl, &crypto.Ed25519Verifier{Key: pubkey}, dir, opts)
+	require.NoError(t, err)
+	require.Equal(t, []string{rawurl}, measured)
+	require.Equal(t, 2, len(entries))
+	require.Equal(t, "grub2", entries[0].Format)
+	require.Equal(t, rawurl, entries[0].ConfigPath)
+	require.Equal(t, rawurl, entries[0].Source.Path)
+	// the kernels are on the local partition
+	require.Equal(t, path.Join(dir, "boot/vmlinuz-5.0"), entries[0].Kernel)
+	require.Equal(t, path.Join(dir, "boot/initrd.img-5.0"), entries[0].Initramfs)
+	require.Equal(t, "root=/dev/sda2 ro", entries[0].KernelArgs)
+	// and cannot be outside of it
+	require.Equal(t, path.Join(dir, "etc/vmlinuz"), entries[1].Kernel)
+	require.Equal(t, path.Join(dir, "initrd.img"), entries[1].Initramfs)
+}
+
+func TestScanRemoteInvalidSignature(t *testing.T) {
+	pubkey, privkey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	ts := fetchtest.NewFileServer(map[string]string{
+		"/grub.cfg":     remoteGrubCfg,
+		"/grub.cfg.sig": string(ed25519.Sign(privkey, []byte("something else"))),
+		"/menu.lst":     "title Linux\nkernel /vmlinuz\n",
+	})
+	defer ts.Close()
+	verifier := &crypto.Ed25519Verifier{Key: pubkey}
+
+	_, err = ScanRemote(fetchtest.NewFetcher(), ts.URL+"/grub.cfg", verifier, "/mnt/sda1", Options{})
+	require.Error(t, err)
+	// an unsigned config is refused when there is a key
+	_, err = ScanRemote(fetchtest.NewFetcher(), ts.URL+"/menu.lst", verifier, "/mnt/sda1", Options{})
+	require.Error(t, err)
+	entries, err := ScanRemote(fetchtest.NewFetcher(), ts.URL+"/menu.lst", nil, "/mnt/sda1", Options{})
+	require.NoError(t, err)
+	require.Equal(t, "/mnt/sda1/vmlinuz", entries[0].Kernel)
+}
+
+func TestParseRemoteFormat(t *testing.T) {
+	entries, err := ParseRemote("http://example.com/loader/entries.json", []byte(sampleBLSIndex), "/mnt/sda1", Options{})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(entries))
+	require.Equal(t, "bls", entries[0].Format)
+	require.Equal(t, "/mnt/sda1/vmlinuz-5.0", entries[0].Kernel)
+
+	_, err = ParseRemote("http://example.com/boot.json", []byte("{}"), "/mnt/sda1", Options{})
+	require.Error(t, err)
+	_, err = ParseRemote("http://example.com/grub.cfg", []byte(remoteGrubCfg), "/mnt/sda1", Options{Disabled: []string{"grub2"}})
+	require.Error(t, err)
+}
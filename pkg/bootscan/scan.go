@@ -0,0 +1,68 @@
+package bootscan
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultMaxScanDepth is the default maximum directory depth for
+// ScanRecursive.
+const DefaultMaxScanDepth = 5
+
+// ScanRecursive walks the directory tree under basedir, up to maxDepth levels
+// deep, looking for any known boot configuration file regardless of its
+// location, and parses the ones it finds. This is useful to find something
+// bootable on media that don't follow the standard layout. Symbolic links are
+// not followed. Kernel and initrd paths are relative to basedir.
+func ScanRecursive(basedir string, maxDepth int, opts Options) []Entry {
+	resolver := opts.resolver(BasedirResolver(basedir))
+	entries := make([]Entry, 0)
+	err := filepath.Walk(basedir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			opts.logf("Skipping %s: %v", path, err)
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(basedir, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if rel != "." && strings.Count(rel, string(filepath.Separator))+1 >= maxDepth {
+				opts.debugf("Not descending into %s: maximum depth %d reached", path, maxDepth)
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		format := FormatOf(rel)
+		if format == nil {
+			return nil
+		}
+		if !opts.enabled(format.Name) {
+			opts.debugf("Skipping %s: %s configs are disabled", path, format.Name)
+			return nil
+		}
+		if format.Parse == nil {
+			opts.logf("Found %s, but there is no scanner for this kind of config yet", path)
+			return nil
+		}
+		opts.logf("Trying to read %s", path)
+		found, err := ScanFile(format, path, resolver, opts)
+		if err != nil {
+			opts.logf("cannot open %s: %v", path, err)
+			return nil
+		}
+		entries = append(entries, found...)
+		return nil
+	})
+	if err != nil {
+		opts.logf("Error scanning %s: %v", basedir, err)
+	}
+	return entries
+}
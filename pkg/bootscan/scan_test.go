@@ -0,0 +1,131 @@
+package bootscan
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/systemboot/systemboot/pkg/bootconfig"
+)
+
+var sampleGrubCfg = `
+menuentry 'Linux' {
+	linux /boot/vmlinuz root=/dev/sda1
+	initrd /boot/initrd.img
+}
+`
+
+func writeTestFile(t *testing.T, dir, relpath, content string) {
+	fullpath := path.Join(dir, relpath)
+	require.NoError(t, os.MkdirAll(path.Dir(fullpath), 0755))
+	require.NoError(t, ioutil.WriteFile(fullpath, []byte(content), 0644))
+}
+
+func TestScanRecursiveNonstandardLocation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localboot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeTestFile(t, dir, "some/odd/place/grub.cfg", sampleGrubCfg)
+
+	// not found in the standard locations
+	require.Equal(t, 0, len(Scan(dir, Options{})))
+
+	cfgs := ScanRecursive(dir, DefaultMaxScanDepth, Options{})
+	require.Equal(t, 1, len(cfgs))
+	require.Equal(t, path.Join(dir, "boot/vmlinuz"), cfgs[0].Kernel)
+	require.Equal(t, path.Join(dir, "boot/initrd.img"), cfgs[0].Initramfs)
+	require.Equal(t, "root=/dev/sda1", cfgs[0].KernelArgs)
+}
+
+func TestScanRecursiveMaxDepth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localboot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeTestFile(t, dir, "a/b/c/grub.cfg", sampleGrubCfg)
+
+	require.Equal(t, 1, len(ScanRecursive(dir, 4, Options{})))
+	require.Equal(t, 0, len(ScanRecursive(dir, 3, Options{})))
+}
+
+func TestFormatOf(t *testing.T) {
+	for relpath, expected := range map[string]string{
+		"boot/grub2/grub.cfg":       "grub2",
+		"boot/grub/grub.cfg":        "grub",
+		"isolinux/isolinux.cfg":     "syslinux",
+		"loader/entries/linux.conf": "bls",
+		"boot/grub/menu.lst":        "menulst",
+	} {
+		format := FormatOf(relpath)
+		require.NotNil(t, format, relpath)
+		require.Equal(t, expected, format.Name)
+	}
+	require.Nil(t, FormatOf("etc/modprobe.d/blacklist.conf"))
+}
+
+func TestScanMeasuresConfigs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localboot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeTestFile(t, dir, "boot/grub2/grub.cfg", sampleGrubCfg)
+
+	measured := make(map[string]string)
+	opts := Options{
+		Measure: func(path string, data []byte) {
+			measured[path] = string(data)
+		},
+	}
+	entries := Scan(dir, opts)
+	require.Equal(t, 1, len(entries))
+	cfgpath := path.Join(dir, "boot/grub2/grub.cfg")
+	require.Equal(t, "grub2", entries[0].Format)
+	require.Equal(t, cfgpath, entries[0].ConfigPath)
+	require.Equal(t, map[string]string{cfgpath: sampleGrubCfg}, measured)
+	require.Equal(t, path.Join(dir, "boot/vmlinuz"), BootConfigs(entries)[0].Kernel)
+}
+
+func TestScanFileUnsupportedFormat(t *testing.T) {
+	_, err := ScanFile(FormatOf("isolinux/isolinux.cfg"), "/nonexistent", BasedirResolver("/"), Options{})
+	require.Error(t, err)
+}
+
+func TestScanDisabledFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localboot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeTestFile(t, dir, "boot/grub2/grub.cfg", sampleGrubCfg)
+	writeTestFile(t, dir, "isolinux/isolinux.cfg", "DEFAULT linux\n")
+
+	// stand in for the syslinux scanner, recording its invocations
+	var idx int
+	for idx = range Formats {
+		if Formats[idx].Name == "syslinux" {
+			break
+		}
+	}
+	saved := Formats[idx]
+	defer func() { Formats[idx] = saved }()
+	invoked := 0
+	Formats[idx].Paths = []string{"isolinux/isolinux.cfg"}
+	Formats[idx].Parse = func(r io.Reader, resolver Resolver) ([]bootconfig.BootConfig, error) {
+		invoked++
+		return nil, nil
+	}
+
+	opts := Options{Disabled: []string{"syslinux"}}
+	require.Equal(t, 1, len(Scan(dir, opts)))
+	require.Equal(t, 1, len(ScanRecursive(dir, DefaultMaxScanDepth, opts)))
+	require.Equal(t, 0, invoked)
+
+	// enabled again, it is invoked by both
+	Scan(dir, Options{})
+	ScanRecursive(dir, DefaultMaxScanDepth, Options{})
+	require.Equal(t, 2, invoked)
+}
+
+func TestCheckFormatNames(t *testing.T) {
+	require.NoError(t, CheckFormatNames([]string{"grub2", "syslinux"}))
+	require.Error(t, CheckFormatNames([]string{"grub3"}))
+}
@@ -0,0 +1,60 @@
+package crypto
+
+import (
+	"crypto"
+	"fmt"
+	"log"
+	"runtime"
+	"sync"
+)
+
+// Measurement is a blob to measure, with information about it for the logs.
+type Measurement struct {
+	Data []byte
+	Info string
+}
+
+// hashConcurrently returns the digests of the measurements with the given hash
+// algorithm, in the same order, computing them on all CPUs.
+func hashConcurrently(measurements []Measurement, alg crypto.Hash) [][]byte {
+	digests := make([][]byte, len(measurements))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < runtime.NumCPU(); worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range next {
+				h := alg.New()
+				h.Write(measurements[idx].Data)
+				digests[idx] = h.Sum(nil)
+			}
+		}()
+	}
+	for idx := range measurements {
+		next <- idx
+	}
+	close(next)
+	wg.Wait()
+	return digests
+}
+
+// MeasureBatch measures many blobs into a PCR, in the bank of MeasurementHash,
+// with the tpm2-tools binaries. The digests are computed in parallel, and only the
+// high-latency PCR extensions are serialized, in the order of the
+// measurements, so the final PCR value is the same as when measuring the blobs
+// one by one. The digests are also recorded in the NV index set with
+// SetMeasurementNVIndex, if any. It returns the number of blobs that were
+// measured.
+func MeasureBatch(pcr uint32, measurements []Measurement) (int, error) {
+	alg := MeasurementHash()
+	for idx, digest := range hashConcurrently(measurements, alg) {
+		log.Printf("Measuring blob: %v", measurements[idx].Info)
+		if _, err := runTPM2Tool("tpm2_pcrextend", fmt.Sprintf("%d:%s=%x", pcr, pcrBanks[alg], digest)); err != nil {
+			return idx, fmt.Errorf("tpm2_pcrextend failed for %s: %v", measurements[idx].Info, err)
+		}
+		recordNVDigest(digest, measurements[idx].Info)
+		recordEventDigest(pcr, digest, measurements[idx].Info)
+	}
+	return len(measurements), nil
+}
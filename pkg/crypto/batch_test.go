@@ -0,0 +1,42 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMeasureBatchMatchesSerialOrder(t *testing.T) {
+	defer func(orig func(string, ...string) ([]byte, error)) { runTPM2Tool = orig }(runTPM2Tool)
+	var measurements []Measurement
+	for idx := 0; idx < 100; idx++ {
+		measurements = append(measurements, Measurement{
+			Data: []byte("entry " + strconv.Itoa(idx)),
+			Info: fmt.Sprintf("loader/entries/%03d.conf", idx),
+		})
+	}
+
+	// extend the digests one by one, in order
+	serial := &pcrSimulator{}
+	runTPM2Tool = serial.run
+	for _, m := range measurements {
+		_, err := runTPM2Tool("tpm2_pcrextend", fmt.Sprintf("8:sha256=%x", sha256.Sum256(m.Data)))
+		require.NoError(t, err)
+	}
+	expected, err := ReadPCRs([]int{8}, crypto.SHA256)
+	require.NoError(t, err)
+
+	batch := &pcrSimulator{}
+	runTPM2Tool = batch.run
+	measured, err := MeasureBatch(8, measurements)
+	require.NoError(t, err)
+	require.Equal(t, len(measurements), measured)
+	pcrs, err := ReadPCRs([]int{8}, crypto.SHA256)
+	require.NoError(t, err)
+	require.Equal(t, expected, pcrs)
+	require.NotEqual(t, make([]byte, sha256.Size), pcrs[8])
+}
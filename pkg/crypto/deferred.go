@@ -0,0 +1,61 @@
+package crypto
+
+import (
+	"sync"
+)
+
+// DeferredMeasurements holds measurements that are only extended into the TPM
+// on demand, e.g. the config files of the boot entry that is eventually booted
+// rather than every config file that is scanned. This saves TPM operations,
+// but the PCRs then only cover what was measured on demand.
+type DeferredMeasurements struct {
+	mu      sync.Mutex
+	pending map[string][]deferredMeasurement
+}
+
+type deferredMeasurement struct {
+	pcr  uint32
+	data []byte
+}
+
+// Add records a measurement of data into a PCR, see TryMeasureData, without
+// extending it yet. info identifies it, e.g. the path of the measured file.
+func (d *DeferredMeasurements) Add(pcr uint32, data []byte, info string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.pending == nil {
+		d.pending = make(map[string][]deferredMeasurement)
+	}
+	d.pending[info] = append(d.pending[info], deferredMeasurement{pcr: pcr, data: data})
+}
+
+// Measure extends the pending measurements identified by info, in the order
+// they were added, and forgets them so they are only measured once. It
+// returns the number of measurements that were extended.
+func (d *DeferredMeasurements) Measure(info string) int {
+	return d.MeasureFor(info, "")
+}
+
+// MeasureFor is Measure for the boot entry named entry, whose name tags the
+// recorded events, see EnableEventLog.
+func (d *DeferredMeasurements) MeasureFor(info, entry string) int {
+	d.mu.Lock()
+	measurements := d.pending[info]
+	delete(d.pending, info)
+	d.mu.Unlock()
+	for _, m := range measurements {
+		tryMeasureData(m.pcr, m.data, info, entry)
+	}
+	return len(measurements)
+}
+
+// Pending returns the number of measurements that were not extended yet.
+func (d *DeferredMeasurements) Pending() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	count := 0
+	for _, measurements := range d.pending {
+		count += len(measurements)
+	}
+	return count
+}
@@ -0,0 +1,36 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeferredMeasurements(t *testing.T) {
+	sim := &pcrSimulator{}
+	defer fakeMeasurementHash(sim)()
+	require.NoError(t, SetMeasurementHash(crypto.SHA256))
+
+	var d DeferredMeasurements
+	d.Add(ConfigData, []byte("grub.cfg on sda1"), "/mnt/sda1/boot/grub/grub.cfg")
+	d.Add(ConfigData, []byte("grub.cfg on sdb1"), "/mnt/sdb1/boot/grub/grub.cfg")
+	d.Add(ConfigData, []byte("entry.conf"), "/mnt/sdb1/loader/entries/linux.conf")
+	require.Equal(t, 3, d.Pending())
+	// nothing is extended until an entry is selected
+	pcrs, err := ReadPCRs([]int{int(ConfigData)}, crypto.SHA256)
+	require.NoError(t, err)
+	require.Equal(t, make([]byte, sha256.Size), pcrs[int(ConfigData)])
+
+	require.Equal(t, 1, d.Measure("/mnt/sdb1/boot/grub/grub.cfg"))
+	require.Equal(t, 0, d.Measure("/mnt/sdb1/boot/grub/grub.cfg"))
+	require.Equal(t, 2, d.Pending())
+
+	// only the selected config file was extended
+	digest := sha256.Sum256([]byte("grub.cfg on sdb1"))
+	expected := sha256.Sum256(append(make([]byte, sha256.Size), digest[:]...))
+	pcrs, err = ReadPCRs([]int{int(ConfigData)}, crypto.SHA256)
+	require.NoError(t, err)
+	require.Equal(t, expected[:], pcrs[int(ConfigData)])
+}
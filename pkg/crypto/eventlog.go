@@ -0,0 +1,445 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync"
+)
+
+// FirmwareEventLogPath is where the kernel exposes the TPM event log of the
+// firmware.
+var FirmwareEventLogPath = "/sys/kernel/security/tpm0/binary_bios_measurements"
+
+// Event types, see the TCG PC Client Platform Firmware Profile.
+const (
+	// EventNoAction events are informational, and not extended into PCRs
+	EventNoAction uint32 = 0x03
+	// EventIPL events are measurements of the boot loader, as systemboot's
+	EventIPL uint32 = 0x0d
+)
+
+// tpmAlgs maps the hash algorithms to their TPM algorithm IDs.
+var tpmAlgs = map[crypto.Hash]uint16{
+	crypto.SHA1:   0x0004,
+	crypto.SHA256: 0x000b,
+	crypto.SHA384: 0x000c,
+	crypto.SHA512: 0x000d,
+}
+
+// specIDSignature starts the first event of a crypto agile event log.
+var specIDSignature = []byte("Spec ID Event03\x00")
+
+// specIDHeaderLen is the length of the signature, platform class, spec
+// version and uintn size of a Spec ID event, before its algorithms.
+var specIDHeaderLen = len(specIDSignature) + 8
+
+// startupLocalitySignature starts the EV_NO_ACTION event that sets the initial
+// value of PCR 0.
+var startupLocalitySignature = []byte("StartupLocality\x00")
+
+// Event is an entry of a TPM event log.
+type Event struct {
+	PCR  uint32
+	Type uint32
+	// Digests are the digests extended into each PCR bank
+	Digests map[crypto.Hash][]byte
+	Data    []byte
+}
+
+// EventLog is a TPM event log, as written by the firmware.
+type EventLog struct {
+	// Algorithms are the hash algorithms of the digests of each event. A
+	// legacy log only has SHA1 digests
+	Algorithms []crypto.Hash
+	// Events are the events, including the Spec ID event of a crypto agile
+	// log, which has a SHA1 digest only
+	Events []Event
+	// unknownAlgs are the algorithms of the log that this package does not
+	// support, with their digest sizes, which are kept when marshaling
+	unknownAlgs map[uint16]uint16
+	agile       bool
+}
+
+// ReadFirmwareEventLog reads the TPM event log of the firmware.
+func ReadFirmwareEventLog() (*EventLog, error) {
+	data, err := ioutil.ReadFile(FirmwareEventLogPath)
+	if err != nil {
+		return nil, err
+	}
+	return ParseEventLog(data)
+}
+
+// parseLegacyEvent parses an event in the SHA1 format of legacy logs, which is
+// also the format of the first event of crypto agile logs.
+func parseLegacyEvent(r *bytes.Reader) (*Event, error) {
+	var header struct {
+		PCR, Type uint32
+		Digest    [20]byte
+		Size      uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+	data, err := readEventData(r, header.Size)
+	if err != nil {
+		return nil, err
+	}
+	return &Event{
+		PCR:     header.PCR,
+		Type:    header.Type,
+		Digests: map[crypto.Hash][]byte{crypto.SHA1: header.Digest[:]},
+		Data:    data,
+	}, nil
+}
+
+func readEventData(r *bytes.Reader, size uint32) ([]byte, error) {
+	if int64(size) > int64(r.Len()) {
+		return nil, fmt.Errorf("truncated event data of %d bytes", size)
+	}
+	data := make([]byte, size)
+	_, err := io.ReadFull(r, data)
+	return data, err
+}
+
+// parseSpecID returns the digest algorithms and sizes of a Spec ID event.
+func parseSpecID(data []byte) ([]uint16, map[uint16]uint16, error) {
+	// signature, platform class, spec version and uintn size
+	if len(data) < specIDHeaderLen {
+		return nil, nil, fmt.Errorf("truncated Spec ID event of %d bytes", len(data))
+	}
+	r := bytes.NewReader(data[specIDHeaderLen:])
+	var count uint32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, nil, err
+	}
+	if int64(count)*4 > int64(r.Len()) {
+		return nil, nil, fmt.Errorf("invalid number of algorithms %d", count)
+	}
+	ids := make([]uint16, 0, count)
+	sizes := make(map[uint16]uint16, count)
+	for idx := uint32(0); idx < count; idx++ {
+		var alg struct{ ID, Size uint16 }
+		if err := binary.Read(r, binary.LittleEndian, &alg); err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, alg.ID)
+		sizes[alg.ID] = alg.Size
+	}
+	return ids, sizes, nil
+}
+
+// ParseEventLog parses a TPM event log, in the crypto agile format of TPM 2.0
+// firmware, or in the SHA1 format of legacy firmware.
+func ParseEventLog(data []byte) (*EventLog, error) {
+	r := bytes.NewReader(data)
+	first, err := parseLegacyEvent(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid event log: %v", err)
+	}
+	log := &EventLog{Events: []Event{*first}}
+	if first.Type != EventNoAction || !bytes.HasPrefix(first.Data, specIDSignature) {
+		// legacy log
+		log.Algorithms = []crypto.Hash{crypto.SHA1}
+		for r.Len() > 0 {
+			event, err := parseLegacyEvent(r)
+			if err != nil {
+				return nil, fmt.Errorf("invalid event %d: %v", len(log.Events), err)
+			}
+			log.Events = append(log.Events, *event)
+		}
+		return log, nil
+	}
+	log.agile = true
+	ids, sizes, err := parseSpecID(first.Data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Spec ID event: %v", err)
+	}
+	log.unknownAlgs = make(map[uint16]uint16)
+	for _, id := range ids {
+		if alg, ok := algOf(id); ok {
+			log.Algorithms = append(log.Algorithms, alg)
+		} else {
+			log.unknownAlgs[id] = sizes[id]
+		}
+	}
+	for r.Len() > 0 {
+		event, err := parseAgileEvent(r, sizes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid event %d: %v", len(log.Events), err)
+		}
+		log.Events = append(log.Events, *event)
+	}
+	return log, nil
+}
+
+// algOf returns the hash algorithm of a TPM algorithm ID.
+func algOf(id uint16) (crypto.Hash, bool) {
+	for alg, algID := range tpmAlgs {
+		if algID == id {
+			return alg, true
+		}
+	}
+	return 0, false
+}
+
+// parseAgileEvent parses an event in the crypto agile format. The digests of
+// unknown algorithms are skipped.
+func parseAgileEvent(r *bytes.Reader, sizes map[uint16]uint16) (*Event, error) {
+	var header struct{ PCR, Type, Count uint32 }
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+	event := Event{PCR: header.PCR, Type: header.Type, Digests: make(map[crypto.Hash][]byte)}
+	for idx := uint32(0); idx < header.Count; idx++ {
+		var id uint16
+		if err := binary.Read(r, binary.LittleEndian, &id); err != nil {
+			return nil, err
+		}
+		size, ok := sizes[id]
+		if !ok {
+			return nil, fmt.Errorf("digest of algorithm %#x, which is not in the Spec ID event", id)
+		}
+		digest, err := readEventData(r, uint32(size))
+		if err != nil {
+			return nil, err
+		}
+		if alg, ok := algOf(id); ok {
+			event.Digests[alg] = digest
+		}
+	}
+	var size uint32
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return nil, err
+	}
+	data, err := readEventData(r, size)
+	if err != nil {
+		return nil, err
+	}
+	event.Data = data
+	return &event, nil
+}
+
+// Append adds events at the end of the log, e.g. systemboot's own events, see
+// RecordedEvents, after the ones of the firmware. Every event must have a
+// digest for each algorithm of the log. It fails if the log has algorithms
+// that this package does not support, as their digests are unknown.
+func (l *EventLog) Append(events []Event) error {
+	if len(l.unknownAlgs) > 0 {
+		return errors.New("the event log has digests of unsupported algorithms")
+	}
+	for idx, event := range events {
+		digests := make(map[crypto.Hash][]byte, len(l.Algorithms))
+		for _, alg := range l.Algorithms {
+			digest, ok := event.Digests[alg]
+			if !ok {
+				return fmt.Errorf("event %d has no %v digest", idx, alg)
+			}
+			digests[alg] = digest
+		}
+		event.Digests = digests
+		l.Events = append(l.Events, event)
+	}
+	return nil
+}
+
+// narrow keeps only the digests of the given algorithm, including in the Spec
+// ID event, so that the log has the bank systemboot measures into alone.
+func (l *EventLog) narrow(alg crypto.Hash) error {
+	found := false
+	for _, logAlg := range l.Algorithms {
+		found = found || logAlg == alg
+	}
+	if !found {
+		return fmt.Errorf("the event log has no %v digests", alg)
+	}
+	if !l.agile {
+		return nil
+	}
+	spec := l.Events[0].Data
+	ids, _, err := parseSpecID(spec)
+	if err != nil {
+		return err
+	}
+	var data bytes.Buffer
+	data.Write(spec[:specIDHeaderLen])
+	binary.Write(&data, binary.LittleEndian, uint32(1))
+	binary.Write(&data, binary.LittleEndian, []uint16{tpmAlgs[alg], uint16(alg.Size())})
+	// the vendor info
+	data.Write(spec[specIDHeaderLen+4+4*len(ids):])
+	l.Events[0].Data = data.Bytes()
+	for idx := 1; idx < len(l.Events); idx++ {
+		l.Events[idx].Digests = map[crypto.Hash][]byte{alg: l.Events[idx].Digests[alg]}
+	}
+	l.Algorithms = []crypto.Hash{alg}
+	l.unknownAlgs = nil
+	return nil
+}
+
+// Marshal returns the log in the format it was parsed from.
+func (l *EventLog) Marshal() []byte {
+	var buf bytes.Buffer
+	for idx, event := range l.Events {
+		binary.Write(&buf, binary.LittleEndian, []uint32{event.PCR, event.Type})
+		if !l.agile || idx == 0 {
+			var digest [20]byte
+			copy(digest[:], event.Digests[crypto.SHA1])
+			buf.Write(digest[:])
+		} else {
+			binary.Write(&buf, binary.LittleEndian, uint32(len(l.Algorithms)))
+			for _, alg := range l.Algorithms {
+				binary.Write(&buf, binary.LittleEndian, tpmAlgs[alg])
+				buf.Write(event.Digests[alg])
+			}
+		}
+		binary.Write(&buf, binary.LittleEndian, uint32(len(event.Data)))
+		buf.Write(event.Data)
+	}
+	return buf.Bytes()
+}
+
+// Replay returns the PCR values that the events of the log extend, in the bank
+// of the given algorithm, e.g. to check them against the PCRs of the TPM.
+func (l *EventLog) Replay(alg crypto.Hash) (map[uint32][]byte, error) {
+	pcrs := make(map[uint32][]byte)
+	value := func(pcr uint32) []byte {
+		if _, ok := pcrs[pcr]; !ok {
+			pcrs[pcr] = make([]byte, alg.Size())
+		}
+		return pcrs[pcr]
+	}
+	for idx, event := range l.Events {
+		if event.Type == EventNoAction {
+			// the locality the firmware started from is the initial
+			// value of PCR 0
+			if bytes.HasPrefix(event.Data, startupLocalitySignature) && len(event.Data) > len(startupLocalitySignature) {
+				value(0)[alg.Size()-1] = event.Data[len(startupLocalitySignature)]
+			}
+			continue
+		}
+		digest, ok := event.Digests[alg]
+		if !ok {
+			return nil, fmt.Errorf("event %d has no %v digest", idx, alg)
+		}
+		h := alg.New()
+		h.Write(value(event.PCR))
+		h.Write(digest)
+		pcrs[event.PCR] = h.Sum(nil)
+	}
+	return pcrs, nil
+}
+
+// eventLog records systemboot's measurements as events, once enabled with
+// EnableEventLog.
+var eventLog struct {
+	mu      sync.Mutex
+	enabled bool
+	events  []Event
+}
+
+// EnableEventLog starts recording the measurements as events, see
+// RecordedEvents. Each event has the digest of the PCR bank the measurement
+// was extended into, see MeasurementHash.
+func EnableEventLog() {
+	eventLog.mu.Lock()
+	defer eventLog.mu.Unlock()
+	eventLog.enabled = true
+}
+
+// recordEvent records a measurement of data into a PCR as an EV_IPL event
+// described by info, if enabled with EnableEventLog.
+func recordEvent(pcr uint32, data []byte, info string) {
+	h := MeasurementHash().New()
+	h.Write(data)
+	recordEventDigest(pcr, h.Sum(nil), info)
+}
+
+// recordEventDigest is recordEvent for a digest in the algorithm of
+// MeasurementHash.
+func recordEventDigest(pcr uint32, digest []byte, info string) {
+	eventLog.mu.Lock()
+	defer eventLog.mu.Unlock()
+	if !eventLog.enabled {
+		return
+	}
+	digests := map[crypto.Hash][]byte{MeasurementHash(): digest}
+	eventLog.events = append(eventLog.events, Event{PCR: pcr, Type: EventIPL, Digests: digests, Data: []byte(info)})
+}
+
+// Formats of the event descriptions, see SetEventDescriptionFormat.
+const (
+	// EventDescriptionFull describes a measured file by its full path
+	EventDescriptionFull = "full"
+	// EventDescriptionBasename describes a measured file by its base name
+	EventDescriptionBasename = "basename"
+	// EventDescriptionHashed describes a measurement by the hex SHA-256 of
+	// what describes it in full, e.g. the full path
+	EventDescriptionHashed = "hashed"
+)
+
+var eventDescriptionFormat = EventDescriptionFull
+
+// SetEventDescriptionFormat selects how the events recorded since
+// EnableEventLog are described, so that the event log matches what the
+// verifier expects.
+func SetEventDescriptionFormat(format string) error {
+	switch format {
+	case EventDescriptionFull, EventDescriptionBasename, EventDescriptionHashed:
+		eventDescriptionFormat = format
+		return nil
+	}
+	return fmt.Errorf("unknown event description format %q", format)
+}
+
+// eventDescription returns the description of an event measuring info for the
+// boot entry named entry, e.g. `[Ubuntu] /boot/vmlinuz`, so that the event log
+// tells which entry each measurement belongs to. Measurements outside of an
+// entry are described by info alone. Only absolute paths are shortened to
+// their base name, not e.g. the kernel arguments.
+func eventDescription(info, entry string) string {
+	switch eventDescriptionFormat {
+	case EventDescriptionBasename:
+		if strings.HasPrefix(info, "/") {
+			info = path.Base(info)
+		}
+	case EventDescriptionHashed:
+		info = fmt.Sprintf("%x", sha256.Sum256([]byte(info)))
+	}
+	if entry == "" {
+		return info
+	}
+	return "[" + entry + "] " + info
+}
+
+// RecordedEvents returns the measurements recorded since EnableEventLog, in
+// order.
+func RecordedEvents() []Event {
+	eventLog.mu.Lock()
+	defer eventLog.mu.Unlock()
+	return append([]Event(nil), eventLog.events...)
+}
+
+// UnifiedEventLog returns the event log of the firmware followed by the
+// measurements recorded since EnableEventLog, so that replaying it gives the
+// current PCR values. Only the bank of MeasurementHash is kept, as the other
+// banks are not extended by systemboot.
+func UnifiedEventLog() (*EventLog, error) {
+	log, err := ReadFirmwareEventLog()
+	if err != nil {
+		return nil, err
+	}
+	if err := log.narrow(MeasurementHash()); err != nil {
+		return nil, err
+	}
+	if err := log.Append(RecordedEvents()); err != nil {
+		return nil, err
+	}
+	return log, nil
+}
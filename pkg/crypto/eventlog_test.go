@@ -0,0 +1,269 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// firmwareLogFixture writes a crypto agile event log with SHA1 and SHA256
+// digests, as written by TPM 2.0 firmware, event by event.
+type firmwareLogFixture struct {
+	bytes.Buffer
+}
+
+func (f *firmwareLogFixture) specID() {
+	var data bytes.Buffer
+	data.WriteString("Spec ID Event03\x00")
+	// platform class, spec version 2.0 errata 0, uintn size
+	binary.Write(&data, binary.LittleEndian, []uint8{0, 0, 0, 0, 0, 2, 0, 2})
+	binary.Write(&data, binary.LittleEndian, []uint32{2})
+	binary.Write(&data, binary.LittleEndian, []uint16{0x0004, 20, 0x000b, 32})
+	// vendor info size
+	data.WriteByte(0)
+	binary.Write(f, binary.LittleEndian, []uint32{0, EventNoAction})
+	f.Write(make([]byte, 20))
+	binary.Write(f, binary.LittleEndian, uint32(data.Len()))
+	f.Write(data.Bytes())
+}
+
+func (f *firmwareLogFixture) event(pcr, typ uint32, digested, data []byte) {
+	binary.Write(f, binary.LittleEndian, []uint32{pcr, typ, 2})
+	sha1Digest, sha256Digest := sha1.Sum(digested), sha256.Sum256(digested)
+	binary.Write(f, binary.LittleEndian, uint16(0x0004))
+	f.Write(sha1Digest[:])
+	binary.Write(f, binary.LittleEndian, uint16(0x000b))
+	f.Write(sha256Digest[:])
+	binary.Write(f, binary.LittleEndian, uint32(len(data)))
+	f.Write(data)
+}
+
+// fakeEventLog records the events from a clean state, and restores the
+// recorder when the test is done.
+func fakeEventLog() func() {
+	saved := eventLog.events
+	eventLog.events = nil
+	return func() {
+		eventLog.enabled = false
+		eventLog.events = saved
+	}
+}
+
+func TestParseEventLog(t *testing.T) {
+	var fixture firmwareLogFixture
+	fixture.specID()
+	fixture.event(0, 0x80000008, []byte("firmware volume"), []byte("FV"))
+	log, err := ParseEventLog(fixture.Bytes())
+	require.NoError(t, err)
+	require.Equal(t, []crypto.Hash{crypto.SHA1, crypto.SHA256}, log.Algorithms)
+	require.Len(t, log.Events, 2)
+	digest := sha256.Sum256([]byte("firmware volume"))
+	require.Equal(t, digest[:], log.Events[1].Digests[crypto.SHA256])
+	require.Equal(t, []byte("FV"), log.Events[1].Data)
+	require.Equal(t, fixture.Bytes(), log.Marshal())
+
+	_, err = ParseEventLog(fixture.Bytes()[:fixture.Len()-1])
+	require.Error(t, err)
+}
+
+func TestParseLegacyEventLog(t *testing.T) {
+	var data bytes.Buffer
+	digest := sha1.Sum([]byte("option rom"))
+	binary.Write(&data, binary.LittleEndian, []uint32{2, 0x07})
+	data.Write(digest[:])
+	binary.Write(&data, binary.LittleEndian, uint32(3))
+	data.WriteString("ROM")
+	log, err := ParseEventLog(data.Bytes())
+	require.NoError(t, err)
+	require.Equal(t, []crypto.Hash{crypto.SHA1}, log.Algorithms)
+	require.Len(t, log.Events, 1)
+	require.Equal(t, data.Bytes(), log.Marshal())
+}
+
+func TestUnifiedEventLog(t *testing.T) {
+	sim := &pcrSimulator{}
+	defer fakeMeasurementHash(sim)()
+	defer fakeEventLog()()
+
+	// the firmware started from locality 3, and measured into PCR 0 and 4
+	var fixture firmwareLogFixture
+	fixture.specID()
+	fixture.event(0, EventNoAction, nil, []byte("StartupLocality\x00\x03"))
+	fixture.event(0, 0x80000008, []byte("firmware volume"), []byte("FV"))
+	fixture.event(4, 0x80000003, []byte("bootx64.efi"), []byte("bootx64.efi"))
+	dir, err := ioutil.TempDir("", "eventlog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(orig string) { FirmwareEventLogPath = orig }(FirmwareEventLogPath)
+	FirmwareEventLogPath = filepath.Join(dir, "binary_bios_measurements")
+	require.NoError(t, ioutil.WriteFile(FirmwareEventLogPath, fixture.Bytes(), 0644))
+
+	// the PCRs of the simulator continue from the values of the firmware
+	_, err = sim.run("tpm2_pcrextend", fmt.Sprintf("4:sha256=%x", sha256.Sum256([]byte("bootx64.efi"))))
+	require.NoError(t, err)
+	require.NoError(t, SetMeasurementHash(crypto.SHA256))
+	EnableEventLog()
+	TryMeasureData(ConfigData, []byte("grub.cfg"), "grub.cfg")
+	_, err = MeasureBatch(Blob, []Measurement{{Data: []byte("vmlinuz"), Info: "vmlinuz"}})
+	require.NoError(t, err)
+	_, err = MeasureBatch(4, []Measurement{{Data: []byte("initramfs"), Info: "initramfs"}})
+	require.NoError(t, err)
+
+	log, err := UnifiedEventLog()
+	require.NoError(t, err)
+	require.Len(t, log.Events, 7)
+	require.Equal(t, EventIPL, log.Events[4].Type)
+	require.Equal(t, []byte("grub.cfg"), log.Events[4].Data)
+	// only the bank systemboot measures into is kept
+	require.Equal(t, []crypto.Hash{crypto.SHA256}, log.Algorithms)
+	require.Len(t, log.Events[1].Digests, 1)
+	require.Len(t, log.Events[6].Digests, 1)
+
+	replayed, err := log.Replay(crypto.SHA256)
+	require.NoError(t, err)
+	expected, err := ReadPCRs([]int{4, int(ConfigData), int(Blob)}, crypto.SHA256)
+	require.NoError(t, err)
+	for pcr, value := range expected {
+		require.Equal(t, value, replayed[uint32(pcr)], "PCR %d", pcr)
+	}
+
+	locality := make([]byte, sha256.Size)
+	locality[sha256.Size-1] = 3
+	digest := sha256.Sum256([]byte("firmware volume"))
+	pcr0 := sha256.Sum256(append(locality, digest[:]...))
+	require.Equal(t, pcr0[:], replayed[0])
+
+	// the merged log is a valid event log
+	parsed, err := ParseEventLog(log.Marshal())
+	require.NoError(t, err)
+	require.Equal(t, log.Algorithms, parsed.Algorithms)
+	require.Equal(t, log.Events, parsed.Events)
+}
+
+func TestUnifiedEventLogSHA384(t *testing.T) {
+	sim := &pcrSimulator{banks: []crypto.Hash{crypto.SHA256, crypto.SHA384}}
+	defer fakeMeasurementHash(sim)()
+	defer fakeEventLog()()
+	require.NoError(t, SetMeasurementHash(crypto.SHA384))
+	EnableEventLog()
+	TryMeasureData(ConfigData, []byte("grub.cfg"), "grub.cfg")
+	_, err := MeasureBatch(Blob, []Measurement{{Data: []byte("vmlinuz"), Info: "vmlinuz"}})
+	require.NoError(t, err)
+
+	// the events only have the digests of the bank that was extended
+	events := RecordedEvents()
+	require.Len(t, events, 2)
+	for _, event := range events {
+		require.Len(t, event.Digests, 1)
+		require.Len(t, event.Digests[crypto.SHA384], sha512.Size384)
+	}
+	log := EventLog{Algorithms: []crypto.Hash{crypto.SHA384}, Events: events}
+	replayed, err := log.Replay(crypto.SHA384)
+	require.NoError(t, err)
+	expected, err := ReadPCRs([]int{int(ConfigData), int(Blob)}, crypto.SHA384)
+	require.NoError(t, err)
+	for pcr, value := range expected {
+		require.Equal(t, value, replayed[uint32(pcr)], "PCR %d", pcr)
+	}
+
+	// the firmware log has no SHA-384 bank to continue from
+	var fixture firmwareLogFixture
+	fixture.specID()
+	dir, err := ioutil.TempDir("", "eventlog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(orig string) { FirmwareEventLogPath = orig }(FirmwareEventLogPath)
+	FirmwareEventLogPath = filepath.Join(dir, "binary_bios_measurements")
+	require.NoError(t, ioutil.WriteFile(FirmwareEventLogPath, fixture.Bytes(), 0644))
+	_, err = UnifiedEventLog()
+	require.Error(t, err)
+}
+
+func TestParseEventLogShortSpecID(t *testing.T) {
+	var fixture firmwareLogFixture
+	binary.Write(&fixture, binary.LittleEndian, []uint32{0, EventNoAction})
+	fixture.Write(make([]byte, 20))
+	binary.Write(&fixture, binary.LittleEndian, uint32(len(specIDSignature)))
+	fixture.Write(specIDSignature)
+	_, err := ParseEventLog(fixture.Bytes())
+	require.Error(t, err)
+}
+
+func TestRecordedEventsDisabled(t *testing.T) {
+	defer fakeMeasurementHash(&pcrSimulator{})()
+	defer fakeEventLog()()
+	_, err := MeasureBatch(Blob, []Measurement{{Data: []byte("vmlinuz"), Info: "vmlinuz"}})
+	require.NoError(t, err)
+	require.Empty(t, RecordedEvents())
+}
+
+func TestRecordedEventsEntryTags(t *testing.T) {
+	defer fakeMeasurementHash(&pcrSimulator{})()
+	defer fakeEventLog()()
+	require.NoError(t, SetMeasurementHash(crypto.SHA256))
+	dir, err := ioutil.TempDir("", "eventlog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	kernel := filepath.Join(dir, "vmlinuz")
+	initramfs := filepath.Join(dir, "initrd.img")
+	require.NoError(t, ioutil.WriteFile(kernel, []byte("kernel"), 0644))
+	require.NoError(t, ioutil.WriteFile(initramfs, []byte("initramfs"), 0644))
+	EnableEventLog()
+
+	var d DeferredMeasurements
+	d.Add(ConfigData, []byte("grub.cfg"), "/mnt/sda1/boot/grub/grub.cfg")
+	require.Equal(t, 1, d.MeasureFor("/mnt/sda1/boot/grub/grub.cfg", "Ubuntu"))
+	TryMeasureBootConfig("Ubuntu", kernel, initramfs, "root=/dev/sda1", "")
+	TryMeasureData(ConfigData, []byte("untagged"), "untagged")
+
+	var descriptions []string
+	for _, event := range RecordedEvents() {
+		descriptions = append(descriptions, string(event.Data))
+	}
+	require.Equal(t, []string{
+		"[Ubuntu] /mnt/sda1/boot/grub/grub.cfg",
+		"[Ubuntu] Ubuntu",
+		"[Ubuntu] " + kernel,
+		"[Ubuntu] " + initramfs,
+		"[Ubuntu] root=/dev/sda1",
+		"[Ubuntu] ",
+		"[Ubuntu] " + kernel,
+		"[Ubuntu] " + initramfs,
+		"untagged",
+	}, descriptions)
+}
+
+func TestEventDescriptionFormat(t *testing.T) {
+	defer fakeMeasurementHash(&pcrSimulator{})()
+	defer fakeEventLog()()
+	defer func(orig string) { eventDescriptionFormat = orig }(eventDescriptionFormat)
+	require.NoError(t, SetMeasurementHash(crypto.SHA256))
+	EnableEventLog()
+	require.Error(t, SetEventDescriptionFormat("short"))
+
+	hashed := sha256.Sum256([]byte("/mnt/sda1/boot/vmlinuz"))
+	for format, expected := range map[string][]string{
+		EventDescriptionFull:     {"[Ubuntu] /mnt/sda1/boot/vmlinuz", "root=/dev/sda1 ro"},
+		EventDescriptionBasename: {"[Ubuntu] vmlinuz", "root=/dev/sda1 ro"},
+		EventDescriptionHashed:   {fmt.Sprintf("[Ubuntu] %x", hashed), fmt.Sprintf("%x", sha256.Sum256([]byte("root=/dev/sda1 ro")))},
+	} {
+		eventLog.events = nil
+		require.NoError(t, SetEventDescriptionFormat(format))
+		tryMeasureData(Blob, []byte("kernel"), "/mnt/sda1/boot/vmlinuz", "Ubuntu")
+		TryMeasureData(BootConfig, []byte("root=/dev/sda1 ro"), "root=/dev/sda1 ro")
+		events := RecordedEvents()
+		require.Len(t, events, 2)
+		require.Equal(t, expected[0], string(events[0].Data), format)
+		require.Equal(t, expected[1], string(events[1].Data), format)
+	}
+}
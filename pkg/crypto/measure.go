@@ -1,9 +1,11 @@
 package crypto
 
 import (
-	"io/ioutil"
+	"crypto"
+	"fmt"
 	"log"
 
+	"github.com/systemboot/systemboot/pkg/storage"
 	"github.com/systemboot/tpmtool/pkg/tpm"
 )
 
@@ -14,40 +16,135 @@ const (
 	BootConfig uint32 = 8
 	// ConfigData type in PCR 8
 	ConfigData uint32 = 8
+	// Policy type in PCR 8
+	Policy uint32 = 8
 	// NvramVars type in PCR 9
 	NvramVars uint32 = 9
+	// DeviceTree type in PCR 11
+	DeviceTree uint32 = 11
 )
 
-// TryMeasureBootConfig measures bootconfig contents
-func TryMeasureBootConfig(name, kernel, initramfs, kernelArgs, deviceTree string) {
-	TPMInterface, err := tpm.NewTPM()
+// measurementHash is the hash algorithm of the PCR bank that measurements are
+// extended into, see SetMeasurementHash. If it is zero, measurements go
+// through the TPM library, in its default banks.
+var measurementHash crypto.Hash
+
+// SetMeasurementHash selects the PCR bank all the measurements are extended
+// into, e.g. crypto.SHA384 where SHA-384 PCRs are mandated. Measurements then
+// go through the tpm2-tools binaries. It fails if the TPM has no such bank.
+func SetMeasurementHash(alg crypto.Hash) error {
+	if _, ok := pcrBanks[alg]; !ok {
+		return fmt.Errorf("unsupported measurement hash %v", alg)
+	}
+	banks, err := PCRBanks()
 	if err != nil {
-		log.Printf("Cannot open TPM: %v", err)
-		return
+		return err
 	}
-	TryMeasureData(BootConfig, []byte(name), name)
-	TryMeasureData(BootConfig, []byte(kernel), kernel)
-	TryMeasureData(BootConfig, []byte(initramfs), initramfs)
-	TryMeasureData(BootConfig, []byte(kernelArgs), kernelArgs)
-	TryMeasureData(BootConfig, []byte(deviceTree), deviceTree)
-	TryMeasureFiles(kernel, initramfs, deviceTree)
-	TPMInterface.Close()
+	for _, bank := range banks {
+		if bank == alg {
+			measurementHash = alg
+			return nil
+		}
+	}
+	return fmt.Errorf("the TPM has no %s PCR bank", pcrBanks[alg])
+}
+
+// MeasurementHash returns the hash algorithm of the PCR bank measurements are
+// extended into, SHA256 unless another one was set with SetMeasurementHash.
+func MeasurementHash() crypto.Hash {
+	if measurementHash == 0 {
+		return crypto.SHA256
+	}
+	return measurementHash
+}
+
+// extendPCR measures data into a PCR, in the bank of MeasurementHash, with
+// the tpm2-tools binaries.
+func extendPCR(pcr uint32, data []byte) error {
+	alg := MeasurementHash()
+	h := alg.New()
+	h.Write(data)
+	if _, err := runTPM2Tool("tpm2_pcrextend", fmt.Sprintf("%d:%s=%x", pcr, pcrBanks[alg], h.Sum(nil))); err != nil {
+		return fmt.Errorf("tpm2_pcrextend failed: %v", err)
+	}
+	return nil
+}
+
+// TryMeasureBootConfig measures bootconfig contents. The events recorded for
+// them, see EnableEventLog, are tagged with the name of the boot entry.
+func TryMeasureBootConfig(name, kernel, initramfs, kernelArgs, deviceTree string) {
+	if measurementHash == 0 {
+		TPMInterface, err := tpm.NewTPM()
+		if err != nil {
+			log.Printf("Cannot open TPM: %v", err)
+			return
+		}
+		defer TPMInterface.Close()
+	}
+	tryMeasureData(BootConfig, []byte(name), name, name)
+	tryMeasureData(BootConfig, []byte(kernel), kernel, name)
+	tryMeasureData(BootConfig, []byte(initramfs), initramfs, name)
+	tryMeasureData(BootConfig, []byte(kernelArgs), kernelArgs, name)
+	tryMeasureData(BootConfig, []byte(deviceTree), deviceTree, name)
+	tryMeasureFiles(name, kernel, initramfs, deviceTree)
 }
 
-// TryMeasureData measures a byte array with additional information
+// TryMeasureData measures a byte array with additional information, and
+// records it in the NV index set with SetMeasurementNVIndex, if any.
 func TryMeasureData(pcr uint32, data []byte, info string) {
+	tryMeasureData(pcr, data, info, "")
+}
+
+// tryMeasureData is TryMeasureData for the boot entry named entry, if any.
+func tryMeasureData(pcr uint32, data []byte, info, entry string) {
+	recordNV(data, info)
+	if measurementHash != 0 {
+		log.Printf("Measuring blob: %v", info)
+		if err := extendPCR(pcr, data); err != nil {
+			log.Printf("Cannot measure %v: %v", info, err)
+			return
+		}
+		recordEvent(pcr, data, eventDescription(info, entry))
+		return
+	}
 	TPMInterface, err := tpm.NewTPM()
 	if err != nil {
 		log.Printf("Cannot open TPM: %v", err)
 		return
 	}
+	defer TPMInterface.Close()
 	log.Printf("Measuring blob: %v", info)
-	TPMInterface.Measure(pcr, data)
-	TPMInterface.Close()
+	if err := TPMInterface.Measure(pcr, data); err != nil {
+		log.Printf("Cannot measure %v: %v", info, err)
+		return
+	}
+	recordEvent(pcr, data, eventDescription(info, entry))
 }
 
-// TryMeasureFiles measures a variable amount of files
+// TryMeasureFiles measures a variable amount of files, and records them in the
+// NV index set with SetMeasurementNVIndex, if any.
 func TryMeasureFiles(files ...string) {
+	tryMeasureFiles("", files...)
+}
+
+// tryMeasureFiles is TryMeasureFiles for the boot entry named entry, if any.
+func tryMeasureFiles(entry string, files ...string) {
+	if measurementHash != 0 {
+		for _, file := range files {
+			log.Printf("Measuring file: %v", file)
+			data, err := storage.ReadFile(file)
+			if err != nil {
+				continue
+			}
+			recordNV(data, file)
+			if err := extendPCR(Blob, data); err != nil {
+				log.Printf("Cannot measure %v: %v", file, err)
+				continue
+			}
+			recordEvent(Blob, data, eventDescription(file, entry))
+		}
+		return
+	}
 	TPMInterface, err := tpm.NewTPM()
 	if err != nil {
 		log.Printf("Cannot open TPM: %v", err)
@@ -55,11 +152,16 @@ func TryMeasureFiles(files ...string) {
 	}
 	for _, file := range files {
 		log.Printf("Measuring file: %v", file)
-		data, err := ioutil.ReadFile(file)
+		data, err := storage.ReadFile(file)
 		if err != nil {
 			continue
 		}
-		TPMInterface.Measure(Blob, data)
+		recordNV(data, file)
+		if err := TPMInterface.Measure(Blob, data); err != nil {
+			log.Printf("Cannot measure %v: %v", file, err)
+			continue
+		}
+		recordEvent(Blob, data, eventDescription(file, entry))
 	}
 	TPMInterface.Close()
 }
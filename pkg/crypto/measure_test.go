@@ -0,0 +1,58 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/sha512"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeMeasurementHash runs the tests against sim, and restores the default
+// measurement hash and NV index when they are done.
+func fakeMeasurementHash(sim *pcrSimulator) func() {
+	savedRun, savedHash, savedIndex := runTPM2Tool, measurementHash, measurementNVIndex
+	runTPM2Tool = sim.run
+	return func() { runTPM2Tool, measurementHash, measurementNVIndex = savedRun, savedHash, savedIndex }
+}
+
+func TestPCRBanks(t *testing.T) {
+	defer fakeMeasurementHash(&pcrSimulator{banks: []crypto.Hash{crypto.SHA1, crypto.SHA384}})()
+	banks, err := PCRBanks()
+	require.NoError(t, err)
+	require.Equal(t, []crypto.Hash{crypto.SHA1, crypto.SHA384}, banks)
+}
+
+func TestMeasureSHA384(t *testing.T) {
+	sim := &pcrSimulator{banks: []crypto.Hash{crypto.SHA256, crypto.SHA384}}
+	defer fakeMeasurementHash(sim)()
+
+	require.Equal(t, crypto.SHA256, MeasurementHash())
+	require.NoError(t, SetMeasurementHash(crypto.SHA384))
+	require.Equal(t, crypto.SHA384, MeasurementHash())
+
+	TryMeasureData(ConfigData, []byte("grub.cfg"), "grub.cfg")
+	_, err := MeasureBatch(ConfigData, []Measurement{{Data: []byte("entry.conf"), Info: "entry.conf"}})
+	require.NoError(t, err)
+
+	expected := make([]byte, sha512.Size384)
+	for _, data := range []string{"grub.cfg", "entry.conf"} {
+		digest := sha512.Sum384([]byte(data))
+		value := sha512.Sum384(append(expected, digest[:]...))
+		expected = value[:]
+	}
+	pcrs, err := ReadPCRs([]int{int(ConfigData)}, crypto.SHA384)
+	require.NoError(t, err)
+	require.Equal(t, expected, pcrs[int(ConfigData)])
+	// nothing was extended into the SHA256 bank
+	pcrs, err = ReadPCRs([]int{int(ConfigData)}, crypto.SHA256)
+	require.NoError(t, err)
+	require.Equal(t, make([]byte, 32), pcrs[int(ConfigData)])
+}
+
+func TestSetMeasurementHashUnsupportedBank(t *testing.T) {
+	defer fakeMeasurementHash(&pcrSimulator{})()
+	require.Error(t, SetMeasurementHash(crypto.SHA384))
+	require.Error(t, SetMeasurementHash(crypto.MD5))
+	require.Equal(t, crypto.SHA256, MeasurementHash())
+}
@@ -0,0 +1,88 @@
+package crypto
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+)
+
+// The range of the TPM NV indices, see the TCG Registry of Reserved TPM 2.0
+// Handles and Localities.
+const (
+	nvIndexFirst uint32 = 0x01000000
+	nvIndexLast  uint32 = 0x01ffffff
+)
+
+// measurementNVIndex is the NV index that the digests of the measurements are
+// also extended into, see SetMeasurementNVIndex. Zero means none.
+var measurementNVIndex uint32
+
+// SetMeasurementNVIndex makes every measurement also extend its digest, in
+// the algorithm of MeasurementHash, into an NV index, e.g. 0x01500020, in
+// addition to the PCR. The index must have been defined with the extend type
+// (TPMA_NV_EXTEND) and be writable with the owner authorization. Zero stops
+// recording the measurements in an NV index.
+func SetMeasurementNVIndex(index uint32) error {
+	if index != 0 && (index < nvIndexFirst || index > nvIndexLast) {
+		return fmt.Errorf("invalid NV index %#x, not in the %#x-%#x range", index, nvIndexFirst, nvIndexLast)
+	}
+	measurementNVIndex = index
+	return nil
+}
+
+// ExtendNVIndex extends data into an NV index of the extend type with the
+// tpm2-tools binaries. The TPM sets the index to the digest of its previous
+// value and data, with the name algorithm of the index.
+func ExtendNVIndex(index uint32, data []byte) error {
+	tempDir, err := ioutil.TempDir(os.TempDir(), "nvindex")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+	input := path.Join(tempDir, "data.bin")
+	if err := ioutil.WriteFile(input, data, 0600); err != nil {
+		return err
+	}
+	if _, err := runTPM2Tool("tpm2_nvextend", "-C", "o", "-i", input, fmt.Sprintf("%#x", index)); err != nil {
+		return fmt.Errorf("tpm2_nvextend failed: %v", err)
+	}
+	return nil
+}
+
+// ReadNVIndex reads the value of an NV index with the tpm2-tools binaries.
+func ReadNVIndex(index uint32) ([]byte, error) {
+	tempDir, err := ioutil.TempDir(os.TempDir(), "nvindex")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir)
+	output := path.Join(tempDir, "value.bin")
+	if _, err := runTPM2Tool("tpm2_nvread", "-C", "o", "-o", output, fmt.Sprintf("%#x", index)); err != nil {
+		return nil, fmt.Errorf("tpm2_nvread failed: %v", err)
+	}
+	return ioutil.ReadFile(output)
+}
+
+// recordNVDigest extends the digest of a measurement into the NV index set
+// with SetMeasurementNVIndex, if any.
+func recordNVDigest(digest []byte, info string) {
+	if measurementNVIndex == 0 {
+		return
+	}
+	if err := ExtendNVIndex(measurementNVIndex, digest); err != nil {
+		log.Printf("Cannot record %v in NV index %#x: %v", info, measurementNVIndex, err)
+	}
+}
+
+// recordNV extends the digest of data, in the algorithm of MeasurementHash,
+// into the NV index set with SetMeasurementNVIndex, if any.
+func recordNV(data []byte, info string) {
+	if measurementNVIndex == 0 {
+		return
+	}
+	h := MeasurementHash().New()
+	h.Write(data)
+	recordNVDigest(h.Sum(nil), info)
+}
@@ -0,0 +1,45 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMeasureIntoNVIndex(t *testing.T) {
+	sim := &pcrSimulator{}
+	defer fakeMeasurementHash(sim)()
+	require.NoError(t, SetMeasurementHash(crypto.SHA256))
+	require.NoError(t, SetMeasurementNVIndex(0x01500020))
+
+	TryMeasureData(ConfigData, []byte("grub.cfg"), "grub.cfg")
+	_, err := MeasureBatch(ConfigData, []Measurement{{Data: []byte("entry.conf"), Info: "entry.conf"}})
+	require.NoError(t, err)
+
+	expected := make([]byte, sha256.Size)
+	for _, data := range []string{"grub.cfg", "entry.conf"} {
+		digest := sha256.Sum256([]byte(data))
+		value := sha256.Sum256(append(expected, digest[:]...))
+		expected = value[:]
+	}
+	value, err := ReadNVIndex(0x01500020)
+	require.NoError(t, err)
+	require.Equal(t, expected, value)
+	// the PCR was extended as well
+	pcrs, err := ReadPCRs([]int{int(ConfigData)}, crypto.SHA256)
+	require.NoError(t, err)
+	require.Equal(t, expected, pcrs[int(ConfigData)])
+
+	// no other index was written
+	_, err = ReadNVIndex(0x01500021)
+	require.Error(t, err)
+}
+
+func TestSetMeasurementNVIndexInvalid(t *testing.T) {
+	defer fakeMeasurementHash(&pcrSimulator{})()
+	require.Error(t, SetMeasurementNVIndex(0x81000001))
+	require.Error(t, SetMeasurementNVIndex(0x02000000))
+	require.NoError(t, SetMeasurementNVIndex(0))
+}
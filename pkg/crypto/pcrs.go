@@ -0,0 +1,118 @@
+package crypto
+
+import (
+	"crypto"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// pcrBanks maps the supported hash algorithms to their tpm2-tools names.
+var pcrBanks = map[crypto.Hash]string{
+	crypto.SHA1:   "sha1",
+	crypto.SHA256: "sha256",
+	crypto.SHA384: "sha384",
+	crypto.SHA512: "sha512",
+}
+
+// PCRBank returns the hash algorithm of a PCR bank given its tpm2-tools
+// name, e.g. crypto.SHA384 for `sha384`.
+func PCRBank(name string) (crypto.Hash, error) {
+	for alg, bank := range pcrBanks {
+		if bank == name {
+			return alg, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported PCR bank %q", name)
+}
+
+// PCRBanks returns the hash algorithms of the PCR banks that are allocated in
+// the TPM, with the tpm2-tools binaries. Banks that this package does not
+// support are ignored.
+func PCRBanks() ([]crypto.Hash, error) {
+	out, err := runTPM2Tool("tpm2_getcap", "pcrs")
+	if err != nil {
+		return nil, fmt.Errorf("tpm2_getcap failed: %v", err)
+	}
+	// the output looks like `  - sha256: [ 0, 1, 2, ... ]`, with an empty
+	// list for the banks that have no PCR allocated
+	var banks []crypto.Hash
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "- ") {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(line, "- "), ":", 2)
+		if len(parts) != 2 || strings.Trim(parts[1], " []") == "" {
+			continue
+		}
+		if alg, err := PCRBank(strings.TrimSpace(parts[0])); err == nil {
+			banks = append(banks, alg)
+		}
+	}
+	return banks, nil
+}
+
+// pcrSelection formats PCR indices for tpm2-tools, e.g. `sha256:0,7`.
+func pcrSelection(alg crypto.Hash, pcrs []int) (string, error) {
+	bank, ok := pcrBanks[alg]
+	if !ok {
+		return "", fmt.Errorf("unsupported PCR bank %v", alg)
+	}
+	indices := make([]string, 0, len(pcrs))
+	for _, pcr := range pcrs {
+		if pcr < 0 || pcr > 23 {
+			return "", fmt.Errorf("invalid PCR index %d", pcr)
+		}
+		indices = append(indices, strconv.Itoa(pcr))
+	}
+	return bank + ":" + strings.Join(indices, ","), nil
+}
+
+// ReadPCRs reads the current values of the selected PCRs in the bank of the
+// given hash algorithm, with the tpm2-tools binaries. It returns a map from
+// PCR index to digest.
+func ReadPCRs(selection []int, alg crypto.Hash) (map[int][]byte, error) {
+	// the digests are output in ascending PCR order
+	pcrs := make([]int, 0, len(selection))
+	seen := make(map[int]bool)
+	for _, pcr := range selection {
+		if !seen[pcr] {
+			seen[pcr] = true
+			pcrs = append(pcrs, pcr)
+		}
+	}
+	sort.Ints(pcrs)
+	spec, err := pcrSelection(alg, pcrs)
+	if err != nil {
+		return nil, err
+	}
+	if len(pcrs) == 0 {
+		return map[int][]byte{}, nil
+	}
+	tempDir, err := ioutil.TempDir(os.TempDir(), "pcrs")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir)
+	output := path.Join(tempDir, "pcrs.bin")
+	if _, err := runTPM2Tool("tpm2_pcrread", spec, "-o", output); err != nil {
+		return nil, fmt.Errorf("tpm2_pcrread failed: %v", err)
+	}
+	data, err := ioutil.ReadFile(output)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != len(pcrs)*alg.Size() {
+		return nil, fmt.Errorf("tpm2_pcrread returned %d bytes, expected %d %v digests", len(data), len(pcrs), alg)
+	}
+	values := make(map[int][]byte, len(pcrs))
+	for idx, pcr := range pcrs {
+		values[pcr] = data[idx*alg.Size() : (idx+1)*alg.Size()]
+	}
+	return values, nil
+}
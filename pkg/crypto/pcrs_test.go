@@ -0,0 +1,153 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// pcrSimulator simulates the PCR banks of a TPM behind the tpm2-tools
+// commands used to extend and read PCRs and list the banks. It only has a
+// SHA256 bank, unless banks is set. It also simulates NV indices of the
+// extend type with the SHA256 name algorithm.
+type pcrSimulator struct {
+	banks  []crypto.Hash
+	values map[string][]byte
+	nv     map[string][]byte
+}
+
+// bank returns the hash algorithm of a PCR bank of the simulator by name.
+func (s *pcrSimulator) bank(name string) (crypto.Hash, error) {
+	banks := s.banks
+	if banks == nil {
+		banks = []crypto.Hash{crypto.SHA256}
+	}
+	for _, alg := range banks {
+		if pcrBanks[alg] == name {
+			return alg, nil
+		}
+	}
+	return 0, errors.New("no PCR bank " + name)
+}
+
+func (s *pcrSimulator) run(name string, args ...string) ([]byte, error) {
+	switch name {
+	case "tpm2_pcrextend":
+		// tpm2_pcrextend 7:sha256=<hex digest>
+		parts := strings.SplitN(args[0], ":", 2)
+		pcr, _ := strconv.Atoi(parts[0])
+		parts = strings.SplitN(parts[1], "=", 2)
+		alg, err := s.bank(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		digest, err := hex.DecodeString(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		if len(digest) != alg.Size() {
+			return nil, errors.New("invalid digest size")
+		}
+		h := alg.New()
+		h.Write(s.value(alg, pcr))
+		h.Write(digest)
+		if s.values == nil {
+			s.values = make(map[string][]byte)
+		}
+		s.values[parts[0]+":"+strconv.Itoa(pcr)] = h.Sum(nil)
+		return nil, nil
+	case "tpm2_pcrread":
+		// tpm2_pcrread sha256:0,7 -o <file>
+		parts := strings.SplitN(args[0], ":", 2)
+		alg, err := s.bank(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		var out []byte
+		for _, index := range strings.Split(parts[1], ",") {
+			pcr, _ := strconv.Atoi(index)
+			out = append(out, s.value(alg, pcr)...)
+		}
+		return nil, ioutil.WriteFile(args[2], out, 0600)
+	case "tpm2_getcap":
+		// tpm2_getcap pcrs
+		out := "selected-pcrs:\n"
+		for _, alg := range []crypto.Hash{crypto.SHA1, crypto.SHA256, crypto.SHA384, crypto.SHA512} {
+			if _, err := s.bank(pcrBanks[alg]); err == nil {
+				out += "  - " + pcrBanks[alg] + ": [ 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23 ]\n"
+			} else {
+				out += "  - " + pcrBanks[alg] + ": [ ]\n"
+			}
+		}
+		return []byte(out), nil
+	case "tpm2_nvextend":
+		// tpm2_nvextend -C o -i <file> <index>
+		data, err := ioutil.ReadFile(args[3])
+		if err != nil {
+			return nil, err
+		}
+		value, ok := s.nv[args[4]]
+		if !ok {
+			value = make([]byte, sha256.Size)
+		}
+		sum := sha256.Sum256(append(append([]byte(nil), value...), data...))
+		if s.nv == nil {
+			s.nv = make(map[string][]byte)
+		}
+		s.nv[args[4]] = sum[:]
+		return nil, nil
+	case "tpm2_nvread":
+		// tpm2_nvread -C o -o <file> <index>
+		value, ok := s.nv[args[4]]
+		if !ok {
+			return nil, errors.New("NV index not written yet")
+		}
+		return nil, ioutil.WriteFile(args[3], value, 0600)
+	}
+	return nil, errors.New("unsupported command " + name)
+}
+
+func (s *pcrSimulator) value(alg crypto.Hash, pcr int) []byte {
+	if value, ok := s.values[pcrBanks[alg]+":"+strconv.Itoa(pcr)]; ok {
+		return value
+	}
+	return make([]byte, alg.Size())
+}
+
+func TestReadPCRs(t *testing.T) {
+	sim := &pcrSimulator{}
+	defer func(orig func(string, ...string) ([]byte, error)) { runTPM2Tool = orig }(runTPM2Tool)
+	runTPM2Tool = sim.run
+
+	digest := sha256.Sum256([]byte("systemboot"))
+	_, err := runTPM2Tool("tpm2_pcrextend", "7:sha256="+hex.EncodeToString(digest[:]))
+	require.NoError(t, err)
+	expected := sha256.Sum256(append(make([]byte, sha256.Size), digest[:]...))
+
+	pcrs, err := ReadPCRs([]int{7, 0, 7}, crypto.SHA256)
+	require.NoError(t, err)
+	require.Equal(t, map[int][]byte{
+		0: make([]byte, sha256.Size),
+		7: expected[:],
+	}, pcrs)
+}
+
+func TestReadPCRsInvalid(t *testing.T) {
+	defer func(orig func(string, ...string) ([]byte, error)) { runTPM2Tool = orig }(runTPM2Tool)
+	runTPM2Tool = (&pcrSimulator{}).run
+
+	_, err := ReadPCRs([]int{24}, crypto.SHA256)
+	require.Error(t, err)
+	_, err = ReadPCRs([]int{0}, crypto.MD5)
+	require.Error(t, err)
+	// the simulator only has a SHA256 bank
+	_, err = ReadPCRs([]int{0}, crypto.SHA1)
+	require.Error(t, err)
+}
@@ -0,0 +1,254 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto"
+	_ "crypto/sha256" // hash functions of PKCS7 signatures
+	_ "crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+)
+
+// PKCS7 / CMS object identifiers, see RFC 5652
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+)
+
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      pkcs7ContentInfo
+	Certificates     asn1.RawValue     `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue     `asn1:"optional,tag:1"`
+	SignerInfos      []pkcs7SignerInfo `asn1:"set"`
+}
+
+type pkcs7IssuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type pkcs7SignerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     pkcs7IssuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type pkcs7Attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// pkcs7Hash returns the hash function of a digest algorithm. Only SHA-2 is
+// accepted.
+func pkcs7Hash(alg pkix.AlgorithmIdentifier) (crypto.Hash, error) {
+	switch {
+	case alg.Algorithm.Equal(oidSHA256):
+		return crypto.SHA256, nil
+	case alg.Algorithm.Equal(oidSHA384):
+		return crypto.SHA384, nil
+	case alg.Algorithm.Equal(oidSHA512):
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("unsupported PKCS7 digest algorithm %v", alg.Algorithm)
+}
+
+// signatureAlgorithm returns the x509 signature algorithm for a public key
+// algorithm and a hash.
+func signatureAlgorithm(pubkey x509.PublicKeyAlgorithm, hash crypto.Hash) (x509.SignatureAlgorithm, error) {
+	algorithms := map[x509.PublicKeyAlgorithm]map[crypto.Hash]x509.SignatureAlgorithm{
+		x509.RSA: {
+			crypto.SHA256: x509.SHA256WithRSA,
+			crypto.SHA384: x509.SHA384WithRSA,
+			crypto.SHA512: x509.SHA512WithRSA,
+		},
+		x509.ECDSA: {
+			crypto.SHA256: x509.ECDSAWithSHA256,
+			crypto.SHA384: x509.ECDSAWithSHA384,
+			crypto.SHA512: x509.ECDSAWithSHA512,
+		},
+	}
+	if alg, ok := algorithms[pubkey][hash]; ok {
+		return alg, nil
+	}
+	return x509.UnknownSignatureAlgorithm, fmt.Errorf("unsupported PKCS7 signature algorithm: %v with %v", pubkey, hash)
+}
+
+// signedBytes returns the bytes covered by the signature of a signer: the
+// content itself, or the DER encoding of the authenticated attributes, which
+// must then contain the digest of the content.
+func (si *pkcs7SignerInfo) signedBytes(content []byte, hash crypto.Hash) ([]byte, error) {
+	if len(si.AuthenticatedAttributes.FullBytes) == 0 {
+		return content, nil
+	}
+	// the attributes are signed as a SET, not with their implicit tag
+	signed := append([]byte{0x31}, si.AuthenticatedAttributes.FullBytes[1:]...)
+	var attrs []pkcs7Attribute
+	if _, err := asn1.UnmarshalWithParams(signed, &attrs, "set"); err != nil {
+		return nil, fmt.Errorf("invalid PKCS7 authenticated attributes: %v", err)
+	}
+	h := hash.New()
+	h.Write(content)
+	for _, attr := range attrs {
+		if !attr.Type.Equal(oidMessageDigest) || len(attr.Values) != 1 {
+			continue
+		}
+		var digest []byte
+		if _, err := asn1.Unmarshal(attr.Values[0].FullBytes, &digest); err != nil {
+			return nil, fmt.Errorf("invalid PKCS7 message digest: %v", err)
+		}
+		if !bytes.Equal(digest, h.Sum(nil)) {
+			return nil, errors.New("PKCS7 message digest does not match the content")
+		}
+		return signed, nil
+	}
+	return nil, errors.New("PKCS7 authenticated attributes have no message digest")
+}
+
+// isTrusted returns true if cert is one of the trusted certificates, or is
+// issued by one of them, possibly through the given intermediates. Validity
+// periods are not enforced, since the clock is not reliable at boot.
+func isTrusted(cert *x509.Certificate, trusted, intermediates []*x509.Certificate) bool {
+	roots := x509.NewCertPool()
+	for _, t := range trusted {
+		if cert.Equal(t) {
+			return true
+		}
+		roots.AddCert(t)
+	}
+	pool := x509.NewCertPool()
+	for _, c := range intermediates {
+		pool.AddCert(c)
+	}
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: pool,
+		CurrentTime:   cert.NotBefore,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err == nil
+}
+
+// VerifyDetachedPKCS7 verifies a detached PKCS7 (CMS) signature of content, in
+// DER or PEM format, as produced e.g. by `openssl cms -sign -binary` or the
+// kernel's sign-file. At least one signer must have a valid signature and a
+// certificate that is trusted, i.e. one of trusted or issued by one of them.
+func VerifyDetachedPKCS7(content, signature []byte, trusted []*x509.Certificate) error {
+	if block, _ := pem.Decode(signature); block != nil {
+		signature = block.Bytes
+	}
+	var ci pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(signature, &ci); err != nil {
+		return fmt.Errorf("invalid PKCS7 signature: %v", err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return fmt.Errorf("PKCS7 content type is %v, not signed data", ci.ContentType)
+	}
+	var sd pkcs7SignedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return fmt.Errorf("invalid PKCS7 signed data: %v", err)
+	}
+	if !sd.ContentInfo.ContentType.Equal(oidData) || len(sd.ContentInfo.Content.Bytes) != 0 {
+		return errors.New("PKCS7 signature is not a detached signature of data")
+	}
+	var embedded []*x509.Certificate
+	if len(sd.Certificates.Bytes) > 0 {
+		certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+		if err != nil {
+			return fmt.Errorf("invalid PKCS7 certificates: %v", err)
+		}
+		embedded = certs
+	}
+	if len(sd.SignerInfos) == 0 {
+		return errors.New("PKCS7 signature has no signers")
+	}
+	var lastErr error
+	for _, si := range sd.SignerInfos {
+		if lastErr = verifySigner(&si, content, embedded, trusted); lastErr == nil {
+			return nil
+		}
+	}
+	return lastErr
+}
+
+// verifySigner verifies the signature of a single signer.
+func verifySigner(si *pkcs7SignerInfo, content []byte, embedded, trusted []*x509.Certificate) error {
+	var signer *x509.Certificate
+	for _, cert := range append(append([]*x509.Certificate{}, trusted...), embedded...) {
+		if bytes.Equal(cert.RawIssuer, si.IssuerAndSerialNumber.Issuer.FullBytes) &&
+			cert.SerialNumber.Cmp(si.IssuerAndSerialNumber.SerialNumber) == 0 {
+			signer = cert
+			break
+		}
+	}
+	if signer == nil {
+		return errors.New("PKCS7 signer certificate not found")
+	}
+	if !isTrusted(signer, trusted, embedded) {
+		return fmt.Errorf("PKCS7 signer %q is not trusted", signer.Subject.CommonName)
+	}
+	hash, err := pkcs7Hash(si.DigestAlgorithm)
+	if err != nil {
+		return err
+	}
+	algorithm, err := signatureAlgorithm(signer.PublicKeyAlgorithm, hash)
+	if err != nil {
+		return err
+	}
+	signed, err := si.signedBytes(content, hash)
+	if err != nil {
+		return err
+	}
+	if err := signer.CheckSignature(algorithm, signed, si.EncryptedDigest); err != nil {
+		return fmt.Errorf("invalid PKCS7 signature by %q: %v", signer.Subject.CommonName, err)
+	}
+	return nil
+}
+
+// LoadCertificatesFromFile loads the PEM-encoded certificates in a file.
+func LoadCertificatesFromFile(filename string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate found in %s", filename)
+	}
+	return certs, nil
+}
@@ -0,0 +1,78 @@
+package crypto
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	// pkcs7CAFile is the CA that issued pkcs7SignerFile
+	pkcs7CAFile string = "tests/pkcs7_ca.pem"
+	// pkcs7SignerFile is the certificate that signed testDataFile
+	pkcs7SignerFile string = "tests/pkcs7_signer.pem"
+	// pkcs7OtherFile is a self-signed certificate that is not trusted
+	pkcs7OtherFile string = "tests/pkcs7_other.pem"
+	// pkcs7SignatureFile is a DER signature of testDataFile, with signed
+	// attributes
+	pkcs7SignatureFile string = "tests/pkcs7_data.p7s"
+	// pkcs7NoAttrSignatureFile is a PEM signature of testDataFile, without
+	// signed attributes
+	pkcs7NoAttrSignatureFile string = "tests/pkcs7_data_noattr.p7s"
+	// pkcs7UntrustedSignatureFile is a signature of testDataFile by
+	// pkcs7OtherFile
+	pkcs7UntrustedSignatureFile string = "tests/pkcs7_data_untrusted.p7s"
+)
+
+func loadCerts(t *testing.T, filename string) []*x509.Certificate {
+	certs, err := LoadCertificatesFromFile(filename)
+	require.NoError(t, err)
+	require.Len(t, certs, 1)
+	return certs
+}
+
+func readPKCS7TestFiles(t *testing.T, signatureFile string) ([]byte, []byte) {
+	data, err := ioutil.ReadFile(testDataFile)
+	require.NoError(t, err)
+	signature, err := ioutil.ReadFile(signatureFile)
+	require.NoError(t, err)
+	return data, signature
+}
+
+func TestVerifyDetachedPKCS7(t *testing.T) {
+	for _, signatureFile := range []string{pkcs7SignatureFile, pkcs7NoAttrSignatureFile} {
+		data, signature := readPKCS7TestFiles(t, signatureFile)
+		// trusted directly, or through its issuer
+		require.NoError(t, VerifyDetachedPKCS7(data, signature, loadCerts(t, pkcs7SignerFile)), signatureFile)
+		require.NoError(t, VerifyDetachedPKCS7(data, signature, loadCerts(t, pkcs7CAFile)), signatureFile)
+	}
+}
+
+func TestVerifyDetachedPKCS7TamperedContent(t *testing.T) {
+	for _, signatureFile := range []string{pkcs7SignatureFile, pkcs7NoAttrSignatureFile} {
+		data, signature := readPKCS7TestFiles(t, signatureFile)
+		data[0] ^= 0xff
+		require.Error(t, VerifyDetachedPKCS7(data, signature, loadCerts(t, pkcs7CAFile)), signatureFile)
+	}
+}
+
+func TestVerifyDetachedPKCS7Untrusted(t *testing.T) {
+	data, signature := readPKCS7TestFiles(t, pkcs7UntrustedSignatureFile)
+	require.Error(t, VerifyDetachedPKCS7(data, signature, loadCerts(t, pkcs7CAFile)))
+	require.NoError(t, VerifyDetachedPKCS7(data, signature, loadCerts(t, pkcs7OtherFile)))
+
+	data, signature = readPKCS7TestFiles(t, pkcs7SignatureFile)
+	require.Error(t, VerifyDetachedPKCS7(data, signature, loadCerts(t, pkcs7OtherFile)))
+}
+
+func TestVerifyDetachedPKCS7Garbage(t *testing.T) {
+	data, _ := readPKCS7TestFiles(t, pkcs7SignatureFile)
+	require.Error(t, VerifyDetachedPKCS7(data, []byte("not a signature"), loadCerts(t, pkcs7CAFile)))
+}
+
+func TestLoadCertificatesFromFileNoCertificate(t *testing.T) {
+	_, err := LoadCertificatesFromFile(publicKeyPEMFile)
+	require.Error(t, err)
+}
@@ -0,0 +1,62 @@
+package crypto
+
+import (
+	"crypto"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+)
+
+// Quote is a TPM quote, i.e. a digest of PCR values signed by an attestation
+// key, in the formats output by tpm2_quote.
+type Quote struct {
+	// Message is the TPMS_ATTEST structure that was signed
+	Message []byte `json:"message"`
+	// Signature is the TPMT_SIGNATURE of Message
+	Signature []byte `json:"signature"`
+	// PCRs are the values of the quoted PCRs, in ascending order
+	PCRs []byte `json:"pcrs"`
+	// Selection lists the quoted PCRs, in ascending order
+	Selection []int `json:"selection"`
+}
+
+// QuotePCRs asks the TPM for a quote over the selected PCRs in the bank of
+// the given hash algorithm, signed with the attestation key loaded in the
+// akContext file, with the tpm2-tools binaries. The nonce, provided by the
+// verifier, guarantees that the quote is fresh.
+func QuotePCRs(akContext string, selection []int, alg crypto.Hash, nonce []byte) (*Quote, error) {
+	if len(selection) == 0 {
+		return nil, errors.New("no PCR to quote")
+	}
+	pcrs := append([]int(nil), selection...)
+	sort.Ints(pcrs)
+	spec, err := pcrSelection(alg, pcrs)
+	if err != nil {
+		return nil, err
+	}
+	tempDir, err := ioutil.TempDir(os.TempDir(), "quote")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir)
+	msg := path.Join(tempDir, "quote.msg")
+	sig := path.Join(tempDir, "quote.sig")
+	values := path.Join(tempDir, "quote.pcrs")
+	if _, err := runTPM2Tool("tpm2_quote", "-c", akContext, "-l", spec, "-q", hex.EncodeToString(nonce), "-m", msg, "-s", sig, "-o", values, "-g", pcrBanks[alg]); err != nil {
+		return nil, fmt.Errorf("tpm2_quote failed: %v", err)
+	}
+	quote := Quote{Selection: pcrs}
+	for _, f := range []struct {
+		name string
+		data *[]byte
+	}{{msg, &quote.Message}, {sig, &quote.Signature}, {values, &quote.PCRs}} {
+		if *f.data, err = ioutil.ReadFile(f.name); err != nil {
+			return nil, err
+		}
+	}
+	return &quote, nil
+}
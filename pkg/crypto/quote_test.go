@@ -0,0 +1,36 @@
+package crypto
+
+import (
+	"crypto"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuotePCRs(t *testing.T) {
+	var calls [][]string
+	defer func(orig func(string, ...string) ([]byte, error)) { runTPM2Tool = orig }(runTPM2Tool)
+	runTPM2Tool = func(name string, args ...string) ([]byte, error) {
+		calls = append(calls, append([]string{name}, args...))
+		// -m msg -s sig -o pcrs
+		for idx, data := range map[int]string{7: "msg", 9: "sig", 11: "pcrs"} {
+			if err := ioutil.WriteFile(args[idx], []byte(data), 0600); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	}
+
+	quote, err := QuotePCRs("ak.ctx", []int{8, 7}, crypto.SHA256, []byte{0xca, 0xfe})
+	require.NoError(t, err)
+	require.Equal(t, []byte("msg"), quote.Message)
+	require.Equal(t, []byte("sig"), quote.Signature)
+	require.Equal(t, []byte("pcrs"), quote.PCRs)
+	require.Equal(t, []int{7, 8}, quote.Selection)
+	require.Equal(t, 1, len(calls))
+	require.Equal(t, []string{"tpm2_quote", "-c", "ak.ctx", "-l", "sha256:7,8", "-q", "cafe"}, calls[0][:7])
+
+	_, err = QuotePCRs("ak.ctx", nil, crypto.SHA256, nil)
+	require.Error(t, err)
+}
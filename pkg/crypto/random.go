@@ -0,0 +1,25 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TPMRandom returns size random bytes from the RNG of the TPM, with the
+// tpm2-tools binaries.
+func TPMRandom(size int) ([]byte, error) {
+	out, err := runTPM2Tool("tpm2_getrandom", "--hex", strconv.Itoa(size))
+	if err != nil {
+		return nil, fmt.Errorf("tpm2_getrandom failed: %v", err)
+	}
+	data, err := hex.DecodeString(strings.TrimSpace(string(out)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid tpm2_getrandom output: %v", err)
+	}
+	if len(data) != size {
+		return nil, fmt.Errorf("tpm2_getrandom returned %d bytes instead of %d", len(data), size)
+	}
+	return data, nil
+}
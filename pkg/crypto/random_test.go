@@ -0,0 +1,31 @@
+package crypto
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTPMRandom(t *testing.T) {
+	defer func(orig func(string, ...string) ([]byte, error)) { runTPM2Tool = orig }(runTPM2Tool)
+	var args []string
+	runTPM2Tool = func(name string, a ...string) ([]byte, error) {
+		args = append([]string{name}, a...)
+		return []byte("00112233\n"), nil
+	}
+	data, err := TPMRandom(4)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x00, 0x11, 0x22, 0x33}, data)
+	require.Equal(t, []string{"tpm2_getrandom", "--hex", "4"}, args)
+
+	// the TPM returned fewer bytes than requested
+	_, err = TPMRandom(8)
+	require.Error(t, err)
+
+	runTPM2Tool = func(string, ...string) ([]byte, error) {
+		return nil, errors.New("no TPM")
+	}
+	_, err = TPMRandom(4)
+	require.Error(t, err)
+}
@@ -0,0 +1,55 @@
+package crypto
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// TPM2ToolsUnsealer unseals a secret with the tpm2-tools binaries. The secret
+// is a TPM2 sealed data object, stored as a public part (KeyPrefix + ".pub")
+// and a private part (KeyPrefix + ".priv"), created under the owner hierarchy
+// primary key and bound to a PCR policy over the given SHA256 PCRs.
+type TPM2ToolsUnsealer struct {
+	KeyPrefix string
+	PCRs      []int
+}
+
+// runTPM2Tool runs a tpm2-tools command and returns its standard output. It is
+// a variable so it can be overridden for testing.
+var runTPM2Tool = func(name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = os.Stderr
+	return cmd.Output()
+}
+
+// Unseal loads the sealed object into the TPM and unseals it. This fails if
+// the current PCR values don't satisfy the policy the secret was sealed with.
+func (u TPM2ToolsUnsealer) Unseal() ([]byte, error) {
+	tempDir, err := ioutil.TempDir(os.TempDir(), "unseal")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir)
+	primary := path.Join(tempDir, "primary.ctx")
+	key := path.Join(tempDir, "key.ctx")
+	if _, err := runTPM2Tool("tpm2_createprimary", "-C", "o", "-c", primary); err != nil {
+		return nil, fmt.Errorf("tpm2_createprimary failed: %v", err)
+	}
+	if _, err := runTPM2Tool("tpm2_load", "-C", primary, "-u", u.KeyPrefix+".pub", "-r", u.KeyPrefix+".priv", "-c", key); err != nil {
+		return nil, fmt.Errorf("tpm2_load failed: %v", err)
+	}
+	pcrs := make([]string, 0, len(u.PCRs))
+	for _, pcr := range u.PCRs {
+		pcrs = append(pcrs, strconv.Itoa(pcr))
+	}
+	secret, err := runTPM2Tool("tpm2_unseal", "-c", key, "-p", "pcr:sha256:"+strings.Join(pcrs, ","))
+	if err != nil {
+		return nil, fmt.Errorf("tpm2_unseal failed: %v", err)
+	}
+	return secret, nil
+}
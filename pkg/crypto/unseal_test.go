@@ -0,0 +1,43 @@
+package crypto
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTPM2ToolsUnsealer(t *testing.T) {
+	defer func(orig func(string, ...string) ([]byte, error)) { runTPM2Tool = orig }(runTPM2Tool)
+	var commands []string
+	runTPM2Tool = func(name string, args ...string) ([]byte, error) {
+		commands = append(commands, name)
+		if name == "tpm2_unseal" {
+			require.Equal(t, "pcr:sha256:0,7", args[len(args)-1])
+			return []byte("s3cr3t"), nil
+		}
+		if name == "tpm2_load" {
+			require.Contains(t, strings.Join(args, " "), "/esp/luks.pub")
+		}
+		return nil, nil
+	}
+	u := TPM2ToolsUnsealer{KeyPrefix: "/esp/luks", PCRs: []int{0, 7}}
+	secret, err := u.Unseal()
+	require.NoError(t, err)
+	require.Equal(t, []byte("s3cr3t"), secret)
+	require.Equal(t, []string{"tpm2_createprimary", "tpm2_load", "tpm2_unseal"}, commands)
+}
+
+func TestTPM2ToolsUnsealerPolicyFailure(t *testing.T) {
+	defer func(orig func(string, ...string) ([]byte, error)) { runTPM2Tool = orig }(runTPM2Tool)
+	runTPM2Tool = func(name string, args ...string) ([]byte, error) {
+		if name == "tpm2_unseal" {
+			return nil, errors.New("policy check failed")
+		}
+		return nil, nil
+	}
+	u := TPM2ToolsUnsealer{KeyPrefix: "/esp/luks", PCRs: []int{7}}
+	_, err := u.Unseal()
+	require.Error(t, err)
+}
@@ -0,0 +1,290 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/ed25519"
+)
+
+// Signature algorithms, as returned by SignatureAlgorithm.
+const (
+	AlgorithmEd25519  = "ed25519"
+	AlgorithmRSAPSS   = "rsa-pss"
+	AlgorithmECDSA    = "ecdsa"
+	AlgorithmMinisign = "minisign"
+)
+
+// Verifier verifies detached signatures made with one public key.
+type Verifier interface {
+	// Algorithm returns the algorithm of the signatures the verifier
+	// accepts, e.g. AlgorithmEd25519
+	Algorithm() string
+	// Verify returns an error if signature is not a valid signature of data
+	Verify(data, signature []byte) error
+}
+
+// Ed25519Verifier verifies raw 64-byte ed25519 signatures.
+type Ed25519Verifier struct {
+	Key ed25519.PublicKey
+}
+
+// Algorithm implements Verifier.
+func (v *Ed25519Verifier) Algorithm() string { return AlgorithmEd25519 }
+
+// Verify implements Verifier.
+func (v *Ed25519Verifier) Verify(data, signature []byte) error {
+	if len(signature) != ed25519.SignatureSize || !ed25519.Verify(v.Key, data, signature) {
+		return errors.New("invalid ed25519 signature")
+	}
+	return nil
+}
+
+// RSAPSSVerifier verifies RSA-PSS signatures of the SHA256 digest of the data,
+// as made by `openssl dgst -sha256 -sigopt rsa_padding_mode:pss`.
+type RSAPSSVerifier struct {
+	Key *rsa.PublicKey
+}
+
+// Algorithm implements Verifier.
+func (v *RSAPSSVerifier) Algorithm() string { return AlgorithmRSAPSS }
+
+// Verify implements Verifier.
+func (v *RSAPSSVerifier) Verify(data, signature []byte) error {
+	digest := sha256.Sum256(data)
+	opts := rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto}
+	if err := rsa.VerifyPSS(v.Key, crypto.SHA256, digest[:], signature, &opts); err != nil {
+		return fmt.Errorf("invalid RSA-PSS signature: %v", err)
+	}
+	return nil
+}
+
+// ECDSAVerifier verifies DER-encoded ECDSA signatures, as made by `openssl
+// dgst`, of the digest of the data with the hash function matching the curve:
+// SHA256 for P-256, SHA384 for P-384 and SHA512 for P-521.
+type ECDSAVerifier struct {
+	Key *ecdsa.PublicKey
+}
+
+// ecdsaSignature is the DER encoding of an ECDSA signature.
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+// Algorithm implements Verifier.
+func (v *ECDSAVerifier) Algorithm() string { return AlgorithmECDSA }
+
+// Verify implements Verifier.
+func (v *ECDSAVerifier) Verify(data, signature []byte) error {
+	var sig ecdsaSignature
+	if rest, err := asn1.Unmarshal(signature, &sig); err != nil || len(rest) > 0 {
+		return errors.New("invalid ECDSA signature encoding")
+	}
+	var digest []byte
+	switch v.Key.Curve {
+	case elliptic.P384():
+		sum := sha512.Sum384(data)
+		digest = sum[:]
+	case elliptic.P521():
+		sum := sha512.Sum512(data)
+		digest = sum[:]
+	default:
+		sum := sha256.Sum256(data)
+		digest = sum[:]
+	}
+	if sig.R.Sign() <= 0 || sig.S.Sign() <= 0 || !ecdsa.Verify(v.Key, digest, sig.R, sig.S) {
+		return errors.New("invalid ECDSA signature")
+	}
+	return nil
+}
+
+// minisign constants, see https://jedisct1.github.io/minisign/
+const (
+	minisignUntrustedComment = "untrusted comment:"
+	minisignTrustedComment   = "trusted comment: "
+	minisignKeyIDSize        = 8
+)
+
+var (
+	// minisignAlgorithm is the algorithm of keys and legacy signatures, and
+	// minisignPrehashed the one of signatures of the BLAKE2b-512 digest of
+	// the data
+	minisignAlgorithm = []byte("Ed")
+	minisignPrehashed = []byte("ED")
+)
+
+// MinisignVerifier verifies minisign signatures, legacy or prehashed, including
+// their trusted comment.
+type MinisignVerifier struct {
+	KeyID [minisignKeyIDSize]byte
+	Key   ed25519.PublicKey
+}
+
+// Algorithm implements Verifier.
+func (v *MinisignVerifier) Algorithm() string { return AlgorithmMinisign }
+
+// minisignLines returns the non-empty lines of a minisign file, which must
+// start with an untrusted comment.
+func minisignLines(data []byte) ([]string, error) {
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimRight(line, "\r"); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) < 2 || !strings.HasPrefix(lines[0], minisignUntrustedComment) {
+		return nil, errors.New("not in minisign format")
+	}
+	return lines, nil
+}
+
+// Verify implements Verifier.
+func (v *MinisignVerifier) Verify(data, signature []byte) error {
+	lines, err := minisignLines(signature)
+	if err != nil {
+		return err
+	}
+	if len(lines) != 4 || !strings.HasPrefix(lines[2], minisignTrustedComment) {
+		return errors.New("invalid minisign signature: no trusted comment")
+	}
+	sig, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(sig) != 2+minisignKeyIDSize+ed25519.SignatureSize {
+		return errors.New("invalid minisign signature encoding")
+	}
+	if !bytes.Equal(sig[2:2+minisignKeyIDSize], v.KeyID[:]) {
+		return fmt.Errorf("minisign signature is by key %X, not %X", sig[2:2+minisignKeyIDSize], v.KeyID)
+	}
+	signed := data
+	switch alg := sig[:2]; {
+	case bytes.Equal(alg, minisignPrehashed):
+		digest := blake2b.Sum512(data)
+		signed = digest[:]
+	case !bytes.Equal(alg, minisignAlgorithm):
+		return fmt.Errorf("unsupported minisign signature algorithm %q", alg)
+	}
+	sig = sig[2+minisignKeyIDSize:]
+	if !ed25519.Verify(v.Key, signed, sig) {
+		return errors.New("invalid minisign signature")
+	}
+	// the global signature covers the signature and the trusted comment
+	global, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return errors.New("invalid minisign global signature encoding")
+	}
+	comment := strings.TrimPrefix(lines[2], minisignTrustedComment)
+	if !ed25519.Verify(v.Key, append(append([]byte(nil), sig...), comment...), global) {
+		return errors.New("invalid minisign signature of the trusted comment")
+	}
+	return nil
+}
+
+// ed25519PKIXPrefix is the DER prefix of an ed25519 key in PKIX format, see
+// RFC 8410, which the x509 package cannot parse.
+var ed25519PKIXPrefix = []byte{0x30, 0x2a, 0x30, 0x05, 0x06, 0x03, 0x2b, 0x65, 0x70, 0x03, 0x21, 0x00}
+
+// ParseVerifier returns a Verifier for a public key, which can be a minisign
+// public key, or a PEM "PUBLIC KEY" with a PKIX-encoded RSA, ECDSA or ed25519
+// key, or a raw ed25519 key like the ones GeneratED25519Key writes.
+func ParseVerifier(data []byte) (Verifier, error) {
+	if lines, err := minisignLines(data); err == nil {
+		key, err := base64.StdEncoding.DecodeString(lines[1])
+		if err != nil || len(key) != 2+minisignKeyIDSize+ed25519.PublicKeySize || !bytes.Equal(key[:2], minisignAlgorithm) {
+			return nil, errors.New("invalid minisign public key")
+		}
+		v := MinisignVerifier{Key: ed25519.PublicKey(key[2+minisignKeyIDSize:])}
+		copy(v.KeyID[:], key[2:])
+		return &v, nil
+	}
+	var block *pem.Block
+	for {
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no public key found")
+		}
+		if block.Type == PubKeyIdentifier {
+			break
+		}
+	}
+	der := block.Bytes
+	switch {
+	case len(der) == ed25519.PublicKeySize:
+		return &Ed25519Verifier{Key: ed25519.PublicKey(der)}, nil
+	case len(der) == len(ed25519PKIXPrefix)+ed25519.PublicKeySize && bytes.HasPrefix(der, ed25519PKIXPrefix):
+		return &Ed25519Verifier{Key: ed25519.PublicKey(der[len(ed25519PKIXPrefix):])}, nil
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %v", err)
+	}
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return &RSAPSSVerifier{Key: k}, nil
+	case *ecdsa.PublicKey:
+		return &ECDSAVerifier{Key: k}, nil
+	}
+	return nil, fmt.Errorf("unsupported public key type %T", key)
+}
+
+// LoadVerifierFromFile reads a public key file and returns its Verifier, see
+// ParseVerifier.
+func LoadVerifierFromFile(publicKeyPath string) (Verifier, error) {
+	data, err := ioutil.ReadFile(publicKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	v, err := ParseVerifier(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", publicKeyPath, err)
+	}
+	return v, nil
+}
+
+// SignatureAlgorithm guesses the algorithm of a signature from its format: a
+// minisign signature starts with an untrusted comment, an ECDSA signature is
+// DER-encoded, an ed25519 signature is 64 raw bytes, and anything else is
+// taken as an RSA-PSS signature.
+func SignatureAlgorithm(signature []byte) string {
+	if bytes.HasPrefix(signature, []byte(minisignUntrustedComment)) {
+		return AlgorithmMinisign
+	}
+	var sig ecdsaSignature
+	if rest, err := asn1.Unmarshal(signature, &sig); err == nil && len(rest) == 0 {
+		return AlgorithmECDSA
+	}
+	if len(signature) == ed25519.SignatureSize {
+		return AlgorithmEd25519
+	}
+	return AlgorithmRSAPSS
+}
+
+// VerifySignature verifies a detached signature of data with the verifiers
+// of the signature's algorithm, see SignatureAlgorithm. It succeeds if any of
+// them accepts the signature.
+func VerifySignature(data, signature []byte, verifiers ...Verifier) error {
+	alg := SignatureAlgorithm(signature)
+	err := fmt.Errorf("no key to verify %s signatures", alg)
+	for _, v := range verifiers {
+		if v.Algorithm() != alg {
+			continue
+		}
+		if err = v.Verify(data, signature); err == nil {
+			return nil
+		}
+	}
+	return err
+}
@@ -0,0 +1,166 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/ed25519"
+)
+
+var (
+	verifierTestData = []byte("kernel /vmlinuz root=/dev/sda1\n")
+	tamperedTestData = []byte("kernel /vmlinuz root=/dev/sda1 init=/bin/sh\n")
+)
+
+// parsePEMKey wraps a DER public key in PEM and parses it with ParseVerifier.
+func parsePEMKey(t *testing.T, der []byte) Verifier {
+	v, err := ParseVerifier(pem.EncodeToMemory(&pem.Block{Type: PubKeyIdentifier, Bytes: der}))
+	require.NoError(t, err)
+	return v
+}
+
+// requireVerifies checks that the verifier accepts signature for
+// verifierTestData only, both directly and through VerifySignature.
+func requireVerifies(t *testing.T, v Verifier, signature []byte) {
+	require.Equal(t, v.Algorithm(), SignatureAlgorithm(signature))
+	require.NoError(t, v.Verify(verifierTestData, signature))
+	require.NoError(t, VerifySignature(verifierTestData, signature, v))
+	require.Error(t, v.Verify(tamperedTestData, signature))
+	require.Error(t, VerifySignature(tamperedTestData, signature, v))
+}
+
+func TestEd25519Verifier(t *testing.T) {
+	pubkey, privkey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	v := parsePEMKey(t, pubkey)
+	require.Equal(t, &Ed25519Verifier{Key: pubkey}, v)
+	requireVerifies(t, v, ed25519.Sign(privkey, verifierTestData))
+
+	// the same key in PKIX format
+	require.Equal(t, v, parsePEMKey(t, append(append([]byte(nil), ed25519PKIXPrefix...), pubkey...)))
+}
+
+func TestEd25519VerifierKeyFile(t *testing.T) {
+	v, err := LoadVerifierFromFile(publicKeyPEMFile)
+	require.NoError(t, err)
+	require.Equal(t, AlgorithmEd25519, v.Algorithm())
+}
+
+func TestRSAPSSVerifier(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	v := parsePEMKey(t, der)
+	require.Equal(t, AlgorithmRSAPSS, v.Algorithm())
+
+	digest := sha256.Sum256(verifierTestData)
+	signature, err := rsa.SignPSS(rand.Reader, key, crypto.SHA256, digest[:], nil)
+	require.NoError(t, err)
+	requireVerifies(t, v, signature)
+
+	// a PKCS1 v1.5 signature is refused
+	signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+	require.Error(t, VerifySignature(verifierTestData, signature, v))
+}
+
+func TestECDSAVerifier(t *testing.T) {
+	for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P384()} {
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		require.NoError(t, err)
+		der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		require.NoError(t, err)
+		v := parsePEMKey(t, der)
+		require.Equal(t, AlgorithmECDSA, v.Algorithm())
+
+		var digest []byte
+		if curve == elliptic.P384() {
+			sum := sha512.Sum384(verifierTestData)
+			digest = sum[:]
+		} else {
+			sum := sha256.Sum256(verifierTestData)
+			digest = sum[:]
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+		require.NoError(t, err)
+		signature, err := asn1.Marshal(ecdsaSignature{R: r, S: s})
+		require.NoError(t, err)
+		requireVerifies(t, v, signature)
+	}
+}
+
+// minisignKey returns a minisign public key file and its private key.
+func minisignKey(t *testing.T, keyID []byte) ([]byte, ed25519.PrivateKey) {
+	pubkey, privkey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key := append(append([]byte("Ed"), keyID...), pubkey...)
+	return []byte("untrusted comment: minisign public key\n" + base64.StdEncoding.EncodeToString(key) + "\n"), privkey
+}
+
+// minisignSign signs data like `minisign -S`, prehashed with alg "ED", or not
+// with alg "Ed".
+func minisignSign(privkey ed25519.PrivateKey, keyID []byte, alg string, data []byte) []byte {
+	signed := data
+	if alg == "ED" {
+		digest := blake2b.Sum512(data)
+		signed = digest[:]
+	}
+	sig := ed25519.Sign(privkey, signed)
+	comment := "timestamp:1546300800\tfile:vmlinuz"
+	global := ed25519.Sign(privkey, append(append([]byte(nil), sig...), comment...))
+	return []byte("untrusted comment: signature from minisign secret key\n" +
+		base64.StdEncoding.EncodeToString(append(append([]byte(alg), keyID...), sig...)) + "\n" +
+		"trusted comment: " + comment + "\n" +
+		base64.StdEncoding.EncodeToString(global) + "\n")
+}
+
+func TestMinisignVerifier(t *testing.T) {
+	keyID := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	pubkey, privkey := minisignKey(t, keyID)
+	v, err := ParseVerifier(pubkey)
+	require.NoError(t, err)
+	require.Equal(t, AlgorithmMinisign, v.Algorithm())
+
+	for _, alg := range []string{"Ed", "ED"} {
+		requireVerifies(t, v, minisignSign(privkey, keyID, alg, verifierTestData))
+	}
+
+	// a signature by another key
+	otherID := []byte{8, 7, 6, 5, 4, 3, 2, 1}
+	_, otherKey := minisignKey(t, otherID)
+	require.Error(t, v.Verify(verifierTestData, minisignSign(otherKey, otherID, "ED", verifierTestData)))
+
+	// a tampered trusted comment
+	signature := minisignSign(privkey, keyID, "ED", verifierTestData)
+	tampered := bytes.Replace(signature, []byte("file:vmlinuz"), []byte("file:initrd"), 1)
+	require.NoError(t, v.Verify(verifierTestData, signature))
+	require.Error(t, v.Verify(verifierTestData, tampered))
+}
+
+func TestVerifySignatureSelectsAlgorithm(t *testing.T) {
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	verifiers := []Verifier{&ECDSAVerifier{Key: &ecKey.PublicKey}, &Ed25519Verifier{Key: edPub}}
+
+	require.NoError(t, VerifySignature(verifierTestData, ed25519.Sign(edPriv, verifierTestData), verifiers...))
+	// there is no key for minisign signatures
+	keyID := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	_, minisignPriv := minisignKey(t, keyID)
+	require.Error(t, VerifySignature(verifierTestData, minisignSign(minisignPriv, keyID, "ED", verifierTestData), verifiers...))
+}
@@ -0,0 +1,123 @@
+// Package duid generates DHCPv6 DHCP Unique Identifiers (DUIDs), see RFC 8415,
+// section 11. The DUIDs are returned in their wire format.
+package duid
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DUID types
+const (
+	TypeLLT  uint16 = 1
+	TypeEN   uint16 = 2
+	TypeLL   uint16 = 3
+	TypeUUID uint16 = 4
+)
+
+// HWTypeEthernet is the IANA hardware type of Ethernet. The IANA hardware
+// types match the ARPHRD_* types reported by Linux for the usual link layers.
+const HWTypeEthernet uint16 = 1
+
+// maxLen is the maximum length of a DUID, including its type.
+const maxLen = 130
+
+// epoch is the reference time of DUID-LLT timestamps.
+var epoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// LL returns a DUID-LL built from a hardware address of the given IANA
+// hardware type, e.g. HWTypeEthernet.
+func LL(hwtype uint16, hwaddr net.HardwareAddr) ([]byte, error) {
+	if len(hwaddr) == 0 {
+		return nil, errors.New("empty hardware address")
+	}
+	duid := make([]byte, 4, 4+len(hwaddr))
+	binary.BigEndian.PutUint16(duid[0:2], TypeLL)
+	binary.BigEndian.PutUint16(duid[2:4], hwtype)
+	return append(duid, hwaddr...), nil
+}
+
+// LLT returns a DUID-LLT built from a hardware address of the given IANA
+// hardware type and a time. Times before 2000, e.g. from a reset RTC, are
+// encoded as 0.
+func LLT(hwtype uint16, hwaddr net.HardwareAddr, t time.Time) ([]byte, error) {
+	if len(hwaddr) == 0 {
+		return nil, errors.New("empty hardware address")
+	}
+	if t.Before(epoch) {
+		t = epoch
+	}
+	duid := make([]byte, 8, 8+len(hwaddr))
+	binary.BigEndian.PutUint16(duid[0:2], TypeLLT)
+	binary.BigEndian.PutUint16(duid[2:4], hwtype)
+	binary.BigEndian.PutUint32(duid[4:8], uint32(t.Sub(epoch)/time.Second))
+	return append(duid, hwaddr...), nil
+}
+
+// UUID returns a DUID-UUID built from a UUID in its textual form, e.g. the
+// SMBIOS system UUID.
+func UUID(uuid string) ([]byte, error) {
+	raw, err := hex.DecodeString(strings.Replace(strings.TrimSpace(uuid), "-", "", -1))
+	if err != nil || len(raw) != 16 {
+		return nil, fmt.Errorf("invalid UUID %q", uuid)
+	}
+	duid := make([]byte, 2, 18)
+	binary.BigEndian.PutUint16(duid[0:2], TypeUUID)
+	return append(duid, raw...), nil
+}
+
+// Parse parses a DUID written in hexadecimal, optionally with colons
+// between the bytes, e.g. `00:03:00:01:de:ad:be:ef:00:01`.
+func Parse(s string) ([]byte, error) {
+	duid, err := hex.DecodeString(strings.Replace(s, ":", "", -1))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DUID %q: %v", s, err)
+	}
+	if len(duid) < 3 || len(duid) > maxLen {
+		return nil, fmt.Errorf("invalid DUID %q: length must be between 3 and %d bytes", s, maxLen)
+	}
+	return duid, nil
+}
+
+// String formats a DUID as colon-separated hexadecimal bytes, the format
+// accepted by Parse.
+func String(duid []byte) string {
+	parts := make([]string, 0, len(duid))
+	for _, b := range duid {
+		parts = append(parts, fmt.Sprintf("%02x", b))
+	}
+	return strings.Join(parts, ":")
+}
+
+// PersistentTime returns the time stored in the given file, as seconds since
+// the Unix epoch. If the file doesn't exist, now is stored in it first, so
+// that DUID-LLTs built from it stay the same across boots.
+func PersistentTime(filename string, now time.Time) (time.Time, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err == nil {
+		secs, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid timestamp in %s: %v", filename, err)
+		}
+		return time.Unix(secs, 0), nil
+	}
+	if !os.IsNotExist(err) {
+		return time.Time{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return time.Time{}, err
+	}
+	if err := ioutil.WriteFile(filename, []byte(strconv.FormatInt(now.Unix(), 10)+"\n"), 0644); err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(now.Unix(), 0), nil
+}
@@ -0,0 +1,105 @@
+package duid
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testHWAddr = net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+
+func TestLL(t *testing.T) {
+	duid, err := LL(HWTypeEthernet, testHWAddr)
+	require.NoError(t, err)
+	require.Equal(t, []byte{
+		0x00, 0x03, // DUID-LL
+		0x00, 0x01, // Ethernet
+		0x00, 0x11, 0x22, 0x33, 0x44, 0x55,
+	}, duid)
+}
+
+func TestLLInfiniBand(t *testing.T) {
+	duid, err := LL(32, testHWAddr)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x00, 0x20}, duid[2:4])
+}
+
+func TestLLEmptyHardwareAddr(t *testing.T) {
+	_, err := LL(HWTypeEthernet, nil)
+	require.Error(t, err)
+}
+
+func TestLLT(t *testing.T) {
+	duid, err := LLT(HWTypeEthernet, testHWAddr, time.Date(2018, time.January, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Equal(t, []byte{
+		0x00, 0x01, // DUID-LLT
+		0x00, 0x01, // Ethernet
+		0x21, 0xdc, 0x36, 0x80, // 568080000 seconds since 2000-01-01
+		0x00, 0x11, 0x22, 0x33, 0x44, 0x55,
+	}, duid)
+}
+
+func TestLLTBeforeEpoch(t *testing.T) {
+	duid, err := LLT(HWTypeEthernet, testHWAddr, time.Unix(0, 0))
+	require.NoError(t, err)
+	require.Equal(t, []byte{0, 0, 0, 0}, duid[4:8])
+}
+
+func TestUUID(t *testing.T) {
+	duid, err := UUID("4c4c4544-0042-3510-8052-b4c04f4d4b32\n")
+	require.NoError(t, err)
+	require.Equal(t, []byte{
+		0x00, 0x04, // DUID-UUID
+		0x4c, 0x4c, 0x45, 0x44, 0x00, 0x42, 0x35, 0x10,
+		0x80, 0x52, 0xb4, 0xc0, 0x4f, 0x4d, 0x4b, 0x32,
+	}, duid)
+}
+
+func TestUUIDInvalid(t *testing.T) {
+	_, err := UUID("4c4c4544-0042-3510")
+	require.Error(t, err)
+	_, err = UUID("not a uuid")
+	require.Error(t, err)
+}
+
+func TestParse(t *testing.T) {
+	expected := []byte{0x00, 0x03, 0x00, 0x01, 0xde, 0xad, 0xbe, 0xef, 0x00, 0x01}
+	for _, s := range []string{"00:03:00:01:de:ad:be:ef:00:01", "00030001deadbeef0001"} {
+		duid, err := Parse(s)
+		require.NoError(t, err)
+		require.Equal(t, expected, duid)
+		require.Equal(t, "00:03:00:01:de:ad:be:ef:00:01", String(duid))
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, s := range []string{"", "0003", "xyz", "00:03:0"} {
+		_, err := Parse(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestPersistentTime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "duid")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "systemboot", "duid-time")
+
+	first, err := PersistentTime(filename, time.Unix(1514764800, 0))
+	require.NoError(t, err)
+	require.Equal(t, int64(1514764800), first.Unix())
+	// later boots get the stored time, whatever the clock says
+	second, err := PersistentTime(filename, time.Unix(0, 0))
+	require.NoError(t, err)
+	require.Equal(t, first.Unix(), second.Unix())
+
+	require.NoError(t, ioutil.WriteFile(filename, []byte("garbage"), 0644))
+	_, err = PersistentTime(filename, time.Now())
+	require.Error(t, err)
+}
@@ -0,0 +1,116 @@
+package fetch
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"path"
+	"strings"
+)
+
+// Checksum is the expected digest of a fetched file.
+type Checksum struct {
+	// Algorithm is the name of the hash algorithm, e.g. "sha256"
+	Algorithm string
+	Value     []byte
+}
+
+// String returns the checksum in `algorithm:hexdigest` format.
+func (c Checksum) String() string {
+	return fmt.Sprintf("%s:%x", c.Algorithm, c.Value)
+}
+
+// Verify checks that data matches the checksum. On mismatch, the returned
+// error contains both the expected and the actual digests.
+func (c Checksum) Verify(data []byte) error {
+	h, err := newHash(c.Algorithm)
+	if err != nil {
+		return err
+	}
+	h.Write(data)
+	actual := h.Sum(nil)
+	if !bytes.Equal(actual, c.Value) {
+		return fmt.Errorf("%s mismatch: expected %x, got %x", c.Algorithm, c.Value, actual)
+	}
+	return nil
+}
+
+// ParseChecksum parses a checksum in `algorithm:hexdigest` format, as returned
+// by Checksum.String.
+func ParseChecksum(s string) (*Checksum, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid checksum %q: want algorithm:hexdigest", s)
+	}
+	h, err := newHash(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	value, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s checksum %q: %v", parts[0], parts[1], err)
+	}
+	if len(value) != h.Size() {
+		return nil, fmt.Errorf("invalid %s checksum length: want %d bytes, got %d", parts[0], h.Size(), len(value))
+	}
+	return &Checksum{Algorithm: parts[0], Value: value}, nil
+}
+
+// sidecarAlgorithms lists the supported sidecar checksum file extensions, in
+// the order in which they are tried.
+var sidecarAlgorithms = []string{"sha256", "sha512"}
+
+func newHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported checksum algorithm %q", algorithm)
+	}
+}
+
+// ParseSidecar parses the content of a sidecar checksum file, like
+// `vmlinuz.sha256`, for the file called filename. Both the coreutils format
+// (`<hex>  <filename>`, one file per line) and a bare hex digest are accepted.
+// A coreutils line is only used if it names filename, even if it is the only
+// line in the file.
+func ParseSidecar(data []byte, algorithm, filename string) (*Checksum, error) {
+	h, err := newHash(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	var digest string
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) == 1 {
+			digest = fields[0]
+			break
+		}
+		// coreutils marks binary mode with a leading '*'
+		name := strings.TrimPrefix(fields[1], "*")
+		if path.Base(name) == filename {
+			digest = fields[0]
+			break
+		}
+	}
+	if digest == "" {
+		return nil, fmt.Errorf("no %s checksum found for %s", algorithm, filename)
+	}
+	value, err := hex.DecodeString(digest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s checksum %q: %v", algorithm, digest, err)
+	}
+	if len(value) != h.Size() {
+		return nil, fmt.Errorf("invalid %s checksum length: want %d bytes, got %d", algorithm, h.Size(), len(value))
+	}
+	return &Checksum{Algorithm: algorithm, Value: value}, nil
+}
@@ -0,0 +1,65 @@
+package fetch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// sha256 of "kernel"
+const kernelSHA256 = "6923dd1bc0460082c5d55a831908c24a282860b7f1cd6c2b79cf1bc8857c639c"
+
+func TestParseSidecarCoreutils(t *testing.T) {
+	c, err := ParseSidecar([]byte(kernelSHA256+"  vmlinuz\n"), "sha256", "vmlinuz")
+	require.NoError(t, err)
+	require.Equal(t, "sha256", c.Algorithm)
+	require.Equal(t, "sha256:"+kernelSHA256, c.String())
+}
+
+func TestParseSidecarBareDigest(t *testing.T) {
+	c, err := ParseSidecar([]byte(kernelSHA256+"\n"), "sha256", "vmlinuz")
+	require.NoError(t, err)
+	require.Equal(t, "sha256:"+kernelSHA256, c.String())
+}
+
+func TestParseSidecarMultipleFiles(t *testing.T) {
+	data := []byte("0000000000000000000000000000000000000000000000000000000000000000  initrd.img\n" +
+		kernelSHA256 + " *boot/vmlinuz\n")
+	c, err := ParseSidecar(data, "sha256", "vmlinuz")
+	require.NoError(t, err)
+	require.Equal(t, "sha256:"+kernelSHA256, c.String())
+}
+
+func TestParseSidecarOtherFile(t *testing.T) {
+	_, err := ParseSidecar([]byte(kernelSHA256+"  initrd.img\n"), "sha256", "vmlinuz")
+	require.Error(t, err)
+}
+
+func TestParseSidecarInvalid(t *testing.T) {
+	_, err := ParseSidecar([]byte("nothex  vmlinuz\n"), "sha256", "vmlinuz")
+	require.Error(t, err)
+	// valid hex, but it's a sha1
+	_, err = ParseSidecar([]byte("da39a3ee5e6b4b0d3255bfef95601890afd80709\n"), "sha256", "vmlinuz")
+	require.Error(t, err)
+	_, err = ParseSidecar([]byte(kernelSHA256), "md5", "vmlinuz")
+	require.Error(t, err)
+}
+
+func TestChecksumVerify(t *testing.T) {
+	c, err := ParseSidecar([]byte(kernelSHA256), "sha256", "vmlinuz")
+	require.NoError(t, err)
+	require.NoError(t, c.Verify([]byte("kernel")))
+	err = c.Verify([]byte("tampered kernel"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), kernelSHA256)
+}
+
+func TestParseChecksum(t *testing.T) {
+	c, err := ParseChecksum("sha256:" + kernelSHA256)
+	require.NoError(t, err)
+	require.NoError(t, c.Verify([]byte("kernel")))
+	_, err = ParseChecksum(kernelSHA256)
+	require.Error(t, err)
+	_, err = ParseChecksum("sha512:" + kernelSHA256)
+	require.Error(t, err)
+}
@@ -0,0 +1,329 @@
+package fetch
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"time"
+)
+
+// Default values used by NewFetcher
+const (
+	DefaultAttempts      = 3
+	DefaultRetryInterval = time.Second
+)
+
+// StatusError is returned when the server replies with a status code other
+// than 200 OK.
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("fetching %s: status code is not 200 OK: %d", e.URL, e.StatusCode)
+}
+
+// Fetcher downloads files over HTTP or SFTP, retrying HTTP on transient
+// errors, and verifies them against a checksum when one is available.
+type Fetcher struct {
+	Client        *http.Client
+	Attempts      int
+	RetryInterval time.Duration
+	// SFTP configures the access to sftp:// URLs, which are rejected if it is
+	// nil.
+	SFTP *SFTPConfig
+	// OnFetch, if set, is called with the outcome of every FetchFile call.
+	OnFetch func(Report)
+	// RequireChecksums makes Fetch fail if no checksum is provided and no
+	// sidecar checksum file can be found for the fetched URL.
+	RequireChecksums bool
+	// MaxSize, if positive, is the size in bytes above which a download is
+	// aborted, since fetched files are held in memory.
+	MaxSize int64
+}
+
+// NewFetcher returns a Fetcher with default settings.
+func NewFetcher() *Fetcher {
+	return &Fetcher{
+		Client:        &http.Client{},
+		Attempts:      DefaultAttempts,
+		RetryInterval: DefaultRetryInterval,
+	}
+}
+
+func retryableNetError(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch err := err.(type) {
+	case net.Error:
+		if err.Timeout() {
+			return true
+		}
+	case *url.Error:
+		return retryableNetError(err.Err)
+	}
+	return false
+}
+
+func retryableHTTPError(resp *http.Response) bool {
+	if resp == nil {
+		return false
+	}
+	if resp.StatusCode == 500 || resp.StatusCode == 502 {
+		return true
+	}
+	return false
+}
+
+// File is a file fetched by a Fetcher.
+type File struct {
+	URL         string
+	ContentType string
+	Data        []byte
+}
+
+// get downloads the given URL with the given client, retrying on transient
+// errors.
+func (f *Fetcher) get(client *http.Client, u *url.URL) (*File, error) {
+	var (
+		resp *http.Response
+		err  error
+	)
+	attempts := f.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	for attempt := 0; attempt < attempts; attempt++ {
+		log.Printf("fetch: attempt %d for http.Get of %s", attempt+1, redacted(u))
+		resp, err = client.Get(u.String())
+		if err != nil && retryableNetError(err) || retryableHTTPError(resp) {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			time.Sleep(f.RetryInterval)
+			continue
+		}
+		break
+	}
+	if err != nil {
+		// url.Error embeds the URL, credentials included
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		return nil, fmt.Errorf("http.Get of %s failed: %v", redacted(u), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, &StatusError{URL: redacted(u), StatusCode: resp.StatusCode}
+	}
+	if f.MaxSize > 0 && resp.ContentLength > f.MaxSize {
+		return nil, fmt.Errorf("%s is %d bytes, more than the maximum of %d", redacted(u), resp.ContentLength, f.MaxSize)
+	}
+	body, err := readAll(resp.Body, f.MaxSize)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s: %v", redacted(u), err)
+	}
+	return &File{
+		URL:         u.String(),
+		ContentType: resp.Header.Get("Content-Type"),
+		Data:        body,
+	}, nil
+}
+
+// readAll reads r until EOF, failing if it is larger than maxSize bytes, unless
+// maxSize is not positive.
+func readAll(r io.Reader, maxSize int64) ([]byte, error) {
+	if maxSize <= 0 {
+		return ioutil.ReadAll(r)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("larger than the maximum of %d bytes", maxSize)
+	}
+	return data, nil
+}
+
+// sameHostRedirects returns a redirect policy that refuses to leave the host
+// of the origin URL, so its credentials are never sent anywhere else.
+func sameHostRedirects(origin *url.URL) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if req.URL.Host != origin.Host {
+			return fmt.Errorf("refusing to follow redirect from %s to %s", origin.Host, req.URL.Host)
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+}
+
+// getURL downloads the given URL with the backend matching its scheme. client
+// is used for HTTP.
+func (f *Fetcher) getURL(client *http.Client, u *url.URL) (*File, error) {
+	switch u.Scheme {
+	case "http", "https":
+		return f.get(client, u)
+	case "sftp":
+		if f.SFTP == nil {
+			return nil, fmt.Errorf("SFTP is not configured, cannot fetch %s", redacted(u))
+		}
+		return f.SFTP.get(u, f.MaxSize)
+	}
+	return nil, fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+}
+
+// isNotFound tells whether err reports that the fetched file does not exist,
+// as opposed to a server or network failure.
+func isNotFound(err error) bool {
+	if serr, ok := err.(*StatusError); ok {
+		return serr.StatusCode == http.StatusNotFound
+	}
+	return os.IsNotExist(err)
+}
+
+// fetchSidecar looks for a sidecar checksum file next to the given URL, e.g.
+// `vmlinuz.sha256` for `vmlinuz`. It returns nil without error if no sidecar
+// file exists. Any other failure, like a server error or a timeout, is
+// returned, so that an unreachable sidecar is not mistaken for a missing one.
+func (f *Fetcher) fetchSidecar(u *url.URL) (*Checksum, error) {
+	client := f.Client
+	if u.User != nil {
+		// the boot URL's credentials are also used for the sidecar, but
+		// must not follow redirects to other hosts
+		c := *f.Client
+		c.CheckRedirect = sameHostRedirects(u)
+		client = &c
+	}
+	filename := path.Base(u.Path)
+	for _, algorithm := range sidecarAlgorithms {
+		sidecar := *u
+		sidecar.Path += "." + algorithm
+		sidecar.RawPath = ""
+		file, err := f.getURL(client, &sidecar)
+		if err != nil {
+			if !isNotFound(err) {
+				return nil, fmt.Errorf("cannot fetch sidecar %s: %v", redacted(&sidecar), err)
+			}
+			log.Printf("fetch: no %s sidecar for %s: %v", algorithm, filename, err)
+			continue
+		}
+		checksum, err := ParseSidecar(file.Data, algorithm, filename)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sidecar %s: %v", redacted(&sidecar), err)
+		}
+		return checksum, nil
+	}
+	return nil, nil
+}
+
+// Fetch downloads the given URL and verifies it against checksum. If checksum
+// is nil, Fetch looks for a sidecar checksum file next to the URL and verifies
+// against that instead.
+func (f *Fetcher) Fetch(rawurl string, checksum *Checksum) ([]byte, error) {
+	file, err := f.FetchFile(rawurl, checksum)
+	if err != nil {
+		return nil, err
+	}
+	return file.Data, nil
+}
+
+// Report describes the outcome of a FetchFile call, for diagnostics.
+type Report struct {
+	// URL is the fetched URL, with the password masked out
+	URL string `json:"url"`
+	// StatusCode is the HTTP status code, or 0 if there was no HTTP reply
+	StatusCode int `json:"status_code,omitempty"`
+	Size       int `json:"size"`
+	// Checksum is the checksum the file was verified against, if any
+	Checksum string `json:"checksum,omitempty"`
+	Verified bool   `json:"verified"`
+	Error    string `json:"error,omitempty"`
+}
+
+// FetchFile is like Fetch, but also returns information about the fetched
+// file, like its content type.
+func (f *Fetcher) FetchFile(rawurl string, checksum *Checksum) (*File, error) {
+	var report Report
+	file, err := f.fetchFile(rawurl, checksum, &report)
+	if err != nil {
+		report.Error = err.Error()
+		if serr, ok := err.(*StatusError); ok {
+			report.StatusCode = serr.StatusCode
+		}
+	}
+	if f.OnFetch != nil {
+		f.OnFetch(report)
+	}
+	return file, err
+}
+
+func (f *Fetcher) fetchFile(rawurl string, checksum *Checksum, report *Report) (*File, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse URL %s: %v", rawurl, err)
+	}
+	report.URL = redacted(u)
+	file, err := f.getURL(f.Client, u)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "http" || u.Scheme == "https" {
+		report.StatusCode = http.StatusOK
+	}
+	report.Size = len(file.Data)
+	if checksum == nil {
+		checksum, err = f.fetchSidecar(u)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if checksum == nil {
+		if f.RequireChecksums {
+			return nil, fmt.Errorf("no checksum available for %s", redacted(u))
+		}
+		log.Printf("fetch: no checksum available for %s, not verifying", redacted(u))
+		return file, nil
+	}
+	report.Checksum = checksum.String()
+	if err := checksum.Verify(file.Data); err != nil {
+		return nil, fmt.Errorf("verification of %s failed: %v", redacted(u), err)
+	}
+	report.Verified = true
+	log.Printf("fetch: %s verified against %s", redacted(u), checksum)
+	return file, nil
+}
+
+// Redact returns rawurl with the password masked out, so it can be logged. It
+// is returned unchanged if it cannot be parsed.
+func Redact(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return rawurl
+	}
+	return redacted(u)
+}
+
+// redacted returns the URL as a string, with the password masked out, so it
+// can be logged.
+func redacted(u *url.URL) string {
+	if u.User == nil {
+		return u.String()
+	}
+	c := *u
+	if _, ok := c.User.Password(); ok {
+		c.User = url.UserPassword(c.User.Username(), "xxxxx")
+	}
+	return c.String()
+}
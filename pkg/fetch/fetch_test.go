@@ -0,0 +1,226 @@
+package fetch
+
+import (
+	"crypto/sha512"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTestFetcher and newFileServer are the helpers of the fetchtest package,
+// which cannot be used here since it imports this package.
+func newTestFetcher() *Fetcher {
+	f := NewFetcher()
+	f.RetryInterval = 0
+	return f
+}
+
+// newFileServer returns a test server serving the given path:content map.
+// Paths not in the map get a 404.
+func newFileServer(files map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, content)
+	}))
+}
+
+func TestFetchWithSidecar(t *testing.T) {
+	ts := newFileServer(map[string]string{
+		"/boot/vmlinuz":        "kernel",
+		"/boot/vmlinuz.sha256": kernelSHA256 + "  vmlinuz\n",
+	})
+	defer ts.Close()
+	data, err := newTestFetcher().Fetch(ts.URL+"/boot/vmlinuz", nil)
+	require.NoError(t, err)
+	require.Equal(t, []byte("kernel"), data)
+}
+
+func TestFetchWithSHA512Sidecar(t *testing.T) {
+	ts := newFileServer(map[string]string{
+		"/vmlinuz":        "kernel",
+		"/vmlinuz.sha512": fmt.Sprintf("%x\n", sha512.Sum512([]byte("kernel"))),
+	})
+	defer ts.Close()
+	f := newTestFetcher()
+	f.RequireChecksums = true
+	data, err := f.Fetch(ts.URL+"/vmlinuz", nil)
+	require.NoError(t, err)
+	require.Equal(t, []byte("kernel"), data)
+}
+
+func TestFetchWithMismatchingSidecar(t *testing.T) {
+	ts := newFileServer(map[string]string{
+		"/vmlinuz":        "tampered kernel",
+		"/vmlinuz.sha256": kernelSHA256 + "  vmlinuz\n",
+	})
+	defer ts.Close()
+	_, err := newTestFetcher().Fetch(ts.URL+"/vmlinuz", nil)
+	require.Error(t, err)
+	// both digests are reported
+	require.Contains(t, err.Error(), kernelSHA256)
+	require.Contains(t, err.Error(), "6426cd8500dbb7550adce2c226e9e6643cd8156639a4b25d2812ec9b299524b3")
+}
+
+func TestFetchWithoutSidecar(t *testing.T) {
+	ts := newFileServer(map[string]string{
+		"/vmlinuz": "kernel",
+	})
+	defer ts.Close()
+	data, err := newTestFetcher().Fetch(ts.URL+"/vmlinuz", nil)
+	require.NoError(t, err)
+	require.Equal(t, []byte("kernel"), data)
+}
+
+func TestFetchSidecarServerError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/vmlinuz" {
+			fmt.Fprint(w, "kernel")
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+	_, err := newTestFetcher().Fetch(ts.URL+"/vmlinuz", nil)
+	require.Error(t, err)
+}
+
+func TestFetchWithoutSidecarRequired(t *testing.T) {
+	ts := newFileServer(map[string]string{
+		"/vmlinuz": "kernel",
+	})
+	defer ts.Close()
+	f := newTestFetcher()
+	f.RequireChecksums = true
+	_, err := f.Fetch(ts.URL+"/vmlinuz", nil)
+	require.Error(t, err)
+}
+
+func TestFetchMaxSize(t *testing.T) {
+	ts := newFileServer(map[string]string{
+		"/vmlinuz": "kernel",
+	})
+	defer ts.Close()
+	f := newTestFetcher()
+	f.MaxSize = 5
+	_, err := f.Fetch(ts.URL+"/vmlinuz", nil)
+	require.Error(t, err)
+	f.MaxSize = 6
+	data, err := f.Fetch(ts.URL+"/vmlinuz", nil)
+	require.NoError(t, err)
+	require.Equal(t, []byte("kernel"), data)
+
+	// without a Content-Length, the body is cut off
+	_, err = readAll(strings.NewReader("kernel"), 5)
+	require.Error(t, err)
+}
+
+func TestFetchExplicitChecksumSkipsSidecar(t *testing.T) {
+	ts := newFileServer(map[string]string{
+		"/vmlinuz": "kernel",
+		// a wrong sidecar that must not be used
+		"/vmlinuz.sha256": "0000000000000000000000000000000000000000000000000000000000000000\n",
+	})
+	defer ts.Close()
+	checksum, err := ParseSidecar([]byte(kernelSHA256), "sha256", "vmlinuz")
+	require.NoError(t, err)
+	data, err := newTestFetcher().Fetch(ts.URL+"/vmlinuz", checksum)
+	require.NoError(t, err)
+	require.Equal(t, []byte("kernel"), data)
+}
+
+func TestFetchNotFound(t *testing.T) {
+	ts := newFileServer(nil)
+	defer ts.Close()
+	_, err := newTestFetcher().Fetch(ts.URL+"/vmlinuz", nil)
+	require.Error(t, err)
+	serr, ok := err.(*StatusError)
+	require.True(t, ok)
+	require.Equal(t, 404, serr.StatusCode)
+}
+
+func TestFetchRetriesOnServerError(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/vmlinuz" {
+			http.NotFound(w, r)
+			return
+		}
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, "kernel")
+	}))
+	defer ts.Close()
+	data, err := newTestFetcher().Fetch(ts.URL+"/vmlinuz", nil)
+	require.NoError(t, err)
+	require.Equal(t, []byte("kernel"), data)
+	require.Equal(t, int32(2), atomic.LoadInt32(&requests))
+}
+
+func TestFetchSidecarCredentialsStayOnHost(t *testing.T) {
+	var leaked bool
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		leaked = true
+		fmt.Fprintln(w, kernelSHA256)
+	}))
+	defer other.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/vmlinuz":
+			fmt.Fprint(w, "kernel")
+		case "/vmlinuz.sha256", "/vmlinuz.sha512":
+			http.Redirect(w, r, other.URL+r.URL.Path, http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL + "/vmlinuz")
+	require.NoError(t, err)
+	u.User = url.UserPassword("user", "secret")
+	// the refused redirect is a failure to fetch the sidecar, not a missing
+	// sidecar, so the kernel is not used unverified
+	_, err = newTestFetcher().Fetch(u.String(), nil)
+	require.Error(t, err)
+	require.False(t, leaked)
+}
+
+func TestFetchUnsupportedScheme(t *testing.T) {
+	_, err := newTestFetcher().Fetch("tftp://example.com/vmlinuz", nil)
+	require.Error(t, err)
+}
+
+func TestFetchOnFetch(t *testing.T) {
+	ts := newFileServer(map[string]string{
+		"/vmlinuz":        "kernel",
+		"/vmlinuz.sha256": kernelSHA256 + "  vmlinuz\n",
+	})
+	defer ts.Close()
+	var reports []Report
+	f := newTestFetcher()
+	f.OnFetch = func(r Report) { reports = append(reports, r) }
+	_, err := f.Fetch(ts.URL+"/vmlinuz", nil)
+	require.NoError(t, err)
+	_, err = f.Fetch(ts.URL+"/initrd", nil)
+	require.Error(t, err)
+	require.Equal(t, []Report{
+		{URL: ts.URL + "/vmlinuz", StatusCode: 200, Size: 6, Checksum: "sha256:" + kernelSHA256, Verified: true},
+		{URL: ts.URL + "/initrd", StatusCode: 404, Error: reports[1].Error},
+	}, reports)
+	require.NotEmpty(t, reports[1].Error)
+}
@@ -0,0 +1,31 @@
+// Package fetchtest provides helpers to test code that fetches files with the
+// fetch package.
+package fetchtest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/systemboot/systemboot/pkg/fetch"
+)
+
+// NewFileServer returns a test server serving the given path:content map.
+// Paths not in the map get a 404.
+func NewFileServer(files map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, content)
+	}))
+}
+
+// NewFetcher returns a fetcher that does not wait between its attempts.
+func NewFetcher() *fetch.Fetcher {
+	f := fetch.NewFetcher()
+	f.RetryInterval = 0
+	return f
+}
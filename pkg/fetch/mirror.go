@@ -0,0 +1,107 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DefaultProbeTimeout is how long RankMirrors waits for a mirror to respond.
+const DefaultProbeTimeout = 5 * time.Second
+
+// probe checks that the server of an HTTP URL responds and has the file, with
+// a HEAD request, or a request for its first byte if HEAD is not supported.
+func (f *Fetcher) probe(ctx context.Context, u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("cannot probe %s URLs", u.Scheme)
+	}
+	req, err := http.NewRequest(http.MethodHead, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := f.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented {
+		req, err = http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Range", "bytes=0-0")
+		if resp, err = f.Client.Do(req.WithContext(ctx)); err != nil {
+			return err
+		}
+		resp.Body.Close()
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return &StatusError{URL: redacted(u), StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+// RankMirrors probes the given mirror URLs of the same file in parallel, and
+// returns them with the first one to respond moved to the front, the others
+// keeping their order. The remaining probes are canceled as soon as a mirror
+// responds. If no mirror responds within DefaultProbeTimeout, the URLs are
+// returned in their original order.
+func (f *Fetcher) RankMirrors(rawurls []string) []string {
+	if len(rawurls) < 2 {
+		return rawurls
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultProbeTimeout)
+	defer cancel()
+	winner := make(chan int, len(rawurls))
+	for idx, rawurl := range rawurls {
+		go func(idx int, rawurl string) {
+			u, err := url.Parse(rawurl)
+			if err != nil {
+				log.Printf("fetch: invalid mirror URL: %v", err)
+				winner <- -1
+				return
+			}
+			if err := f.probe(ctx, u); err != nil {
+				if ctx.Err() != context.Canceled {
+					log.Printf("fetch: mirror %s is not usable: %v", redacted(u), err)
+				}
+				winner <- -1
+				return
+			}
+			winner <- idx
+		}(idx, rawurl)
+	}
+	for range rawurls {
+		idx := <-winner
+		if idx == -1 {
+			continue
+		}
+		log.Printf("fetch: mirror %d of %d responded first", idx+1, len(rawurls))
+		ranked := append([]string{rawurls[idx]}, rawurls[:idx]...)
+		return append(ranked, rawurls[idx+1:]...)
+	}
+	return rawurls
+}
+
+// FetchMirrors downloads a file from the fastest of the given mirror URLs,
+// see RankMirrors, and verifies it like Fetch. If the download fails, the
+// other mirrors are tried in order.
+func (f *Fetcher) FetchMirrors(rawurls []string, checksum *Checksum) ([]byte, error) {
+	if len(rawurls) == 0 {
+		return nil, errors.New("no mirror to fetch from")
+	}
+	var lastErr error
+	for _, rawurl := range f.RankMirrors(rawurls) {
+		data, err := f.Fetch(rawurl, checksum)
+		if err == nil {
+			return data, nil
+		}
+		log.Printf("fetch: download from a mirror failed: %v", err)
+		lastErr = err
+	}
+	return nil, lastErr
+}
@@ -0,0 +1,83 @@
+package fetch
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// mirrorServer is a test server that answers after a delay, and records the
+// methods of the requests it gets.
+type mirrorServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	methods []string
+}
+
+func newMirrorServer(delay time.Duration, content string) *mirrorServer {
+	m := &mirrorServer{}
+	m.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		m.methods = append(m.methods, r.Method)
+		m.mu.Unlock()
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		if r.URL.Path != "/vmlinuz" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, content)
+	}))
+	return m
+}
+
+func (m *mirrorServer) requests() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string{}, m.methods...)
+}
+
+func TestFetchMirrorsFastest(t *testing.T) {
+	slow := newMirrorServer(time.Second, "slow kernel")
+	defer slow.Close()
+	fast := newMirrorServer(0, "kernel")
+	defer fast.Close()
+
+	checksum, err := ParseChecksum("sha256:" + kernelSHA256)
+	require.NoError(t, err)
+	data, err := newTestFetcher().FetchMirrors([]string{slow.URL + "/vmlinuz", fast.URL + "/vmlinuz"}, checksum)
+	require.NoError(t, err)
+	require.Equal(t, []byte("kernel"), data)
+	require.Equal(t, []string{http.MethodHead, http.MethodGet}, fast.requests())
+	// the slow mirror was at most probed
+	require.NotContains(t, slow.requests(), http.MethodGet)
+}
+
+func TestFetchMirrorsFallback(t *testing.T) {
+	// the fast mirror has a corrupted kernel
+	slow := newMirrorServer(100*time.Millisecond, "kernel")
+	defer slow.Close()
+	fast := newMirrorServer(0, "corrupted kernel")
+	defer fast.Close()
+
+	checksum, err := ParseChecksum("sha256:" + kernelSHA256)
+	require.NoError(t, err)
+	data, err := newTestFetcher().FetchMirrors([]string{fast.URL + "/vmlinuz", slow.URL + "/vmlinuz"}, checksum)
+	require.NoError(t, err)
+	require.Equal(t, []byte("kernel"), data)
+}
+
+func TestRankMirrorsNoneResponsive(t *testing.T) {
+	ts := newFileServer(map[string]string{})
+	defer ts.Close()
+	urls := []string{ts.URL + "/a", ts.URL + "/b"}
+	require.Equal(t, urls, newTestFetcher().RankMirrors(urls))
+}
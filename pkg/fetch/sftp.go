@@ -0,0 +1,143 @@
+package fetch
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// DefaultSFTPPort is the port used for sftp:// URLs without one.
+const DefaultSFTPPort = "22"
+
+// SFTPConfig holds the SSH settings used to fetch sftp:// URLs. The host key
+// of the server is always verified, against KnownHostsFile, HostKeyPin, or
+// both: at least one of them is required.
+type SFTPConfig struct {
+	// User is the SSH user name. The user name in the URL, if any, takes
+	// precedence.
+	User string
+	// Password enables password authentication.
+	Password string
+	// PrivateKey is a PEM-encoded private key, and enables public key
+	// authentication.
+	PrivateKey []byte
+	// KnownHostsFile is the path of an OpenSSH known_hosts file.
+	KnownHostsFile string
+	// HostKeyPin is the SHA256 fingerprint of the server's host key, as
+	// printed by `ssh-keygen -l`, e.g. `SHA256:<base64>`.
+	HostKeyPin string
+	// Timeout is the timeout of the SSH connection establishment.
+	Timeout time.Duration
+}
+
+// hostKeyCallback returns a callback that enforces the configured host key
+// verification.
+func (c *SFTPConfig) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	var callbacks []ssh.HostKeyCallback
+	if c.KnownHostsFile != "" {
+		cb, err := knownhosts.New(c.KnownHostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load known hosts: %v", err)
+		}
+		callbacks = append(callbacks, cb)
+	}
+	if c.HostKeyPin != "" {
+		callbacks = append(callbacks, func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if fp := ssh.FingerprintSHA256(key); fp != c.HostKeyPin {
+				return fmt.Errorf("host key of %s is %s, expected %s", hostname, fp, c.HostKeyPin)
+			}
+			return nil
+		})
+	}
+	if len(callbacks) == 0 {
+		return nil, errors.New("no known hosts file nor host key pin configured, refusing to connect without verifying the host key")
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		for _, cb := range callbacks {
+			if err := cb(hostname, remote, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nil
+}
+
+// clientConfig returns the SSH client configuration to fetch the given URL.
+func (c *SFTPConfig) clientConfig(u *url.URL) (*ssh.ClientConfig, error) {
+	hostKeyCallback, err := c.hostKeyCallback()
+	if err != nil {
+		return nil, err
+	}
+	user := c.User
+	if u.User != nil && u.User.Username() != "" {
+		user = u.User.Username()
+	}
+	if user == "" {
+		return nil, errors.New("no SSH user name configured")
+	}
+	var auth []ssh.AuthMethod
+	if len(c.PrivateKey) > 0 {
+		signer, err := ssh.ParsePrivateKey(c.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse SSH private key: %v", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if c.Password != "" {
+		auth = append(auth, ssh.Password(c.Password))
+	}
+	if len(auth) == 0 {
+		return nil, errors.New("no SSH password nor private key configured")
+	}
+	return &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         c.Timeout,
+	}, nil
+}
+
+// get downloads the file at the given sftp:// URL, failing if it is larger
+// than maxSize bytes, unless maxSize is not positive.
+func (c *SFTPConfig) get(u *url.URL, maxSize int64) (*File, error) {
+	config, err := c.clientConfig(u)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), DefaultSFTPPort)
+	}
+	log.Printf("fetch: SFTP get of %s", redacted(u))
+	conn, err := ssh.Dial("tcp", host, config)
+	if err != nil {
+		return nil, fmt.Errorf("SSH connection to %s failed: %v", host, err)
+	}
+	defer conn.Close()
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		return nil, fmt.Errorf("cannot start SFTP session with %s: %v", host, err)
+	}
+	defer client.Close()
+	fd, err := client.Open(u.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, &os.PathError{Op: "open", Path: redacted(u), Err: os.ErrNotExist}
+		}
+		return nil, fmt.Errorf("cannot open %s: %v", redacted(u), err)
+	}
+	defer fd.Close()
+	data, err := readAll(fd, maxSize)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s: %v", redacted(u), err)
+	}
+	return &File{URL: u.String(), Data: data}, nil
+}
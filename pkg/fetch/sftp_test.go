@@ -0,0 +1,188 @@
+package fetch
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	testSFTPUser     = "boot"
+	testSFTPPassword = "secret"
+)
+
+// newTestSSHKey returns a new RSA key, as an SSH signer and PEM-encoded.
+func newTestSSHKey(t *testing.T) (ssh.Signer, []byte) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+	encoded := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return signer, encoded
+}
+
+// newSFTPServer starts an in-process SFTP server, serving the local file
+// system, that accepts testSFTPUser with either testSFTPPassword or
+// clientKey. It returns the server's address and a function to stop it.
+func newSFTPServer(t *testing.T, hostKey ssh.Signer, clientKey ssh.PublicKey) (string, func()) {
+	config := ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == testSFTPUser && string(password) == testSFTPPassword {
+				return nil, nil
+			}
+			return nil, errors.New("access denied")
+		},
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == testSFTPUser && bytes.Equal(key.Marshal(), clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("access denied")
+		},
+	}
+	config.AddHostKey(hostKey)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSFTP(conn, &config)
+		}
+	}()
+	return listener.Addr().String(), func() { listener.Close() }
+}
+
+func serveSFTP(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func(in <-chan *ssh.Request) {
+			for req := range in {
+				// the payload of a subsystem request is a length-prefixed name
+				req.Reply(req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp", nil)
+			}
+		}(requests)
+		server, err := sftp.NewServer(channel)
+		if err != nil {
+			channel.Close()
+			continue
+		}
+		go func() {
+			server.Serve()
+			server.Close()
+		}()
+	}
+}
+
+// writeSFTPTestFiles writes the given name:content files to a temporary
+// directory and returns its path.
+func writeSFTPTestFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "sftp")
+	require.NoError(t, err)
+	for name, content := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	return dir
+}
+
+func TestFetchSFTPWithKeyAndPin(t *testing.T) {
+	hostKey, _ := newTestSSHKey(t)
+	clientKey, clientPEM := newTestSSHKey(t)
+	addr, stop := newSFTPServer(t, hostKey, clientKey.PublicKey())
+	defer stop()
+	dir := writeSFTPTestFiles(t, map[string]string{
+		"vmlinuz":        "kernel",
+		"vmlinuz.sha256": kernelSHA256 + "  vmlinuz\n",
+	})
+	defer os.RemoveAll(dir)
+
+	f := newTestFetcher()
+	f.RequireChecksums = true
+	f.SFTP = &SFTPConfig{
+		User:       testSFTPUser,
+		PrivateKey: clientPEM,
+		HostKeyPin: ssh.FingerprintSHA256(hostKey.PublicKey()),
+	}
+	data, err := f.Fetch("sftp://"+addr+dir+"/vmlinuz", nil)
+	require.NoError(t, err)
+	require.Equal(t, []byte("kernel"), data)
+}
+
+func TestFetchSFTPWithPasswordAndKnownHosts(t *testing.T) {
+	hostKey, _ := newTestSSHKey(t)
+	clientKey, _ := newTestSSHKey(t)
+	addr, stop := newSFTPServer(t, hostKey, clientKey.PublicKey())
+	defer stop()
+	dir := writeSFTPTestFiles(t, map[string]string{"initrd": "initramfs"})
+	defer os.RemoveAll(dir)
+	knownHosts := filepath.Join(dir, "known_hosts")
+	require.NoError(t, ioutil.WriteFile(knownHosts, []byte(knownhosts.Line([]string{addr}, hostKey.PublicKey())+"\n"), 0644))
+
+	f := newTestFetcher()
+	f.SFTP = &SFTPConfig{
+		Password:       testSFTPPassword,
+		KnownHostsFile: knownHosts,
+	}
+	data, err := f.Fetch("sftp://"+testSFTPUser+"@"+addr+dir+"/initrd", nil)
+	require.NoError(t, err)
+	require.Equal(t, []byte("initramfs"), data)
+}
+
+func TestFetchSFTPWithWrongHostKey(t *testing.T) {
+	hostKey, _ := newTestSSHKey(t)
+	otherKey, _ := newTestSSHKey(t)
+	addr, stop := newSFTPServer(t, hostKey, otherKey.PublicKey())
+	defer stop()
+	dir := writeSFTPTestFiles(t, map[string]string{"vmlinuz": "kernel"})
+	defer os.RemoveAll(dir)
+
+	f := newTestFetcher()
+	f.SFTP = &SFTPConfig{
+		User:       testSFTPUser,
+		Password:   testSFTPPassword,
+		HostKeyPin: ssh.FingerprintSHA256(otherKey.PublicKey()),
+	}
+	_, err := f.Fetch("sftp://"+addr+dir+"/vmlinuz", nil)
+	require.Error(t, err)
+}
+
+func TestSFTPConfigRequiresHostKeyVerification(t *testing.T) {
+	f := newTestFetcher()
+	f.SFTP = &SFTPConfig{User: testSFTPUser, Password: testSFTPPassword}
+	_, err := f.Fetch("sftp://127.0.0.1:1/vmlinuz", nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "host key")
+}
+
+func TestSFTPNotConfigured(t *testing.T) {
+	_, err := newTestFetcher().Fetch("sftp://127.0.0.1:1/vmlinuz", nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "SFTP is not configured")
+}
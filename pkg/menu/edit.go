@@ -0,0 +1,146 @@
+package menu
+
+import (
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/systemboot/systemboot/pkg/bootconfig"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// editEntry is the prefix of the input that edits the kernel command line of
+// an entry, e.g. `e2` for the second entry.
+const editEntry = "e"
+
+// MaxAuthFailures is how many times a superuser can fail to authenticate
+// before editing is refused for the rest of the menu.
+const MaxAuthFailures = 3
+
+// Superusers are the users allowed to edit the boot entries, like the GRUB
+// superusers, with their passwords.
+type Superusers struct {
+	// Users are the names of the superusers. If empty, nobody can edit the
+	// entries
+	Users []string
+	// Passwords are the passwords of the users
+	Passwords map[string]Password
+}
+
+// Password is the password of a superuser, in clear text as set by the GRUB
+// `password` command, or hashed as set by `password_pbkdf2`, e.g.
+// `grub.pbkdf2.sha512.10000.<salt>.<hash>`.
+type Password struct {
+	Value  string
+	PBKDF2 bool
+}
+
+// Authenticate returns true if user is a superuser, and password is theirs.
+// A hashed password is only checked against its hash, and nobody can
+// authenticate with a malformed hash, or with a password that still refers to
+// a GRUB variable, e.g. `${GRUB2_PASSWORD}` when user.cfg was not read.
+func (su *Superusers) Authenticate(user, password string) bool {
+	isSuperuser := false
+	for _, name := range su.Users {
+		if name == user {
+			isSuperuser = true
+		}
+	}
+	expected, ok := su.Passwords[user]
+	if !isSuperuser || !ok {
+		return false
+	}
+	if strings.Contains(expected.Value, "$") {
+		return false
+	}
+	if expected.PBKDF2 {
+		return checkPBKDF2(expected.Value, password)
+	}
+	return subtle.ConstantTimeCompare([]byte(expected.Value), []byte(password)) == 1
+}
+
+// checkPBKDF2 returns true if password matches a password hashed by
+// grub-mkpasswd-pbkdf2, i.e. `grub.pbkdf2.sha512.<iterations>.<salt>.<hash>`
+// with the salt and hash in hexadecimal.
+func checkPBKDF2(hashed, password string) bool {
+	parts := strings.Split(hashed, ".")
+	if len(parts) != 6 || parts[2] != "sha512" {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[3])
+	if err != nil || iterations < 1 {
+		return false
+	}
+	salt, err := hex.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	hash, err := hex.DecodeString(parts[5])
+	if err != nil || len(hash) == 0 {
+		return false
+	}
+	key := pbkdf2.Key([]byte(password), salt, iterations, len(hash), sha512.New)
+	return subtle.ConstantTimeCompare(key, hash) == 1
+}
+
+// editChoice returns the index of the entry to edit of an input like `e2`, or
+// false if the input is not an edit.
+func editChoice(line string, count int) (int, bool) {
+	if !strings.HasPrefix(line, editEntry) {
+		return 0, false
+	}
+	choice, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, editEntry)))
+	if err != nil || choice < 1 || choice > count {
+		return 0, false
+	}
+	return choice - 1, true
+}
+
+// readPassword reads a password without echoing it if the input is a
+// terminal, or else as an input line.
+func (s *Selector) readPassword() string {
+	if f, ok := s.In.(*os.File); ok && terminal.IsTerminal(int(f.Fd())) {
+		password, err := terminal.ReadPassword(int(f.Fd()))
+		// the Enter key is not echoed either
+		fmt.Fprintln(s.Out)
+		if err != nil {
+			return ""
+		}
+		return string(password)
+	}
+	password, _ := s.waitLine(-1)
+	return password
+}
+
+// edit asks for the new kernel command line of an entry, after authenticating
+// a superuser if there are any. It returns false if the authentication
+// failed, in which case the entry is not changed, and after MaxAuthFailures
+// failures without asking again. An empty input keeps the command line.
+func (s *Selector) edit(cfg *bootconfig.BootConfig) bool {
+	if s.Superusers != nil {
+		if s.authFailures >= MaxAuthFailures {
+			fmt.Fprintln(s.Out, "Too many authentication failures, editing is disabled")
+			return false
+		}
+		fmt.Fprint(s.Out, "Username: ")
+		user, _ := s.waitLine(-1)
+		fmt.Fprint(s.Out, "Password: ")
+		password := s.readPassword()
+		if !s.Superusers.Authenticate(user, password) {
+			s.authFailures++
+			fmt.Fprintln(s.Out, "Authentication failed")
+			return false
+		}
+	}
+	fmt.Fprintf(s.Out, "Kernel command line of %q: %s\n", cfg.Name, cfg.KernelArgs)
+	fmt.Fprint(s.Out, "New kernel command line, or Enter to keep it: ")
+	if line, ok := s.waitLine(-1); ok && line != "" {
+		cfg.KernelArgs = line
+	}
+	return true
+}
@@ -0,0 +1,112 @@
+package menu
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/systemboot/systemboot/pkg/bootconfig"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+func TestEditRefusedWithoutCredentials(t *testing.T) {
+	superusers := &Superusers{Users: []string{"root"}, Passwords: map[string]Password{"root": {Value: "secret"}, "guest": {Value: "guest"}}}
+	for _, credentials := range []string{"root\nwrong\n", "guest\nguest\n", "\n\n"} {
+		bootconfigs := append([]bootconfig.BootConfig(nil), testBootConfigs...)
+		var out bytes.Buffer
+		s := Selector{
+			Settings: Settings{Timeout: time.Minute, Edit: true, Superusers: superusers},
+			In:       strings.NewReader("e1\n" + credentials + "init=/bin/sh\n"),
+			Out:      &out,
+		}
+		idx, err := s.Select(bootconfigs)
+		require.NoError(t, err)
+		require.Equal(t, 0, idx)
+		require.Contains(t, out.String(), "Authentication failed\n")
+		require.Equal(t, "", bootconfigs[0].KernelArgs)
+		// the command line that was not edited is an invalid choice
+		require.Contains(t, out.String(), "Invalid choice \"init=/bin/sh\"\n")
+	}
+
+	// nobody can edit with empty superusers
+	s := Selector{Settings: Settings{Edit: true, Superusers: &Superusers{}}}
+	require.False(t, s.Superusers.Authenticate("", ""))
+}
+
+func TestAuthenticate(t *testing.T) {
+	salt := []byte{0xde, 0xad, 0xbe, 0xef}
+	hashed := fmt.Sprintf("grub.pbkdf2.sha512.100.%X.%X", salt, pbkdf2.Key([]byte("secret"), salt, 100, 64, sha512.New))
+	superusers := &Superusers{
+		Users: []string{"root", "admin", "fedora", "plain", "var"},
+		Passwords: map[string]Password{
+			"root":   {Value: hashed, PBKDF2: true},
+			"admin":  {Value: "grub.pbkdf2.sha512.100.zz.00", PBKDF2: true},
+			"fedora": {Value: "${GRUB2_PASSWORD}", PBKDF2: true},
+			"plain":  {Value: hashed},
+			"var":    {Value: "$pw"},
+		},
+	}
+	require.True(t, superusers.Authenticate("root", "secret"))
+	// a hash is never compared as a clear text password
+	require.False(t, superusers.Authenticate("root", hashed))
+	require.False(t, superusers.Authenticate("admin", "grub.pbkdf2.sha512.100.zz.00"))
+	require.False(t, superusers.Authenticate("fedora", "${GRUB2_PASSWORD}"))
+	require.False(t, superusers.Authenticate("fedora", ""))
+	// a clear text password is never checked as a hash
+	require.True(t, superusers.Authenticate("plain", hashed))
+	require.False(t, superusers.Authenticate("plain", "secret"))
+	require.False(t, superusers.Authenticate("var", "$pw"))
+}
+
+func TestEditMaxAuthFailures(t *testing.T) {
+	superusers := &Superusers{Users: []string{"root"}, Passwords: map[string]Password{"root": {Value: "secret"}}}
+	bootconfigs := append([]bootconfig.BootConfig(nil), testBootConfigs...)
+	var out bytes.Buffer
+	s := Selector{
+		Settings: Settings{Timeout: time.Minute, Edit: true, Superusers: superusers},
+		In:       strings.NewReader(strings.Repeat("e1\nroot\nwrong\n", MaxAuthFailures) + "e1\n3\n"),
+		Out:      &out,
+	}
+	idx, err := s.Select(bootconfigs)
+	require.NoError(t, err)
+	// the last attempt does not ask for credentials, so 3 is a choice
+	require.Equal(t, 2, idx)
+	require.Equal(t, MaxAuthFailures, strings.Count(out.String(), "Authentication failed\n"))
+	require.Contains(t, out.String(), "Too many authentication failures, editing is disabled\n")
+}
+
+func TestEdit(t *testing.T) {
+	salt := []byte{0xde, 0xad, 0xbe, 0xef}
+	hashed := fmt.Sprintf("grub.pbkdf2.sha512.100.%X.%X", salt, pbkdf2.Key([]byte("secret"), salt, 100, 64, sha512.New))
+	superusers := &Superusers{Users: []string{"root"}, Passwords: map[string]Password{"root": {Value: hashed, PBKDF2: true}}}
+	bootconfigs := append([]bootconfig.BootConfig(nil), testBootConfigs...)
+	var out bytes.Buffer
+	s := Selector{
+		Settings: Settings{Timeout: time.Minute, Edit: true, Superusers: superusers},
+		In:       strings.NewReader("e2\nroot\nsecret\nsingle init=/bin/sh\n"),
+		Out:      &out,
+	}
+	idx, err := s.Select(bootconfigs)
+	require.NoError(t, err)
+	require.Equal(t, 1, idx)
+	require.Equal(t, "single init=/bin/sh", bootconfigs[1].KernelArgs)
+	require.Contains(t, out.String(), "Kernel command line of \"Linux (recovery mode)\": single\n")
+
+	// without superusers, anyone can edit, and Enter keeps the command line
+	s = Selector{Settings: Settings{Timeout: time.Minute, Edit: true}, In: strings.NewReader("e2\n\n"), Out: &bytes.Buffer{}}
+	idx, err = s.Select(bootconfigs)
+	require.NoError(t, err)
+	require.Equal(t, 1, idx)
+	require.Equal(t, "single init=/bin/sh", bootconfigs[1].KernelArgs)
+
+	// editing is disabled by default
+	s = Selector{Settings: Settings{Timeout: time.Minute}, In: strings.NewReader("e2\n3\n"), Out: &out}
+	idx, err = s.Select(bootconfigs)
+	require.NoError(t, err)
+	require.Equal(t, 2, idx)
+	require.Contains(t, out.String(), "Invalid choice \"e2\"\n")
+}
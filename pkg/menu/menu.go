@@ -0,0 +1,275 @@
+// Package menu lets the user pick a boot configuration on a console, with the
+// timeout behaviors of the GRUB menu.
+package menu
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/systemboot/systemboot/pkg/bootconfig"
+)
+
+// Timeout styles, like the GRUB timeout_style variable.
+const (
+	// StyleMenu shows the menu and boots the default entry after the
+	// timeout, unless another one is selected
+	StyleMenu = "menu"
+	// StyleCountdown shows a countdown instead of the menu, which is only
+	// shown if a key is pressed before the timeout
+	StyleCountdown = "countdown"
+	// StyleHidden shows nothing, and only shows the menu if a key is pressed
+	// before the timeout
+	StyleHidden = "hidden"
+)
+
+// IsStyle returns true if style is a known timeout style.
+func IsStyle(style string) bool {
+	return style == StyleMenu || style == StyleCountdown || style == StyleHidden
+}
+
+// Single entry policies, for when there is only one boot configuration.
+const (
+	// SingleEntryBoot boots it without menu nor timeout, only waiting for
+	// the grace period, if any
+	SingleEntryBoot = "boot"
+	// SingleEntryMenu shows the menu as for several entries
+	SingleEntryMenu = "menu"
+)
+
+// IsSingleEntryPolicy returns true if policy is a known single entry policy.
+func IsSingleEntryPolicy(policy string) bool {
+	return policy == SingleEntryBoot || policy == SingleEntryMenu
+}
+
+// Settings controls how the menu is shown.
+type Settings struct {
+	// Timeout is how long to wait before booting the default entry. Zero
+	// boots it immediately, and a negative value waits forever
+	Timeout time.Duration
+	// Style is the timeout style, StyleMenu if empty
+	Style string
+	// Grace is the minimum time during which a key press interrupts the
+	// boot of the default entry, even if Timeout is shorter or zero, e.g. for
+	// laggy serial or IPMI consoles
+	Grace time.Duration
+	// MaxEntries, if positive, is the number of entries shown at once. The
+	// other ones are shown on the next pages, but can be selected from any
+	// page. The default entry is always on the first page
+	MaxEntries int
+	// SingleEntry is what to do when there is only one boot configuration,
+	// SingleEntryBoot if empty
+	SingleEntry string
+	// Edit lets the user edit the kernel command line of an entry before
+	// booting it, by typing e and its number
+	Edit bool
+	// Superusers, if not nil, are the only users allowed to edit the
+	// entries, after typing their password, like with the GRUB superusers
+	Superusers *Superusers
+}
+
+// timeout returns how long to wait before booting the default entry, at least
+// the grace period unless waiting forever.
+func (s Settings) timeout() time.Duration {
+	if s.Timeout >= 0 && s.Timeout < s.Grace {
+		return s.Grace
+	}
+	return s.Timeout
+}
+
+// Selector asks the user which boot configuration to boot. The console is
+// line-based: a key press is an input line, i.e. Enter.
+type Selector struct {
+	Settings
+	In  io.Reader
+	Out io.Writer
+
+	lines   chan string
+	want    chan struct{}
+	stop    chan struct{}
+	pending bool
+	eof     bool
+	// authFailures counts the failed superuser authentications
+	authFailures int
+}
+
+// readLines starts reading the input lines in the background, one at a time
+// when waitLine asks for one, until the end of the input, which closes
+// s.lines, or until stopReading is called.
+func (s *Selector) readLines() {
+	lines := make(chan string)
+	want := make(chan struct{}, 1)
+	stop := make(chan struct{})
+	s.lines, s.want, s.stop = lines, want, stop
+	s.pending, s.eof = false, false
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(s.In)
+		for {
+			select {
+			case <-want:
+			case <-stop:
+				return
+			}
+			if !scanner.Scan() {
+				return
+			}
+			select {
+			case lines <- strings.TrimSpace(scanner.Text()):
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// stopReading stops reading the input once the current line, if one is being
+// read, is in: nothing more is read from In.
+func (s *Selector) stopReading() {
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+}
+
+// waitLine returns the next input line, or false if there is none within
+// timeout, or no more input. A negative timeout waits forever. A line still
+// being read on timeout is returned by the next call.
+func (s *Selector) waitLine(timeout time.Duration) (string, bool) {
+	if s.eof {
+		return "", false
+	}
+	if !s.pending {
+		s.want <- struct{}{}
+		s.pending = true
+	}
+	var expired <-chan time.Time
+	if timeout >= 0 {
+		expired = time.After(timeout)
+	}
+	select {
+	case line, ok := <-s.lines:
+		s.pending = false
+		s.eof = !ok
+		return line, ok
+	case <-expired:
+		return "", false
+	}
+}
+
+// Select returns the index of the boot configuration to boot, the first one
+// being the default. A single boot configuration is selected without waiting
+// for the timeout, unless the SingleEntry policy is SingleEntryMenu, but the
+// grace period still applies. On timeout, which is at least the grace period,
+// or at the end of the input, the default is selected. Once the user
+// interacted with the menu, it waits for a choice without a timeout. An entry
+// edited with Edit is changed in bootconfigs. On return, the input is no
+// longer read, except for the line that was being waited for if the timeout
+// expired: it is discarded once entered.
+func (s *Selector) Select(bootconfigs []bootconfig.BootConfig) (int, error) {
+	if len(bootconfigs) == 0 {
+		return 0, errors.New("no boot configuration to select")
+	}
+	if s.Style != "" && !IsStyle(s.Style) {
+		return 0, fmt.Errorf("unknown menu timeout style %q", s.Style)
+	}
+	if s.SingleEntry != "" && !IsSingleEntryPolicy(s.SingleEntry) {
+		return 0, fmt.Errorf("unknown menu single entry policy %q", s.SingleEntry)
+	}
+	timeout := s.timeout()
+	if len(bootconfigs) == 1 && s.SingleEntry != SingleEntryMenu {
+		// only the grace period can interrupt the boot
+		timeout = 0
+		if s.Grace > 0 {
+			timeout = s.Grace
+		}
+	}
+	if timeout == 0 {
+		return 0, nil
+	}
+	s.readLines()
+	defer s.stopReading()
+	switch s.Style {
+	case StyleHidden:
+		if _, ok := s.waitLine(timeout); !ok {
+			return 0, nil
+		}
+	case StyleCountdown:
+		fmt.Fprintf(s.Out, "Booting %q in %v, press Enter for the menu\n", bootconfigs[0].Name, timeout)
+		if _, ok := s.waitLine(timeout); !ok {
+			return 0, nil
+		}
+	default:
+		return s.choose(bootconfigs, timeout)
+	}
+	return s.choose(bootconfigs, -1)
+}
+
+// morePages is the input that shows the next page of the menu.
+const morePages = "m"
+
+// showPage shows the entries of the page starting at start, and returns the
+// start of the next page, or 0 after the last page.
+func (s *Selector) showPage(bootconfigs []bootconfig.BootConfig, start int) int {
+	end := len(bootconfigs)
+	if s.MaxEntries > 0 && start+s.MaxEntries < end {
+		end = start + s.MaxEntries
+	}
+	for idx := start; idx < end; idx++ {
+		fmt.Fprintf(s.Out, "%3d. %s\n", idx+1, bootconfigs[idx].Name)
+	}
+	if end < len(bootconfigs) {
+		fmt.Fprintf(s.Out, "%3s. Other entries (%d more)\n", morePages, len(bootconfigs)-end)
+		return end
+	}
+	if start > 0 {
+		fmt.Fprintf(s.Out, "%3s. First entries\n", morePages)
+	}
+	return 0
+}
+
+// choose shows the menu and reads the number of the selected entry, or an
+// empty line for the default one. An edited entry is selected.
+func (s *Selector) choose(bootconfigs []bootconfig.BootConfig, timeout time.Duration) (int, error) {
+	next := s.showPage(bootconfigs, 0)
+	paged := next > 0
+	for {
+		pages := ""
+		if paged {
+			pages = ", " + morePages + " for other entries"
+		}
+		if s.Edit {
+			pages += ", " + editEntry + "<number> to edit an entry"
+		}
+		if timeout < 0 {
+			fmt.Fprintf(s.Out, "Select a boot entry [1-%d]%s, or Enter for %q: ", len(bootconfigs), pages, bootconfigs[0].Name)
+		} else {
+			fmt.Fprintf(s.Out, "Select a boot entry [1-%d]%s, or Enter for %q (booting it in %v): ", len(bootconfigs), pages, bootconfigs[0].Name, timeout)
+		}
+		line, ok := s.waitLine(timeout)
+		if !ok || line == "" {
+			fmt.Fprintln(s.Out)
+			return 0, nil
+		}
+		timeout = -1
+		if paged && line == morePages {
+			next = s.showPage(bootconfigs, next)
+			continue
+		}
+		if idx, ok := editChoice(line, len(bootconfigs)); s.Edit && ok {
+			if s.edit(&bootconfigs[idx]) {
+				return idx, nil
+			}
+			continue
+		}
+		choice, err := strconv.Atoi(line)
+		if err == nil && choice >= 1 && choice <= len(bootconfigs) {
+			return choice - 1, nil
+		}
+		fmt.Fprintf(s.Out, "Invalid choice %q\n", line)
+	}
+}
@@ -0,0 +1,197 @@
+package menu
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/systemboot/systemboot/pkg/bootconfig"
+)
+
+var testBootConfigs = []bootconfig.BootConfig{
+	{Name: "Linux", Kernel: "/vmlinuz"},
+	{Name: "Linux (recovery mode)", Kernel: "/vmlinuz", KernelArgs: "single"},
+	{Name: "Reboot", Action: bootconfig.ActionReboot},
+}
+
+func TestSelectHidden(t *testing.T) {
+	// no key is pressed: the input stays open but empty
+	in, _ := io.Pipe()
+	var out bytes.Buffer
+	s := Selector{Settings: Settings{Timeout: 10 * time.Millisecond, Style: StyleHidden}, In: in, Out: &out}
+	idx, err := s.Select(testBootConfigs)
+	require.NoError(t, err)
+	require.Equal(t, 0, idx)
+	// the menu was not shown, and nothing was asked
+	require.Equal(t, "", out.String())
+}
+
+func TestSelectHiddenKeyPress(t *testing.T) {
+	var out bytes.Buffer
+	s := Selector{Settings: Settings{Timeout: time.Minute, Style: StyleHidden}, In: strings.NewReader("\n2\n"), Out: &out}
+	idx, err := s.Select(testBootConfigs)
+	require.NoError(t, err)
+	require.Equal(t, 1, idx)
+	require.Contains(t, out.String(), "  2. Linux (recovery mode)\n")
+}
+
+func TestSelectCountdown(t *testing.T) {
+	in, _ := io.Pipe()
+	var out bytes.Buffer
+	s := Selector{Settings: Settings{Timeout: 10 * time.Millisecond, Style: StyleCountdown}, In: in, Out: &out}
+	idx, err := s.Select(testBootConfigs)
+	require.NoError(t, err)
+	require.Equal(t, 0, idx)
+	require.Equal(t, "Booting \"Linux\" in 10ms, press Enter for the menu\n", out.String())
+}
+
+func TestSelectMenu(t *testing.T) {
+	var out bytes.Buffer
+	s := Selector{Settings: Settings{Timeout: time.Minute}, In: strings.NewReader("4\nfoo\n3\n"), Out: &out}
+	idx, err := s.Select(testBootConfigs)
+	require.NoError(t, err)
+	require.Equal(t, 2, idx)
+	require.Contains(t, out.String(), "  1. Linux\n")
+	require.Contains(t, out.String(), "Invalid choice \"4\"\n")
+	require.Contains(t, out.String(), "Invalid choice \"foo\"\n")
+
+	// the default is selected at the end of the input, and immediately
+	// without a timeout
+	for _, timeout := range []time.Duration{time.Minute, 0} {
+		s = Selector{Settings: Settings{Timeout: timeout}, In: strings.NewReader(""), Out: &out}
+		idx, err = s.Select(testBootConfigs)
+		require.NoError(t, err)
+		require.Equal(t, 0, idx)
+	}
+}
+
+func TestSelectStopsReading(t *testing.T) {
+	in, w := io.Pipe()
+	defer in.Close()
+	go w.Write([]byte("2\n"))
+	var out bytes.Buffer
+	s := Selector{Settings: Settings{Timeout: time.Minute}, In: in, Out: &out}
+	idx, err := s.Select(testBootConfigs)
+	require.NoError(t, err)
+	require.Equal(t, 1, idx)
+	// the next line is left for whoever reads the console next
+	written := make(chan struct{})
+	go func() {
+		w.Write([]byte("3\n"))
+		close(written)
+	}()
+	select {
+	case <-written:
+		t.Fatal("the input was read after the choice")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSelectInvalid(t *testing.T) {
+	s := Selector{Settings: Settings{Timeout: time.Minute, Style: "blink"}, In: strings.NewReader(""), Out: &bytes.Buffer{}}
+	_, err := s.Select(testBootConfigs)
+	require.Error(t, err)
+	_, err = s.Select(nil)
+	require.Error(t, err)
+}
+
+func TestSelectGrace(t *testing.T) {
+	// a key is pressed after a console lag, even though grub.cfg boots the
+	// default entry immediately
+	in, w := io.Pipe()
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		io.WriteString(w, "\n3\n")
+	}()
+	var out bytes.Buffer
+	s := Selector{Settings: Settings{Timeout: 0, Style: StyleHidden, Grace: time.Minute}, In: in, Out: &out}
+	idx, err := s.Select(testBootConfigs)
+	require.NoError(t, err)
+	require.Equal(t, 2, idx)
+	require.Contains(t, out.String(), "  3. Reboot\n")
+
+	// no key is pressed within the grace period
+	in, _ = io.Pipe()
+	out.Reset()
+	s = Selector{Settings: Settings{Timeout: 0, Style: StyleHidden, Grace: 10 * time.Millisecond}, In: in, Out: &out}
+	idx, err = s.Select(testBootConfigs)
+	require.NoError(t, err)
+	require.Equal(t, 0, idx)
+	require.Equal(t, "", out.String())
+
+	// the grace period does not shorten a longer timeout
+	require.Equal(t, time.Minute, Settings{Timeout: time.Minute, Grace: time.Second}.timeout())
+	require.Equal(t, time.Duration(-1), Settings{Timeout: -1, Grace: time.Second}.timeout())
+}
+
+func TestSelectMaxEntries(t *testing.T) {
+	bootconfigs := []bootconfig.BootConfig{
+		{Name: "Linux 5.4", Kernel: "/vmlinuz-5.4"},
+		{Name: "Linux 5.3", Kernel: "/vmlinuz-5.3"},
+		{Name: "Linux 5.2", Kernel: "/vmlinuz-5.2"},
+		{Name: "Linux 5.1", Kernel: "/vmlinuz-5.1"},
+		{Name: "Linux 5.0", Kernel: "/vmlinuz-5.0"},
+	}
+	var out bytes.Buffer
+	s := Selector{Settings: Settings{Timeout: time.Minute, MaxEntries: 2}, In: strings.NewReader("m\nm\nm\n4\n"), Out: &out}
+	idx, err := s.Select(bootconfigs)
+	require.NoError(t, err)
+	require.Equal(t, 3, idx)
+	prompt := "Select a boot entry [1-5], m for other entries, or Enter for \"Linux 5.4\""
+	require.Equal(t, "  1. Linux 5.4\n  2. Linux 5.3\n  m. Other entries (3 more)\n"+
+		prompt+" (booting it in 1m0s): "+
+		"  3. Linux 5.2\n  4. Linux 5.1\n  m. Other entries (1 more)\n"+prompt+": "+
+		"  5. Linux 5.0\n  m. First entries\n"+prompt+": "+
+		"  1. Linux 5.4\n  2. Linux 5.3\n  m. Other entries (3 more)\n"+prompt+": ", out.String())
+
+	// an entry that is not shown can be selected, and the default is on
+	// the first page
+	for input, expected := range map[string]int{"5\n": 4, "\n": 0} {
+		s = Selector{Settings: Settings{Timeout: time.Minute, MaxEntries: 2}, In: strings.NewReader(input), Out: &bytes.Buffer{}}
+		idx, err = s.Select(bootconfigs)
+		require.NoError(t, err)
+		require.Equal(t, expected, idx)
+	}
+
+	// no paging if all the entries fit
+	out.Reset()
+	s = Selector{Settings: Settings{Timeout: time.Minute, MaxEntries: 5}, In: strings.NewReader("m\n1\n"), Out: &out}
+	idx, err = s.Select(bootconfigs)
+	require.NoError(t, err)
+	require.Equal(t, 0, idx)
+	require.NotContains(t, out.String(), "Other entries")
+	require.Contains(t, out.String(), "Invalid choice \"m\"\n")
+}
+
+func TestSelectSingleEntry(t *testing.T) {
+	single := testBootConfigs[:1]
+	// booted immediately with the default policy
+	in, _ := io.Pipe()
+	var out bytes.Buffer
+	s := Selector{Settings: Settings{Timeout: time.Minute}, In: in, Out: &out}
+	idx, err := s.Select(single)
+	require.NoError(t, err)
+	require.Equal(t, 0, idx)
+	require.Equal(t, "", out.String())
+
+	// but Enter still interrupts the boot during the grace period
+	s = Selector{Settings: Settings{Timeout: time.Minute, Grace: time.Minute, Style: StyleHidden}, In: strings.NewReader("\n1\n"), Out: &out}
+	idx, err = s.Select(single)
+	require.NoError(t, err)
+	require.Equal(t, 0, idx)
+	require.Contains(t, out.String(), "  1. Linux\n")
+	out.Reset()
+
+	s = Selector{Settings: Settings{Timeout: time.Minute, SingleEntry: SingleEntryMenu}, In: strings.NewReader("1\n"), Out: &out}
+	idx, err = s.Select(single)
+	require.NoError(t, err)
+	require.Equal(t, 0, idx)
+	require.Contains(t, out.String(), "  1. Linux\n")
+
+	s = Selector{Settings: Settings{SingleEntry: "ask"}}
+	_, err = s.Select(single)
+	require.Error(t, err)
+}
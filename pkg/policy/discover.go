@@ -0,0 +1,99 @@
+package policy
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+)
+
+// Where policy files are looked for, relative to the root of each partition:
+// ESPPolicyPath on EFI system partitions, and DiskPolicyPath on the others.
+// An ESP-resident policy takes precedence over a disk-resident one, since the
+// ESP is closer to the firmware and usually managed with it.
+const (
+	ESPPolicyPath  = "EFI/systemboot/policy.json"
+	DiskPolicyPath = "etc/systemboot/policy.json"
+)
+
+// Root is the root directory of a mounted partition, where Discover looks for
+// a policy file.
+type Root struct {
+	Path string
+	// ESP is true if the partition is an EFI system partition, by its GPT
+	// partition type, not by the files it has
+	ESP bool
+}
+
+// Found is a policy file found by Discover.
+type Found struct {
+	Path string
+	// ESP is true if the policy was found at ESPPolicyPath on an EFI system
+	// partition
+	ESP bool
+}
+
+func (f Found) String() string {
+	if f.ESP {
+		return "ESP policy " + f.Path
+	}
+	return "disk policy " + f.Path
+}
+
+// Discover looks for policy files under the given root directories, i.e. the
+// mount points of the partitions, and returns them in the order they were
+// found. Only ESPPolicyPath is looked for on an EFI system partition, and only
+// DiskPolicyPath on the others, so that no other partition can pass off a
+// policy as an ESP one.
+func Discover(roots []Root) []Found {
+	var found []Found
+	for _, root := range roots {
+		f := Found{Path: path.Join(root.Path, DiskPolicyPath)}
+		if root.ESP {
+			f = Found{Path: path.Join(root.Path, ESPPolicyPath), ESP: true}
+		}
+		if info, err := os.Stat(f.Path); err == nil && info.Mode().IsRegular() {
+			found = append(found, f)
+		}
+	}
+	return found
+}
+
+// Choose returns the policy file to apply among the ones found, and the
+// reason why it was chosen: the first ESP policy if any, otherwise the first
+// disk policy. It returns false if no policy was found.
+func Choose(found []Found) (Found, string, bool) {
+	if len(found) == 0 {
+		return Found{}, "", false
+	}
+	for _, f := range found {
+		if f.ESP {
+			if len(found) == 1 {
+				return f, "it is the only policy found", true
+			}
+			return f, fmt.Sprintf("an ESP policy takes precedence, %d other policies are ignored", len(found)-1), true
+		}
+	}
+	if len(found) == 1 {
+		return found[0], "it is the only policy found", true
+	}
+	return found[0], fmt.Sprintf("there is no ESP policy and it was found first, %d other disk policies are ignored", len(found)-1), true
+}
+
+// LoadDiscovered looks for policy files under the given root directories, and
+// loads the one chosen by Choose, logging which one and why. Only that one is
+// measured. It returns nil if no policy was found.
+func LoadDiscovered(roots []Root) (*Policy, error) {
+	found := Discover(roots)
+	chosen, reason, ok := Choose(found)
+	if !ok {
+		return nil, nil
+	}
+	log.Printf("Using the %s: %s", chosen, reason)
+	for _, f := range found {
+		if f != chosen {
+			log.Printf("Ignoring the %s", f)
+		}
+	}
+	return Load(chosen.Path)
+}
@@ -0,0 +1,71 @@
+package policy
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/systemboot/systemboot/pkg/bootconfig"
+	"github.com/systemboot/systemboot/pkg/crypto"
+)
+
+func writeFile(t *testing.T, filename, content string) {
+	require.NoError(t, os.MkdirAll(path.Dir(filename), 0755))
+	require.NoError(t, ioutil.WriteFile(filename, []byte(content), 0644))
+}
+
+func TestLoadDiscoveredESPWins(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	// the root partition is mounted before the ESP
+	root, esp := path.Join(dir, "sda2"), path.Join(dir, "sda1")
+	writeFile(t, path.Join(root, DiskPolicyPath), `{"cmdline_append": ["disk"]}`)
+	espPolicy := `{"cmdline_append": ["esp"], "allowed_kernels": ["/mnt/*/vmlinuz-*"]}`
+	writeFile(t, path.Join(esp, ESPPolicyPath), espPolicy)
+	var measurements []measurement
+	defer recordMeasurements(&measurements)()
+
+	roots := []Root{{Path: root}, {Path: esp, ESP: true}}
+	found := Discover(roots)
+	require.Equal(t, []Found{
+		{Path: path.Join(root, DiskPolicyPath)},
+		{Path: path.Join(esp, ESPPolicyPath), ESP: true},
+	}, found)
+
+	p, err := LoadDiscovered(roots)
+	require.NoError(t, err)
+	bootconfigs := p.Apply([]bootconfig.BootConfig{
+		{Kernel: "/mnt/sda2/vmlinuz-5.0"},
+		{Kernel: "/mnt/sda2/vmlinuz"},
+	})
+	require.Equal(t, 1, len(bootconfigs))
+	require.Equal(t, "esp", bootconfigs[0].KernelArgs)
+	// only the chosen policy is measured
+	require.Equal(t, []measurement{{crypto.Policy, []byte(espPolicy), path.Join(esp, ESPPolicyPath)}}, measurements)
+}
+
+func TestDiscoverESPByPartitionType(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	// a data partition with an EFI directory is not an ESP
+	data, esp := path.Join(dir, "sda2"), path.Join(dir, "sda1")
+	writeFile(t, path.Join(data, ESPPolicyPath), `{}`)
+	writeFile(t, path.Join(esp, DiskPolicyPath), `{}`)
+	require.Empty(t, Discover([]Root{{Path: data}, {Path: esp, ESP: true}}))
+}
+
+func TestChoose(t *testing.T) {
+	_, _, ok := Choose(nil)
+	require.False(t, ok)
+	chosen, _, ok := Choose([]Found{{Path: "/mnt/sda2/etc/systemboot/policy.json"}, {Path: "/mnt/sdb2/etc/systemboot/policy.json"}})
+	require.True(t, ok)
+	require.Equal(t, "/mnt/sda2/etc/systemboot/policy.json", chosen.Path)
+
+	p, err := LoadDiscovered([]Root{{Path: "/nonexistent"}})
+	require.NoError(t, err)
+	require.Nil(t, p)
+}
@@ -0,0 +1,362 @@
+// Package policy loads the boot policy, a JSON file that restricts and tweaks
+// the boot configurations systemboot finds. Since it influences the boot, the
+// policy file is measured when it is loaded.
+package policy
+
+import (
+	"bytes"
+	gocrypto "crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/systemboot/systemboot/pkg/bootconfig"
+	"github.com/systemboot/systemboot/pkg/crypto"
+)
+
+// Policy is a boot policy.
+type Policy struct {
+	// CmdlineAppend lists arguments appended to the kernel command line of
+	// every boot configuration, before the `--` separator if any
+	CmdlineAppend []string `json:"cmdline_append,omitempty"`
+	// AllowedKernels, if not empty, lists the only kernels that can be
+	// booted, as shell patterns matched against the kernel path, e.g.
+	// `/mnt/*/boot/vmlinuz-*`
+	AllowedKernels []string `json:"allowed_kernels,omitempty"`
+	// Scanners, if not empty, lists the only config formats to scan for
+	Scanners []string `json:"scanners,omitempty"`
+	// DisableScanners lists config formats not to scan for
+	DisableScanners []string `json:"disable_scanners,omitempty"`
+	// Profiles lists kernel arguments to append on specific hardware, in
+	// addition to CmdlineAppend. Only the first matching profile is applied
+	Profiles []Profile `json:"profiles,omitempty"`
+	// QuotePCRs lists the only PCRs that a TPM quote sent to a provisioning
+	// server can reveal, in the bank of the measurement hash. If empty, no
+	// quote is allowed
+	QuotePCRs []int `json:"quote_pcrs,omitempty"`
+	// MeasurementHash is the PCR bank every measurement is extended into,
+	// one of measurementHashes, e.g. sha384 where SHA-384 PCRs are mandated.
+	// If empty, the default banks are used
+	MeasurementHash string `json:"measurement_hash,omitempty"`
+	// SameDevice refuses the boot configurations whose kernel, initramfs
+	// and device tree are not all on the same device, e.g. a trusted
+	// kernel with an initramfs from another disk
+	SameDevice bool `json:"same_device,omitempty"`
+	// FilePermissions checks that the kernel, initramfs and device tree of
+	// the boot configurations cannot be written by other users, a sign of
+	// tampering: FilePermissionsWarn only logs the unsafe files, and
+	// FilePermissionsEnforce refuses them. If empty, nothing is checked
+	FilePermissions string `json:"file_permissions,omitempty"`
+}
+
+// Values of FilePermissions.
+const (
+	FilePermissionsWarn    = "warn"
+	FilePermissionsEnforce = "enforce"
+)
+
+// measurementHashes are the PCR banks a policy can select for measurements.
+var measurementHashes = map[string]gocrypto.Hash{
+	"sha256": gocrypto.SHA256,
+	"sha384": gocrypto.SHA384,
+	"sha512": gocrypto.SHA512,
+}
+
+// MeasurementAlgorithm returns the hash algorithm of MeasurementHash, or zero
+// if it is not set.
+func (p *Policy) MeasurementAlgorithm() (gocrypto.Hash, error) {
+	if p.MeasurementHash == "" {
+		return 0, nil
+	}
+	alg, ok := measurementHashes[p.MeasurementHash]
+	if !ok {
+		return 0, fmt.Errorf("unsupported measurement hash %q", p.MeasurementHash)
+	}
+	return alg, nil
+}
+
+// Profile is a set of kernel arguments for a hardware model, identified by
+// its SMBIOS system manufacturer and product name.
+type Profile struct {
+	Name string `json:"name,omitempty"`
+	// Vendor and Product are shell patterns matched against the SMBIOS
+	// system manufacturer and product name, e.g. `Dell*`. An empty pattern
+	// matches anything
+	Vendor  string `json:"vendor,omitempty"`
+	Product string `json:"product,omitempty"`
+	// CmdlineAppend lists the arguments to append, e.g. `intel_iommu=on`
+	CmdlineAppend []string `json:"cmdline_append"`
+}
+
+// matches returns true if the profile applies to the given hardware.
+func (p *Profile) matches(vendor, product string) bool {
+	if p.Vendor != "" {
+		if ok, _ := filepath.Match(p.Vendor, vendor); !ok {
+			return false
+		}
+	}
+	if p.Product != "" {
+		if ok, _ := filepath.Match(p.Product, product); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// smbiosVendor and smbiosProduct identify the hardware. They are variables so
+// they can be overridden for testing.
+var (
+	smbiosVendor  = bootconfig.SMBIOSVendor
+	smbiosProduct = bootconfig.SMBIOSProduct
+)
+
+// Profile returns the first profile that matches the hardware, or nil if none
+// does.
+func (p *Policy) Profile() *Profile {
+	if len(p.Profiles) == 0 {
+		return nil
+	}
+	vendor, err := smbiosVendor()
+	if err != nil {
+		log.Printf("Cannot read the SMBIOS system manufacturer: %v", err)
+	}
+	product, err := smbiosProduct()
+	if err != nil {
+		log.Printf("Cannot read the SMBIOS product name: %v", err)
+	}
+	for idx := range p.Profiles {
+		if p.Profiles[idx].matches(vendor, product) {
+			return &p.Profiles[idx]
+		}
+	}
+	return nil
+}
+
+// measureData measures the policy file, and setMeasurementHash selects the PCR
+// bank of the measurements. They are variables so they can be overridden for
+// testing.
+var (
+	measureData        = crypto.TryMeasureData
+	setMeasurementHash = crypto.SetMeasurementHash
+)
+
+// Load reads a policy file and measures its content into the Policy PCR, so
+// that a tampered policy changes the PCR values. An invalid policy is measured
+// too, before being refused. The PCR bank of MeasurementHash, if set, is
+// selected first, so that the policy is measured into it like everything else.
+func Load(filename string) (*Policy, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	p, err := parse(data, filename)
+	if err != nil {
+		measureData(crypto.Policy, data, filename)
+		return nil, err
+	}
+	alg, _ := p.MeasurementAlgorithm()
+	if alg != 0 {
+		if err := setMeasurementHash(alg); err != nil {
+			measureData(crypto.Policy, data, filename)
+			return nil, fmt.Errorf("cannot use the measurement hash of policy %s: %v", filename, err)
+		}
+	}
+	measureData(crypto.Policy, data, filename)
+	return p, nil
+}
+
+// parse parses and checks the content of the policy file filename.
+func parse(data []byte, filename string) (*Policy, error) {
+	var p Policy
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&p); err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %v", filename, err)
+	}
+	for _, pattern := range p.AllowedKernels {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid kernel pattern %q in policy %s: %v", pattern, filename, err)
+		}
+	}
+	for _, profile := range p.Profiles {
+		for _, pattern := range []string{profile.Vendor, profile.Product} {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid hardware pattern %q in policy %s: %v", pattern, filename, err)
+			}
+		}
+	}
+	for _, pcr := range p.QuotePCRs {
+		if pcr < 0 || pcr > 23 {
+			return nil, fmt.Errorf("invalid quote PCR %d in policy %s", pcr, filename)
+		}
+	}
+	if _, err := p.MeasurementAlgorithm(); err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %v", filename, err)
+	}
+	switch p.FilePermissions {
+	case "", FilePermissionsWarn, FilePermissionsEnforce:
+	default:
+		return nil, fmt.Errorf("invalid file_permissions %q in policy %s", p.FilePermissions, filename)
+	}
+	return &p, nil
+}
+
+// QuoteSelection returns the PCRs to quote for a server that requested the
+// given ones, or all the PCRs allowed by the policy if it requested none. It
+// fails if any requested PCR is not allowed.
+func (p *Policy) QuoteSelection(requested []int) ([]int, error) {
+	if len(p.QuotePCRs) == 0 {
+		return nil, errors.New("the boot policy allows no PCR in quotes")
+	}
+	if len(requested) == 0 {
+		return p.QuotePCRs, nil
+	}
+	allowed := make(map[int]bool, len(p.QuotePCRs))
+	for _, pcr := range p.QuotePCRs {
+		allowed[pcr] = true
+	}
+	for _, pcr := range requested {
+		if !allowed[pcr] {
+			return nil, fmt.Errorf("the boot policy does not allow quoting PCR %d", pcr)
+		}
+	}
+	return requested, nil
+}
+
+// quotePCRs asks the TPM for a quote. It is a variable so it can be
+// overridden for testing.
+var quotePCRs = crypto.QuotePCRs
+
+// Quote returns a TPM quote of the PCRs requested by a provisioning server,
+// signed with the attestation key in akContext, after checking them against
+// the policy, see QuoteSelection.
+func (p *Policy) Quote(akContext string, requested []int, nonce []byte) (*crypto.Quote, error) {
+	selection, err := p.QuoteSelection(requested)
+	if err != nil {
+		return nil, err
+	}
+	return quotePCRs(akContext, selection, crypto.MeasurementHash(), nonce)
+}
+
+// fileDevice returns the ID of the device a file is on. It is a variable so it
+// can be overridden for testing.
+var fileDevice = func(filename string) (uint64, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(filename, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Dev), nil
+}
+
+// checkSameDevice returns an error if the kernel, initramfs and device tree of
+// a boot configuration are not all on the same device.
+func checkSameDevice(bc *bootconfig.BootConfig) error {
+	kernelDev, err := fileDevice(bc.Kernel)
+	if err != nil {
+		return err
+	}
+	for _, filename := range []string{bc.Initramfs, bc.DeviceTree} {
+		if filename == "" {
+			continue
+		}
+		dev, err := fileDevice(filename)
+		if err != nil {
+			return err
+		}
+		if dev != kernelDev {
+			return fmt.Errorf("%s is not on the same device as kernel %s", filename, bc.Kernel)
+		}
+	}
+	return nil
+}
+
+// checkFilePermissions returns an error if the kernel, initramfs or device
+// tree of a boot configuration is writable by anyone else than the user
+// running systemboot, i.e. if it is world-writable, group-writable by another
+// group, or owned by another user.
+func checkFilePermissions(bc *bootconfig.BootConfig) error {
+	uid, gid := uint32(os.Getuid()), uint32(os.Getgid())
+	for _, filename := range []string{bc.Kernel, bc.Initramfs, bc.DeviceTree} {
+		if filename == "" {
+			continue
+		}
+		info, err := os.Stat(filename)
+		if err != nil {
+			return err
+		}
+		mode := info.Mode().Perm()
+		if mode&0002 != 0 {
+			return fmt.Errorf("%s is world-writable (%v)", filename, mode)
+		}
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			continue
+		}
+		if st.Uid != uid {
+			return fmt.Errorf("%s is owned by uid %d", filename, st.Uid)
+		}
+		if mode&0020 != 0 && st.Gid != gid {
+			return fmt.Errorf("%s is writable by gid %d (%v)", filename, st.Gid, mode)
+		}
+	}
+	return nil
+}
+
+// Allows returns true if the policy allows booting the given configuration.
+// Configurations that halt, reboot or power off instead are always allowed.
+func (p *Policy) Allows(bc *bootconfig.BootConfig) bool {
+	if bc.Action != "" {
+		return true
+	}
+	if p.SameDevice {
+		if err := checkSameDevice(bc); err != nil {
+			log.Printf("The boot policy refuses %q: %v", bc.Name, err)
+			return false
+		}
+	}
+	if p.FilePermissions != "" {
+		if err := checkFilePermissions(bc); err != nil {
+			if p.FilePermissions == FilePermissionsEnforce {
+				log.Printf("The boot policy refuses %q: %v", bc.Name, err)
+				return false
+			}
+			log.Printf("Warning: unsafe boot files for %q: %v", bc.Name, err)
+		}
+	}
+	if len(p.AllowedKernels) == 0 {
+		return true
+	}
+	for _, pattern := range p.AllowedKernels {
+		if ok, _ := filepath.Match(pattern, bc.Kernel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply returns the configurations allowed by the policy, with the policy
+// applied to their kernel command line, including the arguments of the
+// profile matching the hardware, if any.
+func (p *Policy) Apply(bootconfigs []bootconfig.BootConfig) []bootconfig.BootConfig {
+	args := p.CmdlineAppend
+	if profile := p.Profile(); profile != nil {
+		log.Printf("Applying the boot policy profile %q", profile.Name)
+		args = append(append([]string(nil), args...), profile.CmdlineAppend...)
+	}
+	allowed := make([]bootconfig.BootConfig, 0, len(bootconfigs))
+	for _, bc := range bootconfigs {
+		if !p.Allows(&bc) {
+			continue
+		}
+		for _, arg := range args {
+			bc.AppendArg(arg, "")
+		}
+		allowed = append(allowed, bc)
+	}
+	return allowed
+}
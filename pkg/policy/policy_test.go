@@ -0,0 +1,258 @@
+package policy
+
+import (
+	gocrypto "crypto"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/systemboot/systemboot/pkg/bootconfig"
+	"github.com/systemboot/systemboot/pkg/crypto"
+)
+
+type measurement struct {
+	pcr  uint32
+	data []byte
+	info string
+}
+
+// recordMeasurements replaces measureData until the returned function is
+// called, and records the measurements in the given slice.
+func recordMeasurements(measurements *[]measurement) func() {
+	orig := measureData
+	measureData = func(pcr uint32, data []byte, info string) {
+		*measurements = append(*measurements, measurement{pcr, data, info})
+	}
+	return func() { measureData = orig }
+}
+
+func writePolicy(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "policy")
+	require.NoError(t, err)
+	filename := path.Join(dir, "policy.json")
+	require.NoError(t, ioutil.WriteFile(filename, []byte(content), 0644))
+	return filename, func() { os.RemoveAll(dir) }
+}
+
+func TestLoadMeasuresPolicy(t *testing.T) {
+	content := `{"cmdline_append": ["quiet"], "disable_scanners": ["syslinux"]}`
+	filename, cleanup := writePolicy(t, content)
+	defer cleanup()
+	var measurements []measurement
+	defer recordMeasurements(&measurements)()
+
+	p, err := Load(filename)
+	require.NoError(t, err)
+	require.Equal(t, []string{"quiet"}, p.CmdlineAppend)
+	require.Equal(t, []string{"syslinux"}, p.DisableScanners)
+	require.Equal(t, []measurement{{crypto.Policy, []byte(content), filename}}, measurements)
+}
+
+func TestLoadMeasuresInvalidPolicy(t *testing.T) {
+	// a broken or tampered policy is measured too, before being refused
+	content := `{"cmdline_append": ["quiet"], "unknown": true}`
+	filename, cleanup := writePolicy(t, content)
+	defer cleanup()
+	var measurements []measurement
+	defer recordMeasurements(&measurements)()
+
+	_, err := Load(filename)
+	require.Error(t, err)
+	require.Equal(t, []measurement{{crypto.Policy, []byte(content), filename}}, measurements)
+}
+
+func TestApply(t *testing.T) {
+	p := Policy{
+		CmdlineAppend:  []string{"quiet", "lockdown=integrity"},
+		AllowedKernels: []string{"/mnt/*/boot/vmlinuz-*"},
+	}
+	bootconfigs := p.Apply([]bootconfig.BootConfig{
+		{Kernel: "/mnt/sda1/boot/vmlinuz-4.19", KernelArgs: "root=/dev/sda1 -- single"},
+		{Kernel: "/mnt/sda1/vmlinuz"},
+		{Kernel: "/mnt/sdb1/boot/vmlinuz-5.0"},
+		{Name: "Reboot", Action: bootconfig.ActionReboot},
+	})
+	require.Equal(t, 3, len(bootconfigs))
+	require.Equal(t, "root=/dev/sda1 quiet lockdown=integrity -- single", bootconfigs[0].KernelArgs)
+	require.Equal(t, "/mnt/sdb1/boot/vmlinuz-5.0", bootconfigs[1].Kernel)
+	require.Equal(t, "quiet lockdown=integrity", bootconfigs[1].KernelArgs)
+	require.Equal(t, "Reboot", bootconfigs[2].Name)
+}
+
+func TestApplySameDevice(t *testing.T) {
+	defer func(orig func(string) (uint64, error)) { fileDevice = orig }(fileDevice)
+	devices := map[string]uint64{
+		"/mnt/sda1/vmlinuz":    1,
+		"/mnt/sda1/initrd.img": 1,
+		"/mnt/sda1/board.dtb":  1,
+		"/mnt/sdb1/initrd.img": 2,
+	}
+	fileDevice = func(filename string) (uint64, error) {
+		dev, ok := devices[filename]
+		if !ok {
+			return 0, os.ErrNotExist
+		}
+		return dev, nil
+	}
+
+	p := Policy{SameDevice: true}
+	bootconfigs := p.Apply([]bootconfig.BootConfig{
+		{Name: "same device", Kernel: "/mnt/sda1/vmlinuz", Initramfs: "/mnt/sda1/initrd.img", DeviceTree: "/mnt/sda1/board.dtb"},
+		{Name: "initrd on another device", Kernel: "/mnt/sda1/vmlinuz", Initramfs: "/mnt/sdb1/initrd.img"},
+		{Name: "missing initrd", Kernel: "/mnt/sda1/vmlinuz", Initramfs: "/mnt/sda1/missing.img"},
+		{Name: "no initrd", Kernel: "/mnt/sda1/vmlinuz"},
+		{Name: "Reboot", Action: bootconfig.ActionReboot},
+	})
+	var names []string
+	for _, bc := range bootconfigs {
+		names = append(names, bc.Name)
+	}
+	require.Equal(t, []string{"same device", "no initrd", "Reboot"}, names)
+}
+
+func TestApplyFilePermissions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	kernel := path.Join(dir, "vmlinuz")
+	initrd := path.Join(dir, "initrd.img")
+	tampered := path.Join(dir, "vmlinuz-tampered")
+	for _, filename := range []string{kernel, initrd, tampered} {
+		require.NoError(t, ioutil.WriteFile(filename, []byte("data"), 0644))
+	}
+	// chmod, as the umask may drop the write bit
+	require.NoError(t, os.Chmod(tampered, 0666))
+
+	bootconfigs := []bootconfig.BootConfig{
+		{Name: "safe", Kernel: kernel, Initramfs: initrd},
+		{Name: "world-writable kernel", Kernel: tampered, Initramfs: initrd},
+		{Name: "missing initrd", Kernel: kernel, Initramfs: path.Join(dir, "missing.img")},
+		{Name: "Reboot", Action: bootconfig.ActionReboot},
+	}
+	names := func(bootconfigs []bootconfig.BootConfig) []string {
+		var names []string
+		for _, bc := range bootconfigs {
+			names = append(names, bc.Name)
+		}
+		return names
+	}
+	p := Policy{FilePermissions: FilePermissionsEnforce}
+	require.Equal(t, []string{"safe", "Reboot"}, names(p.Apply(bootconfigs)))
+	// only logged
+	p = Policy{FilePermissions: FilePermissionsWarn}
+	require.Equal(t, []string{"safe", "world-writable kernel", "missing initrd", "Reboot"}, names(p.Apply(bootconfigs)))
+}
+
+func TestLoadFilePermissions(t *testing.T) {
+	var measurements []measurement
+	defer recordMeasurements(&measurements)()
+
+	filename, cleanup := writePolicy(t, `{"file_permissions": "enforce"}`)
+	defer cleanup()
+	p, err := Load(filename)
+	require.NoError(t, err)
+	require.Equal(t, FilePermissionsEnforce, p.FilePermissions)
+
+	filename, cleanup = writePolicy(t, `{"file_permissions": "strict"}`)
+	defer cleanup()
+	_, err = Load(filename)
+	require.Error(t, err)
+}
+
+func TestQuoteSelection(t *testing.T) {
+	p := Policy{QuotePCRs: []int{7, 8}}
+	selection, err := p.QuoteSelection(nil)
+	require.NoError(t, err)
+	require.Equal(t, []int{7, 8}, selection)
+	selection, err = p.QuoteSelection([]int{8})
+	require.NoError(t, err)
+	require.Equal(t, []int{8}, selection)
+
+	_, err = (&Policy{}).QuoteSelection([]int{7})
+	require.Error(t, err)
+}
+
+func TestQuoteRefusesPCROutsidePolicy(t *testing.T) {
+	called := false
+	defer func(orig func(string, []int, gocrypto.Hash, []byte) (*crypto.Quote, error)) { quotePCRs = orig }(quotePCRs)
+	quotePCRs = func(akContext string, selection []int, alg gocrypto.Hash, nonce []byte) (*crypto.Quote, error) {
+		called = true
+		return &crypto.Quote{Selection: selection}, nil
+	}
+
+	p := Policy{QuotePCRs: []int{7, 8}}
+	_, err := p.Quote("ak.ctx", []int{0, 7}, []byte("nonce"))
+	require.Error(t, err)
+	require.False(t, called)
+
+	quote, err := p.Quote("ak.ctx", []int{7}, []byte("nonce"))
+	require.NoError(t, err)
+	require.True(t, called)
+	require.Equal(t, []int{7}, quote.Selection)
+}
+
+func TestLoadInvalidQuotePCR(t *testing.T) {
+	filename, cleanup := writePolicy(t, `{"quote_pcrs": [7, 24]}`)
+	defer cleanup()
+	var measurements []measurement
+	defer recordMeasurements(&measurements)()
+
+	_, err := Load(filename)
+	require.Error(t, err)
+}
+
+func TestLoadMeasurementHash(t *testing.T) {
+	var measurements []measurement
+	defer recordMeasurements(&measurements)()
+	defer func(orig func(gocrypto.Hash) error) { setMeasurementHash = orig }(setMeasurementHash)
+	var selected gocrypto.Hash
+	setMeasurementHash = func(alg gocrypto.Hash) error {
+		// the bank is selected before the policy is measured into it
+		require.Empty(t, measurements)
+		selected = alg
+		return nil
+	}
+
+	filename, cleanup := writePolicy(t, `{"measurement_hash": "sha384"}`)
+	defer cleanup()
+	p, err := Load(filename)
+	require.NoError(t, err)
+	alg, err := p.MeasurementAlgorithm()
+	require.NoError(t, err)
+	require.Equal(t, gocrypto.SHA384, alg)
+	require.Equal(t, gocrypto.SHA384, selected)
+	require.Len(t, measurements, 1)
+
+	filename, cleanup = writePolicy(t, `{"measurement_hash": "sha1"}`)
+	defer cleanup()
+	_, err = Load(filename)
+	require.Error(t, err)
+}
+
+func fakeSMBIOS(vendor, product string) func() {
+	savedVendor, savedProduct := smbiosVendor, smbiosProduct
+	smbiosVendor = func() (string, error) { return vendor, nil }
+	smbiosProduct = func() (string, error) { return product, nil }
+	return func() { smbiosVendor, smbiosProduct = savedVendor, savedProduct }
+}
+
+func TestApplyProfile(t *testing.T) {
+	p := Policy{
+		CmdlineAppend: []string{"quiet"},
+		Profiles: []Profile{
+			{Name: "old boards", Vendor: "Acme", Product: "X1*", CmdlineAppend: []string{"noapic"}},
+			{Name: "new boards", Product: "X2*", CmdlineAppend: []string{"intel_iommu=on"}},
+		},
+	}
+	defer fakeSMBIOS("Acme", "X200 Server")()
+	bootconfigs := p.Apply([]bootconfig.BootConfig{{Kernel: "/vmlinuz", KernelArgs: "ro"}})
+	require.Equal(t, "ro quiet intel_iommu=on", bootconfigs[0].KernelArgs)
+	require.Equal(t, []string{"quiet"}, p.CmdlineAppend)
+
+	// no match, no append
+	defer fakeSMBIOS("Other", "X100")()
+	bootconfigs = p.Apply([]bootconfig.BootConfig{{Kernel: "/vmlinuz", KernelArgs: "ro"}})
+	require.Equal(t, "ro quiet", bootconfigs[0].KernelArgs)
+}
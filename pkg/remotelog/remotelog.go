@@ -0,0 +1,134 @@
+// Package remotelog sends log messages to a remote syslog server, in the RFC
+// 5424 format, so that the boot decisions can be collected centrally. Network
+// failures never block the boot: messages that cannot be sent are dropped.
+package remotelog
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPort is the port used for syslog URLs without one.
+const DefaultPort = "514"
+
+// Facility and severity of the messages, see RFC 5424 section 6.2.1.
+const (
+	FacilityDaemon = 3
+	SeverityInfo   = 6
+)
+
+// Timeouts of the network operations, and how long messages are dropped
+// after a failure before trying to connect again.
+var (
+	DialTimeout   = time.Second
+	WriteTimeout  = time.Second
+	RetryInterval = 30 * time.Second
+)
+
+// Writer is an io.Writer that sends every write as a syslog message. It can be
+// used as the output of a log.Logger, e.g. with io.MultiWriter.
+type Writer struct {
+	network, address string
+	appName          string
+	hostname         string
+
+	mu          sync.Mutex
+	conn        net.Conn
+	failedUntil time.Time
+}
+
+// New returns a Writer that sends messages to rawurl, e.g. udp://10.0.0.1 or
+// tcp://logs.example.com:6514, as appName. It connects lazily, on the first
+// message.
+func New(rawurl, appName string) (*Writer, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "udp" && u.Scheme != "tcp" {
+		return nil, fmt.Errorf("unsupported syslog URL %s, only udp:// and tcp:// are", rawurl)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("no host in syslog URL %s", rawurl)
+	}
+	port := u.Port()
+	if port == "" {
+		port = DefaultPort
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	return &Writer{
+		network:  u.Scheme,
+		address:  net.JoinHostPort(u.Hostname(), port),
+		appName:  appName,
+		hostname: hostname,
+	}, nil
+}
+
+// Format returns msg as an RFC 5424 syslog message, without structured data.
+func (w *Writer) Format(t time.Time, msg string) string {
+	return fmt.Sprintf("<%d>1 %s %s %s %d - - %s",
+		FacilityDaemon*8+SeverityInfo,
+		t.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		w.hostname, w.appName, os.Getpid(), msg)
+}
+
+// Write sends p as a syslog message, without its trailing newline. It never
+// fails: if the message cannot be sent, it is dropped, and the next messages
+// too for RetryInterval, so that an unreachable server does not slow the boot
+// down.
+func (w *Writer) Write(p []byte) (int, error) {
+	msg := w.Format(time.Now(), strings.TrimRight(string(p), "\n"))
+	if w.network == "tcp" {
+		// octet counting framing, see RFC 6587
+		msg = fmt.Sprintf("%d %s", len(msg), msg)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if time.Now().Before(w.failedUntil) {
+		return len(p), nil
+	}
+	if err := w.send(msg); err != nil {
+		if w.conn != nil {
+			w.conn.Close()
+			w.conn = nil
+		}
+		w.failedUntil = time.Now().Add(RetryInterval)
+		fmt.Fprintf(os.Stderr, "Cannot send logs to %s://%s, dropping them for %v: %v\n", w.network, w.address, RetryInterval, err)
+	}
+	return len(p), nil
+}
+
+func (w *Writer) send(msg string) error {
+	if w.conn == nil {
+		conn, err := net.DialTimeout(w.network, w.address, DialTimeout)
+		if err != nil {
+			return err
+		}
+		w.conn = conn
+	}
+	if err := w.conn.SetWriteDeadline(time.Now().Add(WriteTimeout)); err != nil {
+		return err
+	}
+	_, err := w.conn.Write([]byte(msg))
+	return err
+}
+
+// Close closes the connection to the server, if any.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
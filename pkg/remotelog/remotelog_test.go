@@ -0,0 +1,63 @@
+package remotelog
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	w, err := New("udp://"+conn.LocalAddr().String(), "localboot")
+	require.NoError(t, err)
+	defer w.Close()
+	logger := log.New(w, "", 0)
+	logger.Printf("Found %d boot configs", 2)
+	logger.Print("Booting kernel /mnt/sda1/boot/vmlinuz")
+
+	buf := make([]byte, 1024)
+	for _, msg := range []string{"Found 2 boot configs", "Booting kernel /mnt/sda1/boot/vmlinuz"} {
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		pattern := fmt.Sprintf(`^<30>1 \d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6}Z \S+ localboot %d - - %s$`, os.Getpid(), regexp.QuoteMeta(msg))
+		require.True(t, regexp.MustCompile(pattern).MatchString(string(buf[:n])), string(buf[:n]))
+	}
+}
+
+func TestWriteUnreachable(t *testing.T) {
+	// a TCP port that nothing listens on
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+
+	w, err := New("tcp://"+address, "localboot")
+	require.NoError(t, err)
+	n, err := w.Write([]byte("Booting\n"))
+	require.NoError(t, err)
+	require.Equal(t, 8, n)
+	// the next messages are dropped without trying to connect again
+	require.True(t, time.Now().Before(w.failedUntil))
+	_, err = w.Write([]byte("Booting\n"))
+	require.NoError(t, err)
+}
+
+func TestNewInvalid(t *testing.T) {
+	for _, rawurl := range []string{"http://10.0.0.1", "udp://", "10.0.0.1:514"} {
+		_, err := New(rawurl, "localboot")
+		require.Error(t, err, rawurl)
+	}
+	w, err := New("udp://10.0.0.1", "netboot")
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.1:514", w.address)
+}
@@ -0,0 +1,45 @@
+// Package safemode implements a global read-only mode, for forensic and
+// recovery use: once enabled, the operations that could change the state of
+// the machine, like writing to disks, updating boot counters or kexec'ing,
+// refuse to run.
+package safemode
+
+import (
+	"sync/atomic"
+)
+
+var enabled int32
+
+// Enable turns safe mode on.
+func Enable() {
+	atomic.StoreInt32(&enabled, 1)
+}
+
+// Disable turns safe mode off. It is meant for tests.
+func Disable() {
+	atomic.StoreInt32(&enabled, 0)
+}
+
+// Enabled returns true if safe mode is on.
+func Enabled() bool {
+	return atomic.LoadInt32(&enabled) == 1
+}
+
+// Error is returned by the operations refused in safe mode.
+type Error struct {
+	// Op describes the refused operation, e.g. "kexec"
+	Op string
+}
+
+func (e *Error) Error() string {
+	return "safe mode: refusing to " + e.Op
+}
+
+// Check returns an Error if safe mode is on, and nil otherwise. It is called
+// before any operation that would change the state of the machine.
+func Check(op string) error {
+	if Enabled() {
+		return &Error{Op: op}
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package safemode
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	require.False(t, Enabled())
+	require.NoError(t, Check("kexec"))
+
+	Enable()
+	defer Disable()
+	require.True(t, Enabled())
+	err := Check("kexec")
+	require.Equal(t, &Error{Op: "kexec"}, err)
+	require.Equal(t, "safe mode: refusing to kexec", err.Error())
+}
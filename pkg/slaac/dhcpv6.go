@@ -0,0 +1,152 @@
+package slaac
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// DHCPv6 constants, see RFC 8415 and RFC 5970.
+const (
+	clientPort = 546
+	serverPort = 547
+
+	msgReply              = 7
+	msgInformationRequest = 11
+
+	optClientID       = 1
+	optORO            = 6
+	optElapsedTime    = 8
+	optStatusCode     = 13
+	optDNSServers     = 23
+	optBootFileURL    = 59
+	optBootFileParams = 60
+)
+
+// allServers is the All_DHCP_Relay_Agents_and_Servers multicast address.
+var allServers = net.ParseIP("ff02::1:2")
+
+// retransmitInterval is how long exchange waits for a reply before sending
+// the information request again.
+var retransmitInterval = time.Second
+
+func appendOption(msg []byte, code uint16, data []byte) []byte {
+	var header [4]byte
+	binary.BigEndian.PutUint16(header[0:], code)
+	binary.BigEndian.PutUint16(header[2:], uint16(len(data)))
+	return append(append(msg, header[:]...), data...)
+}
+
+// newInformationRequest builds an information request asking for the boot
+// file URL and parameters and the DNS servers.
+func newInformationRequest(xid [3]byte, clientID []byte) []byte {
+	msg := append([]byte{msgInformationRequest}, xid[:]...)
+	if clientID != nil {
+		msg = appendOption(msg, optClientID, clientID)
+	}
+	msg = appendOption(msg, optElapsedTime, []byte{0, 0})
+	oro := make([]byte, 0, 6)
+	for _, code := range []uint16{optBootFileURL, optBootFileParams, optDNSServers} {
+		oro = append(oro, byte(code>>8), byte(code))
+	}
+	return appendOption(msg, optORO, oro)
+}
+
+// errOtherTransaction is returned by parseReply for a message that is not a
+// reply to our request.
+var errOtherTransaction = errors.New("not a reply to the information request")
+
+// parseReply parses the reply to the information request with the given
+// transaction ID.
+func parseReply(msg []byte, xid [3]byte) (*Info, error) {
+	if len(msg) < 4 || msg[0] != msgReply || !bytes.Equal(msg[1:4], xid[:]) {
+		return nil, errOtherTransaction
+	}
+	var info Info
+	for opts := msg[4:]; len(opts) > 0; {
+		if len(opts) < 4 {
+			return nil, errors.New("truncated option header")
+		}
+		code := binary.BigEndian.Uint16(opts[0:])
+		length := int(binary.BigEndian.Uint16(opts[2:]))
+		if len(opts) < 4+length {
+			return nil, fmt.Errorf("truncated option %d", code)
+		}
+		data := opts[4 : 4+length]
+		opts = opts[4+length:]
+		switch code {
+		case optStatusCode:
+			if length < 2 {
+				return nil, errors.New("truncated status code")
+			}
+			if status := binary.BigEndian.Uint16(data); status != 0 {
+				return nil, fmt.Errorf("server returned status %d: %s", status, data[2:])
+			}
+		case optBootFileURL:
+			info.BootFileURL = string(data)
+		case optBootFileParams:
+			for len(data) > 0 {
+				if len(data) < 2 {
+					return nil, errors.New("truncated boot file parameter")
+				}
+				n := int(binary.BigEndian.Uint16(data))
+				if len(data) < 2+n {
+					return nil, errors.New("truncated boot file parameter")
+				}
+				info.BootFileParams = append(info.BootFileParams, string(data[2:2+n]))
+				data = data[2+n:]
+			}
+		case optDNSServers:
+			if length%net.IPv6len != 0 {
+				return nil, fmt.Errorf("invalid DNS servers option length %d", length)
+			}
+			for i := 0; i < length; i += net.IPv6len {
+				info.DNSServers = append(info.DNSServers, net.IP(append([]byte(nil), data[i:i+net.IPv6len]...)))
+			}
+		}
+	}
+	return &info, nil
+}
+
+// exchange sends an information request to dst, retransmitting it every
+// retransmitInterval, until it gets a reply or timeout expires.
+func exchange(conn net.PacketConn, dst net.Addr, clientID []byte, timeout time.Duration) (*Info, error) {
+	var xid [3]byte
+	if _, err := rand.Read(xid[:]); err != nil {
+		return nil, err
+	}
+	request := newInformationRequest(xid, clientID)
+	deadline := time.Now().Add(timeout)
+	buf := make([]byte, 65536)
+	for time.Now().Before(deadline) {
+		if _, err := conn.WriteTo(request, dst); err != nil {
+			return nil, err
+		}
+		wait := time.Now().Add(retransmitInterval)
+		if wait.After(deadline) {
+			wait = deadline
+		}
+		if err := conn.SetReadDeadline(wait); err != nil {
+			return nil, err
+		}
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			info, err := parseReply(buf[:n], xid)
+			if err == errOtherTransaction {
+				continue
+			}
+			return info, err
+		}
+	}
+	return nil, fmt.Errorf("no reply after %v", timeout)
+}
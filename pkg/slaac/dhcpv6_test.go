@@ -0,0 +1,99 @@
+package slaac
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInformationRequest(t *testing.T) {
+	xid := [3]byte{1, 2, 3}
+	msg := newInformationRequest(xid, []byte{0, 3, 0, 1, 0xaa, 0xbb})
+	require.Equal(t, []byte{
+		msgInformationRequest, 1, 2, 3,
+		0, optClientID, 0, 6, 0, 3, 0, 1, 0xaa, 0xbb,
+		0, optElapsedTime, 0, 2, 0, 0,
+		0, optORO, 0, 6, 0, optBootFileURL, 0, optBootFileParams, 0, optDNSServers,
+	}, msg)
+}
+
+// reply builds a reply to the transaction xid with the given options.
+func reply(xid [3]byte, opts ...[]byte) []byte {
+	msg := append([]byte{msgReply}, xid[:]...)
+	for _, opt := range opts {
+		msg = append(msg, opt...)
+	}
+	return msg
+}
+
+func option(code uint16, data []byte) []byte {
+	return appendOption(nil, code, data)
+}
+
+func TestParseReply(t *testing.T) {
+	xid := [3]byte{1, 2, 3}
+	dns := net.ParseIP("2001:db8::53")
+	msg := reply(xid,
+		option(optBootFileURL, []byte("http://[2001:db8::2]/boot.json")),
+		option(optBootFileParams, []byte{0, 3, 'f', 'o', 'o', 0, 1, 'x'}),
+		option(optDNSServers, dns),
+		option(42, []byte{1, 2}),
+	)
+	info, err := parseReply(msg, xid)
+	require.NoError(t, err)
+	require.Equal(t, "http://[2001:db8::2]/boot.json", info.BootFileURL)
+	require.Equal(t, []string{"foo", "x"}, info.BootFileParams)
+	require.Equal(t, []net.IP{dns}, info.DNSServers)
+
+	_, err = parseReply(msg, [3]byte{4, 5, 6})
+	require.Equal(t, errOtherTransaction, err)
+
+	_, err = parseReply(reply(xid, option(optStatusCode, []byte{0, 2, 'n', 'o'})), xid)
+	require.Error(t, err)
+
+	_, err = parseReply(append(reply(xid), 0, optBootFileURL, 0, 10, 'h'), xid)
+	require.Error(t, err)
+}
+
+func TestExchange(t *testing.T) {
+	server, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer server.Close()
+	client, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer client.Close()
+
+	go func() {
+		buf := make([]byte, 1500)
+		n, addr, err := server.ReadFrom(buf)
+		if err != nil || n < 4 || buf[0] != msgInformationRequest {
+			return
+		}
+		var xid [3]byte
+		copy(xid[:], buf[1:4])
+		// a reply to another client is ignored
+		server.WriteTo(reply([3]byte{xid[0] + 1, xid[1], xid[2]}), addr)
+		server.WriteTo(reply(xid, option(optBootFileURL, []byte("http://example.com/boot"))), addr)
+	}()
+	info, err := exchange(client, server.LocalAddr(), nil, 5*time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "http://example.com/boot", info.BootFileURL)
+}
+
+func TestExchangeTimeout(t *testing.T) {
+	saved := retransmitInterval
+	defer func() { retransmitInterval = saved }()
+	retransmitInterval = 10 * time.Millisecond
+
+	server, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer server.Close()
+	client, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, err = exchange(client, server.LocalAddr(), nil, 50*time.Millisecond)
+	require.Error(t, err)
+}
@@ -0,0 +1,149 @@
+// Package slaac configures a network interface for netbooting without a
+// DHCPv6 lease: the addresses come from IPv6 stateless address
+// autoconfiguration (SLAAC, RFC 4862) on router advertisements, and the boot
+// file URL from a stateless DHCPv6 information request (RFC 8415, section
+// 6.1).
+package slaac
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"path"
+	"time"
+)
+
+// Mechanisms that can configure an interface.
+const (
+	// MechanismSLAAC means that the addresses were autoconfigured from router
+	// advertisements, but no boot file URL was obtained
+	MechanismSLAAC = "slaac"
+	// MechanismStateless means that the addresses were autoconfigured, and
+	// the boot file URL was obtained with a DHCPv6 information request
+	MechanismStateless = "slaac+dhcpv6-stateless"
+)
+
+// Info is the information returned by a DHCPv6 server to an
+// information request.
+type Info struct {
+	BootFileURL    string
+	BootFileParams []string
+	DNSServers     []net.IP
+}
+
+// Layer autoconfigures interfaces and sends information requests. It is an
+// interface so it can be replaced for testing.
+type Layer interface {
+	// Autoconfigure enables SLAAC on an interface, and waits up to timeout
+	// for it to get a global address. It returns the global addresses.
+	Autoconfigure(ifname string, timeout time.Duration) ([]net.IPNet, error)
+	// InformationRequest sends a DHCPv6 information request through an
+	// interface, identifying as clientID if not nil, and waits up to timeout
+	// for a reply.
+	InformationRequest(ifname string, clientID []byte, timeout time.Duration) (*Info, error)
+}
+
+// Config is the configuration of an interface.
+type Config struct {
+	Addresses   []net.IPNet
+	DNSServers  []net.IP
+	BootFileURL string
+	// Mechanism is how the interface was configured, MechanismSLAAC or
+	// MechanismStateless
+	Mechanism string
+}
+
+// Configure autoconfigures an interface and asks a DHCPv6 server for its boot
+// file URL. If needBootFile is false, e.g. because the boot file URL is set
+// on the command line, a failed information request is not an error, and the
+// returned configuration has MechanismSLAAC.
+func Configure(layer Layer, ifname string, clientID []byte, timeout time.Duration, needBootFile bool) (*Config, error) {
+	addrs, err := layer.Autoconfigure(ifname, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("SLAAC failed on interface %s: %v", ifname, err)
+	}
+	cfg := Config{Addresses: addrs, Mechanism: MechanismSLAAC}
+	info, err := layer.InformationRequest(ifname, clientID, timeout)
+	if err == nil && info.BootFileURL == "" {
+		err = errors.New("the reply has no boot file URL")
+	}
+	if err != nil {
+		if needBootFile {
+			return nil, fmt.Errorf("DHCPv6 information request failed on interface %s: %v", ifname, err)
+		}
+		log.Printf("DHCPv6 information request failed on interface %s: %v", ifname, err)
+		return &cfg, nil
+	}
+	cfg.DNSServers = info.DNSServers
+	cfg.BootFileURL = info.BootFileURL
+	cfg.Mechanism = MechanismStateless
+	return &cfg, nil
+}
+
+// Kernel is the Layer of the running kernel: SLAAC is enabled through
+// /proc/sys, and information requests are sent over UDP.
+var Kernel Layer = kernelLayer{}
+
+// procSysPath is where the IPv6 settings of the interfaces are. It is a
+// variable so it can be overridden for testing.
+var procSysPath = "/proc/sys/net/ipv6/conf"
+
+// pollInterval is how often Autoconfigure checks for a global address.
+var pollInterval = 100 * time.Millisecond
+
+type kernelLayer struct{}
+
+func (kernelLayer) Autoconfigure(ifname string, timeout time.Duration) ([]net.IPNet, error) {
+	for _, setting := range []string{"accept_ra", "autoconf"} {
+		if err := ioutil.WriteFile(path.Join(procSysPath, ifname, setting), []byte("1"), 0644); err != nil {
+			return nil, fmt.Errorf("cannot enable %s: %v", setting, err)
+		}
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		addrs, err := globalAddrs(ifname)
+		if err != nil {
+			return nil, err
+		}
+		if len(addrs) > 0 {
+			return addrs, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("no global IPv6 address after %v", timeout)
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// globalAddrs returns the global IPv6 addresses of an interface.
+func globalAddrs(ifname string) ([]net.IPNet, error) {
+	iface, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var global []net.IPNet
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.To4() != nil || !ipnet.IP.IsGlobalUnicast() {
+			continue
+		}
+		global = append(global, *ipnet)
+	}
+	return global, nil
+}
+
+func (kernelLayer) InformationRequest(ifname string, clientID []byte, timeout time.Duration) (*Info, error) {
+	conn, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6unspecified, Port: clientPort, Zone: ifname})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	dst := &net.UDPAddr{IP: allServers, Port: serverPort, Zone: ifname}
+	return exchange(conn, dst, clientID, timeout)
+}
@@ -0,0 +1,71 @@
+package slaac
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLayer is a Layer that returns canned results.
+type fakeLayer struct {
+	addrs     []net.IPNet
+	raErr     error
+	info      *Info
+	infoErr   error
+	requested bool
+}
+
+func (f *fakeLayer) Autoconfigure(ifname string, timeout time.Duration) ([]net.IPNet, error) {
+	return f.addrs, f.raErr
+}
+
+func (f *fakeLayer) InformationRequest(ifname string, clientID []byte, timeout time.Duration) (*Info, error) {
+	f.requested = true
+	return f.info, f.infoErr
+}
+
+var testAddr = net.IPNet{IP: net.ParseIP("2001:db8::1"), Mask: net.CIDRMask(64, 128)}
+
+func TestConfigureStateless(t *testing.T) {
+	layer := fakeLayer{
+		addrs: []net.IPNet{testAddr},
+		info: &Info{
+			BootFileURL: "http://[2001:db8::2]/boot.json",
+			DNSServers:  []net.IP{net.ParseIP("2001:db8::53")},
+		},
+	}
+	cfg, err := Configure(&layer, "eth0", nil, time.Second, true)
+	require.NoError(t, err)
+	require.Equal(t, MechanismStateless, cfg.Mechanism)
+	require.Equal(t, []net.IPNet{testAddr}, cfg.Addresses)
+	require.Equal(t, "http://[2001:db8::2]/boot.json", cfg.BootFileURL)
+	require.Equal(t, layer.info.DNSServers, cfg.DNSServers)
+}
+
+func TestConfigureNoRouterAdvertisement(t *testing.T) {
+	layer := fakeLayer{raErr: errors.New("no global IPv6 address")}
+	_, err := Configure(&layer, "eth0", nil, time.Second, false)
+	require.Error(t, err)
+	require.False(t, layer.requested)
+}
+
+func TestConfigureNoDHCPv6Server(t *testing.T) {
+	layer := fakeLayer{addrs: []net.IPNet{testAddr}, infoErr: errors.New("no reply")}
+	_, err := Configure(&layer, "eth0", nil, time.Second, true)
+	require.Error(t, err)
+
+	// the boot file URL is not needed, SLAAC alone configured the interface
+	cfg, err := Configure(&layer, "eth0", nil, time.Second, false)
+	require.NoError(t, err)
+	require.Equal(t, MechanismSLAAC, cfg.Mechanism)
+	require.Empty(t, cfg.BootFileURL)
+}
+
+func TestConfigureNoBootFileURL(t *testing.T) {
+	layer := fakeLayer{addrs: []net.IPNet{testAddr}, info: &Info{}}
+	_, err := Configure(&layer, "eth0", nil, time.Second, true)
+	require.Error(t, err)
+}
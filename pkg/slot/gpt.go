@@ -0,0 +1,85 @@
+package slot
+
+import (
+	"github.com/systemboot/systemboot/pkg/storage"
+)
+
+// ChromeOS-style GPT partition attribute bits, see
+// https://www.chromium.org/chromium-os/chromiumos-design-docs/disk-format
+const (
+	gptPriorityShift   = 48
+	gptTriesShift      = 52
+	gptSuccessfulShift = 56
+	gptSlotMask        = uint64(0x1ff) << gptPriorityShift
+)
+
+// GPTStore stores the slot state in the attributes of the GPT partitions
+// named after the slots, on the given disk.
+type GPTStore struct {
+	Disk  string
+	Names []string
+}
+
+// NewGPTStore returns a GPTStore for the default A/B slots on disk.
+func NewGPTStore(disk string) *GPTStore {
+	return &GPTStore{Disk: disk, Names: []string{NameA, NameB}}
+}
+
+// slotFromAttributes decodes the slot state from GPT partition attributes.
+func slotFromAttributes(name string, attrs uint64) Slot {
+	return Slot{
+		Name:           name,
+		Priority:       int(attrs>>gptPriorityShift) & 0xf,
+		TriesRemaining: int(attrs>>gptTriesShift) & 0xf,
+		Successful:     (attrs>>gptSuccessfulShift)&1 == 1,
+	}
+}
+
+// attributesFromSlot encodes the slot state into GPT partition attributes,
+// preserving the bits that are not used by the slot state.
+func attributesFromSlot(s Slot, attrs uint64) uint64 {
+	clamp := func(v int) uint64 {
+		if v > 0xf {
+			return 0xf
+		}
+		return uint64(v)
+	}
+	attrs &^= gptSlotMask
+	attrs |= clamp(s.Priority) << gptPriorityShift
+	attrs |= clamp(s.TriesRemaining) << gptTriesShift
+	if s.Successful {
+		attrs |= 1 << gptSuccessfulShift
+	}
+	return attrs
+}
+
+// Slots reads the slot state from the GPT.
+func (g *GPTStore) Slots() ([]Slot, error) {
+	slots := make([]Slot, 0, len(g.Names))
+	for _, name := range g.Names {
+		attrs, _, err := storage.GPTPartitionAttributes(g.Disk, name)
+		if err != nil {
+			return nil, err
+		}
+		slots = append(slots, slotFromAttributes(name, attrs))
+	}
+	return slots, nil
+}
+
+// Update writes the state of a slot to the GPT.
+func (g *GPTStore) Update(s Slot) error {
+	attrs, _, err := storage.GPTPartitionAttributes(g.Disk, s.Name)
+	if err != nil {
+		return err
+	}
+	return storage.SetGPTPartitionAttributes(g.Disk, s.Name, attributesFromSlot(s, attrs))
+}
+
+// Device returns the partition device of a slot.
+func (g *GPTStore) Device(name string) (string, error) {
+	_, number, err := storage.GPTPartitionAttributes(g.Disk, name)
+	if err != nil {
+		return "", err
+	}
+	return storage.PartitionDevName(g.Disk, number), nil
+}
@@ -0,0 +1,106 @@
+package slot
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/systemboot/systemboot/pkg/storage/storagetest"
+)
+
+func TestGPTAttributesRoundTrip(t *testing.T) {
+	s := Slot{Name: NameA, Priority: 15, TriesRemaining: 7, Successful: true}
+	// unrelated attribute bits, e.g. "required partition", are preserved
+	attrs := attributesFromSlot(s, 1)
+	require.Equal(t, uint64(1)|uint64(0x17f)<<48, attrs)
+	require.Equal(t, s, slotFromAttributes(NameA, attrs))
+	require.Equal(t, uint64(1), attributesFromSlot(Slot{}, attrs))
+}
+
+// bootOnce simulates a boot attempt: the slot is selected and the new state is
+// written to the GPT, as localboot does right before kexec.
+func bootOnce(t *testing.T, store *GPTStore) *Selection {
+	slots, err := store.Slots()
+	require.NoError(t, err)
+	sel, err := Select(slots)
+	require.NoError(t, err)
+	require.NoError(t, sel.Commit(store))
+	return sel
+}
+
+// TestGPTRollbackCycle goes through a full update and rollback cycle on a GPT
+// disk image: B is the known good slot, and a new OS is installed in A, which
+// never marks itself as successfully booted.
+func TestGPTRollbackCycle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	disk := path.Join(dir, "disk.img")
+	storagetest.WriteGPTImage(t, disk, []string{"EFI", NameA, NameB}, []uint64{
+		0,
+		attributesFromSlot(Slot{Priority: 2, TriesRemaining: 2}, 0),
+		attributesFromSlot(Slot{Priority: 1, Successful: true}, 0),
+	})
+	store := NewGPTStore(disk)
+	device, err := store.Device(NameB)
+	require.NoError(t, err)
+	require.Equal(t, disk+"3", device)
+
+	// the new slot is tried as many times as allowed
+	for tries := 1; tries >= 0; tries-- {
+		sel := bootOnce(t, store)
+		require.Equal(t, NameA, sel.Slot.Name)
+		require.Equal(t, tries, sel.Slot.TriesRemaining)
+		require.Equal(t, "", sel.RolledBackFrom)
+	}
+
+	// then it is disabled, and the old slot is booted instead
+	sel := bootOnce(t, store)
+	require.Equal(t, NameB, sel.Slot.Name)
+	require.Equal(t, NameA, sel.RolledBackFrom)
+	slots, err := store.Slots()
+	require.NoError(t, err)
+	require.Equal(t, []Slot{
+		{Name: NameA, Priority: 0, TriesRemaining: 0},
+		{Name: NameB, Priority: 1, Successful: true},
+	}, slots)
+
+	// and it stays that way
+	sel = bootOnce(t, store)
+	require.Equal(t, NameB, sel.Slot.Name)
+	require.Equal(t, "", sel.RolledBackFrom)
+}
+
+// TestGPTUpdateSuccess checks that a slot marked successful by the OS stops
+// using up tries.
+func TestGPTUpdateSuccess(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	disk := path.Join(dir, "disk.img")
+	storagetest.WriteGPTImage(t, disk, []string{NameA, NameB}, []uint64{
+		attributesFromSlot(Slot{Priority: 2, TriesRemaining: 1}, 0),
+		attributesFromSlot(Slot{Priority: 1, Successful: true}, 0),
+	})
+	store := NewGPTStore(disk)
+	require.Equal(t, NameA, bootOnce(t, store).Slot.Name)
+	// the OS marks the boot as successful
+	require.NoError(t, store.Update(Slot{Name: NameA, Priority: 2, Successful: true}))
+	for i := 0; i < 3; i++ {
+		sel := bootOnce(t, store)
+		require.Equal(t, NameA, sel.Slot.Name)
+		require.Equal(t, "", sel.RolledBackFrom)
+	}
+}
+
+func TestGPTStoreMissingSlot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	disk := path.Join(dir, "disk.img")
+	storagetest.WriteGPTImage(t, disk, []string{NameA}, []uint64{0})
+	_, err = NewGPTStore(disk).Slots()
+	require.Error(t, err)
+}
@@ -0,0 +1,182 @@
+package slot
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/systemboot/systemboot/pkg/safemode"
+)
+
+// Default partition names of the A/B slots.
+const (
+	NameA = "SYSTEM_A"
+	NameB = "SYSTEM_B"
+)
+
+// ErrNoBootableSlot is returned by Select when every slot is either disabled
+// or out of tries without having booted successfully.
+var ErrNoBootableSlot = errors.New("no bootable slot")
+
+// Slot is the boot state of an A/B slot, with the same semantics as the
+// ChromeOS GPT attributes: the slot with the highest priority is tried first,
+// a priority of 0 means the slot must not be booted, and a slot that has not
+// been marked successful by the OS can only be tried TriesRemaining more
+// times.
+type Slot struct {
+	Name           string
+	Priority       int
+	TriesRemaining int
+	Successful     bool
+	// LastTry is when a try of the slot was last used up, if known. Not
+	// every Store can record it
+	LastTry time.Time
+}
+
+func (s Slot) String() string {
+	return fmt.Sprintf("%s (priority %d, tries %d, successful %v)", s.Name, s.Priority, s.TriesRemaining, s.Successful)
+}
+
+// bootable returns true if the slot can be booted.
+func (s Slot) bootable() bool {
+	return s.Priority > 0 && (s.Successful || s.TriesRemaining > 0)
+}
+
+// coolingDown returns true if the slot has not booted successfully yet and
+// was already tried less than cooldown ago, which suggests that it crashed
+// and the machine rebooted right away.
+func (s Slot) coolingDown(now time.Time, cooldown time.Duration) bool {
+	if s.Successful || cooldown <= 0 || s.LastTry.IsZero() || now.IsZero() {
+		return false
+	}
+	elapsed := now.Sub(s.LastTry)
+	return elapsed >= 0 && elapsed < cooldown
+}
+
+// Store reads and writes the boot state of the slots, e.g. from GPT partition
+// attributes or VPD.
+type Store interface {
+	Slots() ([]Slot, error)
+	Update(Slot) error
+}
+
+// Selection is the outcome of Select.
+type Selection struct {
+	// Slot is the slot to boot, with its state as it must be written back
+	// before booting it
+	Slot Slot
+	// Exhausted are the slots that ran out of tries without booting
+	// successfully, and have been disabled
+	Exhausted []Slot
+	// RolledBackFrom is the name of the slot that should have been booted,
+	// but ran out of tries, if any
+	RolledBackFrom string
+	// CooledDown is the name of the slot that should have been booted, but
+	// was skipped because it was tried too recently, if any. See
+	// SelectWithCooldown
+	CooledDown string
+}
+
+// Select picks the slot to boot. The slot with the highest priority is
+// preferred, and ties are broken in favour of successful slots, then by name.
+// Slots that ran out of tries without booting successfully are disabled, and
+// the next slot is used instead. If the selected slot has not booted
+// successfully yet, one of its tries is used up. Select doesn't write anything:
+// call Commit on the returned Selection before booting.
+func Select(slots []Slot) (*Selection, error) {
+	return SelectWithCooldown(slots, time.Time{}, 0)
+}
+
+// SelectWithCooldown is like Select, but it also records the time of the try
+// in the selected slot, and skips a slot that has not booted successfully yet
+// and was already tried less than cooldown before now, in favour of the next
+// bootable slot, if any. This breaks the loops where a kernel panics and the
+// machine reboots into the same slot right away. The skipped slot keeps its
+// tries, and is tried again once the cooldown is over.
+func SelectWithCooldown(slots []Slot, now time.Time, cooldown time.Duration) (*Selection, error) {
+	if len(slots) == 0 {
+		return nil, errors.New("no slots")
+	}
+	seen := make(map[string]bool)
+	for _, s := range slots {
+		if seen[s.Name] {
+			return nil, fmt.Errorf("corrupt slot marker: duplicate slot %s", s.Name)
+		}
+		seen[s.Name] = true
+		if s.Priority < 0 || s.TriesRemaining < 0 {
+			return nil, fmt.Errorf("corrupt slot marker: invalid state for %v", s)
+		}
+	}
+	sorted := make([]Slot, len(slots))
+	copy(sorted, slots)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Priority != sorted[j].Priority {
+			return sorted[i].Priority > sorted[j].Priority
+		}
+		if sorted[i].Successful != sorted[j].Successful {
+			return sorted[i].Successful
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+	var (
+		sel    Selection
+		cooled *Slot
+	)
+	pick := func(s Slot) *Selection {
+		if !s.Successful {
+			s.TriesRemaining--
+			if !now.IsZero() {
+				s.LastTry = now
+			}
+		}
+		if cooled != nil && cooled.Name != s.Name {
+			sel.CooledDown = cooled.Name
+		}
+		sel.Slot = s
+		return &sel
+	}
+	for _, s := range sorted {
+		if s.Priority == 0 {
+			continue
+		}
+		if !s.bootable() {
+			if sel.RolledBackFrom == "" {
+				sel.RolledBackFrom = s.Name
+			}
+			s.Priority = 0
+			sel.Exhausted = append(sel.Exhausted, s)
+			continue
+		}
+		if cooled == nil && s.coolingDown(now, cooldown) {
+			skipped := s
+			cooled = &skipped
+			continue
+		}
+		return pick(s), nil
+	}
+	if cooled != nil {
+		// nothing else to boot, better try again than not boot at all
+		return pick(*cooled), nil
+	}
+	return nil, ErrNoBootableSlot
+}
+
+// Commit writes the new state of the selected and exhausted slots.
+func (sel *Selection) Commit(store Store) error {
+	if err := safemode.Check("update the boot slot counters"); err != nil {
+		return err
+	}
+	for _, s := range sel.Exhausted {
+		if err := store.Update(s); err != nil {
+			return fmt.Errorf("cannot disable slot %s: %v", s.Name, err)
+		}
+	}
+	if sel.Slot.Name == "" {
+		return nil
+	}
+	if err := store.Update(sel.Slot); err != nil {
+		return fmt.Errorf("cannot update slot %s: %v", sel.Slot.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package slot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/systemboot/systemboot/pkg/safemode"
+)
+
+func TestSelectHighestPriority(t *testing.T) {
+	sel, err := Select([]Slot{
+		{Name: NameA, Priority: 1, Successful: true},
+		{Name: NameB, Priority: 2, TriesRemaining: 3},
+	})
+	require.NoError(t, err)
+	require.Equal(t, Slot{Name: NameB, Priority: 2, TriesRemaining: 2}, sel.Slot)
+	require.Equal(t, "", sel.RolledBackFrom)
+	require.Equal(t, 0, len(sel.Exhausted))
+}
+
+func TestSelectSuccessfulKeepsTries(t *testing.T) {
+	sel, err := Select([]Slot{
+		{Name: NameA, Priority: 2, TriesRemaining: 0, Successful: true},
+		{Name: NameB, Priority: 1, TriesRemaining: 3},
+	})
+	require.NoError(t, err)
+	require.Equal(t, Slot{Name: NameA, Priority: 2, Successful: true}, sel.Slot)
+}
+
+func TestSelectTieBreak(t *testing.T) {
+	sel, err := Select([]Slot{
+		{Name: NameB, Priority: 1, TriesRemaining: 1},
+		{Name: NameA, Priority: 1, TriesRemaining: 1},
+	})
+	require.NoError(t, err)
+	require.Equal(t, NameA, sel.Slot.Name)
+	sel, err = Select([]Slot{
+		{Name: NameA, Priority: 1, TriesRemaining: 1},
+		{Name: NameB, Priority: 1, Successful: true},
+	})
+	require.NoError(t, err)
+	require.Equal(t, NameB, sel.Slot.Name)
+}
+
+func TestSelectRollback(t *testing.T) {
+	sel, err := Select([]Slot{
+		{Name: NameA, Priority: 2, TriesRemaining: 0},
+		{Name: NameB, Priority: 1, Successful: true},
+	})
+	require.NoError(t, err)
+	require.Equal(t, NameB, sel.Slot.Name)
+	require.Equal(t, NameA, sel.RolledBackFrom)
+	require.Equal(t, []Slot{{Name: NameA}}, sel.Exhausted)
+}
+
+func TestSelectBothExhausted(t *testing.T) {
+	_, err := Select([]Slot{
+		{Name: NameA, Priority: 2, TriesRemaining: 0},
+		{Name: NameB, Priority: 1, TriesRemaining: 0},
+	})
+	require.Equal(t, ErrNoBootableSlot, err)
+	_, err = Select([]Slot{
+		{Name: NameA, Priority: 0, Successful: true},
+		{Name: NameB, Priority: 0, Successful: true},
+	})
+	require.Equal(t, ErrNoBootableSlot, err)
+}
+
+func TestSelectCorrupt(t *testing.T) {
+	_, err := Select(nil)
+	require.Error(t, err)
+	_, err = Select([]Slot{{Name: NameA, Priority: 1}, {Name: NameA, Priority: 2}})
+	require.Error(t, err)
+	_, err = Select([]Slot{{Name: NameA, Priority: -1}})
+	require.Error(t, err)
+}
+
+// memStore is a Store backed by a map
+type memStore map[string]Slot
+
+func (m memStore) Slots() ([]Slot, error) {
+	return []Slot{m[NameA], m[NameB]}, nil
+}
+
+func (m memStore) Update(s Slot) error {
+	m[s.Name] = s
+	return nil
+}
+
+func TestCommit(t *testing.T) {
+	store := memStore{
+		NameA: {Name: NameA, Priority: 2, TriesRemaining: 0},
+		NameB: {Name: NameB, Priority: 1, TriesRemaining: 2},
+	}
+	slots, err := store.Slots()
+	require.NoError(t, err)
+	sel, err := Select(slots)
+	require.NoError(t, err)
+	require.NoError(t, sel.Commit(store))
+	require.Equal(t, Slot{Name: NameA}, store[NameA])
+	require.Equal(t, Slot{Name: NameB, Priority: 1, TriesRemaining: 1}, store[NameB])
+}
+
+func TestCommitSafeMode(t *testing.T) {
+	store := memStore{
+		NameA: {Name: NameA, Priority: 2, TriesRemaining: 0},
+		NameB: {Name: NameB, Priority: 1, TriesRemaining: 2},
+	}
+	slots, err := store.Slots()
+	require.NoError(t, err)
+	sel, err := Select(slots)
+	require.NoError(t, err)
+	safemode.Enable()
+	defer safemode.Disable()
+
+	require.IsType(t, &safemode.Error{}, sel.Commit(store))
+	// no counter was updated
+	require.Equal(t, Slot{Name: NameA, Priority: 2}, store[NameA])
+	require.Equal(t, Slot{Name: NameB, Priority: 1, TriesRemaining: 2}, store[NameB])
+}
+
+func TestSelectCooldownNoFallback(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	// the only bootable slot is tried anyway
+	sel, err := SelectWithCooldown([]Slot{
+		{Name: NameA, Priority: 2, TriesRemaining: 2, LastTry: now.Add(-time.Second)},
+		{Name: NameB, Priority: 0},
+	}, now, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, Slot{Name: NameA, Priority: 2, TriesRemaining: 1, LastTry: now}, sel.Slot)
+	require.Equal(t, "", sel.CooledDown)
+}
@@ -0,0 +1,90 @@
+package slot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/systemboot/systemboot/pkg/vpd"
+)
+
+// VPD accessors. They are variables so they can be overridden for testing.
+var (
+	Get = vpd.Get
+	Set = vpd.Set
+)
+
+// VPDKeyPrefix is the prefix of the read-write VPD keys holding the slot
+// state, e.g. `systemboot_slot_SYSTEM_A`.
+const VPDKeyPrefix = "systemboot_slot_"
+
+// VPDStore stores the slot state in read-write VPD variables, one per slot,
+// whose value looks like `priority=2,tries=3,successful=0`, followed by the
+// Unix time of the last try, e.g. `,last_try=1700000000`, if known.
+type VPDStore struct {
+	Names []string
+}
+
+// NewVPDStore returns a VPDStore for the default A/B slots.
+func NewVPDStore() *VPDStore {
+	return &VPDStore{Names: []string{NameA, NameB}}
+}
+
+// parseVPDSlot parses the VPD value of a slot.
+func parseVPDSlot(name, value string) (Slot, error) {
+	s := Slot{Name: name}
+	for _, field := range strings.Split(strings.TrimSpace(value), ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return s, fmt.Errorf("corrupt slot marker for %s: %q", name, value)
+		}
+		n, err := strconv.Atoi(kv[1])
+		if err != nil || n < 0 {
+			return s, fmt.Errorf("corrupt slot marker for %s: %q", name, value)
+		}
+		switch kv[0] {
+		case "priority":
+			s.Priority = n
+		case "tries":
+			s.TriesRemaining = n
+		case "successful":
+			s.Successful = n != 0
+		case "last_try":
+			s.LastTry = time.Unix(int64(n), 0)
+		default:
+			return s, fmt.Errorf("corrupt slot marker for %s: unknown field %q", name, kv[0])
+		}
+	}
+	return s, nil
+}
+
+// Slots reads the slot state from VPD.
+func (v *VPDStore) Slots() ([]Slot, error) {
+	slots := make([]Slot, 0, len(v.Names))
+	for _, name := range v.Names {
+		value, err := Get(VPDKeyPrefix+name, false)
+		if err != nil {
+			return nil, err
+		}
+		s, err := parseVPDSlot(name, string(value))
+		if err != nil {
+			return nil, err
+		}
+		slots = append(slots, s)
+	}
+	return slots, nil
+}
+
+// Update writes the state of a slot to VPD.
+func (v *VPDStore) Update(s Slot) error {
+	successful := 0
+	if s.Successful {
+		successful = 1
+	}
+	value := fmt.Sprintf("priority=%d,tries=%d,successful=%d", s.Priority, s.TriesRemaining, successful)
+	if !s.LastTry.IsZero() {
+		value += fmt.Sprintf(",last_try=%d", s.LastTry.Unix())
+	}
+	return Set(VPDKeyPrefix+s.Name, []byte(value), false)
+}
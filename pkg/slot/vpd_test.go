@@ -0,0 +1,93 @@
+package slot
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func fakeVPD(vars map[string]string) func() {
+	oldGet, oldSet := Get, Set
+	Get = func(key string, readOnly bool) ([]byte, error) {
+		value, ok := vars[key]
+		if !ok || readOnly {
+			return nil, errors.New("no such key")
+		}
+		return []byte(value), nil
+	}
+	Set = func(key string, value []byte, readOnly bool) error {
+		vars[key] = string(value)
+		return nil
+	}
+	return func() { Get, Set = oldGet, oldSet }
+}
+
+func TestVPDStore(t *testing.T) {
+	vars := map[string]string{
+		VPDKeyPrefix + NameA: "priority=2,tries=1,successful=0",
+		VPDKeyPrefix + NameB: "priority=1,tries=0,successful=1\n",
+	}
+	defer fakeVPD(vars)()
+	store := NewVPDStore()
+	slots, err := store.Slots()
+	require.NoError(t, err)
+	require.Equal(t, []Slot{
+		{Name: NameA, Priority: 2, TriesRemaining: 1},
+		{Name: NameB, Priority: 1, Successful: true},
+	}, slots)
+
+	require.NoError(t, store.Update(Slot{Name: NameA, Priority: 2, TriesRemaining: 0}))
+	require.Equal(t, "priority=2,tries=0,successful=0", vars[VPDKeyPrefix+NameA])
+}
+
+func TestVPDStoreCorrupt(t *testing.T) {
+	for _, value := range []string{"priority=2,tries", "priority=x", "priority=-1", "colour=blue", ""} {
+		restore := fakeVPD(map[string]string{
+			VPDKeyPrefix + NameA: value,
+			VPDKeyPrefix + NameB: "priority=1",
+		})
+		_, err := NewVPDStore().Slots()
+		restore()
+		require.Error(t, err, value)
+	}
+	// missing marker
+	defer fakeVPD(map[string]string{VPDKeyPrefix + NameA: "priority=1"})()
+	_, err := NewVPDStore().Slots()
+	require.Error(t, err)
+}
+
+func TestSelectCooldownFallback(t *testing.T) {
+	vars := map[string]string{
+		VPDKeyPrefix + NameA: "priority=2,tries=3,successful=0",
+		VPDKeyPrefix + NameB: "priority=1,tries=0,successful=1",
+	}
+	defer fakeVPD(vars)()
+	store := NewVPDStore()
+	boot := func(now time.Time) *Selection {
+		slots, err := store.Slots()
+		require.NoError(t, err)
+		sel, err := SelectWithCooldown(slots, now, 2*time.Minute)
+		require.NoError(t, err)
+		require.NoError(t, sel.Commit(store))
+		return sel
+	}
+	start := time.Unix(1700000000, 0)
+
+	// the first attempt boots the new slot and records the time
+	sel := boot(start)
+	require.Equal(t, NameA, sel.Slot.Name)
+	require.Equal(t, "priority=2,tries=2,successful=0,last_try=1700000000", vars[VPDKeyPrefix+NameA])
+
+	// it panics and the machine is back 30s later: fall back to the old slot
+	sel = boot(start.Add(30 * time.Second))
+	require.Equal(t, NameB, sel.Slot.Name)
+	require.Equal(t, NameA, sel.CooledDown)
+	require.Equal(t, "priority=2,tries=2,successful=0,last_try=1700000000", vars[VPDKeyPrefix+NameA])
+
+	// once the cooldown is over, the new slot is tried again
+	sel = boot(start.Add(time.Hour))
+	require.Equal(t, NameA, sel.Slot.Name)
+	require.Equal(t, "", sel.CooledDown)
+}
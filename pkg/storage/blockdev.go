@@ -17,6 +17,8 @@ import (
 var (
 	// LinuxMountsPath is the standard mountpoint list path
 	LinuxMountsPath = "/proc/mounts"
+	// SysClassBlockPath is where the kernel lists the block devices
+	SysClassBlockPath = "/sys/class/block"
 )
 
 // BlockDev maps a device name to a BlockStat structure for a given block device
@@ -129,11 +131,15 @@ func BlockStatFromBytes(buf []byte) (*BlockStat, error) {
 }
 
 // GetBlockStats iterates over /sys/class/block entries and returns a list of
-// BlockDev objects, or an error if any
+// BlockDev objects, or an error if any. The error is a *PseudoFilesystemError
+// if sysfs is not mounted.
 func GetBlockStats() ([]BlockDev, error) {
 	blockdevs := make([]BlockDev, 0)
 	devnames := make([]string, 0)
-	root := "/sys/class/block"
+	root := SysClassBlockPath
+	if _, err := os.Stat(root); err != nil {
+		return nil, pseudoFSError(root, "sysfs", err)
+	}
 	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -170,6 +176,21 @@ func GetBlockStats() ([]BlockDev, error) {
 	return blockdevs, nil
 }
 
+// DeviceSize returns the size in bytes of the block device with the given
+// name, e.g. sda1.
+func DeviceSize(devname string) (uint64, error) {
+	data, err := ioutil.ReadFile(filepath.Join(SysClassBlockPath, devname, "size"))
+	if err != nil {
+		return 0, err
+	}
+	// the size is always in 512-byte sectors, whatever the block size
+	sectors, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size of %s: %v", devname, err)
+	}
+	return sectors * 512, nil
+}
+
 // GetGPTTable tries to read a GPT table from the block device described by the
 // passed BlockDev object, and returns a gpt.Table object, or an error if any
 func GetGPTTable(device BlockDev) (*gpt.Table, error) {
@@ -194,6 +215,25 @@ func FilterEFISystemPartitions(devices []BlockDev) ([]BlockDev, error) {
 	return PartitionsByGUID(devices, SystemPartitionGUID.String())
 }
 
+// EFISystemPartitionPaths returns the paths of the partitions, e.g.
+// /dev/sda1, whose GPT partition type is SystemPartitionGUID, among the
+// partitions of the given disks.
+func EFISystemPartitionPaths(devices []BlockDev) []string {
+	var paths []string
+	for _, device := range devices {
+		table, err := GetGPTTable(device)
+		if err != nil {
+			continue
+		}
+		for idx, part := range table.Partitions {
+			if !part.IsEmpty() && part.Type == SystemPartitionGUID {
+				paths = append(paths, PartitionDevName("/dev/"+device.Name, idx+1))
+			}
+		}
+	}
+	return paths
+}
+
 // PartitionsByGUID returns a list of BlockDev objects whose underlying
 // block device ahs the given GUID
 func PartitionsByGUID(devices []BlockDev, guid string) ([]BlockDev, error) {
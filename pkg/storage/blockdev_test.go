@@ -1,6 +1,9 @@
 package storage
 
 import (
+	"io/ioutil"
+	"os"
+	"path"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -47,3 +50,19 @@ func TestBlockStatFromBytesNotEnoughFields(t *testing.T) {
     _, err := BlockStatFromBytes(input)
     require.Error(t, err)
 }
+
+func TestDeviceSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sysblock")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(p string) { SysClassBlockPath = p }(SysClassBlockPath)
+	SysClassBlockPath = dir
+	require.NoError(t, os.Mkdir(path.Join(dir, "sda1"), 0755))
+	require.NoError(t, ioutil.WriteFile(path.Join(dir, "sda1", "size"), []byte("1048576\n"), 0644))
+
+	size, err := DeviceSize("sda1")
+	require.NoError(t, err)
+	require.Equal(t, uint64(512<<20), size)
+	_, err = DeviceSize("sdb1")
+	require.Error(t, err)
+}
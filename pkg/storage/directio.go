@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// directIOAlign is the alignment of the buffer, offset and length of O_DIRECT
+// reads. 4096 covers the logical block size of all common block devices.
+const directIOAlign = 4096
+
+// directIOChunk is the size of each O_DIRECT read.
+const directIOChunk = 1 << 20
+
+var directIO bool
+
+// openDirect opens a file for O_DIRECT reads. It is a variable so it can be
+// overridden for testing.
+var openDirect = func(name string) (*os.File, error) {
+	return os.OpenFile(name, os.O_RDONLY|syscall.O_DIRECT, 0)
+}
+
+// SetDirectIO sets whether ReadFile reads with O_DIRECT, bypassing the page
+// cache, e.g. to measure large kernels and initramfs images without evicting
+// the rest of a constrained initramfs from memory. It does not affect the
+// files passed to kexec_file_load, which the kernel reads by itself.
+func SetDirectIO(enabled bool) {
+	directIO = enabled
+}
+
+// ReadFile reads a whole file like ioutil.ReadFile. If enabled with
+// SetDirectIO, it reads with O_DIRECT into aligned buffers, and falls back to
+// buffered reads if the file system does not support O_DIRECT.
+func ReadFile(name string) ([]byte, error) {
+	if directIO {
+		data, err := readFileDirect(name)
+		if err == nil {
+			return data, nil
+		}
+		if !isDirectIOUnsupported(err) {
+			return nil, err
+		}
+	}
+	return ioutil.ReadFile(name)
+}
+
+// isDirectIOUnsupported returns true if an O_DIRECT open or read failed because
+// the file system does not support it, e.g. tmpfs.
+func isDirectIOUnsupported(err error) bool {
+	switch e := err.(type) {
+	case *os.PathError:
+		err = e.Err
+	case *os.SyscallError:
+		err = e.Err
+	}
+	return err == syscall.EINVAL || err == syscall.EOPNOTSUPP
+}
+
+// alignedBuffer returns a buffer of the given size whose first byte is
+// aligned to directIOAlign.
+func alignedBuffer(size int) []byte {
+	buf := make([]byte, size+directIOAlign)
+	offset := 0
+	if rem := int(uintptr(unsafe.Pointer(&buf[0])) % directIOAlign); rem != 0 {
+		offset = directIOAlign - rem
+	}
+	return buf[offset : offset+size]
+}
+
+func readFileDirect(name string) ([]byte, error) {
+	fd, err := openDirect(name)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	var data []byte
+	buf := alignedBuffer(directIOChunk)
+	for {
+		// a short read is only possible at the end of the file, as the
+		// following reads would not be aligned
+		n, err := fd.Read(buf)
+		data = append(data, buf[:n]...)
+		if err == io.EOF || (err == nil && n < len(buf)) {
+			return data, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadFileDirectIO(t *testing.T) {
+	dir, err := ioutil.TempDir("", "directio")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer SetDirectIO(false)
+	savedOpenDirect := openDirect
+	defer func() { openDirect = savedOpenDirect }()
+
+	// not a multiple of the chunk size or of the alignment
+	content := make([]byte, 3*directIOChunk+123)
+	rand.New(rand.NewSource(1)).Read(content)
+	kernel := path.Join(dir, "vmlinuz")
+	require.NoError(t, ioutil.WriteFile(kernel, content, 0644))
+	digest := sha256.Sum256(content)
+
+	for _, tc := range []struct {
+		name       string
+		direct     bool
+		openDirect func(string) (*os.File, error)
+	}{
+		{"buffered", false, savedOpenDirect},
+		// O_DIRECT, or buffered if the temporary directory is on tmpfs
+		{"direct", true, savedOpenDirect},
+		// a file system that supports O_DIRECT
+		{"direct supported", true, os.Open},
+		// a file system that does not support O_DIRECT
+		{"direct unsupported", true, func(name string) (*os.File, error) {
+			return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EINVAL}
+		}},
+	} {
+		SetDirectIO(tc.direct)
+		openDirect = tc.openDirect
+		data, err := ReadFile(kernel)
+		require.NoError(t, err, tc.name)
+		require.Equal(t, digest, sha256.Sum256(data), tc.name)
+	}
+
+	// other errors are not hidden by the fallback
+	SetDirectIO(true)
+	openDirect = savedOpenDirect
+	_, err = ReadFile(path.Join(dir, "missing"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestAlignedBuffer(t *testing.T) {
+	buf := alignedBuffer(directIOChunk)
+	require.Equal(t, directIOChunk, len(buf))
+	require.Equal(t, uintptr(0), uintptr(unsafe.Pointer(&buf[0]))%directIOAlign)
+}
@@ -7,6 +7,8 @@ import (
 	"os"
 	"strings"
 	"syscall"
+
+	"github.com/systemboot/systemboot/pkg/safemode"
 )
 
 // Mountpoint holds mount point information for a given device
@@ -16,12 +18,27 @@ type Mountpoint struct {
 	FsType     string
 }
 
-// GetSupportedFilesystems returns the supported file systems for block devices,
+// mount is syscall.Mount. It is a variable so it can be overridden for
+// testing.
+var mount = syscall.Mount
+
+// ReadOnlyOptions are the options added for each file system type when
+// mounting read-only, so that a dirty file system is mounted without replaying
+// its journal, which would write to the device or fail.
+var ReadOnlyOptions = map[string]string{
+	"xfs":  "norecovery",
+	"ext3": "noload",
+	"ext4": "noload",
+}
+
+// GetSupportedFilesystems returns the supported file systems for block devices.
+// The error is a *PseudoFilesystemError if proc is not mounted.
 func GetSupportedFilesystems() ([]string, error) {
-	fd, err := os.Open("/proc/filesystems")
+	fd, err := os.Open(ProcFilesystemsPath)
 	if err != nil {
-		return nil, err
+		return nil, pseudoFSError(ProcFilesystemsPath, "proc", err)
 	}
+	defer fd.Close()
 	filesystems := make([]string, 0)
 	scanner := bufio.NewScanner(fd)
 	for scanner.Scan() {
@@ -39,8 +56,26 @@ func GetSupportedFilesystems() ([]string, error) {
 // Mount tries to mount a block device on the given mountpoint, trying in order
 // the provided file system types. It returns a Mountpoint structure, or an error
 // if the device could not be mounted. If the mount point does not exist, it will
-// be created.
+// be created. The error is an *UnsupportedFilesystemError if the device has a
+// file system that none of the types can mount.
 func Mount(devname, mountpath string, filesystems []string) (*Mountpoint, error) {
+	return MountWithOptions(devname, mountpath, filesystems, "")
+}
+
+// MountWithOptions is like Mount, but also passes the given file system
+// specific options, e.g. "subvol=@" for btrfs. See mount(8). Unless the
+// options contain "rw", the ReadOnlyOptions of each file system type are
+// passed too.
+func MountWithOptions(devname, mountpath string, filesystems []string, options string) (*Mountpoint, error) {
+	readOnly := true
+	for _, option := range strings.Split(options, ",") {
+		if option == "rw" {
+			if err := safemode.Check("mount " + devname + " read-write"); err != nil {
+				return nil, err
+			}
+			readOnly = false
+		}
+	}
 	if err := os.MkdirAll(mountpath, 0744); err != nil {
 		return nil, err
 	}
@@ -48,14 +83,38 @@ func Mount(devname, mountpath string, filesystems []string) (*Mountpoint, error)
 		log.Printf(" * trying %s on %s", fstype, devname)
 		// MS_RDONLY should be enough. See mount(2)
 		flags := uintptr(syscall.MS_RDONLY)
-		// no options
-		data := ""
-		if err := syscall.Mount(devname, mountpath, fstype, flags, data); err != nil {
+		fsOptions := options
+		if extra := ReadOnlyOptions[fstype]; readOnly && extra != "" {
+			if fsOptions == "" {
+				fsOptions = extra
+			} else {
+				fsOptions += "," + extra
+			}
+		}
+		if err := mount(devname, mountpath, fstype, flags, fsOptions); err != nil {
 			log.Printf("    failed with %v", err)
 			continue
 		}
 		log.Printf(" * mounted %s on %s with filesystem type %s", devname, mountpath, fstype)
 		return &Mountpoint{DeviceName: devname, Path: mountpath, FsType: fstype}, nil
 	}
+	if fstype, err := DetectFilesystem(devname); err == nil && fstype != "" && !isMountable(fstype, filesystems) {
+		return nil, &UnsupportedFilesystemError{Device: devname, FsType: fstype}
+	}
 	return nil, fmt.Errorf("no suitable filesystem type found to mount %s", devname)
 }
+
+// Remount makes a mount point read-write, e.g. to write a file on a partition
+// mounted by Mount, or read-only again.
+func Remount(mp Mountpoint, readOnly bool) error {
+	flags := uintptr(syscall.MS_REMOUNT)
+	if readOnly {
+		flags |= syscall.MS_RDONLY
+	} else if err := safemode.Check("remount " + mp.Path + " read-write"); err != nil {
+		return err
+	}
+	if err := mount(mp.DeviceName, mp.Path, mp.FsType, flags, ""); err != nil {
+		return fmt.Errorf("cannot remount %s: %v", mp.Path, err)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/systemboot/systemboot/pkg/safemode"
+)
+
+func TestMountReadWriteSafeMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mount")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	safemode.Enable()
+	defer safemode.Disable()
+
+	_, err = MountWithOptions("/dev/sda1", path.Join(dir, "sda1"), []string{"ext4"}, "noatime,rw")
+	require.IsType(t, &safemode.Error{}, err)
+}
+
+func TestMountReadOnlyOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mount")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(orig func(string, string, string, uintptr, string) error) { mount = orig }(mount)
+	var calls []string
+	mount = func(source, target, fstype string, flags uintptr, data string) error {
+		calls = append(calls, fstype+" "+data)
+		if fstype != "xfs" {
+			return syscall.EINVAL
+		}
+		return nil
+	}
+
+	mp, err := Mount("/dev/sda1", path.Join(dir, "sda1"), []string{"ext4", "vfat", "xfs"})
+	require.NoError(t, err)
+	require.Equal(t, "xfs", mp.FsType)
+	require.Equal(t, []string{"ext4 noload", "vfat ", "xfs norecovery"}, calls)
+
+	calls = nil
+	_, err = MountWithOptions("/dev/sda1", path.Join(dir, "sda1"), []string{"xfs"}, "nouuid")
+	require.NoError(t, err)
+	require.Equal(t, []string{"xfs nouuid,norecovery"}, calls)
+}
+
+func TestRemount(t *testing.T) {
+	defer func(orig func(string, string, string, uintptr, string) error) { mount = orig }(mount)
+	var flags []uintptr
+	mount = func(source, target, fstype string, f uintptr, data string) error {
+		flags = append(flags, f)
+		return nil
+	}
+	mp := Mountpoint{DeviceName: "/dev/sda1", Path: "/mnt/sda1", FsType: "vfat"}
+	require.NoError(t, Remount(mp, false))
+	require.NoError(t, Remount(mp, true))
+	require.Equal(t, []uintptr{syscall.MS_REMOUNT, syscall.MS_REMOUNT | syscall.MS_RDONLY}, flags)
+
+	safemode.Enable()
+	defer safemode.Disable()
+	require.IsType(t, &safemode.Error{}, Remount(mp, false))
+}
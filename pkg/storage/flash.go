@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/systemboot/systemboot/pkg/safemode"
+)
+
+// blkrrpart is the BLKRRPART ioctl, which makes the kernel re-read the
+// partition table of a block device.
+const blkrrpart = 0x125f
+
+// flashChunkSize is how much of an image is written between progress reports.
+var flashChunkSize = 4 << 20
+
+// rereadPartitions makes the kernel re-read the partition table of a block
+// device, given its path. It is a variable so it can be overridden for
+// testing.
+var rereadPartitions = func(devpath string) error {
+	fd, err := os.Open(devpath)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd.Fd(), blkrrpart, 0); errno != 0 {
+		return fmt.Errorf("cannot re-read the partition table of %s: %v", devpath, errno)
+	}
+	return nil
+}
+
+// FlashImage writes a disk image to the start of a block device, e.g.
+// /dev/sda, calling progress, if set, with the bytes written so far after each
+// chunk. The device must not be in use, e.g. mounted. See RescanDevice for its
+// new partitions.
+func FlashImage(image []byte, devpath string, progress func(written, total int64)) error {
+	if err := safemode.Check("write an image to " + devpath); err != nil {
+		return err
+	}
+	fd, err := os.OpenFile(devpath, os.O_WRONLY|syscall.O_EXCL, 0)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	total := int64(len(image))
+	for written := 0; written < len(image); {
+		end := written + flashChunkSize
+		if end > len(image) {
+			end = len(image)
+		}
+		n, err := fd.Write(image[written:end])
+		written += n
+		if err != nil {
+			return fmt.Errorf("cannot write the image to %s at offset %d: %v", devpath, written, err)
+		}
+		if progress != nil {
+			progress(int64(written), total)
+		}
+	}
+	if err := fd.Sync(); err != nil {
+		return fmt.Errorf("cannot flush the image to %s: %v", devpath, err)
+	}
+	return nil
+}
+
+// DevicePartitions returns the block device with the given name among
+// devices, and its partitions, e.g. sda and sda1, or nvme0n1 and nvme0n1p1.
+func DevicePartitions(devices []BlockDev, name string) []BlockDev {
+	prefix := name
+	if last := name[len(name)-1]; last >= '0' && last <= '9' {
+		prefix += "p"
+	}
+	var found []BlockDev
+	for _, dev := range devices {
+		if dev.Name == name || isPartitionOf(dev.Name, prefix) {
+			found = append(found, dev)
+		}
+	}
+	return found
+}
+
+// RescanDevice makes the kernel re-read the partition table of a block device,
+// e.g. once an image is written with FlashImage, and returns the device and
+// its partitions. It waits up to timeout for the partitions to show up, as
+// udev-less environments see them asynchronously.
+func RescanDevice(devpath string, timeout time.Duration) ([]BlockDev, error) {
+	if err := rereadPartitions(devpath); err != nil {
+		return nil, err
+	}
+	return waitPartitions(filepath.Base(devpath), timeout)
+}
+
+// waitPartitions returns the block device with the given name and its
+// partitions, polling for up to timeout until it has at least one partition.
+func waitPartitions(name string, timeout time.Duration) ([]BlockDev, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		all, err := GetBlockStats()
+		if err != nil {
+			return nil, err
+		}
+		devices := DevicePartitions(all, name)
+		if len(devices) > 1 || time.Now().After(deadline) {
+			if len(devices) == 0 {
+				return nil, fmt.Errorf("%s is not a known block device", name)
+			}
+			return devices, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
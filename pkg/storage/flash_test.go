@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/systemboot/systemboot/pkg/safemode"
+)
+
+// addFakeBlockDev adds a block device to a fake /sys/class/block, as a
+// symlink to its directory like in sysfs.
+func addFakeBlockDev(t *testing.T, sysdir, name string) {
+	devdir := path.Join(path.Dir(sysdir), "devices", name)
+	require.NoError(t, os.MkdirAll(devdir, 0755))
+	stat := []byte("       0        1        2        3        4        5        6        7        8        9        10\n")
+	require.NoError(t, ioutil.WriteFile(path.Join(devdir, "stat"), stat, 0644))
+	require.NoError(t, os.MkdirAll(sysdir, 0755))
+	require.NoError(t, os.Symlink(devdir, path.Join(sysdir, name)))
+}
+
+func TestFlashImage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flash")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(p string) { SysClassBlockPath = p }(SysClassBlockPath)
+	SysClassBlockPath = path.Join(dir, "sys/class/block")
+	addFakeBlockDev(t, SysClassBlockPath, "sda")
+	addFakeBlockDev(t, SysClassBlockPath, "sdb")
+	devpath := path.Join(dir, "sda")
+	require.NoError(t, ioutil.WriteFile(devpath, bytes.Repeat([]byte{0xff}, 64), 0600))
+
+	defer func(orig int) { flashChunkSize = orig }(flashChunkSize)
+	flashChunkSize = 16
+	image := []byte("partition table, then partitions, 40 bytes")
+	var progress []int64
+	require.NoError(t, FlashImage(image, devpath, func(written, total int64) {
+		require.Equal(t, int64(len(image)), total)
+		progress = append(progress, written)
+	}))
+	require.Equal(t, []int64{16, 32, int64(len(image))}, progress)
+	data, err := ioutil.ReadFile(devpath)
+	require.NoError(t, err)
+	// the rest of the device is left as is
+	require.Equal(t, append(image, bytes.Repeat([]byte{0xff}, 64-len(image))...), data)
+
+	// the kernel finds the partitions of the image
+	defer func(orig func(string) error) { rereadPartitions = orig }(rereadPartitions)
+	rereadPartitions = func(p string) error {
+		require.Equal(t, devpath, p)
+		addFakeBlockDev(t, SysClassBlockPath, "sda1")
+		return nil
+	}
+	devices, err := RescanDevice(devpath, 5*time.Second)
+	require.NoError(t, err)
+	require.Len(t, devices, 2)
+	require.Equal(t, "sda", devices[0].Name)
+	require.Equal(t, "sda1", devices[1].Name)
+}
+
+func TestFlashImageSafeMode(t *testing.T) {
+	safemode.Enable()
+	defer safemode.Disable()
+	require.IsType(t, &safemode.Error{}, FlashImage([]byte("image"), "/dev/sda", nil))
+}
+
+func TestDevicePartitions(t *testing.T) {
+	var devices []BlockDev
+	for _, name := range []string{"sda", "sda1", "sda12", "sdaa", "sdb1", "nvme0n1", "nvme0n1p1", "nvme0n11"} {
+		devices = append(devices, BlockDev{Name: name})
+	}
+	names := func(devs []BlockDev) []string {
+		var n []string
+		for _, dev := range devs {
+			n = append(n, dev.Name)
+		}
+		return n
+	}
+	require.Equal(t, []string{"sda", "sda1", "sda12"}, names(DevicePartitions(devices, "sda")))
+	require.Equal(t, []string{"nvme0n1", "nvme0n1p1"}, names(DevicePartitions(devices, "nvme0n1")))
+}
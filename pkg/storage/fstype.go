@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// fsMagic is a magic string identifying a file system, or another kind of
+// content of a block device, at a fixed offset.
+type fsMagic struct {
+	name   string
+	offset int
+	magic  []byte
+}
+
+// fsMagics are the known file systems, named like blkid(8) does. The ext
+// file systems are recognized separately, see extType.
+var fsMagics = []fsMagic{
+	{"crypto_LUKS", 0, LUKSMagic},
+	{"xfs", 0, []byte("XFSB")},
+	{"squashfs", 0, []byte("hsqs")},
+	{"ntfs", 3, []byte("NTFS    ")},
+	{"exfat", 3, []byte("EXFAT   ")},
+	{"apfs", 0x20, []byte("NXSB")},
+	{"vfat", 0x36, []byte("FAT12   ")},
+	{"vfat", 0x36, []byte("FAT16   ")},
+	{"vfat", 0x52, []byte("FAT32   ")},
+	{"LVM2_member", 0x218, []byte("LVM2 001")},
+	{"hfsplus", 0x400, []byte("H+")},
+	{"hfsplus", 0x400, []byte("HX")},
+	{"f2fs", 0x400, []byte{0x10, 0x20, 0xf5, 0xf2}},
+	{"swap", 0xff6, []byte("SWAPSPACE2")},
+	{"iso9660", 0x8001, []byte("CD001")},
+	{"btrfs", 0x10040, []byte("_BHRfS_M")},
+	// the first uberblock of the first ZFS label
+	{"zfs_member", 0x20000, []byte{0x0c, 0xb1, 0xba, 0x00, 0, 0, 0, 0}},
+}
+
+// fsMagicsSize is how much of a device DetectFilesystem reads.
+const fsMagicsSize = 0x20008
+
+// ext superblock fields, see Documentation/filesystems/ext4 in the Linux
+// sources.
+const (
+	extMagicOffset           = 0x438
+	extFeatureCompatOffset   = 0x45c
+	extFeatureIncompatOffset = 0x460
+	extCompatHasJournal      = 0x4
+	extIncompatExtents       = 0x40
+	extIncompat64Bit         = 0x80
+)
+
+// extType returns ext2, ext3 or ext4 if buf starts with an ext superblock,
+// depending on its features, or an empty string.
+func extType(buf []byte) string {
+	if len(buf) < extFeatureIncompatOffset+4 || binary.LittleEndian.Uint16(buf[extMagicOffset:]) != 0xef53 {
+		return ""
+	}
+	compat := binary.LittleEndian.Uint32(buf[extFeatureCompatOffset:])
+	incompat := binary.LittleEndian.Uint32(buf[extFeatureIncompatOffset:])
+	switch {
+	case incompat&(extIncompatExtents|extIncompat64Bit) != 0:
+		return "ext4"
+	case compat&extCompatHasJournal != 0:
+		return "ext3"
+	}
+	return "ext2"
+}
+
+// DetectFilesystem returns the type of the file system on a block device,
+// from its magic string, even if the kernel does not support it. It returns
+// an empty string if the type is not recognized.
+func DetectFilesystem(devname string) (string, error) {
+	fd, err := os.Open(devname)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+	buf := make([]byte, fsMagicsSize)
+	n, err := io.ReadFull(fd, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	buf = buf[:n]
+	if fstype := extType(buf); fstype != "" {
+		return fstype, nil
+	}
+	for _, m := range fsMagics {
+		if len(buf) >= m.offset+len(m.magic) && bytes.Equal(buf[m.offset:m.offset+len(m.magic)], m.magic) {
+			return m.name, nil
+		}
+	}
+	return "", nil
+}
+
+// kernelFilesystems lists the kernel file systems that can mount a detected
+// file system type, when it is not just the type itself.
+var kernelFilesystems = map[string][]string{
+	"ext2": {"ext2", "ext3", "ext4"},
+	"ext3": {"ext3", "ext4"},
+	"ntfs": {"ntfs", "ntfs3"},
+	"vfat": {"vfat", "msdos"},
+}
+
+// isMountable returns true if one of the kernel file systems can mount the
+// detected file system type.
+func isMountable(fstype string, filesystems []string) bool {
+	candidates, ok := kernelFilesystems[fstype]
+	if !ok {
+		candidates = []string{fstype}
+	}
+	for _, candidate := range candidates {
+		for _, fs := range filesystems {
+			if fs == candidate {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// UnsupportedFilesystemError is returned by Mount for a device whose file
+// system was recognized, but cannot be mounted with the available file
+// systems, as opposed to a device with no file system.
+type UnsupportedFilesystemError struct {
+	Device string
+	FsType string
+}
+
+func (e *UnsupportedFilesystemError) Error() string {
+	return fmt.Sprintf("%s has a %s file system, which is not supported", e.Device, e.FsType)
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeImage writes an image of the given size with magic at offset.
+func writeImage(t *testing.T, dir, name string, size, offset int, magic []byte) string {
+	image := make([]byte, size)
+	copy(image[offset:], magic)
+	filename := path.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(filename, image, 0644))
+	return filename
+}
+
+func TestDetectFilesystem(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fstype")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ext4 := writeImage(t, dir, "ext4.img", 4096, extMagicOffset, []byte{0x53, 0xef})
+	f, err := os.OpenFile(ext4, os.O_WRONLY, 0)
+	require.NoError(t, err)
+	features := make([]byte, 4)
+	binary.LittleEndian.PutUint32(features, extIncompatExtents)
+	_, err = f.WriteAt(features, extFeatureIncompatOffset)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	for _, tc := range []struct {
+		filename, fstype string
+	}{
+		{ext4, "ext4"},
+		{writeImage(t, dir, "ext2.img", 4096, extMagicOffset, []byte{0x53, 0xef}), "ext2"},
+		{writeImage(t, dir, "xfs.img", 4096, 0, []byte("XFSB")), "xfs"},
+		{writeImage(t, dir, "btrfs.img", 0x11000, 0x10040, []byte("_BHRfS_M")), "btrfs"},
+		{writeImage(t, dir, "zfs.img", 0x21000, 0x20000, []byte{0x0c, 0xb1, 0xba, 0, 0, 0, 0, 0}), "zfs_member"},
+		{writeImage(t, dir, "empty.img", 4096, 0, nil), ""},
+		{writeImage(t, dir, "short.img", 2, 0, []byte("XF")), ""},
+	} {
+		fstype, err := DetectFilesystem(tc.filename)
+		require.NoError(t, err)
+		require.Equal(t, tc.fstype, fstype, tc.filename)
+	}
+}
+
+func TestMountUnsupportedFilesystem(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fstype")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	// an APFS container, which the kernel cannot mount
+	apfs := writeImage(t, dir, "apfs.img", 4096, 0x20, []byte("NXSB"))
+
+	_, err = Mount(apfs, path.Join(dir, "mnt"), []string{"ext4", "vfat"})
+	require.Equal(t, &UnsupportedFilesystemError{Device: apfs, FsType: "apfs"}, err)
+
+	// a device without a known file system is not reported as unsupported
+	empty := writeImage(t, dir, "empty.img", 4096, 0, nil)
+	_, err = Mount(empty, path.Join(dir, "mnt"), []string{"ext4"})
+	require.Error(t, err)
+	_, ok := err.(*UnsupportedFilesystemError)
+	require.False(t, ok)
+}
+
+func TestIsMountable(t *testing.T) {
+	require.True(t, isMountable("ext3", []string{"ext4"}))
+	require.True(t, isMountable("ntfs", []string{"vfat", "ntfs3"}))
+	require.False(t, isMountable("xfs", []string{"ext4", "vfat"}))
+	require.False(t, isMountable("LVM2_member", []string{"ext4"}))
+}
@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/systemboot/systemboot/pkg/safemode"
+)
+
+// GPT layout, see the UEFI specification, section 5.3
+const (
+	gptSectorSize      = 512
+	gptHeaderLBA       = 1
+	gptMinEntrySize    = 128
+	gptEntryNameOffset = 56
+	gptEntryNameLen    = 72
+	gptEntryAttrOffset = 48
+)
+
+var gptSignature = []byte("EFI PART")
+
+// ErrGPTPartitionNotFound is returned when no GPT partition has the requested
+// name.
+var ErrGPTPartitionNotFound = errors.New("GPT partition not found")
+
+// gptHeader holds the fields of a GPT header needed to access the partition
+// entries.
+type gptHeader struct {
+	raw          []byte
+	alternateLBA uint64
+	entriesLBA   uint64
+	numEntries   uint32
+	entrySize    uint32
+}
+
+func readGPTHeader(fd *os.File, lba uint64) (*gptHeader, error) {
+	raw := make([]byte, gptSectorSize)
+	if _, err := fd.ReadAt(raw, int64(lba*gptSectorSize)); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(raw[:8], gptSignature) {
+		return nil, fmt.Errorf("no GPT header at LBA %d", lba)
+	}
+	size := binary.LittleEndian.Uint32(raw[12:16])
+	if size < 92 || size > gptSectorSize {
+		return nil, fmt.Errorf("invalid GPT header size %d", size)
+	}
+	raw = raw[:size]
+	crc := binary.LittleEndian.Uint32(raw[16:20])
+	binary.LittleEndian.PutUint32(raw[16:20], 0)
+	if crc32.ChecksumIEEE(raw) != crc {
+		return nil, fmt.Errorf("invalid GPT header checksum at LBA %d", lba)
+	}
+	binary.LittleEndian.PutUint32(raw[16:20], crc)
+	h := gptHeader{
+		raw:          raw,
+		alternateLBA: binary.LittleEndian.Uint64(raw[32:40]),
+		entriesLBA:   binary.LittleEndian.Uint64(raw[72:80]),
+		numEntries:   binary.LittleEndian.Uint32(raw[80:84]),
+		entrySize:    binary.LittleEndian.Uint32(raw[84:88]),
+	}
+	if h.entrySize < gptMinEntrySize || h.numEntries == 0 || h.numEntries > 1024 {
+		return nil, fmt.Errorf("invalid GPT partition entries: %d entries of %d bytes", h.numEntries, h.entrySize)
+	}
+	return &h, nil
+}
+
+func (h *gptHeader) readEntries(fd *os.File) ([]byte, error) {
+	entries := make([]byte, h.numEntries*h.entrySize)
+	if _, err := fd.ReadAt(entries, int64(h.entriesLBA*gptSectorSize)); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(entries) != binary.LittleEndian.Uint32(h.raw[88:92]) {
+		return nil, errors.New("invalid GPT partition entries checksum")
+	}
+	return entries, nil
+}
+
+// writeEntries writes the partition entries and an updated header.
+func (h *gptHeader) writeEntries(fd *os.File, entries []byte) error {
+	if _, err := fd.WriteAt(entries, int64(h.entriesLBA*gptSectorSize)); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(h.raw[88:92], crc32.ChecksumIEEE(entries))
+	binary.LittleEndian.PutUint32(h.raw[16:20], 0)
+	binary.LittleEndian.PutUint32(h.raw[16:20], crc32.ChecksumIEEE(h.raw))
+	myLBA := binary.LittleEndian.Uint64(h.raw[24:32])
+	_, err := fd.WriteAt(h.raw, int64(myLBA*gptSectorSize))
+	return err
+}
+
+// gptEntryName decodes the UTF-16LE name of a partition entry.
+func gptEntryName(entry []byte) string {
+	raw := entry[gptEntryNameOffset : gptEntryNameOffset+gptEntryNameLen]
+	units := make([]uint16, 0, len(raw)/2)
+	for i := 0; i+1 < len(raw); i += 2 {
+		u := binary.LittleEndian.Uint16(raw[i:])
+		if u == 0 {
+			break
+		}
+		units = append(units, u)
+	}
+	return strings.TrimRightFunc(string(utf16.Decode(units)), unicode.IsSpace)
+}
+
+// findEntry returns the offset in entries of the partition with the given
+// name, and its 1-based partition number.
+func (h *gptHeader) findEntry(entries []byte, name string) (int, int, error) {
+	for idx := 0; idx < int(h.numEntries); idx++ {
+		entry := entries[idx*int(h.entrySize) : (idx+1)*int(h.entrySize)]
+		if gptEntryName(entry) == name {
+			return idx * int(h.entrySize), idx + 1, nil
+		}
+	}
+	return 0, 0, ErrGPTPartitionNotFound
+}
+
+// GPTPartitionAttributes returns the attribute flags of the GPT partition
+// with the given name on a disk, along with its 1-based partition number.
+func GPTPartitionAttributes(disk, name string) (uint64, int, error) {
+	fd, err := os.Open(disk)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer fd.Close()
+	h, err := readGPTHeader(fd, gptHeaderLBA)
+	if err != nil {
+		return 0, 0, err
+	}
+	entries, err := h.readEntries(fd)
+	if err != nil {
+		return 0, 0, err
+	}
+	offset, number, err := h.findEntry(entries, name)
+	if err != nil {
+		return 0, 0, err
+	}
+	return binary.LittleEndian.Uint64(entries[offset+gptEntryAttrOffset:]), number, nil
+}
+
+// SetGPTPartitionAttributes sets the attribute flags of the GPT partition with
+// the given name on a disk, updating both the primary and the backup GPT.
+func SetGPTPartitionAttributes(disk, name string, attrs uint64) error {
+	if err := safemode.Check("write the GPT of " + disk); err != nil {
+		return err
+	}
+	fd, err := os.OpenFile(disk, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	primary, err := readGPTHeader(fd, gptHeaderLBA)
+	if err != nil {
+		return err
+	}
+	entries, err := primary.readEntries(fd)
+	if err != nil {
+		return err
+	}
+	offset, _, err := primary.findEntry(entries, name)
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint64(entries[offset+gptEntryAttrOffset:], attrs)
+	if err := primary.writeEntries(fd, entries); err != nil {
+		return err
+	}
+	// the backup GPT is best effort: the primary one is what gets read
+	backup, err := readGPTHeader(fd, primary.alternateLBA)
+	if err != nil {
+		return fmt.Errorf("updated the primary GPT, but not the backup one: %v", err)
+	}
+	if err := backup.writeEntries(fd, entries); err != nil {
+		return fmt.Errorf("updated the primary GPT, but not the backup one: %v", err)
+	}
+	return fd.Sync()
+}
+
+// PartitionDevName returns the device name of a partition of a disk given its
+// 1-based number, e.g. /dev/sda1 or /dev/nvme0n1p1.
+func PartitionDevName(disk string, number int) string {
+	base := path.Base(disk)
+	if base != "" && unicode.IsDigit(rune(base[len(base)-1])) {
+		return fmt.Sprintf("%sp%d", disk, number)
+	}
+	return fmt.Sprintf("%s%d", disk, number)
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/systemboot/systemboot/pkg/safemode"
+	"github.com/systemboot/systemboot/pkg/storage/storagetest"
+)
+
+func TestGPTPartitionAttributes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gpt")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	disk := path.Join(dir, "disk.img")
+	storagetest.WriteGPTImage(t, disk, []string{"EFI", "SYSTEM_A", "SYSTEM_B"}, nil)
+
+	attrs, number, err := GPTPartitionAttributes(disk, "SYSTEM_B")
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), attrs)
+	require.Equal(t, 3, number)
+	_, _, err = GPTPartitionAttributes(disk, "SYSTEM_C")
+	require.Equal(t, ErrGPTPartitionNotFound, err)
+
+	require.NoError(t, SetGPTPartitionAttributes(disk, "SYSTEM_B", 0x0123000000000000))
+	attrs, _, err = GPTPartitionAttributes(disk, "SYSTEM_B")
+	require.NoError(t, err)
+	require.Equal(t, uint64(0x0123000000000000), attrs)
+	attrs, _, err = GPTPartitionAttributes(disk, "SYSTEM_A")
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), attrs)
+
+	// the backup GPT is updated too, and both checksums are still valid
+	fd, err := os.Open(disk)
+	require.NoError(t, err)
+	defer fd.Close()
+	primary, err := readGPTHeader(fd, gptHeaderLBA)
+	require.NoError(t, err)
+	backup, err := readGPTHeader(fd, primary.alternateLBA)
+	require.NoError(t, err)
+	primaryEntries, err := primary.readEntries(fd)
+	require.NoError(t, err)
+	backupEntries, err := backup.readEntries(fd)
+	require.NoError(t, err)
+	require.Equal(t, primaryEntries, backupEntries)
+}
+
+func TestSetGPTPartitionAttributesSafeMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gpt")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	disk := path.Join(dir, "disk.img")
+	storagetest.WriteGPTImage(t, disk, []string{"EFI", "SYSTEM_A", "SYSTEM_B"}, nil)
+	before, err := ioutil.ReadFile(disk)
+	require.NoError(t, err)
+	safemode.Enable()
+	defer safemode.Disable()
+
+	err = SetGPTPartitionAttributes(disk, "SYSTEM_B", 0x0123000000000000)
+	require.IsType(t, &safemode.Error{}, err)
+	after, err := ioutil.ReadFile(disk)
+	require.NoError(t, err)
+	require.Equal(t, before, after)
+}
+
+func TestGPTPartitionAttributesCorrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gpt")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	disk := path.Join(dir, "disk.img")
+	storagetest.WriteGPTImage(t, disk, []string{"SYSTEM_A"}, nil)
+	data, err := ioutil.ReadFile(disk)
+	require.NoError(t, err)
+	// corrupt the partition name, which invalidates the entries checksum
+	data[2*gptSectorSize+gptEntryNameOffset] = 'X'
+	require.NoError(t, ioutil.WriteFile(disk, data, 0644))
+	_, _, err = GPTPartitionAttributes(disk, "SYSTEM_A")
+	require.Error(t, err)
+	require.Error(t, SetGPTPartitionAttributes(disk, "SYSTEM_A", 1))
+}
+
+func TestPartitionDevName(t *testing.T) {
+	require.Equal(t, "/dev/sda2", PartitionDevName("/dev/sda", 2))
+	require.Equal(t, "/dev/nvme0n1p2", PartitionDevName("/dev/nvme0n1", 2))
+	require.Equal(t, "/dev/mmcblk0p1", PartitionDevName("/dev/mmcblk0", 1))
+}
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// loop device ioctls and flags, see linux/loop.h
+const (
+	loopSetFd       = 0x4c00
+	loopClrFd       = 0x4c01
+	loopSetStatus64 = 0x4c04
+	loopCtlGetFree  = 0x4c82
+
+	loFlagsReadOnly = 1
+	loFlagsPartscan = 8
+)
+
+// loopAttachAttempts is how many free loop devices AttachLoop tries, since
+// another process can grab a free device before it is set up.
+const loopAttachAttempts = 5
+
+// loopInfo64 is struct loop_info64 from linux/loop.h.
+type loopInfo64 struct {
+	device         uint64
+	inode          uint64
+	rdevice        uint64
+	offset         uint64
+	sizeLimit      uint64
+	number         uint32
+	encryptType    uint32
+	encryptKeySize uint32
+	flags          uint32
+	fileName       [64]byte
+	cryptName      [64]byte
+	encryptKey     [32]byte
+	init           [2]uint64
+}
+
+func loopIoctl(fd uintptr, request, arg uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, arg); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// setupLoop sets up a free loop device over file, read-only and with its
+// partitions, and returns the number of the loop device. It is a variable so
+// it can be overridden for testing.
+var setupLoop = func(file string) (int, error) {
+	backing, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer backing.Close()
+	ctl, err := os.OpenFile("/dev/loop-control", os.O_RDWR, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer ctl.Close()
+	for attempt := 0; ; attempt++ {
+		number, _, errno := syscall.Syscall(syscall.SYS_IOCTL, ctl.Fd(), loopCtlGetFree, 0)
+		if errno != 0 {
+			return 0, fmt.Errorf("cannot get a free loop device: %v", errno)
+		}
+		devpath := fmt.Sprintf("/dev/loop%d", number)
+		dev, err := os.OpenFile(devpath, os.O_RDWR, 0)
+		if err != nil {
+			return 0, err
+		}
+		err = loopIoctl(dev.Fd(), loopSetFd, backing.Fd())
+		if err == syscall.EBUSY && attempt+1 < loopAttachAttempts {
+			// another process took it first
+			dev.Close()
+			continue
+		}
+		if err != nil {
+			dev.Close()
+			return 0, fmt.Errorf("cannot attach %s to %s: %v", file, devpath, err)
+		}
+		info := loopInfo64{flags: loFlagsReadOnly | loFlagsPartscan}
+		copy(info.fileName[:len(info.fileName)-1], file)
+		if err := loopIoctl(dev.Fd(), loopSetStatus64, uintptr(unsafe.Pointer(&info))); err != nil {
+			loopIoctl(dev.Fd(), loopClrFd, 0)
+			dev.Close()
+			return 0, fmt.Errorf("cannot set up %s: %v", devpath, err)
+		}
+		dev.Close()
+		return int(number), nil
+	}
+}
+
+// clearLoop detaches a loop device given its path. It is a variable so it can
+// be overridden for testing.
+var clearLoop = func(devpath string) error {
+	dev, err := os.Open(devpath)
+	if err != nil {
+		return err
+	}
+	defer dev.Close()
+	if err := loopIoctl(dev.Fd(), loopClrFd, 0); err != nil {
+		return fmt.Errorf("cannot detach %s: %v", devpath, err)
+	}
+	return nil
+}
+
+// AttachLoop sets up a read-only loop device over a disk image file, with its
+// partitions, and returns the name of the loop device, e.g. loop0. It uses
+// the loop ioctls directly, so it does not depend on the losetup flavour
+// shipped in the initramfs. See LoopDevices for the block devices of the
+// image.
+func AttachLoop(file string) (string, error) {
+	number, err := setupLoop(file)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("loop%d", number), nil
+}
+
+// DetachLoop detaches a loop device set up with AttachLoop, given its name.
+func DetachLoop(name string) error {
+	return clearLoop("/dev/" + name)
+}
+
+// LoopDevices returns the loop device with the given name among devices, and
+// its partitions, e.g. loop0, loop0p1 and loop0p2.
+func LoopDevices(devices []BlockDev, name string) []BlockDev {
+	return DevicePartitions(devices, name)
+}
+
+// WaitLoopDevices returns the block devices of the loop device with the given
+// name, as LoopDevices, waiting up to timeout for its partitions to show up,
+// since they may not be listed yet right after AttachLoop, e.g. without
+// udev. The whole timeout is waited for an image without partitions.
+func WaitLoopDevices(name string, timeout time.Duration) ([]BlockDev, error) {
+	return waitPartitions(name, timeout)
+}
+
+// isPartitionOf returns true if devname is prefix followed by a partition
+// number.
+func isPartitionOf(devname, prefix string) bool {
+	number := strings.TrimPrefix(devname, prefix)
+	if number == devname || number == "" {
+		return false
+	}
+	for _, c := range number {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
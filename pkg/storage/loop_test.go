@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttachLoop(t *testing.T) {
+	defer func(orig func(string) (int, error)) { setupLoop = orig }(setupLoop)
+	defer func(orig func(string) error) { clearLoop = orig }(clearLoop)
+	var calls []string
+	setupLoop = func(file string) (int, error) {
+		calls = append(calls, "setup "+file)
+		return 3, nil
+	}
+	clearLoop = func(devpath string) error {
+		calls = append(calls, "clear "+devpath)
+		return nil
+	}
+	name, err := AttachLoop("/images/disk.img")
+	require.NoError(t, err)
+	require.Equal(t, "loop3", name)
+	require.NoError(t, DetachLoop(name))
+	require.Equal(t, []string{"setup /images/disk.img", "clear /dev/loop3"}, calls)
+
+	setupLoop = func(file string) (int, error) {
+		return 0, errors.New("no free loop device")
+	}
+	_, err = AttachLoop("/images/disk.img")
+	require.Error(t, err)
+}
+
+func TestLoopInfo64Size(t *testing.T) {
+	// struct loop_info64 is 232 bytes on all architectures
+	require.Equal(t, uintptr(232), unsafe.Sizeof(loopInfo64{}))
+}
+
+func TestLoopDevices(t *testing.T) {
+	var devices []BlockDev
+	for _, name := range []string{"sda", "sda1", "loop1", "loop1p1", "loop1p12", "loop12", "loop12p1", "loop1px"} {
+		devices = append(devices, BlockDev{Name: name})
+	}
+	require.Equal(t, []BlockDev{{Name: "loop1"}, {Name: "loop1p1"}, {Name: "loop1p12"}}, LoopDevices(devices, "loop1"))
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/systemboot/systemboot/pkg/safemode"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// LUKSMagic is the magic string at the beginning of a LUKS header
+var LUKSMagic = []byte{'L', 'U', 'K', 'S', 0xba, 0xbe}
+
+// IsLUKS returns true if the given device starts with a LUKS header.
+func IsLUKS(devname string) (bool, error) {
+	fd, err := os.Open(devname)
+	if err != nil {
+		return false, err
+	}
+	defer fd.Close()
+	magic := make([]byte, len(LUKSMagic))
+	if _, err := io.ReadFull(fd, magic); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, nil
+		}
+		return false, err
+	}
+	return bytes.Equal(magic, LUKSMagic), nil
+}
+
+// Unsealer unseals a secret that was sealed to the TPM, e.g. bound to a set of
+// PCR values.
+type Unsealer interface {
+	Unseal() ([]byte, error)
+}
+
+// LUKSUnlocker opens a LUKS device with the given passphrase, and maps it to
+// /dev/mapper/<name>.
+type LUKSUnlocker interface {
+	Unlock(devname, name string, passphrase []byte) error
+}
+
+// PassphrasePrompter asks the operator for the passphrase of a device.
+type PassphrasePrompter func(devname string) ([]byte, error)
+
+// CryptsetupUnlocker is a LUKSUnlocker that uses the `cryptsetup` binary.
+type CryptsetupUnlocker struct{}
+
+// Unlock runs `cryptsetup open`, passing the passphrase via stdin. In safe
+// mode, the device is opened read-only.
+func (CryptsetupUnlocker) Unlock(devname, name string, passphrase []byte) error {
+	args := []string{"open", "--type", "luks", "--key-file", "-"}
+	if safemode.Enabled() {
+		args = append(args, "--readonly")
+	}
+	cmd := exec.Command("cryptsetup", append(args, devname, name)...)
+	cmd.Stdin = bytes.NewReader(passphrase)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("cryptsetup open %s failed: %v", devname, err)
+	}
+	return nil
+}
+
+// stdinReader reads the passphrases from the standard input when it is not a
+// terminal. It is shared by all the devices, so that the input buffered while
+// reading a passphrase is not lost for the next one.
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// StdinPassphrasePrompter reads a passphrase from the standard input, without
+// echoing it if it is a terminal.
+func StdinPassphrasePrompter(devname string) ([]byte, error) {
+	fmt.Printf("Enter passphrase for %s: ", devname)
+	if fd := int(os.Stdin.Fd()); terminal.IsTerminal(fd) {
+		passphrase, err := terminal.ReadPassword(fd)
+		// the Enter key is not echoed either
+		fmt.Println()
+		return passphrase, err
+	}
+	line, err := stdinReader.ReadString('\n')
+	if err != nil && line == "" {
+		return nil, err
+	}
+	return []byte(strings.TrimRight(line, "\r\n")), nil
+}
+
+// UnlockLUKS unlocks a LUKS device without operator interaction, using the
+// passphrase unsealed by the given Unsealer. If unsealing fails, for example
+// because the PCR values don't match the sealing policy, or the unsealed
+// passphrase is refused, it falls back to asking the passphrase with the
+// given prompter. Either unsealer or prompter can be nil. It returns the path
+// of the unlocked device under /dev/mapper.
+func UnlockLUKS(devname string, unsealer Unsealer, unlocker LUKSUnlocker, prompter PassphrasePrompter) (string, error) {
+	name := "luks-" + path.Base(devname)
+	if unsealer != nil {
+		passphrase, err := unsealer.Unseal()
+		if err != nil {
+			log.Printf("Cannot unseal the passphrase for %s: %v", devname, err)
+		} else {
+			err = unlocker.Unlock(devname, name, passphrase)
+			if err == nil {
+				log.Printf("Unlocked %s with the TPM-sealed passphrase", devname)
+				return path.Join("/dev/mapper", name), nil
+			}
+			log.Printf("Cannot unlock %s with the TPM-sealed passphrase: %v", devname, err)
+		}
+	}
+	if prompter == nil {
+		return "", fmt.Errorf("cannot unlock %s: no TPM-sealed passphrase and no prompt available", devname)
+	}
+	passphrase, err := prompter(devname)
+	if err != nil {
+		return "", err
+	}
+	if err := unlocker.Unlock(devname, name, passphrase); err != nil {
+		return "", err
+	}
+	return path.Join("/dev/mapper", name), nil
+}
+
+// ErrNoSealedKey is returned by FindSealedKey when no sealed key is found.
+var ErrNoSealedKey = errors.New("no TPM-sealed LUKS key found")
+
+// SealedKeyPath is the path, relative to the root of the ESP, where the
+// TPM-sealed LUKS key is looked up. The `.pub` and `.priv` extensions are
+// appended for the public and private parts of the sealed object.
+var SealedKeyPath = "EFI/systemboot/luks"
+
+// FindSealedKey looks for the TPM-sealed LUKS key on the given mount points
+// and returns the path prefix of the first one found.
+func FindSealedKey(mountpoints []Mountpoint) (string, error) {
+	for _, mp := range mountpoints {
+		prefix := path.Join(mp.Path, SealedKeyPath)
+		if _, err := os.Stat(prefix + ".pub"); err != nil {
+			continue
+		}
+		if _, err := os.Stat(prefix + ".priv"); err != nil {
+			continue
+		}
+		return prefix, nil
+	}
+	return "", ErrNoSealedKey
+}
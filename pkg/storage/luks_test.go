@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeUnsealer struct {
+	secret []byte
+	err    error
+}
+
+func (u fakeUnsealer) Unseal() ([]byte, error) {
+	return u.secret, u.err
+}
+
+// fakeUnlocker accepts a single passphrase and records the unlocked devices
+type fakeUnlocker struct {
+	passphrase string
+	unlocked   map[string]string
+}
+
+func (u *fakeUnlocker) Unlock(devname, name string, passphrase []byte) error {
+	if string(passphrase) != u.passphrase {
+		return errors.New("wrong passphrase")
+	}
+	if u.unlocked == nil {
+		u.unlocked = make(map[string]string)
+	}
+	u.unlocked[devname] = name
+	return nil
+}
+
+func failingPrompter(devname string) ([]byte, error) {
+	return nil, errors.New("unexpected prompt")
+}
+
+func TestUnlockLUKSWithSealedKey(t *testing.T) {
+	unlocker := fakeUnlocker{passphrase: "s3cr3t"}
+	mapped, err := UnlockLUKS("/dev/sda2", fakeUnsealer{secret: []byte("s3cr3t")}, &unlocker, failingPrompter)
+	require.NoError(t, err)
+	require.Equal(t, "/dev/mapper/luks-sda2", mapped)
+	require.Equal(t, "luks-sda2", unlocker.unlocked["/dev/sda2"])
+}
+
+func TestUnlockLUKSPCRMismatchFallsBackToPrompt(t *testing.T) {
+	unlocker := fakeUnlocker{passphrase: "s3cr3t"}
+	var prompted bool
+	prompter := func(devname string) ([]byte, error) {
+		prompted = true
+		return []byte("s3cr3t"), nil
+	}
+	unsealer := fakeUnsealer{err: errors.New("PCR policy check failed")}
+	mapped, err := UnlockLUKS("/dev/sda2", unsealer, &unlocker, prompter)
+	require.NoError(t, err)
+	require.True(t, prompted)
+	require.Equal(t, "/dev/mapper/luks-sda2", mapped)
+}
+
+func TestUnlockLUKSWrongSealedKeyFallsBackToPrompt(t *testing.T) {
+	unlocker := fakeUnlocker{passphrase: "s3cr3t"}
+	prompter := func(devname string) ([]byte, error) {
+		return []byte("s3cr3t"), nil
+	}
+	_, err := UnlockLUKS("/dev/sda2", fakeUnsealer{secret: []byte("stale")}, &unlocker, prompter)
+	require.NoError(t, err)
+}
+
+func TestUnlockLUKSNoPrompt(t *testing.T) {
+	unlocker := fakeUnlocker{passphrase: "s3cr3t"}
+	_, err := UnlockLUKS("/dev/sda2", fakeUnsealer{err: errors.New("no TPM")}, &unlocker, nil)
+	require.Error(t, err)
+}
+
+func TestIsLUKS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "luks")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	luks := path.Join(dir, "luks.img")
+	require.NoError(t, ioutil.WriteFile(luks, append(LUKSMagic, 0, 1), 0644))
+	plain := path.Join(dir, "plain.img")
+	require.NoError(t, ioutil.WriteFile(plain, []byte("LUK"), 0644))
+
+	ok, err := IsLUKS(luks)
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = IsLUKS(plain)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestFindSealedKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	_, err = FindSealedKey([]Mountpoint{{Path: dir}})
+	require.Equal(t, ErrNoSealedKey, err)
+
+	prefix := path.Join(dir, SealedKeyPath)
+	require.NoError(t, os.MkdirAll(path.Dir(prefix), 0755))
+	require.NoError(t, ioutil.WriteFile(prefix+".pub", []byte("pub"), 0644))
+	require.NoError(t, ioutil.WriteFile(prefix+".priv", []byte("priv"), 0644))
+	found, err := FindSealedKey([]Mountpoint{{Path: "/nonexistent"}, {Path: dir}})
+	require.NoError(t, err)
+	require.Equal(t, prefix, found)
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// OverlayDirs are the directories of an overlayfs, e.g. a read-only image as
+// lower directory and a writable directory as upper directory.
+type OverlayDirs struct {
+	// Lower lists the lower directories, the first one being the top one
+	Lower []string
+	// Upper is the upper directory, which shadows the lower ones, if any
+	Upper string
+}
+
+// ParseOverlayDirs parses overlayfs directories, given like the mount options
+// of the overlay, e.g. `lower=/mnt/sda2/image:/mnt/sda2/base,upper=/mnt/sda3/upper`.
+// A `work` directory is accepted for compatibility with the actual mount
+// options, but is ignored, as the overlay is only mounted read-only.
+func ParseOverlayDirs(spec string) (*OverlayDirs, error) {
+	var dirs OverlayDirs
+	for _, option := range strings.Split(spec, ",") {
+		kv := strings.SplitN(option, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, fmt.Errorf("invalid overlay option %q", option)
+		}
+		switch kv[0] {
+		case "lower", "lowerdir":
+			dirs.Lower = strings.Split(kv[1], ":")
+		case "upper", "upperdir":
+			dirs.Upper = kv[1]
+		case "work", "workdir":
+		default:
+			return nil, fmt.Errorf("unknown overlay option %q", kv[0])
+		}
+	}
+	if len(dirs.Lower) == 0 {
+		return nil, errors.New("no lower directory for the overlay")
+	}
+	for _, dir := range append([]string{dirs.Upper}, dirs.Lower...) {
+		if dir != "" && !filepath.IsAbs(dir) {
+			return nil, fmt.Errorf("overlay directory %s is not an absolute path", dir)
+		}
+	}
+	return &dirs, nil
+}
+
+// readOnlyOptions returns the mount options of a read-only merged view of the
+// overlay: the upper directory is stacked as the top lower directory, so its
+// files and whiteouts shadow the lower ones without a work directory, and
+// without any write.
+func (o OverlayDirs) readOnlyOptions() string {
+	lower := o.Lower
+	if o.Upper != "" {
+		lower = append([]string{o.Upper}, lower...)
+	}
+	return "lowerdir=" + strings.Join(lower, ":")
+}
+
+// MountOverlay mounts the merged view of an overlayfs read-only at mountpath,
+// so that the boot configurations of e.g. a read-only image updated by an
+// upper directory are scanned as the running system sees them.
+func MountOverlay(dirs OverlayDirs, mountpath string) (*Mountpoint, error) {
+	if err := os.MkdirAll(mountpath, 0744); err != nil {
+		return nil, err
+	}
+	options := dirs.readOnlyOptions()
+	if err := mount("overlay", mountpath, "overlay", syscall.MS_RDONLY, options); err != nil {
+		return nil, fmt.Errorf("cannot mount the overlay %s on %s: %v", options, mountpath, err)
+	}
+	log.Printf(" * mounted overlay %s on %s", options, mountpath)
+	return &Mountpoint{DeviceName: "overlay", Path: mountpath, FsType: "overlay"}, nil
+}
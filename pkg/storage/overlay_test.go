@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOverlayDirs(t *testing.T) {
+	dirs, err := ParseOverlayDirs("lower=/mnt/sda2/image:/mnt/sda2/base,upper=/mnt/sda3/upper,work=/mnt/sda3/work")
+	require.NoError(t, err)
+	require.Equal(t, &OverlayDirs{Lower: []string{"/mnt/sda2/image", "/mnt/sda2/base"}, Upper: "/mnt/sda3/upper"}, dirs)
+	require.Equal(t, "lowerdir=/mnt/sda3/upper:/mnt/sda2/image:/mnt/sda2/base", dirs.readOnlyOptions())
+
+	// like the overlayfs mount options
+	dirs, err = ParseOverlayDirs("lowerdir=/mnt/sda2/image")
+	require.NoError(t, err)
+	require.Equal(t, "lowerdir=/mnt/sda2/image", dirs.readOnlyOptions())
+
+	for _, spec := range []string{
+		"",
+		"upper=/mnt/sda3/upper",
+		"lower=image",
+		"lower=/mnt/sda2/image,upper=",
+		"lower=/mnt/sda2/image,index=on",
+	} {
+		_, err := ParseOverlayDirs(spec)
+		require.Error(t, err, spec)
+	}
+}
+
+func TestMountOverlay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "overlay")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(orig func(string, string, string, uintptr, string) error) { mount = orig }(mount)
+	var calls []string
+	mount = func(source, target, fstype string, flags uintptr, data string) error {
+		calls = append(calls, source+" "+target+" "+fstype+" "+data)
+		return nil
+	}
+
+	mountpath := path.Join(dir, "overlay")
+	mp, err := MountOverlay(OverlayDirs{Lower: []string{"/mnt/sda2/image"}, Upper: "/mnt/sda3/upper"}, mountpath)
+	require.NoError(t, err)
+	require.Equal(t, &Mountpoint{DeviceName: "overlay", Path: mountpath, FsType: "overlay"}, mp)
+	require.Equal(t, []string{"overlay " + mountpath + " overlay lowerdir=/mnt/sda3/upper:/mnt/sda2/image"}, calls)
+}
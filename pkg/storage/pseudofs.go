@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+var (
+	// ProcPath is where the proc file system is mounted
+	ProcPath = "/proc"
+	// SysPath is where the sysfs file system is mounted
+	SysPath = "/sys"
+	// ProcFilesystemsPath is where the kernel lists the supported file systems
+	ProcFilesystemsPath = "/proc/filesystems"
+)
+
+// PseudoFilesystemError is returned when a file that the kernel provides
+// through /proc or /sys is missing, because the pseudo file system is not
+// mounted, e.g. in a minimal environment that mounts it lazily.
+type PseudoFilesystemError struct {
+	Path   string
+	FsType string
+	Err    error
+}
+
+func (e *PseudoFilesystemError) Error() string {
+	return fmt.Sprintf("cannot read %s, is %s mounted? %v", e.Path, e.FsType, e.Err)
+}
+
+// pseudoFSError returns a *PseudoFilesystemError for a missing file of a
+// pseudo file system, and err as is otherwise.
+func pseudoFSError(path, fstype string, err error) error {
+	if os.IsNotExist(err) {
+		return &PseudoFilesystemError{Path: path, FsType: fstype, Err: err}
+	}
+	return err
+}
+
+// MountPseudoFilesystems mounts proc on ProcPath and sysfs on SysPath, unless
+// they are mounted already, so that the block devices and the kernel command
+// line can be read.
+func MountPseudoFilesystems() error {
+	for _, fs := range []struct {
+		path, fstype, probe string
+	}{
+		{ProcPath, "proc", "self"},
+		{SysPath, "sysfs", "class"},
+	} {
+		if _, err := os.Stat(filepath.Join(fs.path, fs.probe)); err == nil {
+			continue
+		}
+		if err := os.MkdirAll(fs.path, 0555); err != nil {
+			return fmt.Errorf("cannot mount %s on %s: %v", fs.fstype, fs.path, err)
+		}
+		if err := mount(fs.fstype, fs.path, fs.fstype, syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, ""); err != nil {
+			return fmt.Errorf("cannot mount %s on %s: %v", fs.fstype, fs.path, err)
+		}
+	}
+	return nil
+}
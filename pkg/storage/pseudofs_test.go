@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMissingSysfs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pseudofs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(orig string) { SysClassBlockPath = orig }(SysClassBlockPath)
+	SysClassBlockPath = filepath.Join(dir, "sys/class/block")
+	defer func(orig string) { ProcFilesystemsPath = orig }(ProcFilesystemsPath)
+	ProcFilesystemsPath = filepath.Join(dir, "proc/filesystems")
+
+	_, err = GetBlockStats()
+	require.IsType(t, &PseudoFilesystemError{}, err)
+	require.Equal(t, "sysfs", err.(*PseudoFilesystemError).FsType)
+	_, err = GetSupportedFilesystems()
+	require.IsType(t, &PseudoFilesystemError{}, err)
+	require.Equal(t, "proc", err.(*PseudoFilesystemError).FsType)
+}
+
+func TestMountPseudoFilesystems(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pseudofs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(proc, sys string) { ProcPath, SysPath = proc, sys }(ProcPath, SysPath)
+	ProcPath, SysPath = filepath.Join(dir, "proc"), filepath.Join(dir, "sys")
+	defer func(orig func(string, string, string, uintptr, string) error) { mount = orig }(mount)
+	var mounted []string
+	mount = func(source, target, fstype string, flags uintptr, data string) error {
+		mounted = append(mounted, fstype+" "+target)
+		return nil
+	}
+
+	// proc is mounted already
+	require.NoError(t, os.MkdirAll(filepath.Join(ProcPath, "self"), 0755))
+	require.NoError(t, MountPseudoFilesystems())
+	require.Equal(t, []string{"sysfs " + SysPath}, mounted)
+
+	mount = func(source, target, fstype string, flags uintptr, data string) error {
+		return syscall.EPERM
+	}
+	require.Error(t, MountPseudoFilesystems())
+}
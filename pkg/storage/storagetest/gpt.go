@@ -0,0 +1,60 @@
+// Package storagetest provides helpers to test code that uses storage devices.
+package storagetest
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/require"
+)
+
+// WriteGPTImage creates a disk image with a primary and a backup GPT, and one
+// partition for each of the given names, with the given attributes. attrs can
+// be nil for no attributes.
+func WriteGPTImage(t *testing.T, filename string, names []string, attrs []uint64) {
+	const (
+		sectorSize = 512
+		sectors    = 128
+		numEntries = 128
+		entrySize  = 128
+	)
+	image := make([]byte, sectors*sectorSize)
+	entries := make([]byte, numEntries*entrySize)
+	for idx, name := range names {
+		entry := entries[idx*entrySize:]
+		// any non-zero type GUID
+		entry[0] = 0xaf
+		entry[16] = byte(idx + 1)
+		binary.LittleEndian.PutUint64(entry[32:], uint64(40+idx*8))
+		binary.LittleEndian.PutUint64(entry[40:], uint64(47+idx*8))
+		if attrs != nil {
+			binary.LittleEndian.PutUint64(entry[48:], attrs[idx])
+		}
+		for i, u := range utf16.Encode([]rune(name)) {
+			binary.LittleEndian.PutUint16(entry[56+2*i:], u)
+		}
+	}
+	writeHeader := func(myLBA, alternateLBA, entriesLBA uint64) {
+		header := make([]byte, 92)
+		copy(header, "EFI PART")
+		binary.LittleEndian.PutUint32(header[8:], 0x00010000)
+		binary.LittleEndian.PutUint32(header[12:], 92)
+		binary.LittleEndian.PutUint64(header[24:], myLBA)
+		binary.LittleEndian.PutUint64(header[32:], alternateLBA)
+		binary.LittleEndian.PutUint64(header[40:], 34)
+		binary.LittleEndian.PutUint64(header[48:], sectors-34)
+		binary.LittleEndian.PutUint64(header[72:], entriesLBA)
+		binary.LittleEndian.PutUint32(header[80:], numEntries)
+		binary.LittleEndian.PutUint32(header[84:], entrySize)
+		binary.LittleEndian.PutUint32(header[88:], crc32.ChecksumIEEE(entries))
+		binary.LittleEndian.PutUint32(header[16:], crc32.ChecksumIEEE(header))
+		copy(image[myLBA*sectorSize:], header)
+		copy(image[entriesLBA*sectorSize:], entries)
+	}
+	writeHeader(1, sectors-1, 2)
+	writeHeader(sectors-1, 1, sectors-33)
+	require.NoError(t, ioutil.WriteFile(filename, image, 0644))
+}
@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Retries of UnmountVerified before falling back to a lazy unmount.
+var (
+	UnmountAttempts      = 5
+	UnmountRetryInterval = 200 * time.Millisecond
+)
+
+// unmount is syscall.Unmount. It is a variable so it can be overridden for
+// testing.
+var unmount = syscall.Unmount
+
+// unescapeMountPath decodes the octal escapes of /proc/mounts, e.g. `\040`
+// for a space.
+func unescapeMountPath(p string) string {
+	if !strings.Contains(p, `\`) {
+		return p
+	}
+	var out strings.Builder
+	for i := 0; i < len(p); i++ {
+		if p[i] == '\\' && i+4 <= len(p) {
+			if c, err := strconv.ParseUint(p[i+1:i+4], 8, 8); err == nil {
+				out.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		out.WriteByte(p[i])
+	}
+	return out.String()
+}
+
+// IsMounted returns true if a file system is mounted on the given path,
+// according to LinuxMountsPath.
+func IsMounted(mountpath string) (bool, error) {
+	file, err := os.Open(LinuxMountsPath)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	mountpath = path.Clean(mountpath)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 1 && path.Clean(unescapeMountPath(fields[1])) == mountpath {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// UnmountError reports a mount point that could not be cleanly unmounted.
+type UnmountError struct {
+	Mountpoint Mountpoint
+	// Lazy is true if the file system was detached with a lazy unmount,
+	// i.e. it is still busy and will only be unmounted once it is not
+	Lazy bool
+	Err  error
+}
+
+func (e *UnmountError) Error() string {
+	if e.Lazy {
+		return fmt.Sprintf("%s on %s is busy and was lazily unmounted: %v", e.Mountpoint.DeviceName, e.Mountpoint.Path, e.Err)
+	}
+	return fmt.Sprintf("cannot unmount %s from %s: %v", e.Mountpoint.DeviceName, e.Mountpoint.Path, e.Err)
+}
+
+// UnmountVerified unmounts a file system and checks that it is really gone
+// from LinuxMountsPath, since on some media the device can still be busy
+// after umount succeeded. It retries while the unmount fails with EBUSY or
+// the file system is still listed, and detaches it with a lazy unmount as a
+// last resort, which is reported as an *UnmountError too.
+func UnmountVerified(mountpoint Mountpoint) error {
+	var lastErr error
+	for attempt := 0; attempt < UnmountAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(UnmountRetryInterval)
+		}
+		err := unmount(mountpoint.Path, 0)
+		if err != nil && err != syscall.EBUSY && err != syscall.EINVAL {
+			// EINVAL means that nothing is mounted there anymore
+			return &UnmountError{Mountpoint: mountpoint, Err: err}
+		}
+		mounted, checkErr := IsMounted(mountpoint.Path)
+		if checkErr != nil {
+			return &UnmountError{Mountpoint: mountpoint, Err: checkErr}
+		}
+		if !mounted {
+			return nil
+		}
+		if err == nil {
+			err = syscall.EBUSY
+		}
+		lastErr = err
+		log.Printf("%s is still mounted on %s after unmounting it, retrying", mountpoint.DeviceName, mountpoint.Path)
+	}
+	if err := unmount(mountpoint.Path, syscall.MNT_DETACH); err != nil {
+		return &UnmountError{Mountpoint: mountpoint, Err: err}
+	}
+	return &UnmountError{Mountpoint: mountpoint, Lazy: true, Err: lastErr}
+}
+
+// UnmountAll unmounts the given mount points with UnmountVerified, in reverse
+// order so that nested mount points come first, and returns the errors for
+// the ones that could not be cleanly unmounted.
+func UnmountAll(mountpoints []Mountpoint) []error {
+	var errs []error
+	for idx := len(mountpoints) - 1; idx >= 0; idx-- {
+		if err := UnmountVerified(mountpoints[idx]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
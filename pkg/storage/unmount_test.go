@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeMounts replaces LinuxMountsPath with a file listing the given mount
+// points, and unmount with a function that removes them from it, except the
+// first `busy` times.
+func fakeMounts(t *testing.T, busy int, mountpaths ...string) (*[]int, func()) {
+	dir, err := ioutil.TempDir("", "mounts")
+	require.NoError(t, err)
+	write := func(mountpaths []string) {
+		var content string
+		for _, p := range mountpaths {
+			content += "/dev/sda1 " + strings.Replace(p, " ", `\040`, -1) + " ext4 ro,relatime 0 0\n"
+		}
+		require.NoError(t, ioutil.WriteFile(path.Join(dir, "mounts"), []byte(content), 0644))
+	}
+	write(mountpaths)
+	var flags []int
+	savedPath, savedUnmount, savedInterval := LinuxMountsPath, unmount, UnmountRetryInterval
+	LinuxMountsPath = path.Join(dir, "mounts")
+	UnmountRetryInterval = 0
+	unmount = func(target string, flag int) error {
+		flags = append(flags, flag)
+		if busy > 0 && flag != syscall.MNT_DETACH {
+			// umount succeeds, but the file system is still there
+			busy--
+			return nil
+		}
+		var left []string
+		for _, p := range mountpaths {
+			if p != target {
+				left = append(left, p)
+			}
+		}
+		mountpaths = left
+		write(mountpaths)
+		return nil
+	}
+	return &flags, func() {
+		LinuxMountsPath, unmount, UnmountRetryInterval = savedPath, savedUnmount, savedInterval
+		os.RemoveAll(dir)
+	}
+}
+
+func TestUnmountVerifiedRetries(t *testing.T) {
+	flags, restore := fakeMounts(t, 1, "/mnt/sda1", "/mnt/my disk")
+	defer restore()
+
+	// the first check after umount still shows the file system mounted
+	require.NoError(t, UnmountVerified(Mountpoint{DeviceName: "/dev/sda1", Path: "/mnt/sda1"}))
+	require.Equal(t, []int{0, 0}, *flags)
+	mounted, err := IsMounted("/mnt/sda1")
+	require.NoError(t, err)
+	require.False(t, mounted)
+	mounted, err = IsMounted("/mnt/my disk")
+	require.NoError(t, err)
+	require.True(t, mounted)
+}
+
+func TestUnmountAllLazy(t *testing.T) {
+	flags, restore := fakeMounts(t, UnmountAttempts, "/mnt/sda1")
+	defer restore()
+
+	errs := UnmountAll([]Mountpoint{{DeviceName: "/dev/sda1", Path: "/mnt/sda1"}})
+	require.Equal(t, 1, len(errs))
+	require.True(t, errs[0].(*UnmountError).Lazy)
+	require.Equal(t, UnmountAttempts+1, len(*flags))
+	require.Equal(t, syscall.MNT_DETACH, (*flags)[UnmountAttempts])
+}
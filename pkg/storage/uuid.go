@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// Directories where udev or mdev create symlinks to block devices by UUID.
+var (
+	DiskByUUIDPath     = "/dev/disk/by-uuid"
+	DiskByPartUUIDPath = "/dev/disk/by-partuuid"
+)
+
+// DiskByLabelPath is the directory where udev or mdev create symlinks to block
+// devices by file system label.
+var DiskByLabelPath = "/dev/disk/by-label"
+
+// runBlkid runs blkid to read a single tag of a device. It is a variable so
+// it can be overridden for testing.
+var runBlkid = func(devname, tag string) (string, error) {
+	out, err := exec.Command("blkid", "-s", tag, "-o", "value", devname).Output()
+	if err != nil {
+		return "", fmt.Errorf("blkid %s failed: %v", devname, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// runBlkidFind runs blkid to find the device with a tag, e.g. UUID=1234-ABCD.
+// It is a variable so it can be overridden for testing.
+var runBlkidFind = func(token string) (string, error) {
+	out, err := exec.Command("blkid", "-l", "-o", "device", "-t", token).Output()
+	if err != nil {
+		return "", fmt.Errorf("blkid -t %s failed: %v", token, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// lookupDiskLink looks for a symlink in dir pointing to devname, and returns
+// its name.
+func lookupDiskLink(dir, devname string) (string, error) {
+	target, err := filepath.EvalSymlinks(devname)
+	if err != nil {
+		return "", err
+	}
+	links, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	for _, link := range links {
+		if link.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		dest, err := filepath.EvalSymlinks(filepath.Join(dir, link.Name()))
+		if err == nil && dest == target {
+			return link.Name(), nil
+		}
+	}
+	return "", fmt.Errorf("no link to %s in %s", devname, dir)
+}
+
+// deviceTag returns a UUID of a block device, first looking it up in the
+// given /dev/disk directory, then falling back to blkid.
+func deviceTag(devname, dir, tag string) (string, error) {
+	if name, err := lookupDiskLink(dir, devname); err == nil {
+		return name, nil
+	}
+	value, err := runBlkid(devname, tag)
+	if err != nil {
+		return "", err
+	}
+	if value == "" {
+		return "", fmt.Errorf("%s has no %s", devname, tag)
+	}
+	return value, nil
+}
+
+// DeviceUUID returns the UUID of the file system on a block device.
+func DeviceUUID(devname string) (string, error) {
+	return deviceTag(devname, DiskByUUIDPath, "UUID")
+}
+
+// DevicePartUUID returns the partition UUID of a block device, as found in
+// the partition table.
+func DevicePartUUID(devname string) (string, error) {
+	return deviceTag(devname, DiskByPartUUIDPath, "PARTUUID")
+}
+
+// DeviceLabel returns the label of the file system on a block device. The
+// label is returned as escaped by udev, e.g. `My\x20Disk`, if it is read from
+// DiskByLabelPath.
+func DeviceLabel(devname string) (string, error) {
+	return deviceTag(devname, DiskByLabelPath, "LABEL")
+}
+
+// FindByUUID returns the path of the block device, e.g. /dev/sda1, with the
+// given file system UUID or partition UUID.
+func FindByUUID(uuid string) (string, error) {
+	for _, dir := range []string{DiskByUUIDPath, DiskByPartUUIDPath} {
+		if devname, err := filepath.EvalSymlinks(filepath.Join(dir, uuid)); err == nil {
+			return devname, nil
+		}
+	}
+	for _, tag := range []string{"UUID", "PARTUUID"} {
+		if devname, err := runBlkidFind(tag + "=" + uuid); err == nil && devname != "" {
+			return devname, nil
+		}
+	}
+	return "", fmt.Errorf("no device with UUID %s", uuid)
+}
+
+// escapeLabel escapes a file system label like udev does for the names of the
+// links in DiskByLabelPath, e.g. `Boot\x20Disk` for `Boot Disk`: only ASCII
+// letters and digits, `#+-.:=@_` and non-ASCII UTF-8 characters are kept.
+func escapeLabel(label string) string {
+	var escaped strings.Builder
+	for len(label) > 0 {
+		r, size := utf8.DecodeRuneInString(label)
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', strings.ContainsRune("#+-.:=@_", r):
+			escaped.WriteRune(r)
+		case r >= utf8.RuneSelf && r != utf8.RuneError:
+			escaped.WriteRune(r)
+		default:
+			for _, c := range []byte(label[:size]) {
+				fmt.Fprintf(&escaped, "\\x%02x", c)
+			}
+		}
+		label = label[size:]
+	}
+	return escaped.String()
+}
+
+// FindByLabel returns the path of the block device, e.g. /dev/sda1, with the
+// given file system label. The label can contain spaces.
+func FindByLabel(label string) (string, error) {
+	if devname, err := filepath.EvalSymlinks(filepath.Join(DiskByLabelPath, escapeLabel(label))); err == nil {
+		return devname, nil
+	}
+	if devname, err := runBlkidFind("LABEL=" + label); err == nil && devname != "" {
+		return devname, nil
+	}
+	return "", fmt.Errorf("no device with label %q", label)
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeviceUUIDFromLinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uuid")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	devname := path.Join(dir, "sda1")
+	require.NoError(t, ioutil.WriteFile(devname, nil, 0644))
+	byUUID := path.Join(dir, "by-uuid")
+	require.NoError(t, os.Mkdir(byUUID, 0755))
+	require.NoError(t, os.Symlink("../sda1", path.Join(byUUID, "1234-ABCD")))
+	require.NoError(t, os.Symlink("../other", path.Join(byUUID, "5678-EF00")))
+
+	defer func(p string) { DiskByUUIDPath = p }(DiskByUUIDPath)
+	DiskByUUIDPath = byUUID
+	uuid, err := DeviceUUID(devname)
+	require.NoError(t, err)
+	require.Equal(t, "1234-ABCD", uuid)
+}
+
+func TestDevicePartUUIDFallsBackToBlkid(t *testing.T) {
+	defer func(p string, f func(string, string) (string, error)) {
+		DiskByPartUUIDPath, runBlkid = p, f
+	}(DiskByPartUUIDPath, runBlkid)
+	DiskByPartUUIDPath = "/nonexistent"
+	runBlkid = func(devname, tag string) (string, error) {
+		if devname == "/dev/sda1" && tag == "PARTUUID" {
+			return "0f1e2d3c-01", nil
+		}
+		return "", errors.New("no such device")
+	}
+	partuuid, err := DevicePartUUID("/dev/sda1")
+	require.NoError(t, err)
+	require.Equal(t, "0f1e2d3c-01", partuuid)
+	_, err = DevicePartUUID("/dev/sdb1")
+	require.Error(t, err)
+}
+
+func TestFindByUUID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uuid")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"sda1", "sda2"} {
+		require.NoError(t, ioutil.WriteFile(path.Join(dir, name), nil, 0644))
+	}
+	byUUID := path.Join(dir, "by-uuid")
+	byPartUUID := path.Join(dir, "by-partuuid")
+	require.NoError(t, os.Mkdir(byUUID, 0755))
+	require.NoError(t, os.Mkdir(byPartUUID, 0755))
+	require.NoError(t, os.Symlink("../sda1", path.Join(byUUID, "1234-ABCD")))
+	require.NoError(t, os.Symlink("../sda2", path.Join(byPartUUID, "0f1e2d3c-02")))
+
+	defer func(u, p string, f func(string) (string, error)) {
+		DiskByUUIDPath, DiskByPartUUIDPath, runBlkidFind = u, p, f
+	}(DiskByUUIDPath, DiskByPartUUIDPath, runBlkidFind)
+	DiskByUUIDPath, DiskByPartUUIDPath = byUUID, byPartUUID
+	runBlkidFind = func(token string) (string, error) {
+		if token == "UUID=5678-EF00" {
+			return "/dev/sdb1", nil
+		}
+		return "", errors.New("not found")
+	}
+
+	devname, err := FindByUUID("1234-ABCD")
+	require.NoError(t, err)
+	require.Equal(t, path.Join(dir, "sda1"), devname)
+	devname, err = FindByUUID("0f1e2d3c-02")
+	require.NoError(t, err)
+	require.Equal(t, path.Join(dir, "sda2"), devname)
+	devname, err = FindByUUID("5678-EF00")
+	require.NoError(t, err)
+	require.Equal(t, "/dev/sdb1", devname)
+	_, err = FindByUUID("0000-0000")
+	require.Error(t, err)
+}
+
+func TestEscapeLabel(t *testing.T) {
+	for label, expected := range map[string]string{
+		"BOOT":         "BOOT",
+		"Boot Disk":    `Boot\x20Disk`,
+		"a/b\\c":       `a\x2fb\x5cc`,
+		"EFI-System_1": "EFI-System_1",
+		"Démarrage":    "Démarrage",
+		"bad\xffbyte":  `bad\xffbyte`,
+	} {
+		require.Equal(t, expected, escapeLabel(label), label)
+	}
+}
+
+func TestFindByLabel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "label")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(path.Join(dir, "sda1"), nil, 0644))
+	byLabel := path.Join(dir, "by-label")
+	require.NoError(t, os.Mkdir(byLabel, 0755))
+	require.NoError(t, os.Symlink("../sda1", path.Join(byLabel, `Boot\x20Disk`)))
+
+	defer func(p string, f func(string) (string, error)) {
+		DiskByLabelPath, runBlkidFind = p, f
+	}(DiskByLabelPath, runBlkidFind)
+	DiskByLabelPath = byLabel
+	runBlkidFind = func(token string) (string, error) {
+		if token == "LABEL=Data Disk" {
+			return "/dev/sdb1", nil
+		}
+		return "", errors.New("not found")
+	}
+
+	devname, err := FindByLabel("Boot Disk")
+	require.NoError(t, err)
+	require.Equal(t, path.Join(dir, "sda1"), devname)
+	devname, err = FindByLabel("Data Disk")
+	require.NoError(t, err)
+	require.Equal(t, "/dev/sdb1", devname)
+	_, err = FindByLabel("Other Disk")
+	require.Error(t, err)
+}
@@ -5,6 +5,8 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+
+	"github.com/systemboot/systemboot/pkg/safemode"
 )
 
 // VpdDir points to the base directory where the VPD sysfs interface is located.
@@ -41,6 +43,9 @@ func Get(key string, readOnly bool) ([]byte, error) {
 // does not support writing. To write, this library needs a backend able to
 // write to flash chips, like the command line tool flashrom or flashtools.
 func Set(key string, value []byte, readOnly bool) error {
+	if err := safemode.Check("write VPD variable " + key); err != nil {
+		return err
+	}
 	// NOTE this is not implemented yet in the kernel interface, and will always
 	// return a permission denied error
 	return ioutil.WriteFile(path.Join(getBaseDir(readOnly), key), value, 0644)
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"github.com/systemboot/systemboot/pkg/safemode"
 )
 
 func TestGetReadOnly(t *testing.T) {
@@ -40,3 +41,13 @@ func TestGetAllReadOnly(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestSetSafeMode(t *testing.T) {
+	VpdDir = "./tests"
+	safemode.Enable()
+	defer safemode.Disable()
+	require.IsType(t, &safemode.Error{}, Set("mysecretpassword", []byte("changed\n"), false))
+	value, err := Get("mysecretpassword", false)
+	require.NoError(t, err)
+	require.Equal(t, []byte("passw0rd\n"), value)
+}